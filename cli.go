@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/tbellembois/gobkm/handlers"
+	"github.com/tbellembois/gobkm/types"
+)
+
+// importPollInterval is the delay between two import job state requests.
+const importPollInterval = 500 * time.Millisecond
+
+// command is a command-line client subcommand.
+type command struct {
+	args    string // the arguments synopsis
+	help    string
+	minArgs int
+	maxArgs int
+	// flags defines the command flags in fs and returns the command.
+	flags func(fs *flag.FlagSet) func(c *client, args []string) error
+}
+
+// commands are the command-line client subcommands by name.
+var commands = map[string]command{
+	"add":    {"<url>", "add a bookmark", 1, 1, addCommand},
+	"search": {"<terms>", "search the bookmarks", 1, 1, searchCommand},
+	"ls":     {"[folder]", "list a folder, the root folder by default", 0, 1, lsCommand},
+	"mv":     {"<bookmark|folder> <folder>", "move a bookmark or a folder", 2, 2, mvCommand},
+	"rm":     {"<bookmark|folder>", "delete a bookmark or a folder with its content", 1, 1, rmCommand},
+	"star":   {"<bookmark>", "star a bookmark", 1, 1, starCommand},
+	"import": {"<file>", "import a bookmarks file", 1, 1, importCommand},
+	"export": {"", "export the bookmarks", 0, 0, exportCommand},
+}
+
+// client sends the commands requests to a GoBkm server,
+// or to the handlers of a local database.
+type client struct {
+	http    *http.Client
+	baseURL string
+	token   string
+	json    bool // JSON output, a table otherwise
+	out     io.Writer
+}
+
+// localTransport serves the requests with a handler, in process.
+type localTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t localTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, r)
+	return rec.Result(), nil
+}
+
+// parseArgs parses the flags of fs in args, flags and arguments being mixed,
+// and returns the arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		// Everything after -- is an argument.
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// runCommand runs the command name with the given arguments
+// and returns the exit status.
+func runCommand(name string, args []string) int {
+	cmd := commands[name]
+	fs := flag.NewFlagSet("gobkm "+name, flag.ContinueOnError)
+	server := fs.String("server", "", "the GoBkm server URL, the local database if empty")
+	token := fs.String("token", "", "the bearer token sent to the server")
+	jsonOutput := fs.Bool("json", false, "JSON output")
+	debug := fs.Bool("debug", false, "debug (verbose log)")
+	dbf := addDBFlags(fs)
+	addConfigFlag(fs)
+	run := cmd.flags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: gobkm %s [flags] %s\n%s\n", name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(args) < cmd.minArgs || len(args) > cmd.maxArgs {
+		fs.Usage()
+		return 2
+	}
+	if _, err = applySettings(fs, false); err != nil {
+		fmt.Fprintln(os.Stderr, "gobkm:", err)
+		return 1
+	}
+	// The handlers errors are the command errors.
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.FatalLevel)
+	}
+
+	c := &client{baseURL: strings.TrimSuffix(*server, "/"), token: *token, json: *jsonOutput, out: os.Stdout}
+	if c.baseURL == "" {
+		// Local database.
+		ds, err := dbf.openDatastore()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gobkm:", err)
+			return 1
+		}
+		defer ds.Close()
+		mux := http.NewServeMux()
+		handleAPI(mux, &handlers.Env{DB: ds})
+		c.http = &http.Client{Transport: localTransport{mux}}
+		c.baseURL = "http://gobkm"
+	} else {
+		c.http = &http.Client{Timeout: time.Minute}
+	}
+
+	if err = run(c, args); err != nil {
+		fmt.Fprintln(os.Stderr, "gobkm "+name+":", err)
+		return 1
+	}
+	return 0
+}
+
+// send sends a request of the given path and parameters
+// and returns the response body.
+func (c *client) send(method, path string, params url.Values, body io.Reader) ([]byte, error) {
+	u := c.baseURL + path
+	if len(params) != 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	// The user:password of the server URL are sent by the client.
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg := strings.TrimSpace(string(data))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, errors.New(msg)
+	}
+	return data, nil
+}
+
+// call sends a request and decodes its JSON response into v, if not nil.
+func (c *client) call(method, path string, params url.Values, body io.Reader, v interface{}) error {
+	data, err := c.send(method, path, params, body)
+	if err != nil || v == nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// batch applies the given batch operations.
+func (c *client) batch(ops []types.BatchOperation) error {
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	return c.call(http.MethodPost, "/batch/", nil, bytes.NewReader(body), nil)
+}
+
+// subfolders returns the subfolders of the folder id.
+func (c *client) subfolders(id int) ([]*types.Folder, error) {
+	var flds []*types.Folder
+	err := c.call(http.MethodGet, "/getChildrenFolders/", url.Values{"folderId": {strconv.Itoa(id)}}, nil, &flds)
+	return flds, err
+}
+
+// folderBookmarks returns the bookmarks of the folder id.
+func (c *client) folderBookmarks(id int) ([]*types.Bookmark, error) {
+	var bkms []*types.Bookmark
+	err := c.call(http.MethodGet, "/getFolderBookmarks/", url.Values{"folderId": {strconv.Itoa(id)}}, nil, &bkms)
+	return bkms, err
+}
+
+// splitPath returns the folder titles of a path such as IT/Development.
+func splitPath(path string) []string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// resolveFolder returns the id of the folder of the given path,
+// the root folder if empty.
+func (c *client) resolveFolder(path string) (int, error) {
+	id := 1
+	for _, name := range splitPath(path) {
+		flds, err := c.subfolders(id)
+		if err != nil {
+			return 0, err
+		}
+		found := false
+		for _, f := range flds {
+			if f.Title == name {
+				id, found = f.Id, true
+				break
+			}
+		}
+		if !found {
+			return 0, errors.New("no folder " + path)
+		}
+	}
+	return id, nil
+}
+
+// item is the bookmark or the folder of a command argument.
+type item struct {
+	bookmarkID int
+	folderID   int
+}
+
+// resolveItem returns the item of the given argument: a bookmark id,
+// a bookmark URL, or a path whose last element is a subfolder or bookmark title.
+func (c *client) resolveItem(arg string) (item, error) {
+	if id, err := strconv.Atoi(arg); err == nil {
+		return item{bookmarkID: id}, nil
+	}
+	// The bookmarks of an URL are found on their canonical URL
+	// by the Nextcloud API.
+	if strings.Contains(arg, "://") {
+		var resp struct {
+			Data []*types.Bookmark `json:"data"`
+		}
+		if err := c.call(http.MethodGet, "/index.php/apps/bookmarks/public/rest/v2/bookmark", url.Values{"url": {arg}}, nil, &resp); err != nil {
+			return item{}, err
+		}
+		return uniqueBookmark(arg, resp.Data, func(*types.Bookmark) bool { return true })
+	}
+	names := splitPath(arg)
+	if len(names) == 0 {
+		return item{}, errors.New("the root folder can not be changed")
+	}
+	parentID, err := c.resolveFolder(strings.Join(names[:len(names)-1], "/"))
+	if err != nil {
+		return item{}, err
+	}
+	last := names[len(names)-1]
+
+	flds, err := c.subfolders(parentID)
+	if err != nil {
+		return item{}, err
+	}
+	for _, f := range flds {
+		if f.Title == last {
+			return item{folderID: f.Id}, nil
+		}
+	}
+	bkms, err := c.folderBookmarks(parentID)
+	if err != nil {
+		return item{}, err
+	}
+	return uniqueBookmark(arg, bkms, func(b *types.Bookmark) bool { return b.Title == last })
+}
+
+// uniqueBookmark returns the item of the only bookmark of bkms matching
+// the argument arg.
+func uniqueBookmark(arg string, bkms []*types.Bookmark, match func(*types.Bookmark) bool) (item, error) {
+	var it item
+	for _, b := range bkms {
+		if !match(b) {
+			continue
+		}
+		if it.bookmarkID != 0 {
+			return item{}, errors.New("several bookmarks " + arg + ", use the bookmark id")
+		}
+		it.bookmarkID = b.Id
+	}
+	if it.bookmarkID == 0 {
+		return item{}, errors.New("no bookmark or folder " + arg)
+	}
+	return it, nil
+}
+
+// printJSON prints v as indented JSON.
+func (c *client) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printBookmarks prints the given bookmarks, and folders if not nil.
+func (c *client) printBookmarks(flds []*types.Folder, bkms []*types.Bookmark) error {
+	if c.json {
+		if flds == nil {
+			return c.printJSON(bkms)
+		}
+		return c.printJSON(struct {
+			Folders   []*types.Folder
+			Bookmarks []*types.Bookmark
+		}{flds, bkms})
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tURL\tTAGS\tSTARRED")
+	for _, f := range flds {
+		fmt.Fprintf(tw, "-\t%s/\t\t\t\n", f.Title)
+	}
+	for _, b := range bkms {
+		starred := ""
+		if b.Starred {
+			starred = "*"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", b.Id, b.Title, b.URL, strings.Join(b.Tags, ","), starred)
+	}
+	return tw.Flush()
+}
+
+// addCommand adds a bookmark to a folder, with an optional title, tags and star.
+func addCommand(fs *flag.FlagSet) func(c *client, args []string) error {
+	folder := fs.String("folder", "", "the folder path, such as IT/Development, the root folder if empty")
+	title := fs.String("title", "", "the bookmark title, the URL if empty")
+	tags := fs.String("tags", "", "comma separated tags")
+	star := fs.Bool("star", false, "star the bookmark")
+	return func(c *client, args []string) error {
+		folderID, err := c.resolveFolder(*folder)
+		if err != nil {
+			return err
+		}
+		var added types.AddedBookmark
+		if err = c.call(http.MethodGet, "/addBookmark/", url.Values{"bookmarkUrl": {args[0]}, "destinationFolderId": {strconv.Itoa(folderID)}}, nil, &added); err != nil {
+			return err
+		}
+
+		// Setting the other fields at once.
+		added.Title = added.URL
+		var ops []types.BatchOperation
+		if *title != "" {
+			ops = append(ops, types.BatchOperation{Op: types.BatchRename, BookmarkId: added.Id, Title: *title})
+			added.Title = *title
+		}
+		if *tags != "" {
+			ops = append(ops, types.BatchOperation{Op: types.BatchTag, BookmarkId: added.Id, Tags: strings.Split(*tags, ",")})
+			added.Tags = strings.Split(*tags, ",")
+		}
+		if *star {
+			ops = append(ops, types.BatchOperation{Op: types.BatchStar, BookmarkId: added.Id, Starred: true})
+			added.Starred = true
+		}
+		if len(ops) != 0 {
+			if err = c.batch(ops); err != nil {
+				return err
+			}
+		}
+
+		if c.json {
+			return c.printJSON(added)
+		}
+		for _, d := range added.Duplicates {
+			fmt.Fprintf(os.Stderr, "gobkm add: duplicate of bookmark %d %s\n", d.Id, d.Title)
+		}
+		return c.printBookmarks(nil, []*types.Bookmark{&added.Bookmark})
+	}
+}
+
+// searchCommand searches the bookmarks.
+func searchCommand(fs *flag.FlagSet) func(c *client, args []string) error {
+	folder := fs.String("folder", "", "search under this folder path")
+	tag := fs.String("tag", "", "only the bookmarks with this tag")
+	starred := fs.Bool("starred", false, "only the starred bookmarks")
+	sortBy := fs.String("sort", "", "title, created, updated, visited, visits or frecency")
+	desc := fs.Bool("desc", false, "descending order")
+	return func(c *client, args []string) error {
+		params := url.Values{"search": {args[0]}}
+		if *folder != "" {
+			folderID, err := c.resolveFolder(*folder)
+			if err != nil {
+				return err
+			}
+			params.Set("under", strconv.Itoa(folderID))
+		}
+		if *tag != "" {
+			params.Set("tag", *tag)
+		}
+		if *starred {
+			params.Set("starred", "true")
+		}
+		if *sortBy != "" {
+			params.Set("sort", *sortBy)
+		}
+		if *desc {
+			params.Set("order", "desc")
+		}
+		var bkms []*types.Bookmark
+		if err := c.call(http.MethodGet, "/searchBookmarks/", params, nil, &bkms); err != nil {
+			return err
+		}
+		return c.printBookmarks(nil, bkms)
+	}
+}
+
+// lsCommand lists the subfolders and bookmarks of a folder.
+func lsCommand(fs *flag.FlagSet) func(c *client, args []string) error {
+	return func(c *client, args []string) error {
+		path := ""
+		if len(args) != 0 {
+			path = args[0]
+		}
+		folderID, err := c.resolveFolder(path)
+		if err != nil {
+			return err
+		}
+		flds, err := c.subfolders(folderID)
+		if err != nil {
+			return err
+		}
+		bkms, err := c.folderBookmarks(folderID)
+		if err != nil {
+			return err
+		}
+		if flds == nil {
+			flds = []*types.Folder{}
+		}
+		return c.printBookmarks(flds, bkms)
+	}
+}
+
+// mvCommand moves a bookmark or a folder into a folder.
+func mvCommand(fs *flag.FlagSet) func(c *client, args []string) error {
+	return func(c *client, args []string) error {
+		it, err := c.resolveItem(args[0])
+		if err != nil {
+			return err
+		}
+		folderID, err := c.resolveFolder(args[1])
+		if err != nil {
+			return err
+		}
+		return c.batch([]types.BatchOperation{{Op: types.BatchMove, BookmarkId: it.bookmarkID, FolderId: it.folderID, DestinationFolderId: folderID}})
+	}
+}
+
+// rmCommand deletes a bookmark or a folder.
+func rmCommand(fs *flag.FlagSet) func(c *client, args []string) error {
+	return func(c *client, args []string) error {
+		it, err := c.resolveItem(args[0])
+		if err != nil {
+			return err
+		}
+		return c.batch([]types.BatchOperation{{Op: types.BatchDelete, BookmarkId: it.bookmarkID, FolderId: it.folderID}})
+	}
+}
+
+// starCommand stars or unstars a bookmark.
+func starCommand(fs *flag.FlagSet) func(c *client, args []string) error {
+	unstar := fs.Bool("unstar", false, "unstar the bookmark")
+	return func(c *client, args []string) error {
+		it, err := c.resolveItem(args[0])
+		if err != nil {
+			return err
+		}
+		if it.bookmarkID == 0 {
+			return errors.New(args[0] + " is a folder")
+		}
+		return c.batch([]types.BatchOperation{{Op: types.BatchStar, BookmarkId: it.bookmarkID, Starred: !*unstar}})
+	}
+}
+
+// importCommand imports a bookmarks file and waits for the end of the import.
+func importCommand(fs *flag.FlagSet) func(c *client, args []string) error {
+	folder := fs.String("folder", "", "the folder path to import into, the root folder if empty")
+	mode := fs.String("mode", "", "new (a new folder, default), merge or skip")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without saving it")
+	return func(c *client, args []string) error {
+		folderID, err := c.resolveFolder(*folder)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		params := url.Values{"folderId": {strconv.Itoa(folderID)}, "mode": {*mode}, "dryRun": {strconv.FormatBool(*dryRun)}}
+		var job types.ImportJob
+		if err = c.call(http.MethodPost, "/import/", params, file, &job); err != nil {
+			return err
+		}
+		for job.Status == types.ImportJobRunning {
+			time.Sleep(importPollInterval)
+			if err = c.call(http.MethodGet, "/getImportJob/", url.Values{"jobId": {strconv.Itoa(job.Id)}}, nil, &job); err != nil {
+				return err
+			}
+		}
+
+		if c.json {
+			if err = c.printJSON(job); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintf(c.out, "%s: %d folders, %d bookmarks, %d malformed entries\n", job.Status, job.Folders, job.Bookmarks, job.Malformed)
+			if job.Report != nil {
+				tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "ENTRY\tTYPE\tPATH\tTITLE\tURL\tREASON")
+				for _, l := range []struct {
+					name    string
+					entries []types.ImportReportEntry
+				}{{"created", job.Report.Created}, {"skipped", job.Report.Skipped}, {"updated", job.Report.Updated}, {"malformed", job.Report.Malformed}} {
+					for _, e := range l.entries {
+						fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", l.name, e.Type, e.Path, e.Title, e.URL, e.Reason)
+					}
+				}
+				if err = tw.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if job.Status != types.ImportJobDone {
+			return errors.New("import " + job.Status + " " + job.Error)
+		}
+		return nil
+	}
+}
+
+// exportCommand exports the bookmarks to the standard output or a file.
+func exportCommand(fs *flag.FlagSet) func(c *client, args []string) error {
+	format := fs.String("format", "html", "html, json, csv, markdown, opml or xbel")
+	folder := fs.String("folder", "", "export this folder path only")
+	starred := fs.Bool("starred", false, "export the starred bookmarks only")
+	search := fs.String("search", "", "export the search results only")
+	output := fs.String("o", "", "the output file, the standard output if empty")
+	return func(c *client, args []string) error {
+		params := url.Values{"format": {*format}}
+		if *folder != "" {
+			folderID, err := c.resolveFolder(*folder)
+			if err != nil {
+				return err
+			}
+			params.Set("folderId", strconv.Itoa(folderID))
+		}
+		if *starred {
+			params.Set("starred", "true")
+		}
+		if *search != "" {
+			params.Set("search", *search)
+		}
+		data, err := c.send(http.MethodGet, "/export/", params, nil)
+		if err != nil {
+			return err
+		}
+		if *output != "" {
+			return ioutil.WriteFile(*output, data, 0644)
+		}
+		_, err = c.out.Write(data)
+		return err
+	}
+}
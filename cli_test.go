@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/handlers"
+	"github.com/tbellembois/gobkm/models"
+	"github.com/tbellembois/gobkm/types"
+)
+
+// newTestClient returns a client of the handlers of a new database
+// with the folders IT and IT/Development, and its output.
+func newTestClient(t *testing.T) (*client, *bytes.Buffer) {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ds, err := models.NewDBstore(filepath.Join(dir, "bkm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ds.Close() })
+	ds.CreateDatabase()
+	it := &types.Folder{Title: "IT"}
+	it.Id = int(ds.SaveFolder(it))
+	ds.SaveFolder(&types.Folder{Title: "Development", Parent: it})
+	if err = ds.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	handleAPI(mux, &handlers.Env{DB: ds})
+	out := &bytes.Buffer{}
+	return &client{http: &http.Client{Transport: localTransport{mux}}, baseURL: "http://gobkm", out: out}, out
+}
+
+// runTestCommand runs the command name with the given arguments with c.
+func runTestCommand(c *client, name string, args ...string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	run := commands[name].flags(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	return run(c, args)
+}
+
+// lsJSON returns the subfolders and bookmarks titles of the folder path.
+func lsJSON(t *testing.T, c *client, out *bytes.Buffer, path string) string {
+	out.Reset()
+	c.json = true
+	defer func() { c.json = false }()
+	if err := runTestCommand(c, "ls", path); err != nil {
+		t.Fatalf("ls %s: %v", path, err)
+	}
+	var content struct {
+		Folders   []*types.Folder
+		Bookmarks []*types.Bookmark
+	}
+	if err := json.Unmarshal(out.Bytes(), &content); err != nil {
+		t.Fatal(err)
+	}
+	var items []string
+	for _, f := range content.Folders {
+		items = append(items, f.Title+"/")
+	}
+	for _, b := range content.Bookmarks {
+		items = append(items, b.Title)
+	}
+	return strings.Join(items, " ")
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    []string
+		folder  string
+		wantErr bool
+	}{
+		{args: []string{"a", "-folder", "IT", "b"}, want: []string{"a", "b"}, folder: "IT"},
+		{args: []string{"-folder=IT", "a"}, want: []string{"a"}, folder: "IT"},
+		{args: []string{"a", "--", "-b", "c"}, want: []string{"a", "-b", "c"}},
+		{args: []string{}, want: nil},
+		{args: []string{"-unknown"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		folder := fs.String("folder", "", "")
+		got, err := parseArgs(fs, tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseArgs(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (!reflect.DeepEqual(got, tt.want) || *folder != tt.folder) {
+			t.Errorf("parseArgs(%q) = %q folder %q, want %q folder %q", tt.args, got, *folder, tt.want, tt.folder)
+		}
+	}
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Silencing the usages and errors.
+	stderr := os.Stderr
+	if os.Stderr, err = os.Open(os.DevNull); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { os.Stderr = stderr }()
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"add", nil, 2},
+		{"mv", []string{"a"}, 2},
+		{"ls", []string{"a", "b"}, 2},
+		{"ls", []string{"-unknown"}, 2},
+		{"ls", []string{"-db", filepath.Join(dir, "bkm.db"), "-fragments", "unknown"}, 1},
+		{"ls", []string{"-db", filepath.Join(dir, "bkm.db"), "Missing"}, 1},
+	}
+	for _, tt := range tests {
+		if got := runCommand(tt.name, tt.args); got != tt.want {
+			t.Errorf("runCommand(%s, %q) = %d, want %d", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	c, out := newTestClient(t)
+
+	// Adding the bookmarks.
+	c.json = true
+	if err := runTestCommand(c, "add", "https://golang.org/", "-folder", "IT/Development", "-title", "Go", "-tags", "lang,go", "-star"); err != nil {
+		t.Fatal(err)
+	}
+	var added types.AddedBookmark
+	if err := json.Unmarshal(out.Bytes(), &added); err != nil {
+		t.Fatal(err)
+	}
+	if added.Id != 1 || added.Title != "Go" || !reflect.DeepEqual(added.Tags, []string{"lang", "go"}) || !added.Starred {
+		t.Errorf("added bookmark %+v", added.Bookmark)
+	}
+	c.json = false
+	out.Reset()
+	if err := runTestCommand(c, "add", "https://news.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.HasPrefix(got, "ID") || !strings.Contains(got, "2   https://news.example.com/  https://news.example.com/") {
+		t.Errorf("add output:\n%s", got)
+	}
+	if err := runTestCommand(c, "add", "https://example.com/", "-folder", "Missing"); err == nil || err.Error() != "no folder Missing" {
+		t.Errorf("add to a missing folder error %v", err)
+	}
+	if got := lsJSON(t, c, out, "IT/Development"); got != "Go" {
+		t.Errorf("ls IT/Development = %s, want Go", got)
+	}
+
+	// Searching them.
+	searches := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"o"}, "Go https://news.example.com/"},
+		{[]string{"o", "-starred"}, "Go"},
+		{[]string{"o", "-folder", "IT"}, "Go"},
+		{[]string{"o", "-tag", "lang"}, "Go"},
+		{[]string{"o", "-sort", "title", "-desc"}, "https://news.example.com/ Go"},
+	}
+	c.json = true
+	for _, tt := range searches {
+		out.Reset()
+		if err := runTestCommand(c, "search", tt.args...); err != nil {
+			t.Errorf("search %q: %v", tt.args, err)
+			continue
+		}
+		var bkms []*types.Bookmark
+		if err := json.Unmarshal(out.Bytes(), &bkms); err != nil {
+			t.Fatal(err)
+		}
+		var titles []string
+		for _, b := range bkms {
+			titles = append(titles, b.Title)
+		}
+		if got := strings.Join(titles, " "); got != tt.want {
+			t.Errorf("search %q = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+	c.json = false
+
+	// Moving, starring and deleting them by id, URL or path.
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+		path    string // the folder listed after the command
+		ls      string
+	}{
+		{"mv", []string{"IT/Development/Go", "/"}, "", "", "IT/ Go https://news.example.com/"},
+		{"mv", []string{"https://news.example.com/?utm_source=feed", "IT"}, "", "IT", "Development/ https://news.example.com/"},
+		{"mv", []string{"IT/Development", "/"}, "", "", "Development/ IT/ Go"},
+		{"mv", []string{"IT/Missing", "/"}, "no bookmark or folder IT/Missing", "", ""},
+		{"mv", []string{"/", "IT"}, "the root folder can not be changed", "", ""},
+		{"star", []string{"IT", "-unstar"}, "IT is a folder", "", ""},
+		{"star", []string{"1", "-unstar"}, "", "", "Development/ IT/ Go"},
+		{"rm", []string{"Development"}, "", "", "IT/ Go"},
+		{"star", []string{"99"}, "bookmark or folder not found", "", ""},
+	}
+	for _, tt := range tests {
+		err := runTestCommand(c, tt.name, tt.args...)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s %q: error %v, want %s", tt.name, tt.args, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.name, tt.args, err)
+			continue
+		}
+		if got := lsJSON(t, c, out, tt.path); got != tt.ls {
+			t.Errorf("%s %q: ls %s = %s, want %s", tt.name, tt.args, tt.path, got, tt.ls)
+		}
+	}
+	c.json = true
+	out.Reset()
+	if err := runTestCommand(c, "search", "go"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), `"Starred": true`) {
+		t.Errorf("unstarred bookmark %s", out.String())
+	}
+	c.json = false
+
+	// Several bookmarks of the same title.
+	if err := runTestCommand(c, "add", "https://go.dev/", "-title", "Go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runTestCommand(c, "rm", "Go"); err == nil || err.Error() != "several bookmarks Go, use the bookmark id" {
+		t.Errorf("rm of an ambiguous title error %v", err)
+	}
+}
+
+func TestImportExportCommands(t *testing.T) {
+	c, out := newTestClient(t)
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "bookmarks.html")
+	if err = ioutil.WriteFile(file, []byte(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<DL><p>
+<DT><H3>Languages</H3>
+<DL><p>
+<DT><A HREF="https://golang.org/">Go</A>
+</DL><p>
+<DT><A>no URL</A>
+</DL><p>
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run, then the import.
+	if err = runTestCommand(c, "import", file, "-folder", "IT", "-mode", "merge", "-dry-run"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lsJSON(t, c, out, "IT"); got != "Development/" {
+		t.Errorf("dry run import: ls IT = %s", got)
+	}
+	out.Reset()
+	if err = runTestCommand(c, "import", file, "-folder", "IT", "-mode", "merge"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.HasPrefix(got, "done: 1 folders, 1 bookmarks, 1 malformed entries\n") || !strings.Contains(got, "malformed") {
+		t.Errorf("import output:\n%s", got)
+	}
+	if got := lsJSON(t, c, out, "IT/Languages"); got != "Go" {
+		t.Errorf("ls IT/Languages = %s, want Go", got)
+	}
+	if err = runTestCommand(c, "import", file, "-mode", "unknown"); err == nil {
+		t.Error("import of an unknown mode without error")
+	}
+	if err = runTestCommand(c, "import", filepath.Join(dir, "missing.html")); err == nil {
+		t.Error("import of a missing file without error")
+	}
+
+	// Exporting to the output and to a file.
+	out.Reset()
+	if err = runTestCommand(c, "export", "-format", "json", "-folder", "IT/Languages"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.Contains(got, "https://golang.org/") {
+		t.Errorf("JSON export:\n%s", got)
+	}
+	csvFile := filepath.Join(dir, "bookmarks.csv")
+	if err = runTestCommand(c, "export", "-format", "csv", "-o", csvFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "/IT/Languages") {
+		t.Errorf("CSV export:\n%s", data)
+	}
+	if err = runTestCommand(c, "export", "-format", "pdf"); err == nil {
+		t.Error("export of an unknown format without error")
+	}
+}
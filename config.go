@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tbellembois/gobkm/handlers"
+)
+
+// defaultConfigFile is the configuration file read if it exists
+// when none is given.
+const defaultConfigFile = "./gobkm.toml"
+
+// defaultTLSCert and defaultTLSKey are the files of the generated
+// self-signed certificate when none are given.
+const (
+	defaultTLSCert = "./gobkm.crt"
+	defaultTLSKey  = "./gobkm.key"
+)
+
+// Sources of the settings.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// serverFlags are the server parameters.
+type serverFlags struct {
+	listen          *string
+	port            *string
+	baseURL         *string
+	proxy           *string // deprecated name of baseURL
+	logfile         *string
+	logLevel        *string
+	logFormat       *string
+	debug           *bool
+	db              dbFlags
+	pinboardToken   *string
+	faviconURL      *string
+	fetchTimeout    *time.Duration
+	userAgent       *string
+	backupDir       *string
+	backupInterval  *time.Duration
+	backupDaily     *int
+	backupWeekly    *int
+	backupExports   *string
+	tlsCert         *string
+	tlsKey          *string
+	tlsSelfSigned   *bool
+	httpRedirect    *string
+	readTimeout     *time.Duration
+	writeTimeout    *time.Duration
+	idleTimeout     *time.Duration
+	shutdownTimeout *time.Duration
+}
+
+// addServerFlags defines the server parameters in fs.
+func addServerFlags(fs *flag.FlagSet) *serverFlags {
+	return &serverFlags{
+		listen:          fs.String("listen", "", "the address to listen, such as 127.0.0.1:8080, :port if empty"),
+		port:            fs.String("port", "8080", "the port to listen, when -listen is empty"),
+		baseURL:         fs.String("baseurl", "", "the application URL, behind a proxy for instance, http://localhost:port if empty"),
+		proxy:           fs.String("proxy", "", "deprecated, see -baseurl"),
+		logfile:         fs.String("logfile", "", "log to the given file"),
+		logLevel:        fs.String("loglevel", "error", "the log level: debug, info, warning or error"),
+		logFormat:       fs.String("logformat", "text", "the log format: text or json"),
+		debug:           fs.Bool("debug", false, "debug (verbose log), same as -loglevel debug"),
+		db:              addDBFlags(fs),
+		pinboardToken:   fs.String("pinboardtoken", "", "the auth_token of the Pinboard API, user:token, any token if empty"),
+		faviconURL:      fs.String("faviconurl", handlers.DefaultFaviconURL, "the favicon service URL, followed by the bookmark scheme and host, no favicon fetching if empty"),
+		fetchTimeout:    fs.Duration("fetchtimeout", 10*time.Second, "the timeout of the favicon fetching"),
+		userAgent:       fs.String("useragent", "GoBkm", "the User-Agent of the favicon fetching"),
+		backupDir:       fs.String("backupdir", "", "take scheduled compressed snapshots of the database into this directory"),
+		backupInterval:  fs.Duration("backupinterval", 24*time.Hour, "the delay between two snapshots"),
+		backupDaily:     fs.Int("backupdaily", 7, "the number of days with a kept snapshot"),
+		backupWeekly:    fs.Int("backupweekly", 4, "the number of weeks with a kept snapshot"),
+		backupExports:   fs.String("backupexports", "", "comma separated export formats written with each snapshot, such as html,json"),
+		tlsCert:         fs.String("tlscert", "", "serve HTTPS with this certificate file, reloaded on SIGHUP"),
+		tlsKey:          fs.String("tlskey", "", "the private key file of -tlscert"),
+		tlsSelfSigned:   fs.Bool("tlsselfsigned", false, "generate a self-signed certificate if -tlscert and -tlskey are missing, ./gobkm.crt and ./gobkm.key if empty"),
+		httpRedirect:    fs.String("httpredirect", "", "with HTTPS, redirect the HTTP requests to this address, such as :80, to HTTPS"),
+		readTimeout:     fs.Duration("readtimeout", time.Minute, "the timeout of the request reading, body included, none if 0"),
+		writeTimeout:    fs.Duration("writetimeout", 2*time.Minute, "the timeout of the response writing, none if 0"),
+		idleTimeout:     fs.Duration("idletimeout", 2*time.Minute, "the timeout of the idle keep-alive connections"),
+		shutdownTimeout: fs.Duration("shutdowntimeout", 30*time.Second, "the delay to drain the requests and background jobs on SIGINT or SIGTERM"),
+	}
+}
+
+// resolve computes the listen address, base URL and certificate files left empty,
+// from the port and the TLS parameters.
+func (sf *serverFlags) resolve() error {
+	if *sf.tlsSelfSigned {
+		if *sf.tlsCert == "" {
+			*sf.tlsCert = defaultTLSCert
+		}
+		if *sf.tlsKey == "" {
+			*sf.tlsKey = defaultTLSKey
+		}
+	}
+	if (*sf.tlsCert == "") != (*sf.tlsKey == "") {
+		return errors.New("-tlscert and -tlskey go together")
+	}
+	if *sf.httpRedirect != "" && *sf.tlsCert == "" {
+		return errors.New("-httpredirect needs HTTPS")
+	}
+	if *sf.listen == "" {
+		*sf.listen = ":" + *sf.port
+	}
+	if *sf.baseURL == "" {
+		*sf.baseURL = *sf.proxy
+	}
+	if *sf.baseURL == "" {
+		host, port, err := net.SplitHostPort(*sf.listen)
+		if err != nil {
+			return err
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		scheme := "http://"
+		if *sf.tlsCert != "" {
+			scheme = "https://"
+		}
+		*sf.baseURL = scheme + net.JoinHostPort(host, port)
+	}
+	*sf.baseURL = strings.TrimSuffix(*sf.baseURL, "/")
+	return nil
+}
+
+// addConfigFlag defines the configuration file parameter in fs.
+func addConfigFlag(fs *flag.FlagSet) {
+	fs.String("config", "", "the TOML configuration file, "+defaultConfigFile+" if it exists")
+}
+
+// envName returns the environment variable of the flag name.
+func envName(name string) string {
+	return "GOBKM_" + strings.ToUpper(name)
+}
+
+// configValue returns the string of a TOML value, without trailing comment.
+// The arrays are joined with commas.
+func configValue(v string) (string, error) {
+	var (
+		s    string
+		rest string
+	)
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := -1
+		for i := 1; i < len(v); i++ {
+			if v[i] == '\\' {
+				i++
+			} else if v[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		var err error
+		if s, err = strconv.Unquote(v[:end+1]); err != nil {
+			return "", err
+		}
+		rest = v[end+1:]
+	case strings.HasPrefix(v, "'"):
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		s, rest = v[1:end+1], v[end+2:]
+	case strings.HasPrefix(v, "["):
+		end := strings.LastIndex(v, "]")
+		if end < 0 {
+			return "", errors.New("unterminated array")
+		}
+		var items []string
+		for _, item := range strings.Split(v[1:end], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			item, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		s, rest = strings.Join(items, ","), v[end+1:]
+	default:
+		if i := strings.Index(v, "#"); i >= 0 {
+			v = v[:i]
+		}
+		if s = strings.TrimSpace(v); s == "" {
+			return "", errors.New("missing value")
+		}
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", errors.New("unexpected " + rest)
+	}
+	return s, nil
+}
+
+// parseConfig parses the TOML subset of the configuration files:
+// key = value lines, # comments and [table] headers, the keys of a table
+// being prefixed by its name, dir in [backup] being backupdir.
+// The values are strings, numbers, booleans, or arrays joined with commas.
+func parseConfig(data []byte) (map[string]string, error) {
+	var (
+		settings = make(map[string]string)
+		table    string
+	)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated table", i+1)
+			}
+			table = strings.TrimSpace(line[1:end])
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expecting key = value", i+1)
+		}
+		key := table + strings.TrimSpace(line[:eq])
+		value, err := configValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if _, ok := settings[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate %s", i+1, key)
+		}
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// applySettings sets the flags of fs not given on the command line
+// from the GOBKM_{NAME} environment variables, then from the configuration file,
+// and returns the source of each flag value.
+// With strict, the configuration file settings must all be flags of fs.
+func applySettings(fs *flag.FlagSet, strict bool) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) { sources[f.Name] = sourceDefault })
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = sourceFlag })
+
+	// Reading the configuration file.
+	path := fs.Lookup("config").Value.String()
+	if v, ok := os.LookupEnv(envName("config")); ok && sources["config"] != sourceFlag {
+		path, sources["config"] = v, sourceEnv
+	}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+	settings := make(map[string]string)
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if settings, err = parseConfig(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		fs.Set("config", path)
+	case explicit || !os.IsNotExist(err):
+		return nil, err
+	default:
+		err = nil
+	}
+	if strict {
+		for name := range settings {
+			if fs.Lookup(name) == nil || name == "config" {
+				return nil, fmt.Errorf("%s: unknown setting %s", path, name)
+			}
+		}
+	}
+
+	// Setting the flags.
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || sources[f.Name] == sourceFlag || f.Name == "config" {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err = fs.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), err)
+			}
+			sources[f.Name] = sourceEnv
+		} else if v, ok := settings[f.Name]; ok {
+			if err = fs.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("%s: %s: %v", path, f.Name, err)
+			}
+			sources[f.Name] = sourceFile
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// runConfigCommand runs the config command of args and returns the exit status.
+// config print shows the effective server settings in the configuration file format.
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: gobkm config print [flags]")
+		return 2
+	}
+	fs := flag.NewFlagSet("gobkm config print", flag.ContinueOnError)
+	addConfigFlag(fs)
+	sf := addServerFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	sources, err := applySettings(fs, true)
+	if err == nil {
+		err = sf.resolve()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gobkm config:", err)
+		return 1
+	}
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		value := f.Value.String()
+		switch {
+		case strings.Contains(name, "token") && value != "":
+			value = `"********"`
+		case name == "config" || name == "proxy":
+			continue
+		default:
+			switch f.Value.(flag.Getter).Get().(type) {
+			case bool, int:
+			default:
+				value = strconv.Quote(value)
+			}
+		}
+		fmt.Printf("%s = %s # %s\n", name, value, sources[name])
+	}
+	if path := fs.Lookup("config").Value.String(); path != "" {
+		fmt.Printf("# configuration file %s\n", path)
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    string
+		wantErr bool
+	}{
+		{v: `"bkm.db"`, want: "bkm.db"},
+		{v: `"a # b" # comment`, want: "a # b"},
+		{v: `"a \"b\""`, want: `a "b"`},
+		{v: `'C:\gobkm#1'`, want: `C:\gobkm#1`},
+		{v: `8081 # the port`, want: "8081"},
+		{v: `true`, want: "true"},
+		{v: `["html", 'json', csv]`, want: "html,json,csv"},
+		{v: `["a#1", "b"] # formats`, want: "a#1,b"},
+		{v: `[]`, want: ""},
+		{v: `"unterminated`, wantErr: true},
+		{v: `'unterminated`, wantErr: true},
+		{v: `["a", "b"`, wantErr: true},
+		{v: `"a" b`, wantErr: true},
+		{v: `# comment`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := configValue(tt.v)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("configValue(%q) error = %v, want error %v", tt.v, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("configValue(%q) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "keys and comments",
+			data: "# GoBkm\n\nport = 8081 # the port\n  db = \"bkm.db\"\n",
+			want: map[string]string{"port": "8081", "db": "bkm.db"},
+		},
+		{
+			name: "tables",
+			data: "debug = true\n[backup]\ndir = \"/var/backups\"\nexports = [\"html\", \"json\"]\n[ tls ]\ncert = 'cert.pem'\n",
+			want: map[string]string{"debug": "true", "backupdir": "/var/backups", "backupexports": "html,json", "tlscert": "cert.pem"},
+		},
+		{
+			name: "empty",
+			data: "\n# nothing\n",
+			want: map[string]string{},
+		},
+		{
+			name:    "duplicate key",
+			data:    "port = 8081\nport = 8082\n",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate key in a table",
+			data:    "backupdir = \"a\"\n[backup]\ndir = \"b\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing equal",
+			data:    "port 8081\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated table",
+			data:    "[backup\ndir = \"a\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			data:    "db = \"bkm.db\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseConfig([]byte(tt.data))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseConfig error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseConfig = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/tbellembois/gobkm/models"
+)
+
+// dbCommand is a database administration subcommand, working on the local database.
+type dbCommand struct {
+	args  string // the arguments synopsis
+	help  string
+	nArgs int
+	// flags defines the command flags in fs and returns the command.
+	flags func(fs *flag.FlagSet) func(ds *models.SQLiteDataStore, args []string) error
+}
+
+// dbCommands are the database administration subcommands by name.
+var dbCommands = map[string]dbCommand{
+	"check":   {"", "check the database consistency", 0, dbCheckCommand},
+	"backup":  {"<file>", "copy the database into a file, while in use", 1, dbBackupCommand},
+	"restore": {"<file>", "replace the database content by a backup", 1, dbRestoreCommand},
+	"vacuum":  {"", "rebuild the database file, reclaiming the free space", 0, dbVacuumCommand},
+}
+
+// dbUsage prints the database commands usage.
+func dbUsage() {
+	fmt.Fprintln(os.Stderr, "usage: gobkm db <command> [flags] [arguments]")
+	for _, name := range []string{"check", "backup", "restore", "vacuum"} {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, dbCommands[name].help)
+	}
+}
+
+// runDBCommand runs the database command of args and returns the exit status.
+func runDBCommand(args []string) int {
+	if len(args) == 0 {
+		dbUsage()
+		return 2
+	}
+	name := args[0]
+	cmd, ok := dbCommands[name]
+	if !ok {
+		dbUsage()
+		return 2
+	}
+	fs := flag.NewFlagSet("gobkm db "+name, flag.ContinueOnError)
+	debug := fs.Bool("debug", false, "debug (verbose log)")
+	dbf := addDBFlags(fs)
+	addConfigFlag(fs)
+	run := cmd.flags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: gobkm db %s [flags] %s\n%s\n", name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+
+	args, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return 2
+	}
+	if len(args) != cmd.nArgs {
+		fs.Usage()
+		return 2
+	}
+	if _, err = applySettings(fs, false); err != nil {
+		fmt.Fprintln(os.Stderr, "gobkm db:", err)
+		return 1
+	}
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.FatalLevel)
+	}
+
+	// Only a restore creates the database.
+	if _, err = os.Stat(*dbf.path); err != nil && name != "restore" {
+		fmt.Fprintln(os.Stderr, "gobkm db:", err)
+		return 1
+	}
+	ds, err := dbf.openDatastore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gobkm db:", err)
+		return 1
+	}
+	defer ds.Close()
+	if err = run(ds, args); err != nil {
+		fmt.Fprintln(os.Stderr, "gobkm db "+name+":", err)
+		return 1
+	}
+	return 0
+}
+
+// dbCheckCommand checks the database, repairing it with -repair.
+func dbCheckCommand(fs *flag.FlagSet) func(ds *models.SQLiteDataStore, args []string) error {
+	repair := fs.Bool("repair", false, "repair the problems found, but the SQLite corruption")
+	jsonOutput := fs.Bool("json", false, "JSON output")
+	return func(ds *models.SQLiteDataStore, args []string) error {
+		problems := ds.CheckDatabase(*repair)
+		if err := ds.FlushErrors(); err != nil {
+			return err
+		}
+
+		if *jsonOutput {
+			if problems == nil {
+				problems = []models.Problem{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(problems); err != nil {
+				return err
+			}
+		} else if len(problems) != 0 {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "CHECK\tID\tDETAIL")
+			for _, p := range problems {
+				fmt.Fprintf(tw, "%s\t%d\t%s\n", p.Check, p.Id, p.Detail)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+
+		// The corruption is never repaired.
+		remaining := len(problems)
+		if *repair {
+			remaining = 0
+			for _, p := range problems {
+				if p.Check == models.CheckIntegrity {
+					remaining++
+				}
+			}
+		}
+		if remaining != 0 {
+			return errors.New(strconv.Itoa(remaining) + " problems not repaired")
+		}
+		return nil
+	}
+}
+
+// dbBackupCommand copies the database into a file.
+func dbBackupCommand(fs *flag.FlagSet) func(ds *models.SQLiteDataStore, args []string) error {
+	return func(ds *models.SQLiteDataStore, args []string) error {
+		ds.Backup(args[0])
+		return ds.FlushErrors()
+	}
+}
+
+// gunzipFile decompresses the file path into a temporary file and returns its name.
+func gunzipFile(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return "", err
+	}
+	dst, err := ioutil.TempFile("", "gobkm-restore-")
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(dst, gz); err == nil {
+		err = dst.Close()
+	} else {
+		dst.Close()
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// dbRestoreCommand replaces the database content by a backup,
+// such as a compressed scheduled snapshot.
+func dbRestoreCommand(fs *flag.FlagSet) func(ds *models.SQLiteDataStore, args []string) error {
+	return func(ds *models.SQLiteDataStore, args []string) error {
+		path := args[0]
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".gz") {
+			tmp, err := gunzipFile(path)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(tmp)
+			path = tmp
+		}
+		ds.Restore(path)
+		if err := ds.FlushErrors(); err != nil {
+			return err
+		}
+		// An older backup is migrated to the current schema.
+		ds.CreateDatabase()
+		return ds.FlushErrors()
+	}
+}
+
+// dbVacuumCommand rebuilds the database file.
+func dbVacuumCommand(fs *flag.FlagSet) func(ds *models.SQLiteDataStore, args []string) error {
+	return func(ds *models.SQLiteDataStore, args []string) error {
+		ds.Vacuum()
+		return ds.FlushErrors()
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tbellembois/gobkm/models"
+	"github.com/tbellembois/gobkm/types"
+)
+
+// runTestDBCommand runs the database command of args
+// and returns its exit status and standard output, its errors silenced.
+func runTestDBCommand(t *testing.T, args ...string) (int, string) {
+	out, err := ioutil.TempFile("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = out, devNull
+	status := runDBCommand(args)
+	os.Stdout, os.Stderr = stdout, stderr
+
+	out.Close()
+	data, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, string(data)
+}
+
+// dbTitles returns the bookmarks titles of the database path.
+func dbTitles(t *testing.T, path string) []string {
+	ds, err := models.NewDBstore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	var titles []string
+	for _, bkm := range ds.QueryBookmarks(types.BookmarkQuery{}) {
+		titles = append(titles, bkm.Title)
+	}
+	if err = ds.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	return titles
+}
+
+func TestDBCommandUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	missing := filepath.Join(dir, "missing.db")
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, 2},
+		{[]string{"unknown"}, 2},
+		{[]string{"check", "extra"}, 2},
+		{[]string{"backup"}, 2},
+		{[]string{"check", "-unknown"}, 2},
+		// Only a restore creates the database.
+		{[]string{"check", "-db", missing}, 1},
+		{[]string{"vacuum", "-db", missing}, 1},
+	}
+	for _, tt := range tests {
+		if got, _ := runTestDBCommand(t, tt.args...); got != tt.want {
+			t.Errorf("runDBCommand(%q) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+	if _, err = os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("missing database created: %v", err)
+	}
+}
+
+func TestDBCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bkm.db")
+	backup := filepath.Join(dir, "backup.db")
+
+	// A new database with its sample bookmark, backed up.
+	if status, out := runTestDBCommand(t, "restore", "-db", path, filepath.Join(dir, "none.db")); status != 1 || out != "" {
+		t.Errorf("restore of a missing backup: %d %q", status, out)
+	}
+	tests := []struct {
+		args   []string
+		status int
+		out    string
+	}{
+		{[]string{"check", "-db", path}, 0, ""},
+		{[]string{"check", "-db", path, "-json"}, 0, "[]\n"},
+		{[]string{"backup", "-db", path, backup}, 0, ""},
+		{[]string{"vacuum", "-db", path}, 0, ""},
+	}
+	for _, tt := range tests {
+		if status, out := runTestDBCommand(t, tt.args...); status != tt.status || out != tt.out {
+			t.Errorf("%q: %d %q, want %d %q", tt.args, status, out, tt.status, tt.out)
+		}
+	}
+	if got := dbTitles(t, backup); len(got) != 1 || got[0] != "GoLang" {
+		t.Fatalf("backup bookmarks %v", got)
+	}
+
+	// A dangling favicon, found then repaired.
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = raw.Exec("UPDATE bookmark SET favicon='https://golang.org/favicon.ico', title='Go'"); err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+	status, out := runTestDBCommand(t, "check", "-db", path, "-json")
+	var problems []models.Problem
+	if err = json.Unmarshal([]byte(out), &problems); err != nil {
+		t.Fatal(err)
+	}
+	if status != 1 || len(problems) != 1 || problems[0].Check != models.CheckFavicon || problems[0].Detail != "Go" {
+		t.Errorf("check: %d %+v", status, problems)
+	}
+	if status, out = runTestDBCommand(t, "check", "-db", path, "-repair"); status != 0 || out == "" {
+		t.Errorf("check -repair: %d %q", status, out)
+	}
+	if status, _ = runTestDBCommand(t, "check", "-db", path); status != 0 {
+		t.Errorf("check of the repaired database: %d", status)
+	}
+
+	// Restoring the backup, then its compressed copy into a new database.
+	if status, _ = runTestDBCommand(t, "restore", "-db", path, backup); status != 0 {
+		t.Errorf("restore: %d", status)
+	}
+	if got := dbTitles(t, path); len(got) != 1 || got[0] != "GoLang" {
+		t.Errorf("restored bookmarks %v", got)
+	}
+	data, err := ioutil.ReadFile(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := os.Create(backup + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(gz)
+	zw.Write(data)
+	zw.Close()
+	gz.Close()
+	newPath := filepath.Join(dir, "new.db")
+	if status, _ = runTestDBCommand(t, "restore", "-db", newPath, backup+".gz"); status != 0 {
+		t.Errorf("restore of a compressed backup: %d", status)
+	}
+	if got := dbTitles(t, newPath); len(got) != 1 || got[0] != "GoLang" {
+		t.Errorf("restored compressed bookmarks %v", got)
+	}
+	if status, _ = runTestDBCommand(t, "restore", "-db", path, path+"-wal.gz"); status != 1 {
+		t.Errorf("restore of a missing compressed backup: %d", status)
+	}
+}
@@ -1,20 +1,19 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/GeertJohan/go.rice"
 	log "github.com/Sirupsen/logrus"
 	"github.com/tbellembois/gobkm/handlers"
+	"github.com/tbellembois/gobkm/metrics"
 	"github.com/tbellembois/gobkm/models"
 )
 
-const (
-	dbURL = "./bkm.db"
-)
-
 var (
 	datastore   *models.SQLiteDataStore
 	templateBox *rice.Box
@@ -30,49 +29,185 @@ func decoratedHandler(h http.Handler) http.Handler {
 	})
 }
 
+// dbFlags are the database parameters of the server and of the local commands.
+type dbFlags struct {
+	path           *string
+	keepScheme     *bool
+	trackingParams *string
+	fragments      *string
+}
+
+// addDBFlags defines the database parameters in fs.
+func addDBFlags(fs *flag.FlagSet) dbFlags {
+	return dbFlags{
+		path:           fs.String("db", "./bkm.db", "the database file"),
+		keepScheme:     fs.Bool("keepscheme", false, "consider the http and https URLs of a page as different bookmarks"),
+		trackingParams: fs.String("trackingparams", strings.Join(models.DefaultCanonicalizer.StripParams, ","), "comma separated URL parameters ignored to find the duplicate bookmarks, a trailing * matches a prefix"),
+		fragments:      fs.String("fragments", models.DefaultCanonicalizer.Fragments, "URL fragments rule to find the duplicate bookmarks: keep, strip, or routes to keep only the #/ and #! routes"),
+	}
+}
+
+// openDatastore opens the database, creating and populating it if needed.
+func (f dbFlags) openDatastore() (*models.SQLiteDataStore, error) {
+	switch *f.fragments {
+	case models.FragmentsKeep, models.FragmentsStrip, models.FragmentsRoutes:
+	default:
+		return nil, errors.New("unknown fragments rule " + *f.fragments)
+	}
+	ds, err := models.NewDBstore(*f.path)
+	if err != nil {
+		return nil, err
+	}
+	ds.Canonicalizer = models.Canonicalizer{
+		KeepScheme:  *f.keepScheme,
+		StripParams: strings.Split(*f.trackingParams, ","),
+		Fragments:   *f.fragments,
+	}
+	// Database creation.
+	ds.CreateDatabase()
+	ds.PopulateDatabase()
+	// Error check.
+	if err = ds.FlushErrors(); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// handleAPI registers the handlers of env into mux.
+func handleAPI(mux *http.ServeMux, env *handlers.Env) {
+	mux.HandleFunc("/getChildrenFolders/", env.GetChildrenFoldersHandler)
+	mux.HandleFunc("/getFolderBookmarks/", env.GetFolderBookmarksHandler)
+	mux.HandleFunc("/moveFolder/", env.MoveFolderHandler)
+	mux.HandleFunc("/moveBookmark/", env.MoveBookmarkHandler)
+	mux.HandleFunc("/sortFolder/", env.SortFolderHandler)
+	mux.HandleFunc("/renameFolder/", env.RenameFolderHandler)
+	mux.HandleFunc("/renameBookmark/", env.RenameBookmarkHandler)
+	mux.HandleFunc("/addFolder/", env.AddFolderHandler)
+	mux.HandleFunc("/addSmartFolder/", env.AddSmartFolderHandler)
+	mux.HandleFunc("/updateSmartFolder/", env.UpdateSmartFolderHandler)
+	mux.HandleFunc("/addBookmark/", env.AddBookmarkHandler)
+	mux.HandleFunc("/deleteFolder/", env.DeleteFolderHandler)
+	mux.HandleFunc("/deleteBookmark/", env.DeleteBookmarkHandler)
+	mux.HandleFunc("/starBookmark/", env.StarBookmarkHandler)
+	mux.HandleFunc("/describeBookmark/", env.DescribeBookmarkHandler)
+	mux.HandleFunc("/keywordBookmark/", env.KeywordBookmarkHandler)
+	mux.HandleFunc("/renderBookmarkDescription/", env.RenderBookmarkDescriptionHandler)
+	mux.HandleFunc("/export/", env.ExportHandler)
+	mux.HandleFunc("/import/", env.ImportHandler)
+	mux.HandleFunc("/getImportJob/", env.GetImportJobHandler)
+	mux.HandleFunc("/cancelImportJob/", env.CancelImportJobHandler)
+	mux.HandleFunc("/getBackupStatus/", env.GetBackupStatusHandler)
+	mux.HandleFunc("/searchBookmarks/", env.SearchBookmarkHandler)
+	mux.HandleFunc("/getBookmarks/", env.GetBookmarksHandler)
+	mux.HandleFunc("/visitBookmark/", env.VisitBookmarkHandler)
+	mux.HandleFunc("/getBookmarkVisits/", env.GetBookmarkVisitsHandler)
+	mux.HandleFunc("/getReadingList/", env.GetReadingListHandler)
+	mux.HandleFunc("/readBookmark/", env.ReadBookmarkHandler)
+	mux.HandleFunc("/archiveBookmark/", env.ArchiveBookmarkHandler)
+	mux.HandleFunc("/readingList.atom", env.ReadingListFeedHandler)
+	mux.HandleFunc("/feeds/recent.atom", env.RecentFeedHandler)
+	mux.HandleFunc("/feeds/starred.atom", env.StarredFeedHandler)
+	mux.HandleFunc("/feeds/folder/", env.FolderFeedHandler)
+	mux.HandleFunc("/go/", env.GoHandler)
+	mux.HandleFunc("/k/", env.KeywordHandler)
+	mux.HandleFunc("/opensearch.xml", env.OpenSearchHandler)
+	mux.HandleFunc("/suggestBookmarks/", env.SuggestBookmarksHandler)
+	mux.HandleFunc("/getDuplicates/", env.GetDuplicatesHandler)
+	mux.HandleFunc("/mergeDuplicates/", env.MergeDuplicatesHandler)
+	mux.HandleFunc("/batch/", env.BatchHandler)
+	mux.HandleFunc("/addShare/", env.AddShareHandler)
+	mux.HandleFunc("/getShares/", env.GetSharesHandler)
+	mux.HandleFunc("/deleteShare/", env.DeleteShareHandler)
+	mux.HandleFunc("/share/", env.ShareHandler)
+	mux.HandleFunc("/dav/", env.DAVHandler)
+	mux.HandleFunc("/index.php/apps/bookmarks/public/rest/v2/", env.NextcloudHandler)
+	mux.HandleFunc("/api/", env.LinkdingHandler)
+	mux.HandleFunc("/v1/", env.PinboardHandler)
+	// observability handlers
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", env.HealthzHandler)
+	mux.HandleFunc("/readyz", env.ReadyzHandler)
+	// websocket handler
+	mux.HandleFunc("/socket/", env.SocketHandler)
+	// bookmarklet handler
+	mux.HandleFunc("/bookmarkThis/", env.BookmarkThisHandler)
+	//mux.HandleFunc("/bookmarkThis2/", env.BookmarkThis2Handler)
+	mux.HandleFunc("/addBookmarkBookmarklet/", env.AddBookmarkBookmarkletHandler)
+	mux.HandleFunc("/", env.MainHandler)
+}
+
 func main() {
-	// Getting the program parameters.
-	listenPort := flag.String("port", "8080", "the port to listen")
-	goBkmProxyURL := flag.String("proxy", "http://localhost:"+*listenPort, "the proxy full URL if used")
-	logfile := flag.String("logfile", "", "log to the given file")
-	debug := flag.Bool("debug", false, "debug (verbose log), default is error")
+	// Running a command-line client command instead of the server.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "db":
+			os.Exit(runDBCommand(os.Args[2:]))
+		case "config":
+			os.Exit(runConfigCommand(os.Args[2:]))
+		}
+		if _, ok := commands[os.Args[1]]; ok {
+			os.Exit(runCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
+	// Getting the program parameters,
+	// from the command line, the environment and the configuration file.
+	addConfigFlag(flag.CommandLine)
+	sf := addServerFlags(flag.CommandLine)
 	flag.Parse()
+	if _, err = applySettings(flag.CommandLine, true); err != nil {
+		log.Fatal(err)
+	}
+	if err = sf.resolve(); err != nil {
+		log.Fatal(err)
+	}
 
 	// Logging to file if logfile parameter specified.
-	if *logfile != "" {
-		if logf, err = os.OpenFile(*logfile, os.O_WRONLY|os.O_CREATE, 0755); err != nil {
+	if *sf.logfile != "" {
+		if logf, err = os.OpenFile(*sf.logfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
 			log.Panic(err)
 		} else {
 			log.SetOutput(logf)
 		}
 	}
-	// Setting the log level.
-	if *debug {
-		log.SetLevel(log.DebugLevel)
-	} else {
-		log.SetLevel(log.ErrorLevel)
+	// Setting the log level and format.
+	level, err := log.ParseLevel(*sf.logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *sf.debug {
+		level = log.DebugLevel
+	}
+	log.SetLevel(level)
+	switch *sf.logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Fatal("unknown log format " + *sf.logFormat)
 	}
 	log.WithFields(log.Fields{
-		"listenPort": *listenPort,
-		"goBkmURL":   *goBkmProxyURL,
-		"logfile":    *logfile,
-		"debug":      *debug,
+		"listen":  *sf.listen,
+		"baseURL": *sf.baseURL,
+		"logfile": *sf.logfile,
+		"db":      *sf.db.path,
+		"tlsCert": *sf.tlsCert,
 	}).Debug("main:flags")
 
 	// Database initialization.
-	if datastore, err = models.NewDBstore(dbURL); err != nil {
-		log.Panic(err)
-	}
-	// Database creation.
-	datastore.CreateDatabase()
-	datastore.PopulateDatabase()
-	// Error check.
-	if datastore.FlushErrors() != nil {
-		log.Panic(err)
+	if datastore, err = sf.db.openDatastore(); err != nil {
+		log.Fatal(err)
 	}
 
 	// Environment creation.
-	env := handlers.Env{DB: datastore, GoBkmProxyURL: *goBkmProxyURL}
+	env := handlers.Env{
+		DB:            models.NewMetricsDatastore(datastore),
+		GoBkmProxyURL: *sf.baseURL,
+		PinboardToken: *sf.pinboardToken,
+		FaviconURL:    *sf.faviconURL,
+		FetchTimeout:  *sf.fetchTimeout,
+		UserAgent:     *sf.userAgent,
+	}
 	// Building a rice box with the static directory.
 	if templateBox, err = rice.FindBox("static"); err != nil {
 		log.Fatal(err)
@@ -84,29 +219,24 @@ func main() {
 	if env.TplAddBookmarkData, err = templateBox.String("addBookmark.html"); err != nil {
 		log.Fatal(err)
 	}
+	if env.TplShareData, err = templateBox.String("share.html"); err != nil {
+		log.Fatal(err)
+	}
 
-	// Handlers initialization.
-	http.HandleFunc("/getChildrenFolders/", env.GetChildrenFoldersHandler)
-	http.HandleFunc("/getFolderBookmarks/", env.GetFolderBookmarksHandler)
-	http.HandleFunc("/moveFolder/", env.MoveFolderHandler)
-	http.HandleFunc("/moveBookmark/", env.MoveBookmarkHandler)
-	http.HandleFunc("/renameFolder/", env.RenameFolderHandler)
-	http.HandleFunc("/renameBookmark/", env.RenameBookmarkHandler)
-	http.HandleFunc("/addFolder/", env.AddFolderHandler)
-	http.HandleFunc("/addBookmark/", env.AddBookmarkHandler)
-	http.HandleFunc("/deleteFolder/", env.DeleteFolderHandler)
-	http.HandleFunc("/deleteBookmark/", env.DeleteBookmarkHandler)
-	http.HandleFunc("/starBookmark/", env.StarBookmarkHandler)
-	http.HandleFunc("/export/", env.ExportHandler)
-	http.HandleFunc("/import/", env.ImportHandler)
-	http.HandleFunc("/searchBookmarks/", env.SearchBookmarkHandler)
-	// websocket handler
-	http.HandleFunc("/socket/", env.SocketHandler)
-	// bookmarklet handler
-	http.HandleFunc("/bookmarkThis/", env.BookmarkThisHandler)
-	//http.HandleFunc("/bookmarkThis2/", env.BookmarkThis2Handler)
-	http.HandleFunc("/addBookmarkBookmarklet/", env.AddBookmarkBookmarkletHandler)
-	http.HandleFunc("/", env.MainHandler)
+	// Scheduled backups.
+	if *sf.backupDir != "" {
+		cfg := handlers.BackupConfig{Dir: *sf.backupDir, Interval: *sf.backupInterval, Daily: *sf.backupDaily, Weekly: *sf.backupWeekly}
+		if *sf.backupExports != "" {
+			cfg.Exports = strings.Split(*sf.backupExports, ",")
+		}
+		if err = env.StartBackups(cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Handlers and metrics initialization.
+	handleAPI(http.DefaultServeMux, &env)
+	env.RegisterMetrics()
 
 	// Rice boxes initialization.
 	// Awesome fonts may need to send the Access-Control-Allow-Origin header to "*"
@@ -130,7 +260,7 @@ func main() {
 	manifestFileServer := http.StripPrefix("/manifest/", http.FileServer(manifestBox.HTTPBox()))
 	http.Handle("/manifest/", manifestFileServer)
 
-	if err = http.ListenAndServe(":"+*listenPort, nil); err != nil {
+	if err = serve(sf); err != nil {
 		log.Fatal(err)
 	}
 }
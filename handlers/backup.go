@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The backup files are named bkm-{UTC time}.db.gz for the database snapshot
+// and bkm-{UTC time}.{extension}.gz for the exports written with it.
+const (
+	backupPrefix     = "bkm-"
+	backupTimeFormat = "20060102-150405"
+	backupDBSuffix   = ".db.gz"
+)
+
+var (
+	// backupStatus is the state of the scheduled backups.
+	backupStatus      types.BackupStatus
+	backupStatusMutex sync.Mutex
+)
+
+// BackupConfig is the configuration of the scheduled backups.
+type BackupConfig struct {
+	Dir      string        // the backup files directory
+	Interval time.Duration // the delay between two snapshots
+	Daily    int           // the number of days with a kept snapshot, the newest of the day
+	Weekly   int           // the number of weeks with a kept snapshot, the newest of the week
+	Exports  []string      // the export formats written with each snapshot
+}
+
+// snapshot is a database snapshot file.
+type snapshot struct {
+	name string
+	t    time.Time
+}
+
+// listSnapshots returns the database snapshots of dir, newest first.
+func listSnapshots(dir string) ([]snapshot, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []snapshot
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupDBSuffix) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupDBSuffix), time.UTC)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{name: name, t: t})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].t.After(snapshots[j].t) })
+	return snapshots, nil
+}
+
+// snapshotNames returns the names of the given snapshots.
+func snapshotNames(snapshots []snapshot) []string {
+	names := []string{}
+	for _, s := range snapshots {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// writeGzip writes the compressed output of write into the file path,
+// replacing it only once complete.
+func writeGzip(path string, write func(io.Writer) error) error {
+	part := path + ".part"
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	if err = write(gz); err == nil {
+		if err = gz.Close(); err == nil {
+			err = f.Sync()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, path)
+}
+
+// backup writes the database snapshot of the time t and its exports into cfg.Dir.
+// The snapshot is written last, a snapshot file having all its exports.
+func (env *Env) backup(cfg BackupConfig, t time.Time) error {
+	name := filepath.Join(cfg.Dir, backupPrefix+t.UTC().Format(backupTimeFormat))
+
+	// Consistent copy of the database, compressed after.
+	tmp := name + ".db.tmp"
+	defer os.Remove(tmp)
+	env.DB.Backup(tmp)
+	if err := env.DB.FlushErrors(); err != nil {
+		return err
+	}
+
+	if len(cfg.Exports) != 0 {
+		eb := env.buildExportTree(env.DB.GetFolder(1))
+		if err := env.DB.FlushErrors(); err != nil {
+			return err
+		}
+		for _, format := range cfg.Exports {
+			f := exportFormats[format]
+			if err := writeGzip(name+"."+f.extension+".gz", func(w io.Writer) error { return f.write(w, eb) }); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writeGzip(name+backupDBSuffix, func(w io.Writer) error {
+		src, err := os.Open(tmp)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(w, src)
+		return err
+	})
+}
+
+// pruneSnapshots deletes the snapshots and their exports not kept by the
+// cfg.Daily and cfg.Weekly rules, the newest snapshot being always kept,
+// and returns the kept snapshots.
+func pruneSnapshots(cfg BackupConfig) ([]snapshot, error) {
+	snapshots, err := listSnapshots(cfg.Dir)
+	if err != nil || len(snapshots) == 0 {
+		return snapshots, err
+	}
+
+	var (
+		kept  []snapshot
+		days  = make(map[string]bool)
+		weeks = make(map[string]bool)
+	)
+	for i, s := range snapshots {
+		keep := i == 0
+		if day := s.t.Format("2006-01-02"); !days[day] && len(days) < cfg.Daily {
+			days[day], keep = true, true
+		}
+		year, w := s.t.ISOWeek()
+		if week := fmt.Sprintf("%d-%d", year, w); !weeks[week] && len(weeks) < cfg.Weekly {
+			weeks[week], keep = true, true
+		}
+		if keep {
+			kept = append(kept, s)
+			continue
+		}
+
+		// Deleting the snapshot with its exports.
+		files, err := filepath.Glob(filepath.Join(cfg.Dir, strings.TrimSuffix(s.name, backupDBSuffix)+".*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if err = os.Remove(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return kept, nil
+}
+
+// runBackup takes the snapshot of the time now, with its own datastore session,
+// and prunes the old ones, updating the backup status and sending it to the websocket client.
+func (env *Env) runBackup(cfg BackupConfig, now time.Time) {
+	err := env.session().backup(cfg, now)
+	kept, pruneErr := pruneSnapshots(cfg)
+	if err == nil {
+		err = pruneErr
+	}
+
+	backupStatusMutex.Lock()
+	backupStatus.LastAttempt = now
+	backupStatus.Error = ""
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("runBackup")
+		backupStatus.Error = err.Error()
+	} else {
+		backupStatus.LastSuccess = now
+	}
+	if pruneErr == nil {
+		backupStatus.Snapshots = snapshotNames(kept)
+	}
+	status := backupStatus
+	backupStatusMutex.Unlock()
+
+	if err = sendMessage(types.Message{Type: types.MessageBackup, Backup: &status}); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Debug("runBackup:sendMessage")
+	}
+}
+
+// runBackups takes a snapshot at next then every cfg.Interval, until shutdown.
+// A snapshot in progress is a background job awaited by Shutdown.
+func (env *Env) runBackups(cfg BackupConfig, next time.Time) {
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-shutdown:
+			timer.Stop()
+			return
+		}
+		if !startBackground() {
+			return
+		}
+		now := time.Now()
+		next = now.Add(cfg.Interval)
+		env.runBackup(cfg, now)
+		background.Done()
+	}
+}
+
+// StartBackups checks the given configuration and starts the scheduled backups
+// in the background, the first one being due one interval after the newest
+// snapshot of cfg.Dir.
+func (env *Env) StartBackups(cfg BackupConfig) error {
+	log.WithFields(log.Fields{
+		"cfg": cfg,
+	}).Debug("StartBackups")
+
+	// Configuration check.
+	if cfg.Interval <= 0 {
+		return errors.New("invalid backup interval")
+	}
+	for _, format := range cfg.Exports {
+		if _, ok := exportFormats[format]; !ok {
+			return errors.New("unknown backup export format " + format)
+		}
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return err
+	}
+	snapshots, err := listSnapshots(cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	next := time.Now()
+	backupStatusMutex.Lock()
+	backupStatus = types.BackupStatus{Enabled: true, Snapshots: snapshotNames(snapshots)}
+	if len(snapshots) != 0 {
+		backupStatus.LastSuccess = snapshots[0].t.Local()
+		next = snapshots[0].t.Add(cfg.Interval)
+	}
+	backupStatusMutex.Unlock()
+
+	go env.runBackups(cfg, next)
+	return nil
+}
+
+// GetBackupStatusHandler returns the state of the scheduled backups.
+func (env *Env) GetBackupStatusHandler(w http.ResponseWriter, r *http.Request) {
+	backupStatusMutex.Lock()
+	status := backupStatus
+	backupStatusMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		failHTTP(w, "GetBackupStatusHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
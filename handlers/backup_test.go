@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPruneSnapshots(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   BackupConfig
+		files []string
+		kept  []string // the remaining files
+	}{
+		{
+			name: "newest of the days and weeks",
+			cfg:  BackupConfig{Daily: 2, Weekly: 2},
+			files: []string{
+				"bkm-20261018-120000.db.gz", // Sunday of the ISO week 42
+				"bkm-20261018-080000.db.gz",
+				"bkm-20261017-100000.db.gz",
+				"bkm-20261016-100000.db.gz",
+				"bkm-20261016-100000.html.gz",
+				"bkm-20261011-100000.db.gz", // Sunday of the ISO week 41
+				"bkm-20261005-100000.db.gz",
+				"bkm-20261004-100000.db.gz", // Sunday of the ISO week 40
+				"notes.txt",
+			},
+			kept: []string{
+				"bkm-20261011-100000.db.gz",
+				"bkm-20261017-100000.db.gz",
+				"bkm-20261018-120000.db.gz",
+				"notes.txt",
+			},
+		},
+		{
+			name: "newest always kept",
+			cfg:  BackupConfig{},
+			files: []string{
+				"bkm-20261018-120000.db.gz",
+				"bkm-20261018-120000.json.gz",
+				"bkm-20261017-100000.db.gz",
+				"bkm-20261017-100000.json.gz",
+			},
+			kept: []string{
+				"bkm-20261018-120000.db.gz",
+				"bkm-20261018-120000.json.gz",
+			},
+		},
+		{
+			name: "ISO weeks across the new year",
+			cfg:  BackupConfig{Weekly: 2},
+			files: []string{
+				"bkm-20270102-100000.db.gz", // Saturday of the ISO week 2026-53
+				"bkm-20261228-100000.db.gz", // Monday of the ISO week 2026-53
+				"bkm-20261227-100000.db.gz", // Sunday of the ISO week 2026-52
+				"bkm-20261220-100000.db.gz",
+			},
+			kept: []string{
+				"bkm-20261227-100000.db.gz",
+				"bkm-20270102-100000.db.gz",
+			},
+		},
+		{
+			name:  "no snapshot",
+			cfg:   BackupConfig{Daily: 7, Weekly: 4},
+			files: []string{"bkm-invalid.db.gz"},
+			kept:  []string{"bkm-invalid.db.gz"},
+		},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "gobkm-backup-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for _, f := range tt.files {
+			if err = ioutil.WriteFile(filepath.Join(dir, f), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tt.cfg.Dir = dir
+		if _, err = pruneSnapshots(tt.cfg); err != nil {
+			t.Errorf("%s: pruneSnapshots error %v", tt.name, err)
+			continue
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kept []string
+		for _, f := range files {
+			kept = append(kept, f.Name())
+		}
+		sort.Strings(kept)
+		if !reflect.DeepEqual(kept, tt.kept) {
+			t.Errorf("%s: pruneSnapshots kept %v, want %v", tt.name, kept, tt.kept)
+		}
+	}
+}
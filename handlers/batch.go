@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/tbellembois/gobkm/models"
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// normalizeTags returns the given tags lowered and trimmed, without the empty
+// and repeated ones, and false if one of them contains a comma.
+func normalizeTags(tags []string) ([]string, bool) {
+	var result []string
+	has := make(map[string]bool)
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if strings.Contains(t, ",") {
+			return nil, false
+		}
+		if t != "" && !has[t] {
+			has[t] = true
+			result = append(result, t)
+		}
+	}
+	return result, true
+}
+
+// addTags returns the given tags with the add tags not already there.
+func addTags(tags []string, add []string) []string {
+	for _, a := range add {
+		found := false
+		for _, t := range tags {
+			found = found || t == a
+		}
+		if !found {
+			tags = append(tags, a)
+		}
+	}
+	return tags
+}
+
+// retagBookmarks replaces the tag oldTag of its bookmarks by the tag newTag,
+// the tag being removed if newTag is empty, in a single transaction,
+// and returns the number of retagged bookmarks.
+func (env *Env) retagBookmarks(oldTag string, newTag string) int {
+	// Getting the bookmarks of the tag.
+	bkms := env.DB.QueryBookmarks(types.BookmarkQuery{Tag: oldTag})
+	if len(bkms) == 0 {
+		return 0
+	}
+
+	// Retagging them at once.
+	var ops []types.BatchOperation
+	for _, bkm := range bkms {
+		op := types.BatchOperation{Op: types.BatchTag, BookmarkId: bkm.Id, Untags: []string{oldTag}}
+		if newTag != "" {
+			op.Tags = []string{newTag}
+		}
+		ops = append(ops, op)
+	}
+	env.DB.ApplyBatch(ops)
+	return len(bkms)
+}
+
+// isSubfolder returns true if fld is the folder id or one of its subfolders.
+func isSubfolder(fld *types.Folder, id int) bool {
+	for f := fld; f != nil; f = f.Parent {
+		if f.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBatchOperation normalizes the given batch operation,
+// or fails the request and returns false if it is invalid.
+func (env *Env) checkBatchOperation(w http.ResponseWriter, op *types.BatchOperation) bool {
+	var ok bool
+
+	// One bookmark or one folder, the root folder can not be changed.
+	if (op.BookmarkId == 0) == (op.FolderId == 0) {
+		failHTTP(w, "BatchHandler", op.Op+": one of BookmarkId or FolderId expected", http.StatusBadRequest)
+		return false
+	}
+	if op.FolderId == 1 {
+		failHTTP(w, "BatchHandler", op.Op+": the root folder can not be changed", http.StatusBadRequest)
+		return false
+	}
+
+	switch op.Op {
+	case types.BatchMove:
+		// Smart folders are read-only.
+		if env.readOnlyFolder(w, "BatchHandler", op.DestinationFolderId) {
+			return false
+		}
+		if op.FolderId != 0 && op.DestinationFolderId != 0 {
+			dstFld := env.DB.GetFolder(op.DestinationFolderId)
+			if err := env.DB.FlushErrors(); err != nil {
+				failHTTP(w, "BatchHandler", err.Error(), http.StatusInternalServerError)
+				return false
+			}
+			if isSubfolder(dstFld, op.FolderId) {
+				failHTTP(w, "BatchHandler", "can not move a folder into itself or one of its subfolders", http.StatusBadRequest)
+				return false
+			}
+		}
+	case types.BatchDelete:
+	case types.BatchStar, types.BatchTag:
+		if op.BookmarkId == 0 {
+			failHTTP(w, "BatchHandler", op.Op+": BookmarkId expected", http.StatusBadRequest)
+			return false
+		}
+		if op.Op == types.BatchStar {
+			break
+		}
+		if op.Tags, ok = normalizeTags(op.Tags); ok {
+			op.Untags, ok = normalizeTags(op.Untags)
+		}
+		if !ok {
+			failHTTP(w, "BatchHandler", "tag with a comma", http.StatusBadRequest)
+			return false
+		}
+		if len(op.Tags) == 0 && len(op.Untags) == 0 {
+			failHTTP(w, "BatchHandler", "tag: Tags or Untags expected", http.StatusBadRequest)
+			return false
+		}
+	case types.BatchRename:
+		if op.Title = strings.TrimSpace(op.Title); op.Title == "" {
+			failHTTP(w, "BatchHandler", "rename: Title empty", http.StatusBadRequest)
+			return false
+		}
+	default:
+		failHTTP(w, "BatchHandler", "unknown operation "+op.Op, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// BatchHandler applies the JSON list of types.BatchOperation of the request body,
+// moving, deleting, starring, tagging or renaming bookmarks and folders,
+// in a single transaction: all the operations or none are applied.
+// An operation on a missing item fails the whole batch.
+func (env *Env) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		ops []types.BatchOperation
+	)
+
+	// Parameters check.
+	if err = json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		failHTTP(w, "BatchHandler", "JSON operations decode error", http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{
+		"ops": ops,
+	}).Debug("BatchHandler:Operations")
+	if len(ops) == 0 {
+		failHTTP(w, "BatchHandler", "operations empty", http.StatusBadRequest)
+		return
+	}
+	for i := range ops {
+		if !env.checkBatchOperation(w, &ops[i]) {
+			return
+		}
+	}
+
+	// Applying the operations.
+	env.DB.ApplyBatch(ops)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err == sql.ErrNoRows {
+		failHTTP(w, "BatchHandler", "bookmark or folder not found, nothing done", http.StatusNotFound)
+	} else if err == models.ErrFolderCycle {
+		failHTTP(w, "BatchHandler", err.Error()+", nothing done", http.StatusBadRequest)
+	} else if err != nil {
+		failHTTP(w, "BatchHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestBatchHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		// body is formatted with the a, b and d folders ids, b in a,
+		// and the x bookmark id.
+		body string
+		code int
+		// starred is true if the x bookmark is starred after the batch.
+		starred bool
+	}{
+		{"applied", `[{"Op":"star","BookmarkId":%[4]d,"Starred":true},{"Op":"move","FolderId":%[3]d,"DestinationFolderId":%[1]d}]`, http.StatusOK, true},
+		{"missing last item", `[{"Op":"star","BookmarkId":%[4]d,"Starred":true},{"Op":"delete","FolderId":%[2]d},{"Op":"rename","BookmarkId":999,"Title":"t"}]`, http.StatusNotFound, false},
+		{"folder into its subfolder", `[{"Op":"star","BookmarkId":%[4]d,"Starred":true},{"Op":"move","FolderId":%[1]d,"DestinationFolderId":%[2]d}]`, http.StatusBadRequest, false},
+		{"folders into each other", `[{"Op":"star","BookmarkId":%[4]d,"Starred":true},{"Op":"move","FolderId":%[3]d,"DestinationFolderId":%[2]d},{"Op":"move","FolderId":%[1]d,"DestinationFolderId":%[3]d}]`, http.StatusBadRequest, false},
+		{"root folder", `[{"Op":"star","BookmarkId":%[4]d,"Starred":true},{"Op":"delete","FolderId":1}]`, http.StatusBadRequest, false},
+		{"unknown operation", `[{"Op":"star","BookmarkId":%[4]d,"Starred":true},{"Op":"copy","BookmarkId":%[4]d}]`, http.StatusBadRequest, false},
+		{"empty", `[]`, http.StatusBadRequest, false},
+		{"invalid", `{`, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		env := newTestEnv(t)
+		a := saveFolder(t, env, "a", nil)
+		b := saveFolder(t, env, "b", a)
+		d := saveFolder(t, env, "d", nil)
+		x := saveBookmark(t, env, "x", "https://x.example.com/", b)
+
+		body := tt.body
+		if body[0] == '[' && len(body) > 2 {
+			body = fmt.Sprintf(body, a.Id, b.Id, d.Id, x.Id)
+		}
+		w := serve(env.BatchHandler, http.MethodPost, "/batch/", body)
+		if w.Code != tt.code {
+			t.Errorf("%s: answered %d, want %d: %s", tt.name, w.Code, tt.code, w.Body)
+		}
+		bkm := env.DB.GetBookmark(x.Id)
+		if err := env.DB.FlushErrors(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if bkm.Starred != tt.starred {
+			t.Errorf("%s: bookmark starred %t, want %t", tt.name, bkm.Starred, tt.starred)
+		}
+	}
+}
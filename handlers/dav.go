@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// davLockTimeout is the age of a sync lock considered abandoned.
+const davLockTimeout = 15 * time.Minute
+
+// davLock is the sync lock, taken by a client.
+type davLock struct {
+	taken time.Time // the time the lock was taken, zero if the tree is not locked
+	owner string    // the client holding the lock, see davClient
+	token string    // the ETag of the lock file, accepted in If-Match instead of the owner
+}
+
+var (
+	// lock is the current sync lock.
+	lock         davLock
+	davLockMutex sync.Mutex
+)
+
+// davClient returns the identity of the client of r holding the locks:
+// its address, the forwarded one behind a proxy, and its User-Agent.
+func davClient(r *http.Request) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		addr = strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	return addr + " " + r.UserAgent()
+}
+
+// held returns true if the lock is taken and not abandoned.
+// davLockMutex must be held.
+func (l davLock) held() bool {
+	return !l.taken.IsZero() && time.Since(l.taken) < davLockTimeout
+}
+
+// ownedBy returns true if the lock is owned by the client of r,
+// or if r gives the lock token in its If-Match header.
+func (l davLock) ownedBy(r *http.Request) bool {
+	return l.owner == davClient(r) || (l.token != "" && r.Header.Get("If-Match") == l.token)
+}
+
+// takeDAVLock takes the sync lock for the client of r, unless it is held
+// by another client, and returns its token, empty if it is not taken.
+// The owner taking the lock again refreshes it.
+func takeDAVLock(r *http.Request) string {
+	davLockMutex.Lock()
+	defer davLockMutex.Unlock()
+
+	if lock.held() && !lock.ownedBy(r) {
+		return ""
+	}
+	now := time.Now()
+	lock = davLock{taken: now, owner: davClient(r), token: `"` + strconv.FormatInt(now.UnixNano(), 36) + `"`}
+	return lock.token
+}
+
+// davLockState returns the sync lock, the zero davLock if it is not held.
+func davLockState() davLock {
+	davLockMutex.Lock()
+	defer davLockMutex.Unlock()
+
+	if !lock.held() {
+		return davLock{}
+	}
+	return lock
+}
+
+// lockedByOther returns true if the sync lock is held by another client than the one of r.
+func lockedByOther(r *http.Request) bool {
+	l := davLockState()
+	return l.held() && !l.ownedBy(r)
+}
+
+// releaseDAVLock releases the sync lock for the client of r,
+// and returns false if it is held by another client.
+func releaseDAVLock(r *http.Request) bool {
+	davLockMutex.Lock()
+	defer davLockMutex.Unlock()
+
+	if lock.held() && !lock.ownedBy(r) {
+		return false
+	}
+	lock = davLock{}
+	return true
+}
+
+// syncXBELFolder converts the given synchronized folder into an XBEL folder
+// and returns it with the highest sync id of its content.
+func syncXBELFolder(f *types.SyncFolder) (xbelFolder, int) {
+	xf := xbelFolder{ID: strconv.Itoa(f.SyncId), Title: f.Title}
+	highestID := f.SyncId
+	for _, sub := range f.Folders {
+		xsub, subHighestID := syncXBELFolder(sub)
+		xf.Folders = append(xf.Folders, xsub)
+		if subHighestID > highestID {
+			highestID = subHighestID
+		}
+	}
+	for _, b := range f.Bookmarks {
+		xf.Bookmarks = append(xf.Bookmarks, xbelBookmark{ID: strconv.Itoa(b.SyncId), Href: b.URL, Title: b.Title})
+		if b.SyncId > highestID {
+			highestID = b.SyncId
+		}
+	}
+	return xf, highestID
+}
+
+// xbelSyncFolder converts the given XBEL folder into a synchronized folder.
+// The invalid ids are new items.
+func xbelSyncFolder(xf xbelFolder) *types.SyncFolder {
+	f := &types.SyncFolder{Title: xf.Title}
+	f.SyncId, _ = strconv.Atoi(xf.ID)
+	for _, xsub := range xf.Folders {
+		f.Folders = append(f.Folders, xbelSyncFolder(xsub))
+	}
+	for _, xb := range xf.Bookmarks {
+		b := &types.SyncBookmark{Title: xb.Title, URL: xb.Href}
+		b.SyncId, _ = strconv.Atoi(xb.ID)
+		f.Bookmarks = append(f.Bookmarks, b)
+	}
+	return f
+}
+
+// writeSyncXBEL writes the given synchronized tree as a Floccus XBEL file,
+// the highestId comment giving the highest sync id.
+func writeSyncXBEL(wr io.Writer, root *types.SyncFolder) error {
+	xf, highestID := syncXBELFolder(root)
+	doc := xbel{Version: "1.0", Folders: xf.Folders, Bookmarks: xf.Bookmarks}
+
+	if _, err := io.WriteString(wr, xml.Header+`<!DOCTYPE xbel PUBLIC "+//IDN python.org//DTD XML Bookmark Exchange Language 1.0//EN//XML" "http://pyxml.sourceforge.net/topics/dtds/xbel.dtd">`+"\n"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(wr, "<!--- highestId :%d: for Floccus bookmark sync browser extension -->\n", highestID); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(wr)
+	enc.Indent("", "\t")
+	return enc.Encode(doc)
+}
+
+// DAVHandler is the WebDAV endpoint of the Floccus browser extension
+// synchronization: /dav/{name}.xbel is the bookmarks tree as an XBEL file
+// and /dav/{name}.xbel.lock the lock file preventing two browsers
+// from synchronizing at once.
+// A PUT of the XBEL file is applied as a diff of the tree, the items
+// being identified by their XBEL id.
+func (env *Env) DAVHandler(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("DAVHandler")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE")
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, ".xbel.lock"):
+		env.davLockFile(w, r)
+	case strings.HasSuffix(r.URL.Path, ".xbel"):
+		env.davXBELFile(w, r)
+	default:
+		failHTTP(w, "DAVHandler", "no such file", http.StatusNotFound)
+	}
+}
+
+// davLockFile handles the requests of the lock file:
+// it exists while the sync lock is taken. The lock is owned by the client
+// taking it, the other clients can not release it, nor change the tree,
+// until it is released or abandoned.
+func (env *Env) davLockFile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		l := davLockState()
+		if !l.held() {
+			failHTTP(w, "davLockFile", "not locked", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", l.taken.UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", l.token)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, "locked")
+		}
+	case http.MethodPut:
+		token := takeDAVLock(r)
+		if token == "" {
+			failHTTP(w, "davLockFile", "already locked", http.StatusLocked)
+			return
+		}
+		w.Header().Set("ETag", token)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !releaseDAVLock(r) {
+			failHTTP(w, "davLockFile", "locked by another client", http.StatusLocked)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		failHTTP(w, "davLockFile", "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// davXBELFile handles the requests of the XBEL file.
+func (env *Env) davXBELFile(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		// Getting the tree.
+		root := env.DB.GetSyncTree()
+		// Datastore error check.
+		if err = env.DB.FlushErrors(); err != nil {
+			failHTTP(w, "davXBELFile", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xbel+xml; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err = writeSyncXBEL(w, root); err != nil {
+			// Just logging the error, the response is already started.
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("davXBELFile")
+		}
+	case http.MethodPut:
+		// The tree is not changed while another client synchronizes.
+		if lockedByOther(r) {
+			failHTTP(w, "davXBELFile", "locked by another client", http.StatusLocked)
+			return
+		}
+		// Decoding the tree.
+		var doc xbel
+		if err = xml.NewDecoder(r.Body).Decode(&doc); err != nil {
+			failHTTP(w, "davXBELFile", "XBEL decoding error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		root := xbelSyncFolder(xbelFolder{Folders: doc.Folders, Bookmarks: doc.Bookmarks})
+		// Applying it.
+		env.DB.ApplySyncTree(root)
+		// Datastore error check.
+		if err = env.DB.FlushErrors(); err != nil {
+			failHTTP(w, "davXBELFile", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		failHTTP(w, "davXBELFile", "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// serveDAV returns the response of the DAV handler to the method request
+// of path by the client of the given User-Agent, with the given If-Match header if not empty.
+func serveDAV(env *Env, method string, path string, agent string, ifMatch string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("User-Agent", agent)
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	env.DAVHandler(w, r)
+	return w
+}
+
+func TestDAVLock(t *testing.T) {
+	env := newTestEnv(t)
+	lock = davLock{}
+	defer func() { lock = davLock{} }()
+
+	const xbelFile = `<xbel version="1.0"><bookmark href="https://golang.org/"><title>Go</title></bookmark></xbel>`
+	// The steps of two browsers A and B synchronizing.
+	tests := []struct {
+		method, path, agent string
+		ifMatch             bool // giving the lock token
+		status              int
+	}{
+		{"GET", "/dav/bkm.xbel.lock", "A", false, http.StatusNotFound},
+		{"PUT", "/dav/bkm.xbel.lock", "A", false, http.StatusCreated},
+		{"HEAD", "/dav/bkm.xbel.lock", "B", false, http.StatusOK},
+		{"PUT", "/dav/bkm.xbel.lock", "B", false, http.StatusLocked},
+		{"PUT", "/dav/bkm.xbel", "B", false, http.StatusLocked},
+		{"DELETE", "/dav/bkm.xbel.lock", "B", false, http.StatusLocked},
+		{"PUT", "/dav/bkm.xbel", "A", false, http.StatusNoContent},
+		{"PUT", "/dav/bkm.xbel", "A2", true, http.StatusNoContent},
+		{"PUT", "/dav/bkm.xbel.lock", "A", false, http.StatusCreated},
+		{"DELETE", "/dav/bkm.xbel.lock", "A", false, http.StatusNoContent},
+		{"GET", "/dav/bkm.xbel.lock", "B", false, http.StatusNotFound},
+		{"PUT", "/dav/bkm.xbel", "B", false, http.StatusNoContent},
+		{"DELETE", "/dav/bkm.xbel.lock", "B", false, http.StatusNoContent},
+		{"PUT", "/dav/bkm.txt", "A", false, http.StatusNotFound},
+		{"POST", "/dav/bkm.xbel", "A", false, http.StatusMethodNotAllowed},
+	}
+	token := ""
+	for i, tt := range tests {
+		ifMatch := ""
+		if tt.ifMatch {
+			ifMatch = token
+		}
+		body := ""
+		if strings.HasSuffix(tt.path, ".xbel") {
+			body = xbelFile
+		}
+		w := serveDAV(env, tt.method, tt.path, tt.agent, ifMatch, body)
+		if w.Code != tt.status {
+			t.Errorf("%d %s %s by %s: status %d, want %d", i, tt.method, tt.path, tt.agent, w.Code, tt.status)
+		}
+		if tt.method == "PUT" && w.Code == http.StatusCreated {
+			token = w.Header().Get("ETag")
+		}
+	}
+
+	// An abandoned lock is taken by another client.
+	serveDAV(env, "PUT", "/dav/bkm.xbel.lock", "A", "", "")
+	lock.taken = lock.taken.Add(-davLockTimeout)
+	if w := serveDAV(env, "PUT", "/dav/bkm.xbel.lock", "B", "", ""); w.Code != http.StatusCreated {
+		t.Errorf("abandoned lock status %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestDAVXBELFile(t *testing.T) {
+	env := newTestEnv(t)
+	lock = davLock{}
+	it := saveFolder(t, env, "IT", nil)
+	saveBookmark(t, env, "Go & Co", "https://golang.org/", it)
+	saveBookmark(t, env, "News", "https://news.example.com/", nil)
+
+	w := serveDAV(env, "GET", "/dav/bkm.xbel", "A", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("GET status %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "<!--- highestId :3: for Floccus bookmark sync browser extension -->") {
+		t.Errorf("XBEL without highestId 3:\n%s", body)
+	}
+	var doc xbel
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Folders) != 1 || doc.Folders[0].ID != "1" || doc.Folders[0].Bookmarks[0].Title != "Go & Co" || len(doc.Bookmarks) != 1 {
+		t.Fatalf("XBEL tree %+v", doc)
+	}
+	if w = serveDAV(env, "HEAD", "/dav/bkm.xbel", "A", "", ""); w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("HEAD status %d, %d bytes", w.Code, w.Body.Len())
+	}
+
+	// The browser renames Go, deletes News and creates Rust.
+	doc.Folders[0].Bookmarks[0].Title = "Go"
+	doc.Bookmarks = []xbelBookmark{{ID: "10", Href: "https://rust-lang.org/", Title: "Rust"}}
+	put, err := xml.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w = serveDAV(env, "PUT", "/dav/bkm.xbel", "A", "", string(put)); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	var titles []string
+	for _, bkm := range env.DB.QueryBookmarks(types.BookmarkQuery{}) {
+		titles = append(titles, folderPath(bkm.Folder)+" "+bkm.Title)
+	}
+	if got, want := strings.Join(titles, "|"), "/IT Go|/ Rust"; got != want {
+		t.Errorf("bookmarks %s, want %s", got, want)
+	}
+
+	if w = serveDAV(env, "PUT", "/dav/bkm.xbel", "A", "", "<xbel"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid XBEL status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w = serveDAV(env, "OPTIONS", "/dav/", "A", "", ""); w.Header().Get("DAV") != "1" {
+		t.Errorf("OPTIONS DAV header %q", w.Header().Get("DAV"))
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// descriptionPolicy sanitizes the HTML rendered from the bookmarks descriptions.
+var descriptionPolicy = bluemonday.UGCPolicy().AddTargetBlankToFullyQualifiedLinks(true)
+
+// renderDescription renders the given Markdown description as sanitized HTML.
+func renderDescription(description string) []byte {
+	return descriptionPolicy.SanitizeBytes(blackfriday.MarkdownCommon([]byte(description)))
+}
+
+// DescribeBookmarkHandler handles the bookmarks description changes.
+// The bookmarkId and the Markdown description are posted as a form.
+func (env *Env) DescribeBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err        error
+		bookmarkID int
+	)
+	// POST parameters retrieval.
+	if err = r.ParseForm(); err != nil {
+		failHTTP(w, "DescribeBookmarkHandler", err.Error(), http.StatusBadRequest)
+		return
+	}
+	bookmarkIDParam := r.FormValue("bookmarkId")
+	description := r.FormValue("description")
+	log.WithFields(log.Fields{
+		"bookmarkIdParam": bookmarkIDParam,
+		"description":     description,
+	}).Debug("DescribeBookmarkHandler:Query parameter")
+
+	// bookmarkId int convertion.
+	if bookmarkID, err = strconv.Atoi(bookmarkIDParam); err != nil {
+		failHTTP(w, "DescribeBookmarkHandler", "bookmarkId Atoi conversion", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "DescribeBookmarkHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Updating it.
+	bkm.Description = description
+	env.DB.UpdateBookmark(bkm)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "DescribeBookmarkHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// RenderBookmarkDescriptionHandler returns the description of the given bookmark
+// rendered as sanitized HTML.
+func (env *Env) RenderBookmarkDescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err        error
+		bookmarkID int
+	)
+	// GET parameters retrieval.
+	bookmarkIDParam := r.URL.Query()["bookmarkId"]
+	log.WithFields(log.Fields{
+		"bookmarkIdParam": bookmarkIDParam,
+	}).Debug("RenderBookmarkDescriptionHandler:Query parameter")
+
+	// Parameters check.
+	if len(bookmarkIDParam) == 0 {
+		failHTTP(w, "RenderBookmarkDescriptionHandler", "bookmarkId empty", http.StatusBadRequest)
+		return
+	}
+	// bookmarkId int convertion.
+	if bookmarkID, err = strconv.Atoi(bookmarkIDParam[0]); err != nil {
+		failHTTP(w, "RenderBookmarkDescriptionHandler", "bookmarkId Atoi conversion", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "RenderBookmarkDescriptionHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err = w.Write(renderDescription(bkm.Description)); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("RenderBookmarkDescriptionHandler")
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+func TestRenderDescription(t *testing.T) {
+	tests := []struct {
+		description string
+		contains    []string
+		excludes    []string
+	}{
+		{
+			description: "# Notes\n\n*read* the [spec](https://golang.org/ref/spec)",
+			contains:    []string{"<h1>Notes</h1>", "<em>read</em>", `href="https://golang.org/ref/spec"`, `target="_blank"`},
+		},
+		{
+			description: "<script>alert(1)</script>[link](javascript:alert(1)) <img src=x onerror=alert(1)>",
+			excludes:    []string{"<script", "javascript:", "onerror"},
+		},
+		{
+			description: "",
+			excludes:    []string{"<"},
+		},
+	}
+	for _, tt := range tests {
+		got := string(renderDescription(tt.description))
+		for _, s := range tt.contains {
+			if !strings.Contains(got, s) {
+				t.Errorf("renderDescription(%q) = %q, missing %q", tt.description, got, s)
+			}
+		}
+		for _, s := range tt.excludes {
+			if strings.Contains(got, s) {
+				t.Errorf("renderDescription(%q) = %q, containing %q", tt.description, got, s)
+			}
+		}
+	}
+}
+
+func TestDescribeBookmarkHandler(t *testing.T) {
+	env := newTestEnv(t)
+	bkm := saveBookmark(t, env, "Go", "https://golang.org/", nil)
+	id := strconv.Itoa(bkm.Id)
+
+	form := url.Values{"bookmarkId": {id}, "description": {"A *fast* language\n\nwith goroutines"}}
+	r := serveForm(env.DescribeBookmarkHandler, form)
+	if r.Code != http.StatusOK {
+		t.Fatalf("describe status %d, want %d", r.Code, http.StatusOK)
+	}
+	if d := env.DB.GetBookmark(bkm.Id).Description; d != form.Get("description") {
+		t.Errorf("description %q, want %q", d, form.Get("description"))
+	}
+	// The description is searched.
+	if found := env.DB.QueryBookmarks(types.BookmarkQuery{Search: "goroutines"}); len(found) != 1 || found[0].Id != bkm.Id {
+		t.Errorf("description search found %v", found)
+	}
+
+	w := serve(env.RenderBookmarkDescriptionHandler, "GET", "/renderBookmarkDescription/?bookmarkId="+id, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<em>fast</em>") {
+		t.Errorf("render status %d, body %q", w.Code, w.Body.String())
+	}
+	for _, target := range []string{"/renderBookmarkDescription/", "/renderBookmarkDescription/?bookmarkId=x"} {
+		if w = serve(env.RenderBookmarkDescriptionHandler, "GET", target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
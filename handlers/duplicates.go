@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// mergeBookmarks returns the merge of the given duplicate bookmarks, the oldest first.
+// The oldest bookmark is kept in its folder with the best title:
+// the longest one not being the URL itself, the notes of all the bookmarks,
+// the first keyword, all the tags, starred if one of them is, and the sum of their visits.
+// It is unread or archived only if all of them are.
+func mergeBookmarks(bkms []*types.Bookmark) *types.Bookmark {
+	keep := *bkms[0]
+	var notes []string
+	keep.Tags = nil
+	for _, bkm := range bkms {
+		keep.Tags = addTags(keep.Tags, bkm.Tags)
+		if bkm.Title != bkm.URL && (keep.Title == keep.URL || len(bkm.Title) > len(keep.Title)) {
+			keep.Title = bkm.Title
+		}
+		if note := strings.TrimSpace(bkm.Description); note != "" {
+			duplicate := false
+			for _, n := range notes {
+				duplicate = duplicate || n == note
+			}
+			if !duplicate {
+				notes = append(notes, note)
+			}
+		}
+		if keep.Favicon == "" {
+			keep.Favicon = bkm.Favicon
+		}
+		if keep.Keyword == "" {
+			keep.Keyword = bkm.Keyword
+		}
+		keep.Starred = keep.Starred || bkm.Starred
+		keep.Unread = keep.Unread && bkm.Unread
+		keep.Archived = keep.Archived && bkm.Archived
+		if bkm.LastVisitedAt.After(keep.LastVisitedAt) {
+			keep.LastVisitedAt = bkm.LastVisitedAt
+		}
+		if bkm != bkms[0] {
+			keep.VisitCount += bkm.VisitCount
+		}
+	}
+	keep.Description = strings.Join(notes, "\n\n")
+	keep.UpdatedAt = time.Now()
+	return &keep
+}
+
+// GetDuplicatesHandler returns the groups of bookmarks sharing the same canonical URL.
+func (env *Env) GetDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Getting the duplicates.
+	groups := env.DB.GetDuplicateBookmarks()
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "GetDuplicatesHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(groups); err != nil {
+		failHTTP(w, "GetDuplicatesHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// MergeDuplicatesHandler merges the bookmarks of the bookmarkId parameters,
+// sharing the same canonical URL, into the oldest one and returns it.
+func (env *Env) MergeDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err  error
+		bkms []*types.Bookmark
+	)
+	// GET parameters retrieval.
+	bookmarkIDParam := r.URL.Query()["bookmarkId"]
+	log.WithFields(log.Fields{
+		"bookmarkIdParam": bookmarkIDParam,
+	}).Debug("MergeDuplicatesHandler:Query parameter")
+
+	// Parameters check, the ids being de-duplicated.
+	var (
+		bookmarkIDs []int
+		seen        = make(map[int]bool)
+	)
+	for _, idParam := range bookmarkIDParam {
+		var bookmarkID int
+		if bookmarkID, err = strconv.Atoi(idParam); err != nil {
+			failHTTP(w, "MergeDuplicatesHandler", "bookmarkId Atoi conversion", http.StatusBadRequest)
+			return
+		}
+		if !seen[bookmarkID] {
+			seen[bookmarkID] = true
+			bookmarkIDs = append(bookmarkIDs, bookmarkID)
+		}
+	}
+	if len(bookmarkIDs) < 2 {
+		failHTTP(w, "MergeDuplicatesHandler", "at least two distinct bookmarkId expected", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the bookmarks.
+	for _, bookmarkID := range bookmarkIDs {
+		bkm := env.DB.GetBookmark(bookmarkID)
+		if err = env.DB.FlushErrors(); err != nil {
+			failHTTP(w, "MergeDuplicatesHandler", err.Error(), http.StatusNotFound)
+			return
+		}
+		if len(bkms) > 0 && bkm.CanonicalURL != bkms[0].CanonicalURL {
+			failHTTP(w, "MergeDuplicatesHandler", "the bookmarks are not duplicates", http.StatusBadRequest)
+			return
+		}
+		bkms = append(bkms, bkm)
+	}
+	sort.Slice(bkms, func(i, j int) bool {
+		if bkms[i].CreatedAt.Equal(bkms[j].CreatedAt) {
+			return bkms[i].Id < bkms[j].Id
+		}
+		return bkms[i].CreatedAt.Before(bkms[j].CreatedAt)
+	})
+
+	// Merging them, the kept bookmark not being removed.
+	keep := mergeBookmarks(bkms)
+	for _, b := range bkms[1:] {
+		if b.Id == keep.Id {
+			failHTTP(w, "MergeDuplicatesHandler", "the kept bookmark is also removed", http.StatusBadRequest)
+			return
+		}
+	}
+	env.DB.MergeBookmarks(keep, bkms[1:])
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "MergeDuplicatesHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(keep); err != nil {
+		failHTTP(w, "MergeDuplicatesHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
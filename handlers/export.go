@@ -0,0 +1,449 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Export formats.
+const (
+	exportFormatHTML     = "html" // Netscape bookmark file
+	exportFormatJSON     = "json"
+	exportFormatCSV      = "csv"
+	exportFormatMarkdown = "markdown"
+	exportFormatOPML     = "opml"
+	exportFormatXBEL     = "xbel"
+)
+
+// exportFormat describes how to write an export format.
+type exportFormat struct {
+	extension   string
+	contentType string
+	write       func(io.Writer, *exportBookmarksStruct) error
+}
+
+// exportFormats are the supported export formats by name.
+var exportFormats = map[string]exportFormat{
+	exportFormatHTML:     {"html", "text/html; charset=utf-8", writeExportHTML},
+	exportFormatJSON:     {"json", "application/json", writeExportJSON},
+	exportFormatCSV:      {"csv", "text/csv; charset=utf-8", writeExportCSV},
+	exportFormatMarkdown: {"md", "text/markdown; charset=utf-8", writeExportMarkdown},
+	exportFormatOPML:     {"opml", "text/x-opml; charset=utf-8", writeExportOPML},
+	exportFormatXBEL:     {"xbel", "application/xbel+xml; charset=utf-8", writeExportXBEL},
+}
+
+// exportBookmarksStruct is used to build the bookmarks and folders tree in the export operation.
+type exportBookmarksStruct struct {
+	Fld  *types.Folder
+	Bkms []*types.Bookmark
+	Sub  []*exportBookmarksStruct
+}
+
+// buildExportTree recursively builds the bookmarks and folders tree of the given folder.
+func (env *Env) buildExportTree(fld *types.Folder) *exportBookmarksStruct {
+	log.WithFields(log.Fields{
+		"fld": fld,
+	}).Debug("buildExportTree")
+
+	eb := &exportBookmarksStruct{Fld: fld}
+	// Exporting in the folder order.
+	q := types.BookmarkQuery{FolderID: fld.Id}
+	folderSortQuery(&q, fld)
+	// and the smart folders with the bookmarks of their query.
+	if fld.Query != "" {
+		sq, err := smartFolderQuery(fld)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"fld": fld,
+				"err": err,
+			}).Error("buildExportTree:invalid smart folder query")
+			return eb
+		}
+		q = sq
+	}
+	// For each children folder recursively building the bookmarks tree.
+	children := env.DB.GetFolderSubfolders(fld.Id)
+	sortFolders(children, q)
+	for _, child := range children {
+		child.Parent = fld
+		eb.Sub = append(eb.Sub, env.buildExportTree(child))
+	}
+	// Getting the folder bookmarks.
+	eb.Bkms = env.DB.QueryBookmarks(q)
+
+	return eb
+}
+
+// ExportHandler handles the export requests.
+// The optional format parameter is one of html (default), json, csv, markdown, opml or xbel.
+// The whole tree is exported unless one of the folderId (subtree),
+// starred=true (starred bookmarks) or search (search results) parameters is given.
+func (env *Env) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err      error
+		folderID int
+		format   = exportFormatHTML
+		eb       *exportBookmarksStruct
+	)
+	// GET parameters retrieval.
+	formatParam := r.URL.Query()["format"]
+	folderIDParam := r.URL.Query()["folderId"]
+	starredParam := r.URL.Query()["starred"]
+	searchParam := r.URL.Query()["search"]
+	log.WithFields(log.Fields{
+		"formatParam":   formatParam,
+		"folderIdParam": folderIDParam,
+		"starredParam":  starredParam,
+		"searchParam":   searchParam,
+	}).Debug("ExportHandler:Query parameter")
+
+	// Parameters check.
+	if len(formatParam) != 0 && formatParam[0] != "" {
+		format = formatParam[0]
+	}
+	f, ok := exportFormats[format]
+	if !ok {
+		failHTTP(w, "ExportHandler", "unknown export format", http.StatusBadRequest)
+		return
+	}
+
+	// Building the exported tree.
+	switch {
+	case len(starredParam) != 0 && starredParam[0] == "true":
+		eb = &exportBookmarksStruct{Fld: &types.Folder{Title: "starred"}, Bkms: env.DB.GetStarredBookmarks()}
+	case len(searchParam) != 0:
+		eb = &exportBookmarksStruct{Fld: &types.Folder{Title: "search: " + searchParam[0]}, Bkms: env.DB.QueryBookmarks(types.BookmarkQuery{Search: searchParam[0]})}
+	default:
+		folderID = 1
+		if len(folderIDParam) != 0 && folderIDParam[0] != "" {
+			if folderID, err = strconv.Atoi(folderIDParam[0]); err != nil {
+				failHTTP(w, "ExportHandler", "folderId Atoi conversion", http.StatusBadRequest)
+				return
+			}
+		}
+		fld := env.DB.GetFolder(folderID)
+		if err = env.DB.FlushErrors(); err != nil && err != sql.ErrNoRows {
+			failHTTP(w, "ExportHandler", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == sql.ErrNoRows || fld == nil {
+			failHTTP(w, "ExportHandler", "folder not found", http.StatusNotFound)
+			return
+		}
+		eb = env.buildExportTree(fld)
+	}
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "ExportHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Writing the header meta informations.
+	w.Header().Set("Content-Disposition", "attachment; filename=gobkm."+f.extension)
+	w.Header().Set("Content-Type", f.contentType)
+	// Exporting the bookmarks.
+	if err = f.write(w, eb); err != nil {
+		// Just logging the error, the response is already started.
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("ExportHandler")
+	}
+}
+
+// insertIndent the "depth" number of tabs to the given io.Writer.
+func insertIndent(wr io.Writer, depth int) {
+	for i := 0; i < depth; i++ {
+		if _, err := wr.Write([]byte("\t")); err != nil {
+			// Just logging the error.
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("insertIdent")
+		}
+	}
+}
+
+// writeExportHTML exports the given tree as a Netscape bookmark file.
+func writeExportHTML(wr io.Writer, eb *exportBookmarksStruct) error {
+	// HTML header and footer definition.
+	header := `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>GoBkm</TITLE>
+<H1>GoBkm</H1>
+<DL><p>` + "\n"
+	footer := "</DL><p>\n"
+
+	if _, err := io.WriteString(wr, header); err != nil {
+		return err
+	}
+	writeExportHTMLFolder(wr, eb, 0)
+	_, err := io.WriteString(wr, footer)
+	return err
+}
+
+// netscapeDate returns the Netscape bookmark file date attribute name=t,
+// or "" for the zero time.
+func netscapeDate(name string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(" %s=\"%d\"", name, t.Unix())
+}
+
+// writeExportHTMLFolder recursively exports in HTML the given bookmark struct.
+func writeExportHTMLFolder(wr io.Writer, eb *exportBookmarksStruct, depth int) {
+	// Depth is just for cosmetics indent purposes.
+	depth++
+
+	// Writing the folder title.
+	insertIndent(wr, depth)
+	fmt.Fprintf(wr, "<DT><H3%s>%s</H3>\n", netscapeDate("ADD_DATE", eb.Fld.CreatedAt)+netscapeDate("LAST_MODIFIED", eb.Fld.UpdatedAt), html.EscapeString(eb.Fld.Title))
+	insertIndent(wr, depth)
+	fmt.Fprint(wr, "<DL><p>\n")
+
+	for _, sub := range eb.Sub {
+		writeExportHTMLFolder(wr, sub, depth)
+	}
+	for _, bkm := range eb.Bkms {
+		insertIndent(wr, depth)
+		dates := netscapeDate("ADD_DATE", bkm.CreatedAt) + netscapeDate("LAST_MODIFIED", bkm.UpdatedAt) + netscapeDate("LAST_VISIT", bkm.LastVisitedAt)
+		if bkm.Keyword != "" {
+			dates += " SHORTCUTURL=\"" + html.EscapeString(bkm.Keyword) + "\""
+		}
+		if len(bkm.Tags) != 0 {
+			dates += " TAGS=\"" + html.EscapeString(strings.Join(bkm.Tags, ",")) + "\""
+		}
+		fmt.Fprintf(wr, "<DT><A HREF=\"%s\"%s ICON=\"%s\">%s</A>\n", html.EscapeString(bkm.URL), dates, html.EscapeString(bkm.Favicon), html.EscapeString(bkm.Title))
+		if bkm.Description != "" {
+			insertIndent(wr, depth)
+			fmt.Fprintf(wr, "<DD>%s\n", strings.Replace(html.EscapeString(bkm.Description), "\n", "<BR>", -1))
+		}
+	}
+	insertIndent(wr, depth)
+	fmt.Fprint(wr, "</DL><p>\n")
+}
+
+// exportJSONFolder is a folder of the JSON export.
+// The bookmarks are exported with all their fields but the folder,
+// given by the tree structure.
+// The smart folders are exported with their query and current bookmarks.
+type exportJSONFolder struct {
+	Id        int
+	Title     string
+	Query     string `json:",omitempty"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Folders   []*exportJSONFolder
+	Bookmarks []*types.Bookmark
+}
+
+// exportJSONTree converts the given tree for the JSON export.
+func exportJSONTree(eb *exportBookmarksStruct) *exportJSONFolder {
+	jf := &exportJSONFolder{Id: eb.Fld.Id, Title: eb.Fld.Title, Query: eb.Fld.Query, CreatedAt: eb.Fld.CreatedAt, UpdatedAt: eb.Fld.UpdatedAt, Folders: []*exportJSONFolder{}, Bookmarks: []*types.Bookmark{}}
+	for _, sub := range eb.Sub {
+		jf.Folders = append(jf.Folders, exportJSONTree(sub))
+	}
+	for _, bkm := range eb.Bkms {
+		b := *bkm
+		b.Folder = nil
+		jf.Bookmarks = append(jf.Bookmarks, &b)
+	}
+	return jf
+}
+
+// writeExportJSON exports the given tree in JSON.
+func writeExportJSON(wr io.Writer, eb *exportBookmarksStruct) error {
+	enc := json.NewEncoder(wr)
+	enc.SetIndent("", "\t")
+	return enc.Encode(exportJSONTree(eb))
+}
+
+// exportTime returns the given time in RFC 3339, or "" for the zero time.
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// writeExportCSV exports the bookmarks of the given tree in CSV,
+// one row per bookmark with its folder path.
+func writeExportCSV(wr io.Writer, eb *exportBookmarksStruct) error {
+	cw := csv.NewWriter(wr)
+	if err := cw.Write([]string{"id", "title", "url", "folder", "starred", "created", "updated", "visited", "description", "tags"}); err != nil {
+		return err
+	}
+
+	var writeFolder func(eb *exportBookmarksStruct, path string) error
+	writeFolder = func(eb *exportBookmarksStruct, path string) error {
+		for _, bkm := range eb.Bkms {
+			// Starred and search results bookmarks, from QueryBookmarks and
+			// GetStarredBookmarks, are loaded with their folder.
+			bkmPath := path
+			if bkm.Folder != nil {
+				bkmPath = folderPath(bkm.Folder)
+			}
+			row := []string{strconv.Itoa(bkm.Id), bkm.Title, bkm.URL, bkmPath, strconv.FormatBool(bkm.Starred), exportTime(bkm.CreatedAt), exportTime(bkm.UpdatedAt), exportTime(bkm.LastVisitedAt), bkm.Description, strings.Join(bkm.Tags, ",")}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		for _, sub := range eb.Sub {
+			if err := writeFolder(sub, strings.TrimSuffix(path, "/")+"/"+sub.Fld.Title); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := writeFolder(eb, folderPath(eb.Fld)); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// markdownEscaper escapes the Markdown link text special characters.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `*`, `\*`, `_`, `\_`)
+
+// writeExportMarkdown exports the given tree as nested Markdown lists.
+func writeExportMarkdown(wr io.Writer, eb *exportBookmarksStruct) error {
+	if _, err := fmt.Fprintf(wr, "# %s\n\n", markdownEscaper.Replace(eb.Fld.Title)); err != nil {
+		return err
+	}
+
+	var writeFolder func(eb *exportBookmarksStruct, indent string) error
+	writeFolder = func(eb *exportBookmarksStruct, indent string) error {
+		for _, sub := range eb.Sub {
+			if _, err := fmt.Fprintf(wr, "%s- **%s**\n", indent, markdownEscaper.Replace(sub.Fld.Title)); err != nil {
+				return err
+			}
+			if err := writeFolder(sub, indent+"  "); err != nil {
+				return err
+			}
+		}
+		for _, bkm := range eb.Bkms {
+			u := strings.NewReplacer("(", "%28", ")", "%29", " ", "%20").Replace(bkm.URL)
+			if _, err := fmt.Fprintf(wr, "%s- [%s](%s)\n", indent, markdownEscaper.Replace(bkm.Title), u); err != nil {
+				return err
+			}
+			// The description is already Markdown, indented under its bookmark.
+			if bkm.Description != "" {
+				desc := strings.Replace(bkm.Description, "\n", "\n"+indent+"  ", -1)
+				if _, err := fmt.Fprintf(wr, "\n%s  %s\n\n", indent, desc); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return writeFolder(eb, "")
+}
+
+// opml is the OPML 2.0 document of the OPML export.
+type opml struct {
+	XMLName  xml.Name      `xml:"opml"`
+	Version  string        `xml:"version,attr"`
+	Title    string        `xml:"head>title"`
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+// opmlOutline is a folder or a bookmark (type link) of the OPML export.
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Type     string        `xml:"type,attr,omitempty"`
+	URL      string        `xml:"url,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// opmlOutlines converts the given tree content into OPML outlines.
+func opmlOutlines(eb *exportBookmarksStruct) []opmlOutline {
+	var outlines []opmlOutline
+	for _, sub := range eb.Sub {
+		outlines = append(outlines, opmlOutline{Text: sub.Fld.Title, Outlines: opmlOutlines(sub)})
+	}
+	for _, bkm := range eb.Bkms {
+		outlines = append(outlines, opmlOutline{Text: bkm.Title, Type: "link", URL: bkm.URL})
+	}
+	return outlines
+}
+
+// writeExportOPML exports the given tree in OPML.
+func writeExportOPML(wr io.Writer, eb *exportBookmarksStruct) error {
+	if _, err := io.WriteString(wr, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(wr)
+	enc.Indent("", "\t")
+	return enc.Encode(opml{Version: "2.0", Title: eb.Fld.Title, Outlines: opmlOutlines(eb)})
+}
+
+// xbel is the XBEL 1.0 document of the XBEL export.
+type xbel struct {
+	XMLName   xml.Name       `xml:"xbel"`
+	Version   string         `xml:"version,attr"`
+	Title     string         `xml:"title,omitempty"`
+	Folders   []xbelFolder   `xml:"folder"`
+	Bookmarks []xbelBookmark `xml:"bookmark"`
+}
+
+// xbelFolder is a folder of the XBEL export.
+type xbelFolder struct {
+	ID        string         `xml:"id,attr,omitempty"`
+	Added     string         `xml:"added,attr,omitempty"`
+	Modified  string         `xml:"modified,attr,omitempty"`
+	Title     string         `xml:"title"`
+	Folders   []xbelFolder   `xml:"folder"`
+	Bookmarks []xbelBookmark `xml:"bookmark"`
+}
+
+// xbelBookmark is a bookmark of the XBEL export.
+type xbelBookmark struct {
+	ID       string `xml:"id,attr,omitempty"`
+	Href     string `xml:"href,attr"`
+	Added    string `xml:"added,attr,omitempty"`
+	Modified string `xml:"modified,attr,omitempty"`
+	Visited  string `xml:"visited,attr,omitempty"`
+	Title    string `xml:"title"`
+	Desc     string `xml:"desc,omitempty"`
+}
+
+// xbelFolderContent converts the given tree into an XBEL folder.
+func xbelFolderContent(eb *exportBookmarksStruct) xbelFolder {
+	xf := xbelFolder{Title: eb.Fld.Title, Added: exportTime(eb.Fld.CreatedAt), Modified: exportTime(eb.Fld.UpdatedAt)}
+	for _, sub := range eb.Sub {
+		xf.Folders = append(xf.Folders, xbelFolderContent(sub))
+	}
+	for _, bkm := range eb.Bkms {
+		xf.Bookmarks = append(xf.Bookmarks, xbelBookmark{Href: bkm.URL, Title: bkm.Title, Added: exportTime(bkm.CreatedAt), Modified: exportTime(bkm.UpdatedAt), Visited: exportTime(bkm.LastVisitedAt), Desc: bkm.Description})
+	}
+	return xf
+}
+
+// writeExportXBEL exports the given tree in XBEL.
+func writeExportXBEL(wr io.Writer, eb *exportBookmarksStruct) error {
+	xf := xbelFolderContent(eb)
+	doc := xbel{Version: "1.0", Title: xf.Title, Folders: xf.Folders, Bookmarks: xf.Bookmarks}
+
+	if _, err := io.WriteString(wr, xml.Header+`<!DOCTYPE xbel PUBLIC "+//IDN python.org//DTD XML Bookmark Exchange Language 1.0//EN//XML" "http://pyxml.sourceforge.net/topics/dtds/xbel.dtd">`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(wr)
+	enc.Indent("", "\t")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// exportFixture saves the folders IT and IT/Development
+// with a starred bookmark in IT and a described one in Development.
+func exportFixture(t *testing.T, env *Env) {
+	it := saveFolder(t, env, "IT", nil)
+	dev := saveFolder(t, env, "Development", it)
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Go & Co", URL: "https://golang.org/", Folder: it, Starred: true, Tags: []string{"go", "lang"}})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Python_doc", URL: "https://docs.python.org/(3)", Folder: dev, Description: "first line\nsecond line"})
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	env := newTestEnv(t)
+	exportFixture(t, env)
+
+	tests := []struct {
+		format      string
+		contentType string
+		check       func(body string) string // returns what is wrong with the export
+	}{
+		{
+			format:      "",
+			contentType: "text/html; charset=utf-8",
+			check: func(body string) string {
+				for _, want := range []string{
+					"<!DOCTYPE NETSCAPE-Bookmark-file-1>",
+					"<DT><H3",
+					">IT</H3>",
+					">Development</H3>",
+					`<DT><A HREF="https://golang.org/"`,
+					`TAGS="go,lang"`,
+					">Go &amp; Co</A>",
+					"<DD>first line<BR>second line",
+				} {
+					if !strings.Contains(body, want) {
+						return "missing " + want
+					}
+				}
+				return ""
+			},
+		},
+		{
+			format:      "json",
+			contentType: "application/json",
+			check: func(body string) string {
+				var root exportJSONFolder
+				if err := json.Unmarshal([]byte(body), &root); err != nil {
+					return err.Error()
+				}
+				if len(root.Folders) != 1 || root.Folders[0].Title != "IT" {
+					return "no IT folder"
+				}
+				it := root.Folders[0]
+				if len(it.Bookmarks) != 1 || it.Bookmarks[0].Title != "Go & Co" || !it.Bookmarks[0].Starred || it.Bookmarks[0].Folder != nil {
+					return "bad IT bookmarks"
+				}
+				if len(it.Folders) != 1 || len(it.Folders[0].Bookmarks) != 1 || it.Folders[0].Bookmarks[0].Description != "first line\nsecond line" {
+					return "bad Development folder"
+				}
+				return ""
+			},
+		},
+		{
+			format:      "csv",
+			contentType: "text/csv; charset=utf-8",
+			check: func(body string) string {
+				rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+				if err != nil {
+					return err.Error()
+				}
+				if len(rows) != 3 || rows[0][0] != "id" {
+					return "bad rows"
+				}
+				if rows[1][1] != "Go & Co" || rows[1][3] != "/IT" || rows[1][4] != "true" || rows[1][9] != "go,lang" {
+					return "bad IT row"
+				}
+				if rows[2][1] != "Python_doc" || rows[2][3] != "/IT/Development" || rows[2][8] != "first line\nsecond line" {
+					return "bad Development row"
+				}
+				return ""
+			},
+		},
+		{
+			format:      "markdown",
+			contentType: "text/markdown; charset=utf-8",
+			check: func(body string) string {
+				for _, want := range []string{
+					"- **IT**\n",
+					"  - **Development**\n",
+					"    - [Python\\_doc](https://docs.python.org/%283%29)\n",
+					"\n      first line\n      second line\n",
+					"  - [Go & Co](https://golang.org/)\n",
+				} {
+					if !strings.Contains(body, want) {
+						return "missing " + want
+					}
+				}
+				return ""
+			},
+		},
+		{
+			format:      "opml",
+			contentType: "text/x-opml; charset=utf-8",
+			check: func(body string) string {
+				var doc opml
+				if err := xml.Unmarshal([]byte(body), &doc); err != nil {
+					return err.Error()
+				}
+				if len(doc.Outlines) != 1 || doc.Outlines[0].Text != "IT" || len(doc.Outlines[0].Outlines) != 2 {
+					return "bad IT outline"
+				}
+				if o := doc.Outlines[0].Outlines[1]; o.Type != "link" || o.URL != "https://golang.org/" {
+					return "bad bookmark outline"
+				}
+				return ""
+			},
+		},
+		{
+			format:      "xbel",
+			contentType: "application/xbel+xml; charset=utf-8",
+			check: func(body string) string {
+				var doc xbel
+				if err := xml.Unmarshal([]byte(body), &doc); err != nil {
+					return err.Error()
+				}
+				if len(doc.Folders) != 1 || doc.Folders[0].Title != "IT" || len(doc.Folders[0].Bookmarks) != 1 {
+					return "bad IT folder"
+				}
+				if b := doc.Folders[0].Bookmarks[0]; b.Href != "https://golang.org/" || b.Added == "" {
+					return "bad IT bookmark"
+				}
+				if f := doc.Folders[0].Folders; len(f) != 1 || f[0].Bookmarks[0].Desc != "first line\nsecond line" {
+					return "bad Development folder"
+				}
+				return ""
+			},
+		},
+	}
+	for _, tt := range tests {
+		w := serve(env.ExportHandler, "GET", "/export/?format="+tt.format, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("%q: status %d, want %d", tt.format, w.Code, http.StatusOK)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%q: content type %q, want %q", tt.format, ct, tt.contentType)
+		}
+		if msg := tt.check(w.Body.String()); msg != "" {
+			t.Errorf("%q: %s", tt.format, msg)
+		}
+	}
+}
+
+func TestExportScopes(t *testing.T) {
+	env := newTestEnv(t)
+	exportFixture(t, env)
+
+	tests := []struct {
+		query  string
+		status int
+		titles []string // the exported bookmarks titles
+	}{
+		{query: "", status: http.StatusOK, titles: []string{"Go & Co", "Python_doc"}},
+		{query: "folderId=3", status: http.StatusOK, titles: []string{"Python_doc"}},
+		{query: "starred=true", status: http.StatusOK, titles: []string{"Go & Co"}},
+		{query: "search=python", status: http.StatusOK, titles: []string{"Python_doc"}},
+		{query: "search=nothing", status: http.StatusOK, titles: nil},
+		{query: "folderId=99", status: http.StatusNotFound},
+		{query: "folderId=x", status: http.StatusBadRequest},
+		{query: "format=pdf", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := serve(env.ExportHandler, "GET", "/export/?"+tt.query+"&format=csv", "")
+		if w.Code != tt.status {
+			t.Errorf("%q: status %d, want %d", tt.query, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var titles []string
+		for _, row := range rows[1:] {
+			titles = append(titles, row[1])
+		}
+		if strings.Join(titles, "|") != strings.Join(tt.titles, "|") {
+			t.Errorf("%q: exported %v, want %v", tt.query, titles, tt.titles)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxFeedEntries is the maximum number of entries of the folders,
+// recent and starred bookmarks feeds.
+const maxFeedEntries = 50
+
+// atomFeed is an Atom feed of bookmarks.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is a link of an Atom feed or entry.
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// atomPerson is the author of an Atom feed.
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+// atomText is an Atom text construct.
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// atomCategory is the folder of an Atom entry.
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// atomEntry is a bookmark of an Atom feed.
+type atomEntry struct {
+	Title     string        `xml:"title"`
+	ID        string        `xml:"id"`
+	Links     []atomLink    `xml:"link"`
+	Published string        `xml:"published"`
+	Updated   string        `xml:"updated"`
+	Category  *atomCategory `xml:"category,omitempty"`
+	Summary   *atomText     `xml:"summary,omitempty"`
+}
+
+// atomTime returns the given time in the Atom date format.
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// feedUpdated returns the last update time of the given bookmarks,
+// the zero time if there is none.
+func feedUpdated(bkms []*types.Bookmark) time.Time {
+	var updated time.Time
+	for _, bkm := range bkms {
+		if bkm.UpdatedAt.After(updated) {
+			updated = bkm.UpdatedAt
+		}
+	}
+	return updated
+}
+
+// writeAtomFeed writes the Atom feed of the given bookmarks, with their folder,
+// and the feed URL selfURL. The entries link to the bookmarks URLs,
+// their id is their /go/ URL and their summary is their rendered note.
+func (env *Env) writeAtomFeed(wr io.Writer, title string, selfURL string, bkms []*types.Bookmark) error {
+	base := env.baseURL()
+	feed := atomFeed{
+		Title:  title,
+		ID:     selfURL,
+		Links:  []atomLink{{Href: selfURL, Rel: "self", Type: "application/atom+xml"}, {Href: base + "/"}},
+		Author: atomPerson{Name: "GoBkm"},
+	}
+
+	for _, bkm := range bkms {
+		entry := atomEntry{
+			Title:     bkm.Title,
+			ID:        base + "/go/" + strconv.Itoa(bkm.Id),
+			Links:     []atomLink{{Href: bkm.URL}},
+			Published: atomTime(bkm.CreatedAt),
+			Updated:   atomTime(bkm.UpdatedAt),
+		}
+		if bkm.Folder != nil {
+			entry.Category = &atomCategory{Term: folderPath(bkm.Folder)}
+		}
+		if bkm.Description != "" {
+			entry.Summary = &atomText{Type: "html", Text: string(renderDescription(bkm.Description))}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	// The feed is updated with its last updated bookmark, the empty feed
+	// with a fixed time for its ETag to be stable.
+	updated := feedUpdated(bkms)
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+	feed.Updated = atomTime(updated)
+
+	if _, err := io.WriteString(wr, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(wr)
+	enc.Indent("", "  ")
+	return enc.Encode(feed)
+}
+
+// serveAtomFeed responds with the Atom feed of the given bookmarks,
+// or with a 304 to the conditional requests of an unchanged feed:
+// its ETag is the hash of the feed. There is no Last-Modified,
+// the last updated bookmark time going backwards when an entry is removed.
+func (env *Env) serveAtomFeed(w http.ResponseWriter, r *http.Request, functionName string, title string, selfURL string, bkms []*types.Bookmark) {
+	var buf bytes.Buffer
+	if err := env.writeAtomFeed(&buf, title, selfURL, bkms); err != nil {
+		failHTTP(w, functionName, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	// ServeContent handles the If-None-Match header, the If-Modified-Since
+	// one being ignored without modification time.
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// serveQueryFeed responds with the Atom feed of the bookmarks of the given query,
+// the last maxFeedEntries added.
+func (env *Env) serveQueryFeed(w http.ResponseWriter, r *http.Request, functionName string, title string, selfURL string, q types.BookmarkQuery) {
+	q.Sort, q.Desc, q.Limit = types.SortCreated, true, maxFeedEntries
+	// Getting the bookmarks.
+	bkms := env.DB.QueryBookmarks(q)
+	// Datastore error check.
+	if err := env.DB.FlushErrors(); err != nil {
+		failHTTP(w, functionName, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	env.serveAtomFeed(w, r, functionName, title, selfURL, bkms)
+}
+
+// RecentFeedHandler returns the Atom feed of the last added bookmarks.
+func (env *Env) RecentFeedHandler(w http.ResponseWriter, r *http.Request) {
+	env.serveQueryFeed(w, r, "RecentFeedHandler", "GoBkm recent bookmarks", env.baseURL()+"/feeds/recent.atom", types.BookmarkQuery{})
+}
+
+// StarredFeedHandler returns the Atom feed of the last added starred bookmarks.
+func (env *Env) StarredFeedHandler(w http.ResponseWriter, r *http.Request) {
+	env.serveQueryFeed(w, r, "StarredFeedHandler", "GoBkm starred bookmarks", env.baseURL()+"/feeds/starred.atom", types.BookmarkQuery{Starred: true})
+}
+
+// FolderFeedHandler returns the Atom feed /feeds/folder/{id}.atom of the last added
+// bookmarks of the folder id and its subfolders, or of the smart folder id.
+func (env *Env) FolderFeedHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err      error
+		folderID int
+		q        types.BookmarkQuery
+	)
+	// Path parameter retrieval.
+	folderIDParam := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/feeds/folder/"), ".atom")
+	log.WithFields(log.Fields{
+		"folderIdParam": folderIDParam,
+	}).Debug("FolderFeedHandler:Path parameter")
+
+	// folderId int convertion.
+	if folderID, err = strconv.Atoi(folderIDParam); err != nil {
+		failHTTP(w, "FolderFeedHandler", "folderId Atoi conversion", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the folder.
+	fld := env.DB.GetFolder(folderID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err == sql.ErrNoRows || (err == nil && fld == nil) {
+		failHTTP(w, "FolderFeedHandler", "folder not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		failHTTP(w, "FolderFeedHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q.Under = fld.Id
+	if fld.Query != "" {
+		if q, err = smartFolderQuery(fld); err != nil {
+			failHTTP(w, "FolderFeedHandler", err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	env.serveQueryFeed(w, r, "FolderFeedHandler", "GoBkm "+folderPath(fld), env.baseURL()+"/feeds/folder/"+strconv.Itoa(fld.Id)+".atom", q)
+}
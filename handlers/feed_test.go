@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// serveFeed returns the response of h to the GET request of target
+// with the given If-None-Match header if not empty.
+func serveFeed(h http.HandlerFunc, target string, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestFeeds(t *testing.T) {
+	env := newTestEnv(t)
+	it := saveFolder(t, env, "IT", nil)
+	dev := saveFolder(t, env, "Development", it)
+	now := time.Now()
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/", Folder: dev, Starred: true, Description: "*fast*", CreatedAt: now.Add(-time.Hour)})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Rust", URL: "https://rust-lang.org/", Folder: it, CreatedAt: now})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "News", URL: "https://news.example.com/", CreatedAt: now.Add(-2 * time.Hour)})
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		h      http.HandlerFunc
+		target string
+		status int
+		id     string
+		titles string // the entries titles
+	}{
+		{env.RecentFeedHandler, "/feeds/recent.atom", http.StatusOK, "http://gobkm.test/feeds/recent.atom", "[Rust Go News]"},
+		{env.StarredFeedHandler, "/feeds/starred.atom", http.StatusOK, "http://gobkm.test/feeds/starred.atom", "[Go]"},
+		{env.FolderFeedHandler, "/feeds/folder/" + strconv.Itoa(it.Id) + ".atom", http.StatusOK, "http://gobkm.test/feeds/folder/2.atom", "[Rust Go]"},
+		{env.FolderFeedHandler, "/feeds/folder/" + strconv.Itoa(dev.Id) + ".atom", http.StatusOK, "http://gobkm.test/feeds/folder/3.atom", "[Go]"},
+		{env.FolderFeedHandler, "/feeds/folder/99.atom", http.StatusNotFound, "", ""},
+		{env.FolderFeedHandler, "/feeds/folder/x.atom", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		w := serveFeed(tt.h, tt.target, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var feed atomFeed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		var titles []string
+		for _, e := range feed.Entries {
+			titles = append(titles, e.Title)
+		}
+		if feed.ID != tt.id || fmt.Sprint(titles) != tt.titles {
+			t.Errorf("%s: feed %s entries %v, want %s entries %s", tt.target, feed.ID, titles, tt.id, tt.titles)
+		}
+	}
+
+	// The Go entry has its /go/ id, folder and rendered note.
+	w := serveFeed(env.StarredFeedHandler, "/feeds/starred.atom", "")
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	e := feed.Entries[0]
+	if e.ID != "http://gobkm.test/go/1" || e.Links[0].Href != "https://golang.org/" || e.Category == nil || e.Category.Term != "/IT/Development" || e.Summary == nil || e.Summary.Text != "<p><em>fast</em></p>\n" {
+		t.Errorf("Go entry %+v", e)
+	}
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	env := newTestEnv(t)
+	saveBookmark(t, env, "Go", "https://golang.org/", nil)
+
+	w := serveFeed(env.RecentFeedHandler, "/feeds/recent.atom", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Last-Modified") != "" {
+		t.Fatalf("status %d, ETag %q, Last-Modified %q", w.Code, etag, w.Header().Get("Last-Modified"))
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/atom+xml; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+
+	// Unchanged feed.
+	if w = serveFeed(env.RecentFeedHandler, "/feeds/recent.atom", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("unchanged feed status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	// A new entry changes the feed, removing it gives the previous feed back.
+	bkm := saveBookmark(t, env, "Rust", "https://rust-lang.org/", nil)
+	w = serveFeed(env.RecentFeedHandler, "/feeds/recent.atom", etag)
+	if w.Code != http.StatusOK {
+		t.Errorf("new entry feed status %d, want %d", w.Code, http.StatusOK)
+	}
+	env.DB.DeleteBookmark(bkm)
+	if w = serveFeed(env.RecentFeedHandler, "/feeds/recent.atom", etag); w.Code != http.StatusNotModified {
+		t.Errorf("entry removed feed status %d, want %d", w.Code, http.StatusNotModified)
+	}
+	etag = w.Header().Get("ETag")
+	env.DB.DeleteBookmark(env.DB.GetBookmark(1))
+	if w = serveFeed(env.RecentFeedHandler, "/feeds/recent.atom", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("emptied feed status %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	_ "github.com/mattn/go-sqlite3" // register sqlite3 driver
+)
+
+// sqliteHeader starts the SQLite database files, such as the Firefox places.sqlite.
+const sqliteHeader = "SQLite format 3\x00"
+
+// Firefox moz_bookmarks types.
+const (
+	firefoxBookmark = 1
+	firefoxFolder   = 2
+)
+
+// firefoxRoots are the titles of the Firefox root folders by guid.
+// The tags root is not imported.
+var firefoxRoots = map[string]string{
+	"menu________": "Bookmarks Menu",
+	"toolbar_____": "Bookmarks Toolbar",
+	"unfiled_____": "Other Bookmarks",
+	"mobile______": "Mobile Bookmarks",
+}
+
+// firefoxItem is a row of the Firefox moz_bookmarks table.
+type firefoxItem struct {
+	id, itemType  int
+	guid, title   string
+	url, keyword  string
+	createdAt     time.Time
+	updatedAt     time.Time
+	lastVisitedAt time.Time
+}
+
+// firefoxTime returns the time of a Firefox date, a unix time in microseconds.
+func firefoxTime(t sql.NullInt64) time.Time {
+	if !t.Valid || t.Int64 <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t.Int64*int64(time.Microsecond))
+}
+
+// importFirefox imports the bookmarks of the Firefox places.sqlite database
+// fileName into parentFolder, with their moz_keywords keywords.
+// The Firefox root folders are imported as folders.
+// It stops if ctx is cancelled.
+func (imp *importer) importFirefox(ctx context.Context, fileName string, parentFolder *types.Folder) error {
+	var (
+		places *sql.DB
+		rows   *sql.Rows
+		err    error
+		// children are the items by parent id, in the Firefox order.
+		children = make(map[int][]*firefoxItem)
+	)
+	if places, err = sql.Open("sqlite3", fileName); err != nil {
+		return err
+	}
+	defer places.Close()
+
+	if rows, err = places.QueryContext(ctx, `SELECT b.id, b.type, b.parent, COALESCE(b.guid, ''), COALESCE(b.title, ''), COALESCE(p.url, ''),
+		COALESCE((SELECT k.keyword FROM moz_keywords k WHERE k.place_id=b.fk ORDER BY k.id LIMIT 1), ''),
+		b.dateAdded, b.lastModified, p.last_visit_date
+		FROM moz_bookmarks b LEFT JOIN moz_places p ON p.id=b.fk
+		WHERE b.type IN (?, ?) ORDER BY b.parent, b.position`, firefoxBookmark, firefoxFolder); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			item                                firefoxItem
+			parent                              int
+			createdAt, updatedAt, lastVisitedAt sql.NullInt64
+		)
+		if err = rows.Scan(&item.id, &item.itemType, &parent, &item.guid, &item.title, &item.url, &item.keyword, &createdAt, &updatedAt, &lastVisitedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		item.createdAt, item.updatedAt, item.lastVisitedAt = firefoxTime(createdAt), firefoxTime(updatedAt), firefoxTime(lastVisitedAt)
+		children[parent] = append(children[parent], &item)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	// importFolder recursively imports the children of the Firefox folder id into fld.
+	var importFolder func(id int, fld *types.Folder) error
+	importFolder = func(id int, fld *types.Folder) error {
+		for _, item := range children[id] {
+			// Leaving on cancellation.
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if item.itemType == firefoxFolder {
+				sub := imp.folder(&types.Folder{Title: item.title, Parent: fld, CreatedAt: item.createdAt, UpdatedAt: item.updatedAt})
+				if err := importFolder(item.id, sub); err != nil {
+					return err
+				}
+				continue
+			}
+			if item.url == "" {
+				imp.malformed("bookmark", fld, item.title, "missing URL")
+				continue
+			}
+			if item.title == "" {
+				item.title = item.url
+			}
+			imp.bookmark(&types.Bookmark{Title: item.title, URL: item.url, Keyword: item.keyword, Folder: fld, CreatedAt: item.createdAt, UpdatedAt: item.updatedAt, LastVisitedAt: item.lastVisitedAt})
+		}
+		return nil
+	}
+
+	// The places root has no parent.
+	for _, root := range children[0] {
+		for _, item := range children[root.id] {
+			title, ok := firefoxRoots[item.guid]
+			if !ok || item.itemType != firefoxFolder || len(children[item.id]) == 0 {
+				continue
+			}
+			fld := imp.folder(&types.Folder{Title: title, Parent: parentFolder, CreatedAt: item.createdAt, UpdatedAt: item.updatedAt})
+			if err = importFolder(item.id, fld); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
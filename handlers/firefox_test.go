@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestPlaces returns a Firefox places.sqlite file with a Bookmarks Menu
+// of a folder and two keyword bookmarks, and an empty Bookmarks Toolbar.
+func newTestPlaces(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gobkm-places-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fileName := filepath.Join(dir, "places.sqlite")
+
+	places, err := sql.Open("sqlite3", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer places.Close()
+	for _, query := range []string{
+		"CREATE TABLE moz_places (id integer PRIMARY KEY, url text, last_visit_date integer)",
+		"CREATE TABLE moz_bookmarks (id integer PRIMARY KEY, type integer, fk integer, parent integer, position integer, title text, guid text, dateAdded integer, lastModified integer)",
+		"CREATE TABLE moz_keywords (id integer PRIMARY KEY, keyword text, place_id integer)",
+		"INSERT INTO moz_places VALUES (1, 'https://pkg.go.dev/search?q=%s', 1760000000000000), (2, 'https://en.wikipedia.org/wiki/%s', NULL)",
+		`INSERT INTO moz_bookmarks VALUES
+			(1, 2, NULL, 0, 0, '', 'root________', 0, 0),
+			(2, 2, NULL, 1, 0, 'menu', 'menu________', 1700000000000000, 1700000000000000),
+			(3, 2, NULL, 1, 1, 'toolbar', 'toolbar_____', 0, 0),
+			(4, 2, NULL, 2, 0, 'Search', 'folder000001', 1700000000000000, 1700000000000000),
+			(5, 1, 1, 4, 0, 'Go packages', 'bookmark0001', 1700000000000000, 1710000000000000),
+			(6, 1, 2, 4, 1, NULL, 'bookmark0002', 1700000000000000, 1700000000000000)`,
+		"INSERT INTO moz_keywords VALUES (1, 'godoc', 1), (2, 'wp', 2)",
+	} {
+		if _, err = places.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fileName
+}
+
+func TestImportFirefox(t *testing.T) {
+	env := newTestEnv(t)
+	// The wp keyword is already used.
+	wiki := saveBookmark(t, env, "Wiki", "https://wiki.example.com/%s", nil)
+	wiki.Keyword = "wp"
+	env.DB.UpdateBookmark(wiki)
+
+	root := env.DB.GetFolder(1)
+	imp := newImporter(env, root, importModeMerge, false)
+	if err := imp.importFirefox(context.Background(), newTestPlaces(t), root); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	godoc := env.DB.GetBookmarkByKeyword("godoc")
+	if godoc == nil {
+		t.Fatal("no godoc keyword bookmark")
+	}
+	if godoc.Title != "Go packages" || godoc.Folder == nil || folderPath(godoc.Folder) != "/Bookmarks Menu/Search" {
+		t.Errorf("godoc bookmark %q in %q", godoc.Title, folderPath(godoc.Folder))
+	}
+	if godoc.CreatedAt.Unix() != 1700000000 || godoc.UpdatedAt.Unix() != 1710000000 || godoc.LastVisitedAt.Unix() != 1760000000 {
+		t.Errorf("godoc bookmark times %v, %v, %v", godoc.CreatedAt, godoc.UpdatedAt, godoc.LastVisitedAt)
+	}
+	if b := env.DB.GetBookmarkByKeyword("wp"); b == nil || b.Id != wiki.Id {
+		t.Errorf("wp keyword bookmark %v, want %q", b, wiki.Title)
+	}
+	// The untitled bookmark is titled by its URL.
+	if bkms := env.DB.SearchBookmarks("wikipedia"); len(bkms) != 1 || bkms[0].Title != "https://en.wikipedia.org/wiki/%s" || bkms[0].Keyword != "" {
+		t.Errorf("wikipedia bookmarks %v", bkms)
+	}
+	// The empty toolbar is not imported.
+	for _, fld := range env.DB.GetRootFolders() {
+		if fld.Title == "Bookmarks Toolbar" {
+			t.Error("empty Bookmarks Toolbar imported")
+		}
+	}
+}
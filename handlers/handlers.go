@@ -1,20 +1,20 @@
 package handlers
 
 import (
-	"bytes"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
-	"golang.org/x/net/html"
-
 	"github.com/gorilla/websocket"
 	"github.com/tbellembois/gobkm/models"
 	"github.com/tbellembois/gobkm/types"
@@ -22,7 +22,9 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
-const faviconRequestBaseURL = "http://www.google.com/s2/favicons?domain_url="
+// DefaultFaviconURL is the default favicon service URL,
+// followed by the bookmark scheme and host.
+const DefaultFaviconURL = "http://www.google.com/s2/favicons?domain_url="
 
 var (
 	upgrader = websocket.Upgrader{
@@ -32,14 +34,21 @@ var (
 	}
 	wsconn *websocket.Conn
 	wserr  error
+	// wsmutex protects wsconn, a websocket connection supports one writer at a time.
+	wsmutex sync.Mutex
 )
 
 // Env is a structure used to pass objects throughout the application.
 type Env struct {
 	DB                  models.Datastore
 	GoBkmProxyURL       string // the application URL
+	PinboardToken       string // the Pinboard API auth_token, user:token, not checked if empty
+	FaviconURL          string // the favicon service URL, no favicon fetching if empty
+	FetchTimeout        time.Duration
+	UserAgent           string // the User-Agent of the fetching requests
 	TplMainData         string // main template data
 	TplAddBookmarkData  string // add bookmark template data
+	TplShareData        string // shared folder template data
 	CSSMainData         []byte // main css data
 	CSSAwesoneFontsData []byte // awesome fonts css data
 	JsData              []byte // js data
@@ -47,20 +56,17 @@ type Env struct {
 
 // staticDataStruct is used to pass static data to the Main template.
 type staticDataStruct struct {
-	Bkms                []*types.Bookmark
-	CSSMainData         string
-	CSSAwesoneFontsData string
-	JsData              string
-	GoBkmProxyURL       string
-	NewBookmarkURL      string
-	NewBookmarkTitle    string
-}
-
-// exportBookmarksStruct is used to build the bookmarks and folders tree in the export operation.
-type exportBookmarksStruct struct {
-	Fld  *types.Folder
-	Bkms []*types.Bookmark
-	Sub  []*exportBookmarksStruct
+	Bkms                   []*types.Bookmark
+	CSSMainData            string
+	CSSAwesoneFontsData    string
+	JsData                 string
+	GoBkmProxyURL          string
+	NewBookmarkURL         string
+	NewBookmarkTitle       string
+	NewBookmarkDescription string
+	NewBookmarkReadLater   bool
+	RootSortMode           string
+	Duplicates             []*types.Bookmark
 }
 
 // failHTTP send an HTTP error (httpStatus) with the given errorMessage.
@@ -74,28 +80,47 @@ func failHTTP(w http.ResponseWriter, functionName string, errorMessage string, h
 	fmt.Fprint(w, errorMessage)
 }
 
-// insertIndent the "depth" number of tabs to the given io.Writer.
-func insertIndent(wr io.Writer, depth int) {
-	for i := 0; i < depth; i++ {
-		if _, err := wr.Write([]byte("\t")); err != nil {
-			// Just logging the error.
-			log.WithFields(log.Fields{
-				"err": err,
-			}).Error("insertIdent")
+// sendMessage sends the given message to the websocket client.
+func sendMessage(m types.Message) error {
+	wsmutex.Lock()
+	defer wsmutex.Unlock()
+
+	if wsconn == nil {
+		return errors.New("no websocket client")
+	}
+	return wsconn.WriteJSON(m)
+}
+
+// readWebsocket reads the messages of the websocket client until it leaves,
+// forgetting then its connection unless replaced by a new client.
+func readWebsocket(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
 		}
 	}
+	wsmutex.Lock()
+	defer wsmutex.Unlock()
+	if wsconn == conn {
+		wsconn = nil
+	}
+	conn.Close()
 }
 
 // SocketHandler handles the websocket communications
 func (env *Env) SocketHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debug("SocketHandler called")
+	wsmutex.Lock()
+	defer wsmutex.Unlock()
 	wsconn, wserr = upgrader.Upgrade(w, r, nil)
 	if wserr != nil {
 		log.WithFields(log.Fields{
 			"wserr": wserr,
 		}).Error("SocketHandler")
 		failHTTP(w, "SocketHandler", "error opening socket", http.StatusInternalServerError)
+		return
 	}
+	go readWebsocket(wsconn)
 	// TESTS
 	//for i := 0; i < 10; i++ {
 	//	wsconn.WriteMessage(websocket.BinaryMessage, []byte("Message from server:"+strconv.Itoa(i)))
@@ -103,19 +128,51 @@ func (env *Env) SocketHandler(w http.ResponseWriter, r *http.Request) {
 	//}
 }
 
-// UpdateBookmarkFavicon retrieves and updates the favicon for the given bookmark.
+// session returns a copy of env with its own datastore session,
+// for a background job.
+func (env *Env) session() *Env {
+	s := *env
+	s.DB = env.DB.Session()
+	return &s
+}
+
+// fetch gets the given URL with the fetching settings of env.
+func (env *Env) fetch(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if env.UserAgent != "" {
+		req.Header.Set("User-Agent", env.UserAgent)
+	}
+	client := &http.Client{Timeout: env.FetchTimeout}
+	return client.Do(req)
+}
+
+// UpdateBookmarkFavicon retrieves and updates the favicon for the given bookmark,
+// with its own datastore session as it runs in the background.
 func (env *Env) UpdateBookmarkFavicon(bkm *types.Bookmark) {
+	if env.FaviconURL == "" {
+		return
+	}
+	ds := env.DB.Session()
 	if u, err := url.Parse(bkm.URL); err == nil {
 		// Building the favicon request URL.
 		bkmDomain := u.Scheme + "://" + u.Host
-		faviconRequestURL := faviconRequestBaseURL + bkmDomain
+		faviconRequestURL := env.FaviconURL + bkmDomain
 		log.WithFields(log.Fields{
 			"bkmDomain":         bkmDomain,
 			"faviconRequestUrl": faviconRequestURL,
 		}).Debug("UpdateBookmarkFavicon")
 
 		// Getting the favicon.
-		if response, err := http.Get(faviconRequestURL); err == nil {
+		response, err := env.fetch(faviconRequestURL)
+		if err != nil {
+			faviconFetches.Inc(faviconFetchError)
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Debug("UpdateBookmarkFavicon:fetch")
+		} else {
 			defer func() {
 				if err := response.Body.Close(); err != nil {
 					log.WithFields(log.Fields{
@@ -130,6 +187,10 @@ func (env *Env) UpdateBookmarkFavicon(bkm *types.Bookmark) {
 				"response.ContentLength": response.ContentLength,
 				"contentType":            contentType,
 			}).Debug("UpdateBookmarkFavicon")
+			if response.StatusCode != http.StatusOK || !strings.HasPrefix(contentType, "image/") {
+				faviconFetches.Inc(faviconNotImage)
+				return
+			}
 
 			// Converting the image into a base64 string.
 			image, _ := ioutil.ReadAll(response.Body)
@@ -139,12 +200,15 @@ func (env *Env) UpdateBookmarkFavicon(bkm *types.Bookmark) {
 			}).Debug("UpdateBookmarkFavicon")
 
 			// Updating the bookmark into the DB.
-			env.DB.UpdateBookmark(bkm)
-			if err = env.DB.FlushErrors(); err != nil {
+			ds.UpdateBookmark(bkm)
+			if err = ds.FlushErrors(); err != nil {
+				faviconFetches.Inc(faviconStoreError)
 				log.WithFields(log.Fields{
 					"err": err,
 				}).Error("UpdateBookmarkFavicon")
+				return
 			}
+			faviconFetches.Inc(faviconSuccess)
 		}
 	}
 }
@@ -223,9 +287,15 @@ func (env *Env) BookmarkThisHandler(w http.ResponseWriter, r *http.Request) {
 	// GET parameters retrieval.
 	url = r.URL.Query()["url"]
 	t := r.URL.Query()["title"]
+	// The page selected text.
+	description := r.URL.Query().Get("description")
+	// Saving into the reading list.
+	readLater := r.URL.Query().Get("readLater") == "true"
 	log.WithFields(log.Fields{
-		"url": url,
-		"t":   t,
+		"url":         url,
+		"t":           t,
+		"description": description,
+		"readLater":   readLater,
 	}).Debug("BookmarkThisHandler:Query parameter")
 
 	// Parameters check.
@@ -246,15 +316,24 @@ func (env *Env) BookmarkThisHandler(w http.ResponseWriter, r *http.Request) {
 		// TODO: should we exit the program ?
 	}
 
-	newBookmark := staticDataStruct{NewBookmarkURL: url[0], NewBookmarkTitle: title}
+	newBookmark := staticDataStruct{NewBookmarkURL: url[0], NewBookmarkTitle: title, NewBookmarkDescription: description, NewBookmarkReadLater: readLater}
+	// Warning about the bookmarks of the same URL.
+	newBookmark.Duplicates = env.DB.FindBookmarksByURL(url[0])
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "BookmarkThisHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
 	if err = htmlTpl.Execute(w, newBookmark); err != nil {
 		failHTTP(w, "BookmarkThisHandler", err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// SearchBookmarkHandler returns the bookmarks with the title containing the search parameter.
+// The bookmarks are sorted and filtered with the bookmarkQueryFromRequest parameters.
 func (env *Env) SearchBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		err error
+		q   types.BookmarkQuery
 	)
 	// GET parameters retrieval.
 	search := r.URL.Query()["search"]
@@ -267,9 +346,19 @@ func (env *Env) SearchBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 		failHTTP(w, "SearchBookmarkHandler", "search empty", http.StatusBadRequest)
 		return
 	}
+	if q, err = bookmarkQueryFromRequest(r); err != nil {
+		failHTTP(w, "SearchBookmarkHandler", err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Searching the bookmarks.
-	bkms := env.DB.SearchBookmarks(search[0])
+	q.Search = search[0]
+	bkms := env.DB.QueryBookmarks(q)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "SearchBookmarkHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Adding them into a map.
 	var bookmarksMap []*types.Bookmark
@@ -314,9 +403,15 @@ func (env *Env) AddBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 		failHTTP(w, "AddBookmarkHandler", "destinationFolderId Atoi conversion", http.StatusInternalServerError)
 		return
 	}
+	// Smart folders are read-only.
+	if env.readOnlyFolder(w, "AddBookmarkHandler", destinationFolderID) {
+		return
+	}
 
 	// Getting the destination folder.
 	dstFld := env.DB.GetFolder(destinationFolderID)
+	// and the bookmarks of the same URL, to be warned about.
+	duplicates := env.DB.FindBookmarksByURL(bookmarkURLDecoded)
 	// Creating a new Bookmark.
 	newBookmark := types.Bookmark{Title: bookmarkURLDecoded, URL: bookmarkURLDecoded, Folder: dstFld}
 	// Saving the bookmark into the DB, getting its id.
@@ -329,19 +424,20 @@ func (env *Env) AddBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Updating the bookmark favicon.
 	newBookmark.Id = int(bookmarkID)
-	go env.UpdateBookmarkFavicon(&newBookmark)
+	goBackground(func() { env.UpdateBookmarkFavicon(&newBookmark) })
 
 	w.Header().Set("Content-Type", "application/json")
-	if err = json.NewEncoder(w).Encode(types.Bookmark{Id: int(bookmarkID), URL: bookmarkURLDecoded}); err != nil {
+	if err = json.NewEncoder(w).Encode(types.AddedBookmark{Bookmark: types.Bookmark{Id: int(bookmarkID), URL: bookmarkURLDecoded}, Duplicates: duplicates}); err != nil {
 		failHTTP(w, "AddBookmarkHandler", err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func (env *Env) AddBookmarkBookmarkletHandler(w http.ResponseWriter, r *http.Request) {
 	var (
-		err   error
-		url   string
-		title string
+		err         error
+		url         string
+		title       string
+		description string
 	)
 	r.ParseForm()
 	// Parameters check.
@@ -353,15 +449,19 @@ func (env *Env) AddBookmarkBookmarkletHandler(w http.ResponseWriter, r *http.Req
 		failHTTP(w, "AddBookmarkBookmarkletHandler", "title empty", http.StatusBadRequest)
 		return
 	}
+	description = r.FormValue("description")
+	readLater := r.FormValue("readLater") == "true"
 	log.WithFields(log.Fields{
-		"url":   url,
-		"title": title,
+		"url":         url,
+		"title":       title,
+		"description": description,
+		"readLater":   readLater,
 	}).Debug("AddBookmarkBookmarkletHandler:Query parameter")
 
 	// Getting the destination folder = root folder.
 	dstFld := env.DB.GetFolder(0)
 	// Creating a new Bookmark.
-	newBookmark := types.Bookmark{Title: title, URL: url, Folder: dstFld}
+	newBookmark := types.Bookmark{Title: title, URL: url, Description: description, Unread: readLater, Folder: dstFld}
 	// Saving the bookmark into the DB, getting its id.
 	bookmarkID := env.DB.SaveBookmark(&newBookmark)
 	// Datastore error check.
@@ -372,15 +472,9 @@ func (env *Env) AddBookmarkBookmarkletHandler(w http.ResponseWriter, r *http.Req
 
 	// Updating the bookmark favicon.
 	newBookmark.Id = int(bookmarkID)
-	go env.UpdateBookmarkFavicon(&newBookmark)
+	goBackground(func() { env.UpdateBookmarkFavicon(&newBookmark) })
 
-	var jsonResp []byte
-	if jsonResp, err = json.Marshal(newBookmark); err != nil {
-		failHTTP(w, "AddBookmarkBookmarkletHandler", err.Error(), http.StatusInternalServerError)
-		return
-
-	}
-	if err = wsconn.WriteMessage(websocket.TextMessage, jsonResp); err != nil {
+	if err = sendMessage(types.Message{Type: types.MessageBookmark, Bookmark: &newBookmark}); err != nil {
 		failHTTP(w, "AddBookmarkBookmarkletHandler", err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -566,7 +660,6 @@ func (env *Env) RenameBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 		failHTTP(w, "RenameBookmarkHandler", err.Error(), http.StatusInternalServerError)
 		return
 	}
-	_ = wsconn.WriteMessage(websocket.TextMessage, []byte("Bookmark renamed."))
 }
 
 // StarBookmarkHandler handles the bookmark starring/unstarring.
@@ -627,18 +720,24 @@ func (env *Env) StarBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // MoveBookmarkHandler handles the bookmarks move.
+// The bookmark is moved after the last bookmark of the destination folder,
+// or before the bookmark of the optional beforeBookmarkId parameter,
+// the destination folder being then manually ordered.
 func (env *Env) MoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		bookmarkID          int
 		destinationFolderID int
+		beforeBookmarkID    int
 		err                 error
 	)
 	// GET parameters retrieval.
 	bookmarkIDParam := r.URL.Query()["bookmarkId"]
 	destinationFolderIDParam := r.URL.Query()["destinationFolderId"]
+	beforeBookmarkIDParam := r.URL.Query()["beforeBookmarkId"]
 	log.WithFields(log.Fields{
 		"bookmarkIdParam":          bookmarkIDParam,
 		"destinationFolderIdParam": destinationFolderIDParam,
+		"beforeBookmarkIdParam":    beforeBookmarkIDParam,
 	}).Debug("MoveBookmarkHandler:Query parameter")
 
 	// Parameters check.
@@ -655,6 +754,16 @@ func (env *Env) MoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 		failHTTP(w, "MoveBookmarkHandler", "destinationFolderId Atoi conversion", http.StatusInternalServerError)
 		return
 	}
+	if len(beforeBookmarkIDParam) != 0 {
+		if beforeBookmarkID, err = strconv.Atoi(beforeBookmarkIDParam[0]); err != nil {
+			failHTTP(w, "MoveBookmarkHandler", "beforeBookmarkId Atoi conversion", http.StatusBadRequest)
+			return
+		}
+	}
+	// Smart folders are read-only.
+	if env.readOnlyFolder(w, "MoveBookmarkHandler", destinationFolderID) {
+		return
+	}
 
 	// Getting the bookmark
 	bkm := env.DB.GetBookmark(bookmarkID)
@@ -674,6 +783,15 @@ func (env *Env) MoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Updating the folder into the DB.
 	env.DB.UpdateBookmark(bkm)
+	// Positioning the bookmark.
+	env.DB.PositionBookmark(bkm, beforeBookmarkID)
+	if beforeBookmarkID != 0 {
+		if bkm.Folder != nil {
+			env.DB.SortFolder(bkm.Folder.Id, types.SortManual)
+		} else {
+			env.DB.SortFolder(1, types.SortManual)
+		}
+	}
 	// Datastore error check.
 	if err = env.DB.FlushErrors(); err != nil {
 		failHTTP(w, "MoveBookmarkHandler", err.Error(), http.StatusInternalServerError)
@@ -682,18 +800,24 @@ func (env *Env) MoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // MoveFolderHandler handles the folders move.
+// The folder is moved after the last folder of the destination folder,
+// or before the folder of the optional beforeFolderId parameter,
+// the destination folder being then manually ordered.
 func (env *Env) MoveFolderHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		sourceFolderID      int
 		destinationFolderID int
+		beforeFolderID      int
 		err                 error
 	)
 	// GET parameters retrieval.
 	sourceFolderIDParam := r.URL.Query()["sourceFolderId"]
 	destinationFolderIDParam := r.URL.Query()["destinationFolderId"]
+	beforeFolderIDParam := r.URL.Query()["beforeFolderId"]
 	log.WithFields(log.Fields{
 		"sourceFolderIdParam":      sourceFolderIDParam,
 		"destinationFolderIdParam": destinationFolderIDParam,
+		"beforeFolderIdParam":      beforeFolderIDParam,
 	}).Debug("MoveFolderHandler:Query parameter")
 
 	// Parameters check.
@@ -710,6 +834,16 @@ func (env *Env) MoveFolderHandler(w http.ResponseWriter, r *http.Request) {
 		failHTTP(w, "MoveFolderHandler", "destinationFolderId Atoi conversion", http.StatusInternalServerError)
 		return
 	}
+	if len(beforeFolderIDParam) != 0 {
+		if beforeFolderID, err = strconv.Atoi(beforeFolderIDParam[0]); err != nil {
+			failHTTP(w, "MoveFolderHandler", "beforeFolderId Atoi conversion", http.StatusBadRequest)
+			return
+		}
+	}
+	// Smart folders have no subfolders.
+	if env.readOnlyFolder(w, "MoveFolderHandler", destinationFolderID) {
+		return
+	}
 
 	// Getting the source folder.
 	srcFld := env.DB.GetFolder(sourceFolderID)
@@ -729,6 +863,15 @@ func (env *Env) MoveFolderHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Updating the source folder into the DB.
 	env.DB.UpdateFolder(srcFld)
+	// Positioning the source folder.
+	env.DB.PositionFolder(srcFld, beforeFolderID)
+	if beforeFolderID != 0 {
+		if srcFld.Parent != nil {
+			env.DB.SortFolder(srcFld.Parent.Id, types.SortManual)
+		} else {
+			env.DB.SortFolder(1, types.SortManual)
+		}
+	}
 	// Datastore error check.
 	if err = env.DB.FlushErrors(); err != nil {
 		failHTTP(w, "MoveBookmarkHandler", err.Error(), http.StatusInternalServerError)
@@ -736,11 +879,55 @@ func (env *Env) MoveFolderHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SortFolderHandler sets the sort mode of a folder content,
+// one of manual, title, created, visits or frecency.
+func (env *Env) SortFolderHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		folderID int
+		err      error
+	)
+	// GET parameters retrieval.
+	folderIDParam := r.URL.Query()["folderId"]
+	sortModeParam := r.URL.Query()["sortMode"]
+	log.WithFields(log.Fields{
+		"folderIdParam": folderIDParam,
+		"sortModeParam": sortModeParam,
+	}).Debug("SortFolderHandler:Query parameter")
+
+	// Parameters check.
+	if len(folderIDParam) == 0 || len(sortModeParam) == 0 {
+		failHTTP(w, "SortFolderHandler", "folderIdParam or sortModeParam empty", http.StatusBadRequest)
+		return
+	}
+	// folderId int convertion.
+	if folderID, err = strconv.Atoi(folderIDParam[0]); err != nil {
+		failHTTP(w, "SortFolderHandler", "folderId Atoi conversion", http.StatusBadRequest)
+		return
+	}
+	if !isFolderSortMode(sortModeParam[0]) {
+		failHTTP(w, "SortFolderHandler", "unknown sort mode "+sortModeParam[0], http.StatusBadRequest)
+		return
+	}
+
+	// Updating the folder into the DB.
+	env.DB.SortFolder(folderID, sortModeParam[0])
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "SortFolderHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // GetFolderBookmarksHandler retrieves the bookmarks for the given folder.
+// The bookmarks are sorted and filtered with the bookmarkQueryFromRequest parameters,
+// and sorted by the folder sort mode without sort parameter.
+// The bookmarks of a smart folder are the bookmarks selected by its query,
+// the sort parameters overriding its sort.
 func (env *Env) GetFolderBookmarksHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		folderID int
 		err      error
+		q        types.BookmarkQuery
 	)
 	// GET parameters retrieval.
 	folderIDParam := r.URL.Query()["folderId"]
@@ -758,8 +945,38 @@ func (env *Env) GetFolderBookmarksHandler(w http.ResponseWriter, r *http.Request
 		failHTTP(w, "GetFolderBookmarksHandler", "folderId Atoi conversion", http.StatusInternalServerError)
 		return
 	}
+	if q, err = bookmarkQueryFromRequest(r); err != nil {
+		failHTTP(w, "GetFolderBookmarksHandler", err.Error(), http.StatusBadRequest)
+		return
+	}
+	fld := env.DB.GetFolder(folderID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err == sql.ErrNoRows {
+		failHTTP(w, "GetFolderBookmarksHandler", "folder not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		failHTTP(w, "GetFolderBookmarksHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fld.Query != "" {
+		// Evaluating the smart folder query.
+		var sq types.BookmarkQuery
+		if sq, err = smartFolderQuery(fld); err != nil {
+			failHTTP(w, "GetFolderBookmarksHandler", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if q.Sort != "" {
+			sq.Sort, sq.Desc = q.Sort, q.Desc
+		}
+		q = sq
+	} else {
+		if q.Sort == "" {
+			folderSortQuery(&q, fld)
+		}
+		q.FolderID = folderID
+	}
 	// Getting the folder bookmarks.
-	bkms := env.DB.GetFolderBookmarks(folderID)
+	bkms := env.DB.QueryBookmarks(q)
 	// Datastore error check.
 	if err = env.DB.FlushErrors(); err != nil {
 		failHTTP(w, "GetFolderBookmarksHandler", err.Error(), http.StatusInternalServerError)
@@ -783,10 +1000,13 @@ func (env *Env) GetFolderBookmarksHandler(w http.ResponseWriter, r *http.Request
 }
 
 // GetChildrenFoldersHandler retrieves the subfolders for the given folder.
+// The optional sort (title, created, updated or manual) and order (asc or desc) parameters sort the folders,
+// sorted by the folder sort mode without sort parameter.
 func (env *Env) GetChildrenFoldersHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		folderID int
 		err      error
+		q        types.BookmarkQuery
 	)
 	// GET parameters retrieval.
 	folderIDParam := r.URL.Query()["folderId"]
@@ -804,6 +1024,16 @@ func (env *Env) GetChildrenFoldersHandler(w http.ResponseWriter, r *http.Request
 		failHTTP(w, "GetChildrenFoldersHandler", "folderId Atoi conversion", http.StatusInternalServerError)
 		return
 	}
+	if q, err = bookmarkQueryFromRequest(r); err != nil {
+		failHTTP(w, "GetChildrenFoldersHandler", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if q.Sort == "" {
+		if fld := env.DB.GetFolder(folderID); fld != nil {
+			folderSortQuery(&q, fld)
+		}
+	}
 
 	// Getting the folder children folders.
 	flds := env.DB.GetFolderSubfolders(folderID)
@@ -812,6 +1042,7 @@ func (env *Env) GetChildrenFoldersHandler(w http.ResponseWriter, r *http.Request
 		failHTTP(w, "GetChildrenFoldersHandler", err.Error(), http.StatusInternalServerError)
 		return
 	}
+	sortFolders(flds, q)
 
 	// Adding them into a map.
 	var foldersMap []*types.Folder
@@ -825,210 +1056,184 @@ func (env *Env) GetChildrenFoldersHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// MainHandler handles the main application page.
-func (env *Env) MainHandler(w http.ResponseWriter, r *http.Request) {
-	log.Debug("MainHandler called")
+// GetBookmarksHandler retrieves the bookmarks of all the folders
+// sorted and filtered with the bookmarkQueryFromRequest parameters,
+// for example the bookmarks not visited since a date.
+func (env *Env) GetBookmarksHandler(w http.ResponseWriter, r *http.Request) {
 	var (
-		folderAndBookmark = new(staticDataStruct)
-		err               error
+		err error
+		q   types.BookmarkQuery
 	)
+	if q, err = bookmarkQueryFromRequest(r); err != nil {
+		failHTTP(w, "GetBookmarksHandler", err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Getting the starred bookmarks.
-	starredBookmarks := env.DB.GetStarredBookmarks()
-
-	// Getting the static data.
-	folderAndBookmark.JsData = string(env.JsData)
-	folderAndBookmark.GoBkmProxyURL = env.GoBkmProxyURL
-	folderAndBookmark.Bkms = starredBookmarks
-
-	// Building the HTML template.
-	htmlTpl := template.New("main")
-	if htmlTpl, err = htmlTpl.Parse(env.TplMainData); err != nil {
-		failHTTP(w, "MainHandler", err.Error(), http.StatusInternalServerError)
-		// TODO: should we exit the program ?
+	// Getting the bookmarks.
+	bkms := env.DB.QueryBookmarks(q)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "GetBookmarksHandler", err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if err = htmlTpl.Execute(w, folderAndBookmark); err != nil {
-		failHTTP(w, "MainHandler", err.Error(), http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(bkms); err != nil {
+		failHTTP(w, "GetBookmarksHandler", err.Error(), http.StatusInternalServerError)
 	}
 }
 
-// TestHandler not used in production.
-func (env *Env) TestHandler(w http.ResponseWriter, r *http.Request) {
+// VisitBookmarkHandler records a visit of the given bookmark.
+func (env *Env) VisitBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		bookmarkID int
+		err        error
+	)
+	// GET parameters retrieval.
+	bookmarkIDParam := r.URL.Query()["bookmarkId"]
 	log.WithFields(log.Fields{
-		"r": r,
-	}).Debug("TestHandler")
-}
+		"bookmarkIdParam": bookmarkIDParam,
+	}).Debug("VisitBookmarkHandler:Query parameter")
 
-// ImportHandler handles the import requests.
-func (env *Env) ImportHandler(w http.ResponseWriter, r *http.Request) {
-	// Getting the import file.
-	file, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		failHTTP(w, "ImportHandler", err.Error(), http.StatusInternalServerError)
+	// Parameters check.
+	if len(bookmarkIDParam) == 0 {
+		failHTTP(w, "VisitBookmarkHandler", "bookmarkId empty", http.StatusBadRequest)
+		return
+	}
+	// bookmarkId int convertion.
+	if bookmarkID, err = strconv.Atoi(bookmarkIDParam[0]); err != nil {
+		failHTTP(w, "VisitBookmarkHandler", "bookmarkId Atoi conversion", http.StatusBadRequest)
 		return
 	}
-	// Parsing the HTML.
-	doc, err := html.Parse(bytes.NewReader(file))
-	if err != nil {
-		failHTTP(w, "ImportHandler", err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// Building a new import folder name.
-	currentDate := time.Now().Local()
-	importFolderName := "import-" + currentDate.Format("2006-01-02")
-	// Creating and saving a new folder.
-	importFolder := types.Folder{Title: importFolderName}
-	id := env.DB.SaveFolder(&importFolder)
-	importFolder.Id = int(id)
-
-	// Function to recursively parse the n node.
-	var f func(n *html.Node, parentFolder *types.Folder)
-	f = func(n *html.Node, parentFolder *types.Folder) {
-		// Keeping the parent folder before calling f recursively.
-		var parentFolderBackup types.Folder
-		parentFolderBackup = *parentFolder
-
-		// Parsing the n children.
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			// Got a dt tag.
-			if c.Type == html.ElementNode && c.Data == "dt" {
-				dtTag := c.FirstChild
-				switch dtTag.Data {
-				case "h3":
-					// Got a <dt><h3> tag.
-					// Building the new folder.
-					h3Value := dtTag.FirstChild.Data
-					newFolder := types.Folder{Title: h3Value, Parent: parentFolder}
-					// Saving it into the DB.
-					id := env.DB.SaveFolder(&newFolder)
-					newFolder.Id = int(id)
-					// Updating the parent folder for next recursion.
-					parentFolder = &newFolder
-				case "a":
-					// Got a <dt><a> tag.
-					var h3Value string
-					var h3Href string
-					var h3Icon string
-
-					// Parsing the link attributes for href and icon.
-					for _, attr := range dtTag.Attr {
-						key := attr.Key
-						val := attr.Val
-						if key == "href" {
-							h3Href = val
-						}
-						if key == "icon" {
-							h3Icon = val
-						}
-					}
-					// Looking for a link title.
-					if dtTag.FirstChild != nil {
-						h3Value = dtTag.FirstChild.Data
-					} else {
-						h3Value = h3Href
-					}
-
-					// Creating the new Bookmark.
-					newBookmark := types.Bookmark{Title: h3Value, URL: h3Href, Favicon: h3Icon, Folder: parentFolder}
-					log.WithFields(log.Fields{
-						"newBookmark": newBookmark,
-					}).Debug("ImportHandler:Saving bookmark")
-					// And saving it.
-					env.DB.SaveBookmark(&newBookmark)
-				}
-			}
 
-			// Calling recursively f for each child of n.
-			f(c, parentFolder)
+	// Recording the visit.
+	env.DB.VisitBookmark(bookmarkID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "VisitBookmarkHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
 
-			// Restoring the parent folder.
-			parentFolder = &parentFolderBackup
-		}
+// GoHandler redirects /go/{id} to the URL of the bookmark id
+// and records the visit.
+func (env *Env) GoHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		bookmarkID int
+		err        error
+	)
+	// Path parameter retrieval.
+	bookmarkIDParam := strings.TrimPrefix(r.URL.Path, "/go/")
+	log.WithFields(log.Fields{
+		"bookmarkIdParam": bookmarkIDParam,
+	}).Debug("GoHandler:Path parameter")
+
+	// bookmarkId int convertion.
+	if bookmarkID, err = strconv.Atoi(bookmarkIDParam); err != nil {
+		failHTTP(w, "GoHandler", "bookmarkId Atoi conversion", http.StatusBadRequest)
+		return
 	}
 
-	// Importing the folders and bookmarks.
-	f(doc, &importFolder)
-	// Database errors check.
-	if err = env.DB.FlushErrors(); err != nil {
-		failHTTP(w, "ImportHandler", err.Error(), http.StatusInternalServerError)
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err == sql.ErrNoRows {
+		failHTTP(w, "GoHandler", "bookmark not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		failHTTP(w, "GoHandler", err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Returning "ok" to inform the AJAX caller that everyting was fine.
-	if _, err = w.Write([]byte("ok")); err != nil {
-		// Just logging the error.
+	env.visitRedirect(w, r, "GoHandler", bookmarkID, bkm.URL)
+}
+
+// visitRedirect records a visit of the bookmark id and redirects to target.
+func (env *Env) visitRedirect(w http.ResponseWriter, r *http.Request, functionName string, id int, target string) {
+	// Recording the visit.
+	env.DB.VisitBookmark(id)
+	if err := env.DB.FlushErrors(); err != nil {
+		// Just logging the error, the visit is not worth failing the redirect.
 		log.WithFields(log.Fields{
 			"err": err,
-		}).Error("ImportHandler")
+		}).Error(functionName + ": visit record error")
 	}
+
+	// Not cached so that every visit is recorded.
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
 }
 
-// ExportHandler handles the export requests.
-func (env *Env) ExportHandler(w http.ResponseWriter, r *http.Request) {
-	// Getting the root folder.
-	rootFolder := env.DB.GetFolder(1)
-	// HTML header and footer definition.
-	header := `<!DOCTYPE NETSCAPE-Bookmark-file-1>
-<!-- This is an automatically generated file.
-     It will be read and overwritten.
-     DO NOT EDIT! -->
-<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
-<TITLE>GoBkm</TITLE>
-<H1>GoBkm</H1>
-<DL><p>` + "\n"
-	footer := "</DL><p>\n"
-
-	// Writing the header meta informations.
-	w.Header().Set("Content-Disposition", "attachment; filename=gobkm.html")
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	// Writing the HTML header
-	if _, err := w.Write([]byte(header)); err != nil {
-		// Just logging the error.
-		log.WithFields(log.Fields{
-			"err": err,
-		}).Error("ExportHandler")
+// GetBookmarkVisitsHandler returns the number of visits per day of the given bookmark.
+func (env *Env) GetBookmarkVisitsHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		bookmarkID int
+		err        error
+	)
+	// GET parameters retrieval.
+	bookmarkIDParam := r.URL.Query()["bookmarkId"]
+	log.WithFields(log.Fields{
+		"bookmarkIdParam": bookmarkIDParam,
+	}).Debug("GetBookmarkVisitsHandler:Query parameter")
+
+	// Parameters check.
+	if len(bookmarkIDParam) == 0 {
+		failHTTP(w, "GetBookmarkVisitsHandler", "bookmarkId empty", http.StatusBadRequest)
+		return
 	}
-	// Exporting the bookmarks.
-	env.ExportTree(w, &exportBookmarksStruct{Fld: rootFolder}, 0)
-	// Writing the HTML footer.
-	if _, err := w.Write([]byte(footer)); err != nil {
-		// Just logging the error.
-		log.WithFields(log.Fields{
-			"err": err,
-		}).Error("ExportHandler")
+	// bookmarkId int convertion.
+	if bookmarkID, err = strconv.Atoi(bookmarkIDParam[0]); err != nil {
+		failHTTP(w, "GetBookmarkVisitsHandler", "bookmarkId Atoi conversion", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the visits.
+	visits := env.DB.GetBookmarkVisits(bookmarkID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "GetBookmarkVisitsHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(visits); err != nil {
+		failHTTP(w, "GetBookmarkVisitsHandler", err.Error(), http.StatusInternalServerError)
 	}
 }
 
-// ExportTree recursively exports in HTML the given bookmark struct.
-func (env *Env) ExportTree(wr io.Writer, eb *exportBookmarksStruct, depth int) *exportBookmarksStruct {
-	// Depth is just for cosmetics indent purposes.
-	depth++
-	log.WithFields(log.Fields{
-		"*eb": *eb,
-	}).Debug("ExportTree")
+// MainHandler handles the main application page.
+func (env *Env) MainHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("MainHandler called")
+	var (
+		folderAndBookmark = new(staticDataStruct)
+		err               error
+	)
 
-	// Writing the folder title.
-	insertIndent(wr, depth)
-	wr.Write([]byte("<DT><H3>" + eb.Fld.Title + "</H3>\n"))
-	insertIndent(wr, depth)
-	wr.Write([]byte("<DL><p>\n"))
+	// Getting the starred bookmarks.
+	starredBookmarks := env.DB.GetStarredBookmarks()
 
-	// For each children folder recursively building the bookmars tree.
-	for _, child := range env.DB.GetFolderSubfolders(eb.Fld.Id) {
-		eb.Sub = append(eb.Sub, env.ExportTree(wr, &exportBookmarksStruct{Fld: child}, depth))
+	// Getting the static data.
+	folderAndBookmark.JsData = string(env.JsData)
+	folderAndBookmark.GoBkmProxyURL = env.GoBkmProxyURL
+	folderAndBookmark.Bkms = starredBookmarks
+	if rootFolder := env.DB.GetFolder(1); rootFolder != nil {
+		folderAndBookmark.RootSortMode = rootFolder.SortMode
 	}
 
-	// Getting the folder bookmarks.
-	eb.Bkms = env.DB.GetFolderBookmarks(eb.Fld.Id)
-	// Writing them.
-	for _, bkm := range eb.Bkms {
-		insertIndent(wr, depth)
-		wr.Write([]byte("<DT><A HREF=\"" + bkm.URL + "\" ICON=\"" + bkm.Favicon + "\">" + bkm.Title + "</A>\n"))
+	// Building the HTML template.
+	htmlTpl := template.New("main")
+	if htmlTpl, err = htmlTpl.Parse(env.TplMainData); err != nil {
+		failHTTP(w, "MainHandler", err.Error(), http.StatusInternalServerError)
+		// TODO: should we exit the program ?
+	}
+
+	if err = htmlTpl.Execute(w, folderAndBookmark); err != nil {
+		failHTTP(w, "MainHandler", err.Error(), http.StatusInternalServerError)
 	}
-	insertIndent(wr, depth)
-	wr.Write([]byte("</DL><p>\n"))
+}
 
-	return eb
+// TestHandler not used in production.
+func (env *Env) TestHandler(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"r": r,
+	}).Debug("TestHandler")
 }
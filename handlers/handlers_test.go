@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/models"
+	"github.com/tbellembois/gobkm/types"
+)
+
+// newTestEnv returns an Env of a new database with its root folder,
+// removed at the end of the test.
+func newTestEnv(t *testing.T) *Env {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := models.NewDBstore(filepath.Join(dir, "bkm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.CreateDatabase()
+	if err = db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	return &Env{DB: db, GoBkmProxyURL: "http://gobkm.test"}
+}
+
+// serve returns the response of h to the method request of target, with the given body.
+func serve(h http.HandlerFunc, method string, target string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// serveForm returns the response of h to the POST request of the given form.
+func serveForm(h http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// saveFolder saves a folder of the given title into parent, the root folder if nil.
+func saveFolder(t *testing.T, env *Env, title string, parent *types.Folder) *types.Folder {
+	fld := &types.Folder{Title: title, Parent: parent}
+	fld.Id = int(env.DB.SaveFolder(fld))
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	return fld
+}
+
+// saveBookmark saves a bookmark of the given title and URL into fld, the root folder if nil.
+func saveBookmark(t *testing.T, env *Env, title string, url string, fld *types.Folder) *types.Bookmark {
+	bkm := &types.Bookmark{Title: title, URL: url, Folder: fld}
+	bkm.Id = int(env.DB.SaveBookmark(bkm))
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	return bkm
+}
+
+func TestGoHandler(t *testing.T) {
+	env := newTestEnv(t)
+	bkm := saveBookmark(t, env, "Go", "https://golang.org/", nil)
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"/go/1", http.StatusFound, "https://golang.org/"},
+		{"/go/1", http.StatusFound, "https://golang.org/"},
+		{"/go/2", http.StatusNotFound, ""},
+		{"/go/x", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := serve(env.GoHandler, "GET", tt.target, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+			continue
+		}
+		if l := w.Header().Get("Location"); l != tt.location {
+			t.Errorf("%s: location %q, want %q", tt.target, l, tt.location)
+		}
+		if tt.status == http.StatusFound && w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: cached redirect", tt.target)
+		}
+	}
+
+	// Both redirects are recorded as visits.
+	w := serve(env.GetBookmarkVisitsHandler, "GET", "/getBookmarkVisits/?bookmarkId="+strconv.Itoa(bkm.Id), "")
+	var visits []types.Visit
+	if err := json.NewDecoder(w.Body).Decode(&visits); err != nil {
+		t.Fatal(err)
+	}
+	if len(visits) != 1 || visits[0].Count != 2 {
+		t.Errorf("visits %v, want 2 visits today", visits)
+	}
+	if n := env.DB.GetBookmark(bkm.Id).VisitCount; n != 2 {
+		t.Errorf("visit count %d, want 2", n)
+	}
+}
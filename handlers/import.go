@@ -0,0 +1,495 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Import modes.
+const (
+	importModeNewFolder = "new"   // import into a new import-<date> folder
+	importModeMerge     = "merge" // merge the folders with the existing ones with the same path
+	importModeSkip      = "skip"  // merge the folders and skip the URLs already bookmarked anywhere
+)
+
+// importer saves the imported folders and bookmarks according to the import mode.
+// In dry run mode nothing is written into the DB,
+// the folders that would be created have an Id of 0.
+type importer struct {
+	env    *Env
+	mode   string
+	dryRun bool
+	root   *types.Folder // destination folder
+	report types.ImportReport
+	// progress is called after each processed entry.
+	progress func(folders int, bookmarks int, malformed int)
+	// Number of processed folders and bookmarks.
+	folders   int
+	bookmarks int
+	// urls is the set of the already bookmarked canonical URLs (skip mode only).
+	urls map[string]bool
+	// folderBookmarks caches the bookmarks of the merged folders with the imported ones,
+	// by folder path, the folders created in dry run mode having no id (merge mode only).
+	folderBookmarks map[string][]*types.Bookmark
+	// keywords is the set of the keywords given to the imported bookmarks.
+	keywords map[string]bool
+}
+
+// newImporter returns an importer of the given mode into the dst folder.
+func newImporter(env *Env, dst *types.Folder, mode string, dryRun bool) *importer {
+	imp := &importer{
+		env:             env,
+		mode:            mode,
+		dryRun:          dryRun,
+		root:            dst,
+		report:          types.ImportReport{Mode: mode, DryRun: dryRun},
+		progress:        func(int, int, int) {},
+		folderBookmarks: make(map[string][]*types.Bookmark),
+		keywords:        make(map[string]bool),
+	}
+	if mode == importModeSkip {
+		imp.urls = make(map[string]bool)
+		for _, bkm := range env.DB.GetAllBookmarks() {
+			imp.urls[env.DB.Canonicalize(bkm.URL)] = true
+		}
+	}
+	return imp
+}
+
+// folderPath returns the full path of the given folder, such as /IT/Development.
+func folderPath(f *types.Folder) string {
+	var titles []string
+	for ; f != nil && f.Parent != nil; f = f.Parent {
+		titles = append([]string{f.Title}, titles...)
+	}
+	return "/" + strings.Join(titles, "/")
+}
+
+// start creates the folder the import is done into and returns it.
+// A new import-<date> folder is created in importModeNewFolder mode,
+// the destination folder is returned otherwise.
+func (imp *importer) start() *types.Folder {
+	if imp.mode != importModeNewFolder {
+		return imp.root
+	}
+	// Building a new import folder name.
+	importFolderName := "import-" + time.Now().Local().Format("2006-01-02")
+	return imp.createFolder(&types.Folder{Title: importFolderName, Parent: imp.root})
+}
+
+// createFolder saves the given folder, or just reports it in dry run mode.
+func (imp *importer) createFolder(f *types.Folder) *types.Folder {
+	imp.report.Created = append(imp.report.Created, types.ImportReportEntry{Type: "folder", Path: folderPath(f.Parent), Title: f.Title})
+	if !imp.dryRun {
+		f.Id = int(imp.env.DB.SaveFolder(f))
+	}
+	return f
+}
+
+// malformed reports the given malformed entry.
+func (imp *importer) malformed(entryType string, parent *types.Folder, title string, reason string) {
+	log.WithFields(log.Fields{
+		"entryType": entryType,
+		"title":     title,
+		"reason":    reason,
+	}).Debug("importer:malformed entry")
+	imp.report.Malformed = append(imp.report.Malformed, types.ImportReportEntry{Type: entryType, Path: folderPath(parent), Title: title, Reason: reason})
+	imp.progress(imp.folders, imp.bookmarks, len(imp.report.Malformed))
+}
+
+// folder returns the folder to import into for the given folder,
+// with at least a Title and a Parent: the given folder once created
+// or an existing folder with the same path, a smart folder with the same query.
+func (imp *importer) folder(f *types.Folder) *types.Folder {
+	title, parent := f.Title, f.Parent
+	imp.folders++
+	defer imp.progress(imp.folders, imp.bookmarks, len(imp.report.Malformed))
+
+	// The root folder of a GoBkm export is the destination folder itself.
+	if title == "/" && parent == imp.root && imp.mode != importModeNewFolder {
+		return parent
+	}
+	// Looking for an existing folder with the same path.
+	// Folders that does not exist yet (dry run) have no children.
+	if imp.mode != importModeNewFolder && parent.Id != 0 {
+		for _, fld := range imp.env.DB.GetFolderSubfolders(parent.Id) {
+			if fld.Title == title && fld.Query == f.Query {
+				fld.Parent = parent
+				imp.report.Skipped = append(imp.report.Skipped, types.ImportReportEntry{Type: "folder", Path: folderPath(parent), Title: title})
+				return fld
+			}
+		}
+	}
+	return imp.createFolder(f)
+}
+
+// keyword returns the given imported keyword normalized for the bookmark id,
+// or "" if it is invalid or already used by another bookmark.
+func (imp *importer) keyword(keyword string, id int) string {
+	keyword, ok := normalizeKeyword(keyword)
+	if !ok || keyword == "" || imp.keywords[keyword] {
+		return ""
+	}
+	if owner := imp.env.DB.GetBookmarkByKeyword(keyword); owner != nil && owner.Id != id {
+		log.WithFields(log.Fields{
+			"keyword": keyword,
+			"owner":   owner.Id,
+		}).Debug("importer:keyword already used")
+		return ""
+	}
+	imp.keywords[keyword] = true
+	return keyword
+}
+
+// bookmark saves the given bookmark according to the import mode.
+// Keywords already used by other bookmarks are dropped.
+func (imp *importer) bookmark(b *types.Bookmark) {
+	imp.bookmarks++
+	defer imp.progress(imp.folders, imp.bookmarks, len(imp.report.Malformed))
+
+	entry := types.ImportReportEntry{Type: "bookmark", Path: folderPath(b.Folder), Title: b.Title, URL: b.URL}
+	b.Tags, _ = normalizeTags(b.Tags)
+
+	switch imp.mode {
+	case importModeSkip:
+		canonicalURL := imp.env.DB.Canonicalize(b.URL)
+		if imp.urls[canonicalURL] {
+			imp.report.Skipped = append(imp.report.Skipped, entry)
+			return
+		}
+		imp.urls[canonicalURL] = true
+	case importModeMerge:
+		// Getting the folder bookmarks once.
+		bkms, ok := imp.folderBookmarks[entry.Path]
+		if !ok && b.Folder.Id != 0 {
+			bkms = imp.env.DB.GetFolderBookmarks(b.Folder.Id)
+			imp.folderBookmarks[entry.Path] = bkms
+		}
+		for _, bkm := range bkms {
+			if bkm.URL != b.URL {
+				continue
+			}
+			if b.Keyword != "" && b.Keyword != bkm.Keyword {
+				if b.Keyword = imp.keyword(b.Keyword, bkm.Id); b.Keyword == "" {
+					b.Keyword = bkm.Keyword
+				}
+			}
+			tags := addTags(append([]string(nil), bkm.Tags...), b.Tags)
+			if bkm.Title == b.Title && (b.Favicon == "" || bkm.Favicon == b.Favicon) && (b.Description == "" || bkm.Description == b.Description) && (b.Keyword == "" || bkm.Keyword == b.Keyword) && len(tags) == len(bkm.Tags) {
+				imp.report.Skipped = append(imp.report.Skipped, entry)
+				return
+			}
+			// Updating the existing bookmark with the imported values.
+			bkm.Title = b.Title
+			if b.Favicon != "" {
+				bkm.Favicon = b.Favicon
+			}
+			if b.Description != "" {
+				bkm.Description = b.Description
+			}
+			if b.Keyword != "" {
+				bkm.Keyword = b.Keyword
+			}
+			bkm.Tags = tags
+			bkm.Folder = b.Folder
+			imp.report.Updated = append(imp.report.Updated, entry)
+			if !imp.dryRun {
+				imp.env.DB.UpdateBookmark(bkm)
+			}
+			return
+		}
+	}
+
+	if b.Keyword != "" {
+		b.Keyword = imp.keyword(b.Keyword, 0)
+	}
+	imp.report.Created = append(imp.report.Created, entry)
+	if !imp.dryRun {
+		b.Id = int(imp.env.DB.SaveBookmark(b))
+	}
+	// The next bookmarks of the same URL are merged into this one.
+	if imp.mode == importModeMerge {
+		imp.folderBookmarks[entry.Path] = append(imp.folderBookmarks[entry.Path], b)
+	}
+}
+
+// netscapeTime returns the time of a Netscape bookmark file date attribute,
+// a unix time in seconds, milliseconds or microseconds depending on the browser.
+// The zero time is returned for invalid dates.
+func netscapeTime(val string) time.Time {
+	t, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+	switch {
+	case err != nil || t <= 0:
+		return time.Time{}
+	case t > 1e14:
+		return time.Unix(0, t*int64(time.Microsecond))
+	case t > 1e11:
+		return time.Unix(0, t*int64(time.Millisecond))
+	default:
+		return time.Unix(t, 0)
+	}
+}
+
+// tagText returns the text of the current tag of z, up to its end tag.
+func tagText(z *html.Tokenizer, tag string) string {
+	var text string
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(text)
+		case html.TextToken:
+			text += string(z.Text())
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == tag {
+				return strings.TrimSpace(text)
+			}
+		case html.StartTagToken:
+			// A new entry starts, the end tag is missing.
+			if name, _ := z.TagName(); string(name) == "dt" || string(name) == "dl" {
+				return strings.TrimSpace(text)
+			}
+		}
+	}
+}
+
+// importNetscape imports the Netscape bookmark file read from r into parentFolder.
+// The file is parsed as a stream: the folders are kept in a stack
+// pushed on <DL> and popped on </DL>.
+// A bookmark is saved at the next tag, once its optional <DD> description is read.
+// It stops if ctx is cancelled.
+func (imp *importer) importNetscape(ctx context.Context, r io.Reader, parentFolder *types.Folder) error {
+	var (
+		z = html.NewTokenizer(r)
+		// stack of opened folders, the top is the current folder.
+		stack = []*types.Folder{parentFolder}
+		// pendingFolder is the last <H3> folder, opened by the next <DL>.
+		pendingFolder *types.Folder
+		// pendingBookmark is the last <A> bookmark, not saved yet.
+		pendingBookmark *types.Bookmark
+		// inDescription is true while reading the <DD> text of pendingBookmark.
+		inDescription bool
+	)
+	// savePendingBookmark saves the last bookmark with its description.
+	savePendingBookmark := func() {
+		if pendingBookmark != nil {
+			pendingBookmark.Description = strings.TrimSpace(pendingBookmark.Description)
+			imp.bookmark(pendingBookmark)
+			pendingBookmark = nil
+		}
+		inDescription = false
+	}
+
+	for {
+		// Leaving on cancellation.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		currentFolder := stack[len(stack)-1]
+		switch z.Next() {
+		case html.ErrorToken:
+			savePendingBookmark()
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		case html.TextToken:
+			if inDescription {
+				pendingBookmark.Description += string(z.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "dl" {
+				savePendingBookmark()
+				if len(stack) == 1 {
+					imp.malformed("folder", currentFolder, "", "unbalanced </DL>")
+					continue
+				}
+				stack = stack[:len(stack)-1]
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "dd":
+				// Got a <dd> tag, the description of the last bookmark.
+				inDescription = pendingBookmark != nil
+			case "br":
+				if inDescription {
+					pendingBookmark.Description += "\n"
+				}
+			case "dt":
+				savePendingBookmark()
+			case "dl":
+				savePendingBookmark()
+				// Opening the last folder, or the current one again
+				// for the top <DL> of the file.
+				if pendingFolder != nil {
+					stack = append(stack, pendingFolder)
+					pendingFolder = nil
+				} else {
+					stack = append(stack, currentFolder)
+				}
+			case "h3":
+				// Got a <dt><h3> tag.
+				savePendingBookmark()
+				fld := &types.Folder{Parent: currentFolder}
+
+				// Parsing the folder attributes for the dates.
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					switch string(key) {
+					case "add_date":
+						fld.CreatedAt = netscapeTime(string(val))
+					case "last_modified":
+						fld.UpdatedAt = netscapeTime(string(val))
+					}
+				}
+				fld.Title = tagText(z, "h3")
+				pendingFolder = imp.folder(fld)
+			case "a":
+				// Got a <dt><a> tag.
+				savePendingBookmark()
+				var h3Href string
+				var h3Icon string
+				bkm := &types.Bookmark{Folder: currentFolder}
+
+				// Parsing the link attributes for href, icon, dates and keyword.
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					switch string(key) {
+					case "href":
+						h3Href = string(val)
+					case "icon":
+						h3Icon = string(val)
+					case "add_date":
+						bkm.CreatedAt = netscapeTime(string(val))
+					case "last_modified":
+						bkm.UpdatedAt = netscapeTime(string(val))
+					case "last_visit":
+						bkm.LastVisitedAt = netscapeTime(string(val))
+					case "shortcuturl":
+						bkm.Keyword = string(val)
+					case "tags":
+						bkm.Tags, _ = normalizeTags(strings.Split(string(val), ","))
+					}
+				}
+				// Looking for a link title.
+				h3Value := tagText(z, "a")
+				if h3Value == "" {
+					h3Value = h3Href
+				}
+
+				// Checking the link.
+				if h3Href == "" {
+					imp.malformed("bookmark", currentFolder, h3Value, "missing HREF")
+					continue
+				}
+				// The keywords URL templates %s are not URL escapes.
+				if _, err := url.Parse(keywordURL(h3Href, "")); err != nil {
+					imp.malformed("bookmark", currentFolder, h3Value, "invalid URL: "+err.Error())
+					continue
+				}
+
+				// The new Bookmark is saved with its description.
+				bkm.Title, bkm.URL, bkm.Favicon = h3Value, h3Href, h3Icon
+				pendingBookmark = bkm
+			}
+		}
+	}
+}
+
+// importJSONFolder recursively imports the content of the given JSON export folder into parentFolder.
+// The smart folders are imported with their query, their exported bookmarks being
+// the bookmarks of other folders.
+func (imp *importer) importJSONFolder(ctx context.Context, jf *exportJSONFolder, parentFolder *types.Folder) error {
+	for _, sub := range jf.Folders {
+		// Leaving on cancellation.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if sub.Query != "" {
+			if _, err := parseSmartQuery(sub.Query); err != nil {
+				imp.malformed("folder", parentFolder, sub.Title, err.Error())
+				continue
+			}
+			imp.folder(&types.Folder{Title: sub.Title, Parent: parentFolder, Query: sub.Query, CreatedAt: sub.CreatedAt, UpdatedAt: sub.UpdatedAt})
+			continue
+		}
+		fld := imp.folder(&types.Folder{Title: sub.Title, Parent: parentFolder, CreatedAt: sub.CreatedAt, UpdatedAt: sub.UpdatedAt})
+		if err := imp.importJSONFolder(ctx, sub, fld); err != nil {
+			return err
+		}
+	}
+	for _, bkm := range jf.Bookmarks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if bkm.URL == "" {
+			imp.malformed("bookmark", parentFolder, bkm.Title, "missing URL")
+			continue
+		}
+		bkm.Id = 0
+		bkm.Folder = parentFolder
+		imp.bookmark(bkm)
+	}
+	return nil
+}
+
+// importJSON imports the GoBkm JSON export read from r into parentFolder.
+// The exported root folder is imported as a folder like the others.
+func (imp *importer) importJSON(ctx context.Context, r io.Reader, parentFolder *types.Folder) error {
+	var jf exportJSONFolder
+	if err := json.NewDecoder(r).Decode(&jf); err != nil {
+		return err
+	}
+	return imp.importJSONFolder(ctx, &exportJSONFolder{Folders: []*exportJSONFolder{&jf}}, parentFolder)
+}
+
+// importFile imports the Netscape bookmark file, GoBkm JSON export
+// or Firefox places.sqlite file, according to its first characters.
+// The SQLite databases are read from their file, r must be an *os.File.
+func (imp *importer) importFile(ctx context.Context, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if header, _ := br.Peek(len(sqliteHeader)); string(header) == sqliteHeader {
+		file, ok := r.(*os.File)
+		if !ok {
+			return errors.New("SQLite databases are imported from files only")
+		}
+		return imp.importFirefox(ctx, file.Name(), imp.start())
+	}
+	for {
+		c, _, err := br.ReadRune()
+		if err != nil {
+			return err
+		}
+		// Skipping the leading spaces and byte order mark.
+		if unicode.IsSpace(c) || c == '\uFEFF' {
+			continue
+		}
+		if err = br.UnreadRune(); err != nil {
+			return err
+		}
+		if c == '{' {
+			return imp.importJSON(ctx, br, imp.start())
+		}
+		return imp.importNetscape(ctx, br, imp.start())
+	}
+}
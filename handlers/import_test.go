@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// importTestFile has an existing folder and bookmark, and duplicated entries.
+const importTestFile = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<DL><p>
+<DT><H3>IT</H3>
+<DL><p>
+<DT><A HREF="https://golang.org/">Go</A>
+<DT><A HREF="https://example.com/">Example</A>
+<DT><A HREF="https://example.com/">Example</A>
+</DL><p>
+<DT><H3>New</H3>
+<DL><p>
+<DT><A HREF="https://new.example.com/">New</A>
+<DT><A HREF="https://new.example.com/">New</A>
+</DL><p>
+<DT><A HREF="https://root.example.com/">Root</A>
+<DT><A HREF="https://root.example.com/?utm_source=feed">Root</A>
+</DL><p>
+`
+
+// countEntries returns the number of folder and bookmark entries.
+func countEntries(entries []types.ImportReportEntry) (folders int, bookmarks int) {
+	for _, e := range entries {
+		if e.Type == "folder" {
+			folders++
+		} else {
+			bookmarks++
+		}
+	}
+	return folders, bookmarks
+}
+
+func TestImportModes(t *testing.T) {
+	tests := []struct {
+		mode   string
+		dryRun bool
+		// Created and skipped folders and bookmarks.
+		createdFolders, createdBookmarks int
+		skippedFolders, skippedBookmarks int
+		// bookmarks is the number of bookmarks once imported.
+		bookmarks int
+	}{
+		{importModeNewFolder, false, 3, 7, 0, 0, 8},
+		{importModeNewFolder, true, 3, 7, 0, 0, 1},
+		{importModeMerge, false, 1, 4, 1, 3, 5},
+		{importModeMerge, true, 1, 4, 1, 3, 1},
+		{importModeSkip, false, 1, 3, 1, 4, 4},
+		{importModeSkip, true, 1, 3, 1, 4, 1},
+	}
+	for _, tt := range tests {
+		env := newTestEnv(t)
+		it := saveFolder(t, env, "IT", nil)
+		saveBookmark(t, env, "Go", "https://golang.org/", it)
+
+		root := env.DB.GetFolder(1)
+		imp := newImporter(env, root, tt.mode, tt.dryRun)
+		if err := imp.importFile(context.Background(), strings.NewReader(importTestFile)); err != nil {
+			t.Fatalf("%s dry run %t: import error %v", tt.mode, tt.dryRun, err)
+		}
+		if err := env.DB.FlushErrors(); err != nil {
+			t.Fatalf("%s dry run %t: datastore error %v", tt.mode, tt.dryRun, err)
+		}
+
+		folders, bookmarks := countEntries(imp.report.Created)
+		if folders != tt.createdFolders || bookmarks != tt.createdBookmarks {
+			t.Errorf("%s dry run %t: created %d folders and %d bookmarks, want %d and %d", tt.mode, tt.dryRun, folders, bookmarks, tt.createdFolders, tt.createdBookmarks)
+		}
+		folders, bookmarks = countEntries(imp.report.Skipped)
+		if folders != tt.skippedFolders || bookmarks != tt.skippedBookmarks {
+			t.Errorf("%s dry run %t: skipped %d folders and %d bookmarks, want %d and %d", tt.mode, tt.dryRun, folders, bookmarks, tt.skippedFolders, tt.skippedBookmarks)
+		}
+		if n := len(env.DB.GetAllBookmarks()); n != tt.bookmarks {
+			t.Errorf("%s dry run %t: %d bookmarks, want %d", tt.mode, tt.dryRun, n, tt.bookmarks)
+		}
+	}
+}
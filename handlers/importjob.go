@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// importProgressInterval is the minimum delay between two import progress messages.
+	importProgressInterval = 500 * time.Millisecond
+	// finishedImportJobTTL is the delay the finished jobs and their reports are kept.
+	finishedImportJobTTL = time.Hour
+	// maxFinishedImportJobs is the number of finished jobs kept, the newest ones.
+	maxFinishedImportJobs = 10
+)
+
+var (
+	// importJobs are the running and finished import jobs by id.
+	importJobs      = make(map[int]*importJob)
+	importJobsMutex sync.Mutex
+	lastImportJobID int
+)
+
+// importJob is an import running in the background.
+type importJob struct {
+	mutex  sync.Mutex
+	job    types.ImportJob
+	cancel context.CancelFunc
+	// lastProgress is the time of the last progress message.
+	lastProgress time.Time
+	// finished is the time the job finished, zero while running.
+	finished time.Time
+}
+
+// evictImportJobs forgets the finished jobs older than finishedImportJobTTL,
+// and the oldest ones beyond maxFinishedImportJobs.
+// importJobsMutex must be held.
+func evictImportJobs() {
+	var finished []*importJob
+	for id, j := range importJobs {
+		j.mutex.Lock()
+		t := j.finished
+		j.mutex.Unlock()
+		switch {
+		case t.IsZero():
+		case time.Since(t) > finishedImportJobTTL:
+			delete(importJobs, id)
+		default:
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxFinishedImportJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].job.Id > finished[k].job.Id })
+	for _, j := range finished[maxFinishedImportJobs:] {
+		delete(importJobs, j.job.Id)
+	}
+}
+
+// newImportJob registers and returns a new running import job.
+func newImportJob(cancel context.CancelFunc) *importJob {
+	importJobsMutex.Lock()
+	defer importJobsMutex.Unlock()
+
+	evictImportJobs()
+	lastImportJobID++
+	j := &importJob{job: types.ImportJob{Id: lastImportJobID, Status: types.ImportJobRunning}, cancel: cancel}
+	importJobs[j.job.Id] = j
+	return j
+}
+
+// getImportJob returns the import job with the given id or nil.
+func getImportJob(id int) *importJob {
+	importJobsMutex.Lock()
+	defer importJobsMutex.Unlock()
+
+	evictImportJobs()
+	return importJobs[id]
+}
+
+// state returns a copy of the job state.
+func (j *importJob) state() types.ImportJob {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.job
+}
+
+// notify sends the job progress to the websocket client.
+// The report is not sent, it is retrieved with GetImportJobHandler.
+func (j *importJob) notify(job types.ImportJob) {
+	job.Report = nil
+	if err := sendMessage(types.Message{Type: types.MessageImportJob, ImportJob: &job}); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Debug("importJob:notify")
+	}
+}
+
+// progress updates the job counters and sends them to the client
+// at most every importProgressInterval.
+func (j *importJob) progress(folders int, bookmarks int, malformed int) {
+	j.mutex.Lock()
+	j.job.Folders = folders
+	j.job.Bookmarks = bookmarks
+	j.job.Malformed = malformed
+	if time.Since(j.lastProgress) < importProgressInterval {
+		j.mutex.Unlock()
+		return
+	}
+	j.lastProgress = time.Now()
+	job := j.job
+	j.mutex.Unlock()
+
+	j.notify(job)
+}
+
+// finish sets the final job status and report and sends them to the client.
+func (j *importJob) finish(status string, err error, report types.ImportReport) {
+	j.mutex.Lock()
+	j.job.Status = status
+	j.finished = time.Now()
+	if err != nil {
+		j.job.Error = err.Error()
+	}
+	j.job.Malformed = len(report.Malformed)
+	j.job.Report = &report
+	job := j.job
+	j.mutex.Unlock()
+
+	log.WithFields(log.Fields{
+		"id":        job.Id,
+		"status":    job.Status,
+		"folders":   job.Folders,
+		"bookmarks": job.Bookmarks,
+		"err":       err,
+	}).Debug("importJob:finish")
+	j.notify(job)
+}
+
+// run imports the file with the given importer then removes the file,
+// releasing the job context.
+func (j *importJob) run(ctx context.Context, imp *importer, fileName string) {
+	defer j.cancel()
+	defer func() {
+		if err := os.Remove(fileName); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("importJob:error removing the import file")
+		}
+	}()
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		j.finish(types.ImportJobFailed, err, imp.report)
+		return
+	}
+	defer file.Close()
+
+	imp.progress = j.progress
+	err = imp.importFile(ctx, file)
+	// Database errors check.
+	if dbErr := imp.env.DB.FlushErrors(); dbErr != nil {
+		err = dbErr
+	}
+
+	switch {
+	case err == context.Canceled:
+		j.finish(types.ImportJobCancelled, nil, imp.report)
+	case err != nil:
+		j.finish(types.ImportJobFailed, err, imp.report)
+	default:
+		j.finish(types.ImportJobDone, nil, imp.report)
+	}
+}
+
+// ImportHandler handles the import requests.
+// The request body is a Netscape bookmark file, a GoBkm JSON export
+// or a Firefox places.sqlite file, imported by a background job.
+// The optional folderId parameter is the destination folder (root by default),
+// the optional mode parameter is one of new (default), merge or skip,
+// and with dryRun=true nothing is saved.
+// The JSON types.ImportJob of the new job is returned.
+func (env *Env) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err      error
+		folderID = 1
+		mode     = importModeNewFolder
+		dryRun   bool
+		file     *os.File
+	)
+	// GET parameters retrieval.
+	folderIDParam := r.URL.Query()["folderId"]
+	modeParam := r.URL.Query()["mode"]
+	dryRunParam := r.URL.Query()["dryRun"]
+	log.WithFields(log.Fields{
+		"folderIdParam": folderIDParam,
+		"modeParam":     modeParam,
+		"dryRunParam":   dryRunParam,
+	}).Debug("ImportHandler:Query parameter")
+
+	// Parameters check.
+	if len(folderIDParam) != 0 && folderIDParam[0] != "" {
+		if folderID, err = strconv.Atoi(folderIDParam[0]); err != nil {
+			failHTTP(w, "ImportHandler", "folderId Atoi conversion", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(modeParam) != 0 && modeParam[0] != "" {
+		mode = modeParam[0]
+	}
+	if mode != importModeNewFolder && mode != importModeMerge && mode != importModeSkip {
+		failHTTP(w, "ImportHandler", "unknown import mode", http.StatusBadRequest)
+		return
+	}
+	if len(dryRunParam) != 0 && dryRunParam[0] == "true" {
+		dryRun = true
+	}
+
+	// Getting the destination folder.
+	dstFld := env.DB.GetFolder(folderID)
+	if err = env.DB.FlushErrors(); err != nil && err != sql.ErrNoRows {
+		failHTTP(w, "ImportHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == sql.ErrNoRows || dstFld == nil {
+		failHTTP(w, "ImportHandler", "destination folder not found", http.StatusNotFound)
+		return
+	}
+	if dstFld.Query != "" {
+		failHTTP(w, "ImportHandler", "smart folders are read-only", http.StatusBadRequest)
+		return
+	}
+
+	// Copying the request body into a temporary file
+	// for the job to parse it after the request is over.
+	if file, err = ioutil.TempFile("", "gobkm-import-"); err != nil {
+		failHTTP(w, "ImportHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err = io.Copy(file, r.Body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		failHTTP(w, "ImportHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		failHTTP(w, "ImportHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Starting the import job, unless shutting down.
+	if !startBackground() {
+		os.Remove(file.Name())
+		failHTTP(w, "ImportHandler", "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := newImportJob(cancel)
+	go func() {
+		defer background.Done()
+		job.run(ctx, newImporter(env.session(), dstFld, mode, dryRun), file.Name())
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err = json.NewEncoder(w).Encode(job.state()); err != nil {
+		failHTTP(w, "ImportHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// importJobFromRequest returns the import job of the jobId request parameter.
+func importJobFromRequest(w http.ResponseWriter, r *http.Request, functionName string) *importJob {
+	var (
+		err   error
+		jobID int
+	)
+	// GET parameters retrieval.
+	jobIDParam := r.URL.Query()["jobId"]
+	log.WithFields(log.Fields{
+		"jobIdParam": jobIDParam,
+	}).Debug(functionName + ":Query parameter")
+
+	// Parameters check.
+	if len(jobIDParam) == 0 {
+		failHTTP(w, functionName, "jobId empty", http.StatusBadRequest)
+		return nil
+	}
+	// jobId int convertion.
+	if jobID, err = strconv.Atoi(jobIDParam[0]); err != nil {
+		failHTTP(w, functionName, "jobId Atoi conversion", http.StatusBadRequest)
+		return nil
+	}
+
+	job := getImportJob(jobID)
+	if job == nil {
+		failHTTP(w, functionName, "no import job with that id", http.StatusNotFound)
+	}
+	return job
+}
+
+// GetImportJobHandler returns the state of an import job,
+// with its report when it is over.
+func (env *Env) GetImportJobHandler(w http.ResponseWriter, r *http.Request) {
+	job := importJobFromRequest(w, r, "GetImportJobHandler")
+	if job == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job.state()); err != nil {
+		failHTTP(w, "GetImportJobHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CancelImportJobHandler cancels a running import job.
+// The folders and bookmarks already imported are kept.
+func (env *Env) CancelImportJobHandler(w http.ResponseWriter, r *http.Request) {
+	job := importJobFromRequest(w, r, "CancelImportJobHandler")
+	if job == nil {
+		return
+	}
+	job.cancel()
+}
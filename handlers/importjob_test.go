@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// resetImportJobs forgets the import jobs of the previous tests.
+func resetImportJobs() {
+	importJobsMutex.Lock()
+	defer importJobsMutex.Unlock()
+
+	importJobs = make(map[int]*importJob)
+}
+
+func TestImportHandler(t *testing.T) {
+	resetImportJobs()
+	env := newTestEnv(t)
+	file := `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<DL><p>
+<DT><A HREF="https://golang.org/">Go</A>
+<DT><A>no URL</A>
+</DL><p>
+`
+	tests := []struct {
+		target string
+		code   int
+		status string // the final status of the job if started
+		// created is the number of created folders and bookmarks.
+		created int
+	}{
+		{"/import/", http.StatusAccepted, types.ImportJobDone, 2},
+		{"/import/?mode=merge&dryRun=true", http.StatusAccepted, types.ImportJobDone, 1},
+		{"/import/?mode=unknown", http.StatusBadRequest, "", 0},
+		{"/import/?folderId=999", http.StatusNotFound, "", 0},
+		{"/import/?folderId=a", http.StatusBadRequest, "", 0},
+	}
+	for _, tt := range tests {
+		w := serve(env.ImportHandler, http.MethodPost, tt.target, file)
+		if w.Code != tt.code {
+			t.Errorf("%s: answered %d, want %d", tt.target, w.Code, tt.code)
+			continue
+		}
+		if w.Code != http.StatusAccepted {
+			continue
+		}
+		var job types.ImportJob
+		if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+		background.Wait()
+
+		w = serve(env.GetImportJobHandler, http.MethodGet, "/getImportJob/?jobId="+strconv.Itoa(job.Id), "")
+		if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != tt.status || job.Report == nil {
+			t.Errorf("%s: job %+v, want %s with its report", tt.target, job, tt.status)
+			continue
+		}
+		if len(job.Report.Created) != tt.created || job.Malformed != 1 || len(job.Report.Malformed) != 1 {
+			t.Errorf("%s: report %+v, want %d created and 1 malformed entries", tt.target, job.Report, tt.created)
+		}
+	}
+
+	if w := serve(env.GetImportJobHandler, http.MethodGet, "/getImportJob/?jobId=999", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: answered %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestImportJobCancelled(t *testing.T) {
+	resetImportJobs()
+	env := newTestEnv(t)
+	file, err := ioutil.TempFile("", "gobkm-import-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`<DL><p><DT><A HREF="https://golang.org/">Go</A></DL><p>`)
+	file.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := newImportJob(cancel)
+	w := serve(env.CancelImportJobHandler, http.MethodGet, "/cancelImportJob/?jobId="+strconv.Itoa(job.state().Id), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancelImportJob answered %d", w.Code)
+	}
+	job.run(ctx, newImporter(env, env.DB.GetFolder(1), importModeNewFolder, false), file.Name())
+	if state := job.state(); state.Status != types.ImportJobCancelled {
+		t.Errorf("job status %s, want %s", state.Status, types.ImportJobCancelled)
+	}
+	if n := len(env.DB.GetAllBookmarks()); n != 0 {
+		t.Errorf("%d bookmarks imported by the cancelled job", n)
+	}
+}
+
+func TestEvictImportJobs(t *testing.T) {
+	resetImportJobs()
+	var jobs []*importJob
+	for i := 0; i < maxFinishedImportJobs+3; i++ {
+		jobs = append(jobs, newImportJob(func() {}))
+	}
+	// The first one is expired, the second one running.
+	jobs[0].finish(types.ImportJobDone, nil, types.ImportReport{})
+	jobs[0].finished = time.Now().Add(-finishedImportJobTTL - time.Minute)
+	for _, j := range jobs[2:] {
+		j.finish(types.ImportJobDone, nil, types.ImportReport{})
+	}
+
+	for i, j := range jobs {
+		// The running job and the newest finished ones are kept.
+		kept := i == 1 || i >= 3
+		if got := getImportJob(j.state().Id) != nil; got != kept {
+			t.Errorf("job %d kept %t, want %t", i, got, kept)
+		}
+	}
+}
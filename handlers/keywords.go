@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// normalizeKeyword returns the given keyword lowered and trimmed,
+// and false if it contains spaces.
+func normalizeKeyword(keyword string) (string, bool) {
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	return keyword, strings.IndexFunc(keyword, unicode.IsSpace) == -1
+}
+
+// keywordURL returns the given bookmark URL template with the %s replaced
+// by the escaped terms and the %S by the terms as is, like Firefox does.
+func keywordURL(template string, terms string) string {
+	path, query := template, ""
+	if i := strings.Index(template, "?"); i != -1 {
+		path, query = template[:i], template[i:]
+	}
+	return strings.NewReplacer("%s", url.PathEscape(terms), "%S", terms).Replace(path) +
+		strings.NewReplacer("%s", url.QueryEscape(terms), "%S", terms).Replace(query)
+}
+
+// KeywordBookmarkHandler sets the keyword of the given bookmark,
+// an empty keyword removes it. A keyword already used by another bookmark
+// is a conflict.
+func (env *Env) KeywordBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err        error
+		bookmarkID int
+		ok         bool
+	)
+	// GET parameters retrieval.
+	bookmarkIDParam := r.URL.Query()["bookmarkId"]
+	keywordParam := r.URL.Query()["keyword"]
+	log.WithFields(log.Fields{
+		"bookmarkIdParam": bookmarkIDParam,
+		"keywordParam":    keywordParam,
+	}).Debug("KeywordBookmarkHandler:Query parameter")
+
+	// Parameters check.
+	if len(bookmarkIDParam) == 0 {
+		failHTTP(w, "KeywordBookmarkHandler", "bookmarkId empty", http.StatusBadRequest)
+		return
+	}
+	// bookmarkId int convertion.
+	if bookmarkID, err = strconv.Atoi(bookmarkIDParam[0]); err != nil {
+		failHTTP(w, "KeywordBookmarkHandler", "bookmarkId Atoi conversion", http.StatusBadRequest)
+		return
+	}
+	keyword := ""
+	if len(keywordParam) != 0 {
+		if keyword, ok = normalizeKeyword(keywordParam[0]); !ok {
+			failHTTP(w, "KeywordBookmarkHandler", "keyword with spaces", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "KeywordBookmarkHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Checking that the keyword is free.
+	if owner := env.DB.GetBookmarkByKeyword(keyword); owner != nil && owner.Id != bookmarkID {
+		failHTTP(w, "KeywordBookmarkHandler", "keyword already used by "+owner.Title, http.StatusConflict)
+		return
+	}
+
+	// Updating it.
+	bkm.Keyword = keyword
+	env.DB.UpdateBookmark(bkm)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "KeywordBookmarkHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// KeywordHandler redirects /k/?q=keyword terms to the URL of the bookmark
+// with the keyword, the terms replacing its %s, and records the visit.
+// Without keyword, a q being the title of a bookmark, such as a picked search
+// suggestion, redirects to this bookmark, and any other q to the GoBkm search.
+// It can be registered as a browser search engine with /k/?q=%s.
+func (env *Env) KeywordHandler(w http.ResponseWriter, r *http.Request) {
+	// GET parameters retrieval.
+	qParam := r.URL.Query()["q"]
+	log.WithFields(log.Fields{
+		"qParam": qParam,
+	}).Debug("KeywordHandler:Query parameter")
+
+	// Parameters check.
+	if len(qParam) == 0 || strings.TrimSpace(qParam[0]) == "" {
+		failHTTP(w, "KeywordHandler", "q empty", http.StatusBadRequest)
+		return
+	}
+	q := strings.TrimSpace(qParam[0])
+
+	// Splitting the keyword and the terms.
+	keyword, terms := q, ""
+	if i := strings.IndexFunc(q, unicode.IsSpace); i != -1 {
+		keyword, terms = q[:i], strings.TrimSpace(q[i:])
+	}
+	keyword, _ = normalizeKeyword(keyword)
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmarkByKeyword(keyword)
+	// Datastore error check.
+	if err := env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "KeywordHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if bkm != nil {
+		env.visitRedirect(w, r, "KeywordHandler", bkm.Id, keywordURL(bkm.URL, terms))
+		return
+	}
+
+	// Without keyword, looking for a bookmark with the q title.
+	bkms := env.DB.SearchBookmarks(q)
+	// Datastore error check.
+	if err := env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "KeywordHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, bkm := range bkms {
+		if strings.EqualFold(bkm.Title, q) {
+			env.visitRedirect(w, r, "KeywordHandler", bkm.Id, bkm.URL)
+			return
+		}
+	}
+
+	http.Redirect(w, r, env.baseURL()+"/?search="+url.QueryEscape(q), http.StatusFound)
+}
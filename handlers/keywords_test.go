@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestKeywordURL(t *testing.T) {
+	tests := []struct {
+		template string
+		terms    string
+		want     string
+	}{
+		{"https://pkg.go.dev/search?q=%s", "net/http client", "https://pkg.go.dev/search?q=net%2Fhttp+client"},
+		{"https://en.wikipedia.org/wiki/%s", "Go language", "https://en.wikipedia.org/wiki/Go%20language"},
+		{"https://example.com/%S?raw=%S", "a/b", "https://example.com/a/b?raw=a/b"},
+		{"https://example.com/%s?q=%s", "", "https://example.com/?q="},
+		{"https://golang.org/", "ignored", "https://golang.org/"},
+	}
+	for _, tt := range tests {
+		if got := keywordURL(tt.template, tt.terms); got != tt.want {
+			t.Errorf("keywordURL(%q, %q) = %q, want %q", tt.template, tt.terms, got, tt.want)
+		}
+	}
+}
+
+func TestKeywordBookmarkHandler(t *testing.T) {
+	env := newTestEnv(t)
+	saveBookmark(t, env, "Go packages", "https://pkg.go.dev/search?q=%s", nil)
+	saveBookmark(t, env, "Wikipedia", "https://en.wikipedia.org/wiki/%s", nil)
+
+	tests := []struct {
+		target  string
+		status  int
+		keyword string // the bookmark 1 keyword after the request
+	}{
+		{"/keywordBookmark/?bookmarkId=1&keyword=+GoDoc+", http.StatusOK, "godoc"},
+		{"/keywordBookmark/?bookmarkId=2&keyword=godoc", http.StatusConflict, "godoc"},
+		{"/keywordBookmark/?bookmarkId=1&keyword=go+doc", http.StatusBadRequest, "godoc"},
+		{"/keywordBookmark/?bookmarkId=1&keyword=godoc", http.StatusOK, "godoc"},
+		{"/keywordBookmark/?keyword=godoc", http.StatusBadRequest, "godoc"},
+		{"/keywordBookmark/?bookmarkId=1&keyword=", http.StatusOK, ""},
+		{"/keywordBookmark/?bookmarkId=2&keyword=godoc", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		if w := serve(env.KeywordBookmarkHandler, "GET", tt.target, ""); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+		}
+		if k := env.DB.GetBookmark(1).Keyword; k != tt.keyword {
+			t.Errorf("%s: keyword %q, want %q", tt.target, k, tt.keyword)
+		}
+	}
+}
+
+func TestKeywordHandler(t *testing.T) {
+	env := newTestEnv(t)
+	bkm := saveBookmark(t, env, "Go packages", "https://pkg.go.dev/search?q=%s", nil)
+	bkm.Keyword = "godoc"
+	env.DB.UpdateBookmark(bkm)
+	saveBookmark(t, env, "Go blog", "https://blog.golang.org/", nil)
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"/k/?q=godoc+net/http", http.StatusFound, "https://pkg.go.dev/search?q=net%2Fhttp"},
+		{"/k/?q=GoDoc++json+encoding+", http.StatusFound, "https://pkg.go.dev/search?q=json+encoding"},
+		{"/k/?q=go+blog", http.StatusFound, "https://blog.golang.org/"},
+		{"/k/?q=rust+book", http.StatusFound, "http://gobkm.test/?search=rust+book"},
+		{"/k/?q=+", http.StatusBadRequest, ""},
+		{"/k/", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := serve(env.KeywordHandler, "GET", tt.target, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+			continue
+		}
+		if l := w.Header().Get("Location"); l != tt.location {
+			t.Errorf("%s: location %q, want %q", tt.target, l, tt.location)
+		}
+	}
+	// The keyword redirects are visits.
+	if n := env.DB.GetBookmark(bkm.Id).VisitCount; n != 2 {
+		t.Errorf("keyword bookmark visit count %d, want 2", n)
+	}
+}
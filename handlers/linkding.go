@@ -0,0 +1,564 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The linkding REST API subset for the linkding clients.
+// The tags have no id in GoBkm, their id is their rank in the sorted tags.
+const (
+	linkdingAPIPath = "/api/"
+	// ldDefaultLimit is the default number of results of a page.
+	ldDefaultLimit = 100
+)
+
+// ldPage is a page of results, with the URLs of the next and previous pages.
+type ldPage struct {
+	Count    int         `json:"count"`
+	Next     *string     `json:"next"`
+	Previous *string     `json:"previous"`
+	Results  interface{} `json:"results"`
+}
+
+// ldBookmark is a linkding bookmark.
+// GoBkm does not fetch the website metadata nor shares bookmarks.
+type ldBookmark struct {
+	ID                    int       `json:"id"`
+	URL                   string    `json:"url"`
+	Title                 string    `json:"title"`
+	Description           string    `json:"description"`
+	Notes                 string    `json:"notes"`
+	WebsiteTitle          *string   `json:"website_title"`
+	WebsiteDescription    *string   `json:"website_description"`
+	WebArchiveSnapshotURL string    `json:"web_archive_snapshot_url"`
+	FaviconURL            *string   `json:"favicon_url"`
+	PreviewImageURL       *string   `json:"preview_image_url"`
+	IsArchived            bool      `json:"is_archived"`
+	Unread                bool      `json:"unread"`
+	Shared                bool      `json:"shared"`
+	TagNames              []string  `json:"tag_names"`
+	DateAdded             time.Time `json:"date_added"`
+	DateModified          time.Time `json:"date_modified"`
+}
+
+// ldBookmarkParams are the parameters of a bookmark creation or update,
+// nil if not given.
+type ldBookmarkParams struct {
+	URL         *string   `json:"url"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Notes       *string   `json:"notes"`
+	IsArchived  *bool     `json:"is_archived"`
+	Unread      *bool     `json:"unread"`
+	TagNames    *[]string `json:"tag_names"`
+}
+
+// ldTag is a linkding tag.
+type ldTag struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	DateAdded time.Time `json:"date_added"`
+}
+
+// ldCheck is the result of a bookmark URL check.
+type ldCheck struct {
+	Bookmark *ldBookmark     `json:"bookmark"`
+	Metadata ldCheckMetadata `json:"metadata"`
+	AutoTags []string        `json:"auto_tags"`
+}
+
+// ldCheckMetadata is the website metadata of a bookmark URL check,
+// GoBkm does not fetch them.
+type ldCheckMetadata struct {
+	URL         string  `json:"url"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+}
+
+// ldBookmarkOf returns the linkding bookmark of the given bookmark.
+func ldBookmarkOf(bkm *types.Bookmark) ldBookmark {
+	b := ldBookmark{
+		ID:           bkm.Id,
+		URL:          bkm.URL,
+		Title:        bkm.Title,
+		Description:  bkm.Description,
+		IsArchived:   bkm.Archived,
+		Unread:       bkm.Unread,
+		TagNames:     bkm.Tags,
+		DateAdded:    bkm.CreatedAt,
+		DateModified: bkm.UpdatedAt,
+	}
+	if strings.HasPrefix(bkm.Favicon, "data:image/") {
+		b.FaviconURL = &bkm.Favicon
+	}
+	if b.TagNames == nil {
+		b.TagNames = []string{}
+	}
+	return b
+}
+
+// parseLinkdingQuery returns the #tags and the words of the given search,
+// and whether it selects the !unread and !untagged bookmarks.
+func parseLinkdingQuery(q string) (tags []string, words []string, unread bool, untagged bool) {
+	for _, f := range strings.Fields(q) {
+		switch {
+		case f == "!unread":
+			unread = true
+		case f == "!untagged":
+			untagged = true
+		case strings.HasPrefix(f, "#") && len(f) > 1:
+			tags = append(tags, f[1:])
+		default:
+			words = append(words, f)
+		}
+	}
+	return
+}
+
+// ldFail sends a linkding API error (httpStatus) with the given errorMessage.
+func ldFail(w http.ResponseWriter, functionName string, errorMessage string, httpStatus int) {
+	log.WithFields(log.Fields{
+		"functionName": functionName,
+		"errorMessage": errorMessage,
+	}).Error("ldFail")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(map[string]string{"detail": errorMessage})
+}
+
+// ldWrite sends the given linkding API response with the httpStatus.
+func ldWrite(w http.ResponseWriter, functionName string, httpStatus int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// Just logging the error, the response is already started.
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error(functionName)
+	}
+}
+
+// ldFlushErrors fails the request and returns true on datastore errors.
+func (env *Env) ldFlushErrors(w http.ResponseWriter, functionName string) bool {
+	if err := env.DB.FlushErrors(); err == sql.ErrNoRows {
+		ldFail(w, functionName, "Not found.", http.StatusNotFound)
+		return true
+	} else if err != nil {
+		ldFail(w, functionName, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
+
+// ldPaging returns the limit and offset parameters of the request,
+// or fails the request and returns false if they are invalid.
+func ldPaging(w http.ResponseWriter, r *http.Request, functionName string) (int, int, bool) {
+	var err error
+	limit, offset := ldDefaultLimit, 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			ldFail(w, functionName, "invalid limit", http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+			ldFail(w, functionName, "invalid offset", http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+// ldPageOf returns the page of the given limit and offset of count results,
+// and the bounds of its results.
+func (env *Env) ldPageOf(r *http.Request, count int, limit int, offset int) (ldPage, int, int) {
+	// pageURL returns the URL of the request with the given offset.
+	pageURL := func(offset int) *string {
+		params := r.URL.Query()
+		params.Set("limit", strconv.Itoa(limit))
+		params.Set("offset", strconv.Itoa(offset))
+		u := env.baseURL() + r.URL.Path + "?" + params.Encode()
+		return &u
+	}
+
+	p := ldPage{Count: count}
+	start, end := offset, offset+limit
+	if start > count {
+		start = count
+	}
+	if end > count {
+		end = count
+	} else if end < count {
+		p.Next = pageURL(end)
+	}
+	if offset > 0 {
+		previous := offset - limit
+		if previous < 0 {
+			previous = 0
+		}
+		p.Previous = pageURL(previous)
+	}
+	return p, start, end
+}
+
+// ldApplyBookmarkParams sets the given parameters to the bookmark,
+// or fails the request and returns false if they are invalid.
+// The notes are the description if it is not given.
+func ldApplyBookmarkParams(w http.ResponseWriter, functionName string, bkm *types.Bookmark, p ldBookmarkParams) bool {
+	var ok bool
+	if p.URL != nil {
+		if *p.URL == "" {
+			ldFail(w, functionName, "url empty", http.StatusBadRequest)
+			return false
+		}
+		bkm.URL = *p.URL
+	}
+	if p.Title != nil {
+		bkm.Title = strings.TrimSpace(*p.Title)
+	}
+	if bkm.Title == "" {
+		bkm.Title = bkm.URL
+	}
+	if p.Description != nil && *p.Description != "" {
+		bkm.Description = *p.Description
+	} else if p.Notes != nil {
+		bkm.Description = *p.Notes
+	} else if p.Description != nil {
+		bkm.Description = ""
+	}
+	if p.IsArchived != nil {
+		bkm.Archived = *p.IsArchived
+	}
+	if p.Unread != nil {
+		bkm.Unread = *p.Unread
+	}
+	if p.TagNames != nil {
+		if bkm.Tags, ok = normalizeTags(*p.TagNames); !ok {
+			ldFail(w, functionName, "tag with a comma", http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// LinkdingHandler serves the linkding REST API subset under linkdingAPIPath,
+// so that the linkding clients can use GoBkm: the bookmarks, bookmarks/archived,
+// bookmarks/check, bookmarks/{id}, bookmarks/{id}/archive, bookmarks/{id}/unarchive,
+// tags and tags/{id} endpoints.
+// The new bookmarks are created in the root folder.
+func (env *Env) LinkdingHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, linkdingAPIPath), "/")
+	log.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   path,
+	}).Debug("LinkdingHandler")
+
+	// The endpoint and its optional id.
+	parts, id := strings.Split(path, "/"), ""
+	if len(parts) > 1 {
+		if _, err := strconv.Atoi(parts[1]); err == nil {
+			id, parts[1] = parts[1], "{id}"
+		}
+	}
+	route := r.Method + " " + strings.Join(parts, "/")
+
+	routes := map[string]func(http.ResponseWriter, *http.Request, string){
+		"GET bookmarks": func(w http.ResponseWriter, r *http.Request, _ string) {
+			env.ldGetBookmarks(w, r, false)
+		},
+		"GET bookmarks/archived": func(w http.ResponseWriter, r *http.Request, _ string) {
+			env.ldGetBookmarks(w, r, true)
+		},
+		"POST bookmarks":        env.ldAddBookmark,
+		"GET bookmarks/check":   env.ldCheckBookmark,
+		"GET bookmarks/{id}":    env.ldGetBookmark,
+		"PUT bookmarks/{id}":    env.ldUpdateBookmark,
+		"PATCH bookmarks/{id}":  env.ldUpdateBookmark,
+		"DELETE bookmarks/{id}": env.ldDeleteBookmark,
+		"POST bookmarks/{id}/archive": func(w http.ResponseWriter, r *http.Request, id string) {
+			env.ldArchiveBookmark(w, r, id, true)
+		},
+		"POST bookmarks/{id}/unarchive": func(w http.ResponseWriter, r *http.Request, id string) {
+			env.ldArchiveBookmark(w, r, id, false)
+		},
+		"GET tags":      env.ldGetTags,
+		"GET tags/{id}": env.ldGetTag,
+	}
+	if handler, ok := routes[route]; ok {
+		handler(w, r, id)
+		return
+	}
+	ldFail(w, "LinkdingHandler", "no such endpoint "+route, http.StatusNotFound)
+}
+
+// ldGetBookmarks returns a page of the bookmarks not archived,
+// or archived, the last added first, with the optional limit (100 by default)
+// and offset parameters and the q search: words, #tags, !unread and !untagged.
+func (env *Env) ldGetBookmarks(w http.ResponseWriter, r *http.Request, archived bool) {
+	// GET parameters retrieval.
+	q := r.URL.Query().Get("q")
+	log.WithFields(log.Fields{
+		"q":        q,
+		"archived": archived,
+	}).Debug("ldGetBookmarks:Query parameter")
+
+	// Parameters check.
+	limit, offset, ok := ldPaging(w, r, "ldGetBookmarks")
+	if !ok {
+		return
+	}
+	tags, words, unread, untagged := parseLinkdingQuery(q)
+
+	// Getting the bookmarks.
+	bkms := env.DB.QueryBookmarks(types.BookmarkQuery{Unread: unread, Archived: archived, Sort: types.SortCreated, Desc: true})
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldGetBookmarks") {
+		return
+	}
+
+	// Filtering and paging them.
+	results := []ldBookmark{}
+	for _, bkm := range bkms {
+		if bkm.Archived != archived || (untagged && len(bkm.Tags) != 0) || !matchBookmark(bkm, tags, words, false) {
+			continue
+		}
+		results = append(results, ldBookmarkOf(bkm))
+	}
+	p, start, end := env.ldPageOf(r, len(results), limit, offset)
+	p.Results = results[start:end]
+
+	ldWrite(w, "ldGetBookmarks", http.StatusOK, p)
+}
+
+// ldGetBookmark returns the bookmark id.
+func (env *Env) ldGetBookmark(w http.ResponseWriter, r *http.Request, id string) {
+	bookmarkID, _ := strconv.Atoi(id)
+
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldGetBookmark") {
+		return
+	}
+
+	ldWrite(w, "ldGetBookmark", http.StatusOK, ldBookmarkOf(bkm))
+}
+
+// ldCheckBookmark returns the first bookmark of the url parameter, null if there is none.
+func (env *Env) ldCheckBookmark(w http.ResponseWriter, r *http.Request, _ string) {
+	// GET parameters retrieval.
+	u := r.URL.Query().Get("url")
+	log.WithFields(log.Fields{
+		"url": u,
+	}).Debug("ldCheckBookmark:Query parameter")
+
+	// Parameters check.
+	if u == "" {
+		ldFail(w, "ldCheckBookmark", "url empty", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the bookmarks of the URL.
+	bkms := env.DB.FindBookmarksByURL(u)
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldCheckBookmark") {
+		return
+	}
+
+	check := ldCheck{Metadata: ldCheckMetadata{URL: u}, AutoTags: []string{}}
+	if len(bkms) != 0 {
+		b := ldBookmarkOf(bkms[0])
+		check.Bookmark = &b
+	}
+	ldWrite(w, "ldCheckBookmark", http.StatusOK, check)
+}
+
+// ldAddBookmark creates a bookmark with the url parameter, or updates
+// the first bookmark of this URL like linkding does, and returns it.
+func (env *Env) ldAddBookmark(w http.ResponseWriter, r *http.Request, _ string) {
+	var p ldBookmarkParams
+	// Parameters check.
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		ldFail(w, "ldAddBookmark", "JSON bookmark decode error", http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{
+		"p": p,
+	}).Debug("ldAddBookmark:Query parameter")
+	if p.URL == nil {
+		ldFail(w, "ldAddBookmark", "url empty", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the bookmarks of the same URL.
+	bkms := env.DB.FindBookmarksByURL(*p.URL)
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldAddBookmark") {
+		return
+	}
+	bkm := &types.Bookmark{}
+	if len(bkms) != 0 {
+		bkm = bkms[0]
+	}
+	oldURL := bkm.URL
+	if !ldApplyBookmarkParams(w, "ldAddBookmark", bkm, p) {
+		return
+	}
+
+	// Saving the bookmark into the DB.
+	if bkm.Id == 0 {
+		bkm.Id = int(env.DB.SaveBookmark(bkm))
+	} else {
+		env.DB.UpdateBookmark(bkm)
+	}
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldAddBookmark") {
+		return
+	}
+
+	b := ldBookmarkOf(bkm)
+	// Updating the bookmark favicon.
+	if bkm.URL != oldURL {
+		goBackground(func() { env.UpdateBookmarkFavicon(bkm) })
+	}
+
+	ldWrite(w, "ldAddBookmark", http.StatusCreated, b)
+}
+
+// ldUpdateBookmark updates the bookmark id with the given parameters,
+// the missing ones being unchanged, and returns it.
+// The url parameter is mandatory with PUT.
+func (env *Env) ldUpdateBookmark(w http.ResponseWriter, r *http.Request, id string) {
+	var p ldBookmarkParams
+	bookmarkID, _ := strconv.Atoi(id)
+	// Parameters check.
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		ldFail(w, "ldUpdateBookmark", "JSON bookmark decode error", http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{
+		"p": p,
+	}).Debug("ldUpdateBookmark:Query parameter")
+	if r.Method == http.MethodPut && p.URL == nil {
+		ldFail(w, "ldUpdateBookmark", "url empty", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldUpdateBookmark") {
+		return
+	}
+	oldURL := bkm.URL
+	if !ldApplyBookmarkParams(w, "ldUpdateBookmark", bkm, p) {
+		return
+	}
+
+	// Updating it.
+	env.DB.UpdateBookmark(bkm)
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldUpdateBookmark") {
+		return
+	}
+
+	b := ldBookmarkOf(bkm)
+	// Updating the bookmark favicon.
+	if bkm.URL != oldURL {
+		goBackground(func() { env.UpdateBookmarkFavicon(bkm) })
+	}
+
+	ldWrite(w, "ldUpdateBookmark", http.StatusOK, b)
+}
+
+// ldArchiveBookmark archives, also marking it as read, or unarchives the bookmark id.
+func (env *Env) ldArchiveBookmark(w http.ResponseWriter, r *http.Request, id string, archived bool) {
+	bookmarkID, _ := strconv.Atoi(id)
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldArchiveBookmark") {
+		return
+	}
+
+	// Updating it.
+	bkm.Archived = archived
+	if archived {
+		bkm.Unread = false
+	}
+	env.DB.UpdateBookmark(bkm)
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldArchiveBookmark") {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ldDeleteBookmark deletes the bookmark id.
+func (env *Env) ldDeleteBookmark(w http.ResponseWriter, r *http.Request, id string) {
+	bookmarkID, _ := strconv.Atoi(id)
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Deleting it.
+	env.DB.DeleteBookmark(bkm)
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldDeleteBookmark") {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ldGetTags returns a page of the sorted tags, with the optional limit
+// (100 by default) and offset parameters.
+func (env *Env) ldGetTags(w http.ResponseWriter, r *http.Request, _ string) {
+	// Parameters check.
+	limit, offset, ok := ldPaging(w, r, "ldGetTags")
+	if !ok {
+		return
+	}
+
+	// Getting the tags.
+	tags := env.DB.GetTags()
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldGetTags") {
+		return
+	}
+
+	results := []ldTag{}
+	p, start, end := env.ldPageOf(r, len(tags), limit, offset)
+	for i := start; i < end; i++ {
+		results = append(results, ldTag{ID: i + 1, Name: tags[i]})
+	}
+	p.Results = results
+
+	ldWrite(w, "ldGetTags", http.StatusOK, p)
+}
+
+// ldGetTag returns the tag id.
+func (env *Env) ldGetTag(w http.ResponseWriter, r *http.Request, id string) {
+	tagID, _ := strconv.Atoi(id)
+
+	// Getting the tags.
+	tags := env.DB.GetTags()
+	// Datastore error check.
+	if env.ldFlushErrors(w, "ldGetTag") {
+		return
+	}
+	if tagID < 1 || tagID > len(tags) {
+		ldFail(w, "ldGetTag", "Not found.", http.StatusNotFound)
+		return
+	}
+
+	ldWrite(w, "ldGetTag", http.StatusOK, ldTag{ID: tagID, Name: tags[tagID-1]})
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// serveLD returns the response of the linkding handler to the method request
+// of the endpoint path, with the given JSON body.
+func serveLD(env *Env, method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, linkdingAPIPath+path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	env.LinkdingHandler(w, r)
+	return w
+}
+
+func TestParseLinkdingQuery(t *testing.T) {
+	tags, words, unread, untagged := parseLinkdingQuery(" go #lang  # !unread tour !untagged ")
+	if fmt.Sprint(tags) != "[lang]" || fmt.Sprint(words) != "[go # tour]" || !unread || !untagged {
+		t.Errorf("parseLinkdingQuery = %v %v %v %v", tags, words, unread, untagged)
+	}
+}
+
+func TestLinkdingBookmarks(t *testing.T) {
+	env := newTestEnv(t)
+	now := time.Now()
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/", Tags: []string{"lang"}, Unread: true, CreatedAt: now.Add(-time.Hour)})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Rust", URL: "https://rust-lang.org/", Tags: []string{"lang", "safe"}, CreatedAt: now})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "News", URL: "https://news.example.com/", Description: "Daily golang news", CreatedAt: now.Add(-2 * time.Hour)})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Old", URL: "https://old.example.com/", Archived: true, CreatedAt: now.Add(-3 * time.Hour)})
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		status   int
+		titles   []string
+		count    int
+		previous string
+		next     string
+	}{
+		{"bookmarks/", http.StatusOK, []string{"Rust", "Go", "News"}, 3, "", ""},
+		{"bookmarks/?q=golang", http.StatusOK, []string{"Go", "News"}, 2, "", ""},
+		{"bookmarks/?q=%23lang", http.StatusOK, []string{"Rust", "Go"}, 2, "", ""},
+		{"bookmarks/?q=%23lang+%23safe", http.StatusOK, []string{"Rust"}, 1, "", ""},
+		{"bookmarks/?q=!unread", http.StatusOK, []string{"Go"}, 1, "", ""},
+		{"bookmarks/?q=!untagged", http.StatusOK, []string{"News"}, 1, "", ""},
+		{"bookmarks/archived/", http.StatusOK, []string{"Old"}, 1, "", ""},
+		{"bookmarks/?limit=1", http.StatusOK, []string{"Rust"}, 3, "", "http://gobkm.test/api/bookmarks/?limit=1&offset=1"},
+		{"bookmarks/?limit=1&offset=1", http.StatusOK, []string{"Go"}, 3, "http://gobkm.test/api/bookmarks/?limit=1&offset=0", "http://gobkm.test/api/bookmarks/?limit=1&offset=2"},
+		{"bookmarks/?limit=2&offset=2&q=", http.StatusOK, []string{"News"}, 3, "http://gobkm.test/api/bookmarks/?limit=2&offset=0&q=", ""},
+		{"bookmarks/?offset=9", http.StatusOK, []string{}, 3, "http://gobkm.test/api/bookmarks/?limit=100&offset=0", ""},
+		{"bookmarks/?limit=0", http.StatusBadRequest, nil, 0, "", ""},
+		{"bookmarks/?offset=-1", http.StatusBadRequest, nil, 0, "", ""},
+		{"bookmarks/archived/?limit=x", http.StatusBadRequest, nil, 0, "", ""},
+		{"users/profile/", http.StatusNotFound, nil, 0, "", ""},
+	}
+	for _, tt := range tests {
+		w := serveLD(env, "GET", tt.path, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var p struct {
+			Count    int
+			Next     *string
+			Previous *string
+			Results  []ldBookmark
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		titles := []string{}
+		for _, b := range p.Results {
+			titles = append(titles, b.Title)
+		}
+		next, previous := "", ""
+		if p.Next != nil {
+			next = *p.Next
+		}
+		if p.Previous != nil {
+			previous = *p.Previous
+		}
+		if !reflect.DeepEqual(titles, tt.titles) || p.Count != tt.count || previous != tt.previous || next != tt.next {
+			t.Errorf("%s: page %d %v previous %q next %q, want %d %v previous %q next %q", tt.path, p.Count, titles, previous, next, tt.count, tt.titles, tt.previous, tt.next)
+		}
+	}
+
+	// A single bookmark.
+	w := serveLD(env, "GET", "bookmarks/3/", "")
+	var b ldBookmark
+	if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Title != "News" || b.Description != "Daily golang news" || b.TagNames == nil || b.FaviconURL != nil {
+		t.Errorf("News bookmark %+v", b)
+	}
+	if w = serveLD(env, "GET", "bookmarks/99/", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing bookmark status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestLinkdingBookmarkChanges(t *testing.T) {
+	env := newTestEnv(t)
+
+	// Creating a bookmark, then updating it by creating it again.
+	w := serveLD(env, "POST", "bookmarks/", `{"url":"https://golang.org/","title":" Go ","notes":"fast","tag_names":["Lang","lang"],"unread":true}`)
+	var b ldBookmark
+	if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || b.ID != 1 || b.Title != "Go" || b.Description != "fast" || fmt.Sprint(b.TagNames) != "[lang]" || !b.Unread {
+		t.Errorf("created bookmark status %d %+v", w.Code, b)
+	}
+	w = serveLD(env, "POST", "bookmarks/", `{"url":"https://golang.org/","title":"Golang"}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || b.ID != 1 || b.Title != "Golang" || fmt.Sprint(b.TagNames) != "[lang]" {
+		t.Errorf("created again bookmark status %d %+v", w.Code, b)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string // the bookmark title, URL, description, tags, unread and archived
+	}{
+		{"POST", "bookmarks/", `{"url":"https://rust-lang.org/"}`, http.StatusCreated, "https://rust-lang.org/ https://rust-lang.org/  [] false false"},
+		{"POST", "bookmarks/", `{"title":"No URL"}`, http.StatusBadRequest, ""},
+		{"POST", "bookmarks/", `{"url":"https://example.com/","tag_names":["a,b"]}`, http.StatusBadRequest, ""},
+		{"POST", "bookmarks/", `{"url":`, http.StatusBadRequest, ""},
+		{"PATCH", "bookmarks/1/", `{"description":"","tag_names":[]}`, http.StatusOK, "Golang https://golang.org/  [] true false"},
+		{"PATCH", "bookmarks/1/", `{"url":""}`, http.StatusBadRequest, ""},
+		{"PUT", "bookmarks/1/", `{"title":"Go"}`, http.StatusBadRequest, ""},
+		{"PUT", "bookmarks/1/", `{"url":"https://go.dev/","title":"Go","description":"The Go site"}`, http.StatusOK, "Go https://go.dev/ The Go site [] true false"},
+		{"PATCH", "bookmarks/99/", `{"title":"Missing"}`, http.StatusNotFound, ""},
+		{"POST", "bookmarks/1/archive/", "", http.StatusNoContent, "Go https://go.dev/ The Go site [] false true"},
+		{"POST", "bookmarks/1/unarchive/", "", http.StatusNoContent, "Go https://go.dev/ The Go site [] false false"},
+		{"POST", "bookmarks/99/archive/", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w = serveLD(env, tt.method, tt.path, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s %s %s: status %d, want %d", tt.method, tt.path, tt.body, w.Code, tt.status)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		if w.Code == http.StatusNoContent {
+			w = serveLD(env, "GET", "bookmarks/1/", "")
+		}
+		var b ldBookmark
+		if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%s %s %s %v %t %t", b.Title, b.URL, b.Description, b.TagNames, b.Unread, b.IsArchived); got != tt.want {
+			t.Errorf("%s %s %s: bookmark %s, want %s", tt.method, tt.path, tt.body, got, tt.want)
+		}
+	}
+	background.Wait()
+
+	// Checking the URLs.
+	checks := []struct {
+		url    string
+		status int
+		id     int // the bookmark found, 0 if none
+	}{
+		{"https%3A%2F%2Fgo.dev%2F", http.StatusOK, 1},
+		{"https%3A%2F%2Fgolang.org%2F", http.StatusOK, 0},
+		{"", http.StatusBadRequest, 0},
+	}
+	for _, tt := range checks {
+		w = serveLD(env, "GET", "bookmarks/check/?url="+tt.url, "")
+		if w.Code != tt.status {
+			t.Errorf("check %s: status %d, want %d", tt.url, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var c ldCheck
+		if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+			t.Fatal(err)
+		}
+		if (c.Bookmark == nil) != (tt.id == 0) || (c.Bookmark != nil && c.Bookmark.ID != tt.id) || c.AutoTags == nil {
+			t.Errorf("check %s: %+v, want bookmark %d", tt.url, c, tt.id)
+		}
+	}
+
+	// Deleting a bookmark.
+	if w = serveLD(env, "DELETE", "bookmarks/1/", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w = serveLD(env, "DELETE", "bookmarks/1/", ""); w.Code != http.StatusNotFound {
+		t.Errorf("deleted bookmark delete status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestLinkdingTags(t *testing.T) {
+	env := newTestEnv(t)
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/", Tags: []string{"lang", "go"}})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Rust", URL: "https://rust-lang.org/", Tags: []string{"safe"}})
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		tags   string // the tag ids and names
+		count  int
+	}{
+		{"tags/", http.StatusOK, "[1:go 2:lang 3:safe]", 3},
+		{"tags/?limit=1&offset=1", http.StatusOK, "[2:lang]", 3},
+		{"tags/?offset=5", http.StatusOK, "[]", 3},
+		{"tags/?limit=x", http.StatusBadRequest, "", 0},
+		{"tags/2/", http.StatusOK, "2:lang", 0},
+		{"tags/0/", http.StatusNotFound, "", 0},
+		{"tags/4/", http.StatusNotFound, "", 0},
+	}
+	for _, tt := range tests {
+		w := serveLD(env, "GET", tt.path, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var got string
+		var count int
+		if strings.HasSuffix(tt.path, "/2/") {
+			var tag ldTag
+			if err := json.Unmarshal(w.Body.Bytes(), &tag); err != nil {
+				t.Fatal(err)
+			}
+			got = fmt.Sprintf("%d:%s", tag.ID, tag.Name)
+		} else {
+			var p struct {
+				Count   int
+				Results []ldTag
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			var tags []string
+			for _, tag := range p.Results {
+				tags = append(tags, fmt.Sprintf("%d:%s", tag.ID, tag.Name))
+			}
+			got, count = fmt.Sprint(tags), p.Count
+		}
+		if got != tt.tags || count != tt.count {
+			t.Errorf("%s: tags %s count %d, want %s count %d", tt.path, got, count, tt.tags, tt.count)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tbellembois/gobkm/metrics"
+)
+
+// Outcomes of the favicon fetchings.
+const (
+	faviconSuccess    = "success"
+	faviconFetchError = "fetch_error" // the favicon service is not reachable
+	faviconNotImage   = "not_image"   // the favicon service did not answer an image
+	faviconStoreError = "store_error"
+)
+
+var (
+	httpRequests   = metrics.NewCounterVec("gobkm_http_requests_total", "Number of the HTTP requests, by handler pattern and status code.", "handler", "code")
+	httpDuration   = metrics.NewHistogramVec("gobkm_http_request_duration_seconds", "Duration of the HTTP requests, by handler pattern.", metrics.DefaultBuckets, "handler")
+	faviconFetches = metrics.NewCounterVec("gobkm_favicon_fetches_total", "Number of the favicon fetchings, by outcome.", "outcome")
+	_              = metrics.NewGaugeFunc("gobkm_websocket_clients", "Number of the connected websocket clients.", func() (float64, error) {
+		wsmutex.Lock()
+		defer wsmutex.Unlock()
+
+		if wsconn == nil {
+			return 0, nil
+		}
+		return 1, nil
+	})
+)
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Hijack lets the websocket handler take over the connection.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection not hijackable")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Flush sends the buffered response.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// InstrumentMux returns mux counting the requests and measuring their duration,
+// by the pattern of the handler serving them.
+func InstrumentMux(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "none"
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		httpDuration.Observe(time.Since(start).Seconds(), pattern)
+		httpRequests.Inc(pattern, strconv.Itoa(rec.status))
+	})
+}
+
+// RegisterMetrics registers the metrics of the env database content:
+// the bookmarks and folders totals, counted once per scrape.
+func (env *Env) RegisterMetrics() {
+	metrics.NewGaugeFuncs(
+		[]string{"gobkm_bookmarks", "gobkm_folders"},
+		[]string{"Number of the bookmarks.", "Number of the folders, the root folder excluded."},
+		func() ([]float64, error) {
+			bookmarks, folders, err := env.DB.Counts()
+			return []float64{float64(bookmarks), float64(folders)}, err
+		})
+}
+
+// HealthzHandler answers while the process is alive.
+func (env *Env) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// ReadyzHandler answers when the database is reachable
+// and its migrations applied, or fails with 503.
+func (env *Env) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if err := env.DB.Ready(); err != nil {
+		failHTTP(w, "ReadyzHandler", err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tbellembois/gobkm/metrics"
+	"github.com/tbellembois/gobkm/models"
+	"github.com/tbellembois/gobkm/types"
+)
+
+// metricValue returns the exposed value of the sample of the given name and labels,
+// 0 if not exposed.
+func metricValue(t *testing.T, sample string) float64 {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), sample+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(scanner.Text(), sample+" "), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+// metricDeltas returns the changes of the given samples values by f.
+func metricDeltas(t *testing.T, f func(), samples ...string) []float64 {
+	before := make([]float64, len(samples))
+	for i, s := range samples {
+		before[i] = metricValue(t, s)
+	}
+	f()
+	deltas := make([]float64, len(samples))
+	for i, s := range samples {
+		deltas[i] = metricValue(t, s) - before[i]
+	}
+	return deltas
+}
+
+func TestInstrumentMux(t *testing.T) {
+	env := newTestEnv(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test/ok/", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "ok") })
+	mux.HandleFunc("/test/created", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/test/empty", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/test/socket/", env.SocketHandler)
+	h := InstrumentMux(mux)
+
+	samples := []string{
+		`gobkm_http_requests_total{handler="/test/ok/",code="200"}`,
+		`gobkm_http_requests_total{handler="/test/created",code="201"}`,
+		`gobkm_http_requests_total{handler="/test/empty",code="200"}`,
+		`gobkm_http_requests_total{handler="none",code="404"}`,
+		`gobkm_http_request_duration_seconds_count{handler="/test/ok/"}`,
+		`gobkm_http_request_duration_seconds_bucket{handler="/test/ok/",le="+Inf"}`,
+	}
+	deltas := metricDeltas(t, func() {
+		for _, target := range []string{"/test/ok/a", "/test/ok/b", "/test/created", "/test/empty", "/test/missing"} {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+		}
+	}, samples...)
+	if got := fmt.Sprint(deltas); got != "[2 1 1 1 2 2]" {
+		t.Errorf("%v counted %s, want [2 1 1 1 2 2]", samples, got)
+	}
+
+	// The websocket connections, hijacked, are counted as switching protocols.
+	server := httptest.NewServer(h)
+	defer server.Close()
+	var client *websocket.Conn
+	deltas = metricDeltas(t, func() {
+		var err error
+		if client, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/test/socket/", nil); err != nil {
+			t.Fatal(err)
+		}
+	}, `gobkm_http_requests_total{handler="/test/socket/",code="101"}`)
+	if deltas[0] != 1 {
+		t.Errorf("websocket connections counted %v, want 1", deltas[0])
+	}
+	if v := metricValue(t, "gobkm_websocket_clients"); v != 1 {
+		t.Errorf("websocket clients %v, want 1", v)
+	}
+	client.Close()
+	for i := 0; metricValue(t, "gobkm_websocket_clients") != 0; i++ {
+		if i == 100 {
+			t.Fatal("websocket client left still counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFaviconMetrics(t *testing.T) {
+	env := newTestEnv(t)
+	bkm := saveBookmark(t, env, "Go", "https://golang.org/", nil)
+	icons := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("domain_url") == "https://golang.org" {
+			w.Header().Set("Content-Type", "image/png")
+		}
+		fmt.Fprint(w, "icon")
+	}))
+	defer icons.Close()
+
+	tests := []struct {
+		faviconURL, url string
+		want            string
+	}{
+		{icons.URL + "/?domain_url=", "https://golang.org/", faviconSuccess},
+		{icons.URL + "/?domain_url=", "https://example.com/", faviconNotImage},
+		{"http://127.0.0.1:1/?domain_url=", "https://golang.org/", faviconFetchError},
+	}
+	for _, tt := range tests {
+		env.FaviconURL = tt.faviconURL
+		sample := `gobkm_favicon_fetches_total{outcome="` + tt.want + `"}`
+		deltas := metricDeltas(t, func() {
+			env.UpdateBookmarkFavicon(&types.Bookmark{Id: bkm.Id, Title: bkm.Title, URL: tt.url})
+		}, sample)
+		if deltas[0] != 1 {
+			t.Errorf("%s of %s: %s counted %v, want 1", tt.faviconURL, tt.url, tt.want, deltas[0])
+		}
+	}
+	if got := env.DB.GetBookmark(bkm.Id).Favicon; got != "data:image/png;base64,aWNvbg==" {
+		t.Errorf("favicon %q", got)
+	}
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	env := newTestEnv(t)
+	fld := saveFolder(t, env, "IT", nil)
+	saveBookmark(t, env, "Go", "https://golang.org/", fld)
+	saveBookmark(t, env, "Rust", "https://rust-lang.org/", nil)
+	env.RegisterMetrics()
+
+	if v := metricValue(t, "gobkm_bookmarks"); v != 2 {
+		t.Errorf("bookmarks gauge %v, want 2", v)
+	}
+	if v := metricValue(t, "gobkm_folders"); v != 1 {
+		t.Errorf("folders gauge %v, want 1", v)
+	}
+}
+
+func TestHealthzReadyz(t *testing.T) {
+	env := newTestEnv(t)
+	db := env.DB.(*models.SQLiteDataStore)
+	env.DB = models.NewMetricsDatastore(db)
+
+	if w := serve(env.HealthzHandler, "GET", "/healthz", ""); w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("healthz: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(env.ReadyzHandler, "GET", "/readyz", ""); w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("readyz: %d %q", w.Code, w.Body.String())
+	}
+
+	// Not ready with migrations missing or the database closed, but alive.
+	if _, err := db.Exec("PRAGMA user_version=0"); err != nil {
+		t.Fatal(err)
+	}
+	deltas := metricDeltas(t, func() {
+		if w := serve(env.ReadyzHandler, "GET", "/readyz", ""); w.Code != http.StatusServiceUnavailable || !strings.HasPrefix(w.Body.String(), "database version 0") {
+			t.Errorf("readyz without migrations: %d %q", w.Code, w.Body.String())
+		}
+	}, `gobkm_datastore_errors_total{method="Ready"}`)
+	if deltas[0] != 1 {
+		t.Errorf("readiness errors counted %v, want 1", deltas[0])
+	}
+	db.Close()
+	if w := serve(env.ReadyzHandler, "GET", "/readyz", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz of a closed database: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(env.HealthzHandler, "GET", "/healthz", ""); w.Code != http.StatusOK {
+		t.Errorf("healthz of a closed database: %d", w.Code)
+	}
+}
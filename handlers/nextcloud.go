@@ -0,0 +1,802 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The Nextcloud Bookmarks REST API v2 subset for the Nextcloud Bookmarks clients.
+// The Nextcloud root folder is -1, the other folders having their GoBkm id.
+const (
+	nextcloudAPIPath = "/index.php/apps/bookmarks/public/rest/v2/"
+	ncRootFolder     = -1
+	// ncDefaultLimit is the default number of bookmarks of a page.
+	ncDefaultLimit = 10
+)
+
+// ncResponse is a Nextcloud API response, Data for the lists
+// and the error messages, Item for a single item.
+type ncResponse struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Item   interface{} `json:"item,omitempty"`
+}
+
+// ncBookmark is a Nextcloud bookmark.
+type ncBookmark struct {
+	ID           int      `json:"id"`
+	URL          string   `json:"url"`
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	Added        int64    `json:"added"`
+	LastModified int64    `json:"lastmodified"`
+	ClickCount   int      `json:"clickcount"`
+	Tags         []string `json:"tags"`
+	Folders      []int    `json:"folders"`
+}
+
+// ncBookmarkParams are the parameters of a bookmark creation or update,
+// nil if not given.
+type ncBookmarkParams struct {
+	URL         *string   `json:"url"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	Folders     *[]int    `json:"folders"`
+}
+
+// ncFolder is a Nextcloud folder.
+type ncFolder struct {
+	ID           int    `json:"id"`
+	Title        string `json:"title"`
+	ParentFolder int    `json:"parent_folder"`
+}
+
+// ncFolderNode is a Nextcloud folder of the folders hierarchy.
+type ncFolderNode struct {
+	ncFolder
+	Children []*ncFolderNode `json:"children"`
+}
+
+// ncFolderParams are the parameters of a folder creation or update,
+// nil if not given.
+type ncFolderParams struct {
+	Title        *string `json:"title"`
+	ParentFolder *int    `json:"parent_folder"`
+}
+
+// ncFolderID returns the Nextcloud id of the given GoBkm folder id.
+func ncFolderID(id int) int {
+	if id == 0 || id == 1 {
+		return ncRootFolder
+	}
+	return id
+}
+
+// gobkmFolderID returns the GoBkm id of the given Nextcloud folder id.
+func gobkmFolderID(id int) int {
+	if id == ncRootFolder || id == 0 {
+		return 1
+	}
+	return id
+}
+
+// ncBookmarkOf returns the Nextcloud bookmark of the given bookmark,
+// in the folder folderID if its folder is not retrieved.
+func ncBookmarkOf(bkm *types.Bookmark, folderID int) ncBookmark {
+	if bkm.Folder != nil {
+		folderID = bkm.Folder.Id
+	}
+	b := ncBookmark{
+		ID:           bkm.Id,
+		URL:          bkm.URL,
+		Title:        bkm.Title,
+		Description:  bkm.Description,
+		Added:        bkm.CreatedAt.Unix(),
+		LastModified: bkm.UpdatedAt.Unix(),
+		ClickCount:   bkm.VisitCount,
+		Tags:         bkm.Tags,
+		Folders:      []int{ncFolderID(folderID)},
+	}
+	if b.Tags == nil {
+		b.Tags = []string{}
+	}
+	return b
+}
+
+// ncFolderOf returns the Nextcloud folder of the given folder.
+func ncFolderOf(fld *types.Folder) ncFolder {
+	f := ncFolder{ID: ncFolderID(fld.Id), Title: fld.Title, ParentFolder: ncRootFolder}
+	if fld.Parent != nil {
+		f.ParentFolder = ncFolderID(fld.Parent.Id)
+	}
+	return f
+}
+
+// formValue returns the given form parameter, nil if not given.
+func formValue(form url.Values, key string) *string {
+	if v := form[key]; len(v) != 0 {
+		return &v[0]
+	}
+	return nil
+}
+
+// ncBookmarkParamsFromRequest returns the bookmark parameters
+// of the JSON body of the request, or of its form parameters.
+func ncBookmarkParamsFromRequest(r *http.Request) (ncBookmarkParams, error) {
+	var p ncBookmarkParams
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&p)
+		return p, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return p, err
+	}
+	p.URL = formValue(r.Form, "url")
+	p.Title = formValue(r.Form, "title")
+	p.Description = formValue(r.Form, "description")
+	if tags, ok := r.Form["tags[]"]; ok {
+		p.Tags = &tags
+	}
+	if folderParams, ok := r.Form["folders[]"]; ok {
+		folders := make([]int, len(folderParams))
+		for i, f := range folderParams {
+			var err error
+			if folders[i], err = strconv.Atoi(f); err != nil {
+				return p, err
+			}
+		}
+		p.Folders = &folders
+	}
+	return p, nil
+}
+
+// ncFolderParamsFromRequest returns the folder parameters
+// of the JSON body of the request, or of its form parameters.
+func ncFolderParamsFromRequest(r *http.Request) (ncFolderParams, error) {
+	var p ncFolderParams
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&p)
+		return p, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return p, err
+	}
+	p.Title = formValue(r.Form, "title")
+	if parent := formValue(r.Form, "parent_folder"); parent != nil {
+		parentID, err := strconv.Atoi(*parent)
+		if err != nil {
+			return p, err
+		}
+		p.ParentFolder = &parentID
+	}
+	return p, nil
+}
+
+// ncFail sends a Nextcloud API error (httpStatus) with the given errorMessage.
+func ncFail(w http.ResponseWriter, functionName string, errorMessage string, httpStatus int) {
+	log.WithFields(log.Fields{
+		"functionName": functionName,
+		"errorMessage": errorMessage,
+	}).Error("ncFail")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(ncResponse{Status: "error", Data: []string{errorMessage}})
+}
+
+// ncWrite sends the given Nextcloud API response.
+func ncWrite(w http.ResponseWriter, functionName string, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		ncFail(w, functionName, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ncFlushErrors fails the request and returns true on datastore errors,
+// notFound being the message of a missing item.
+func (env *Env) ncFlushErrors(w http.ResponseWriter, functionName string, notFound string) bool {
+	if err := env.DB.FlushErrors(); err == sql.ErrNoRows {
+		ncFail(w, functionName, notFound, http.StatusNotFound)
+		return true
+	} else if err != nil {
+		ncFail(w, functionName, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
+
+// ncParseID returns the given id path parameter, or fails the request
+// and returns false if it is not an integer.
+func ncParseID(w http.ResponseWriter, functionName string, id string) (int, bool) {
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		ncFail(w, functionName, "id Atoi conversion", http.StatusBadRequest)
+		return 0, false
+	}
+	return i, true
+}
+
+// ncWritableFolder returns the folder of the given Nextcloud folder id,
+// or fails the request and returns nil if it is missing or a smart folder.
+func (env *Env) ncWritableFolder(w http.ResponseWriter, functionName string, id int) *types.Folder {
+	fld := env.DB.GetFolder(gobkmFolderID(id))
+	if env.ncFlushErrors(w, functionName, "folder not found") {
+		return nil
+	}
+	if fld.Query != "" {
+		ncFail(w, functionName, "smart folders are read-only", http.StatusBadRequest)
+		return nil
+	}
+	return fld
+}
+
+// ncApplyBookmarkParams sets the given parameters to the bookmark,
+// or fails the request and returns false if they are invalid.
+// The bookmark is moved to the first folder of the folders parameter.
+func (env *Env) ncApplyBookmarkParams(w http.ResponseWriter, functionName string, bkm *types.Bookmark, p ncBookmarkParams) bool {
+	var ok bool
+	if p.URL != nil {
+		if *p.URL == "" {
+			ncFail(w, functionName, "url empty", http.StatusBadRequest)
+			return false
+		}
+		bkm.URL = *p.URL
+	}
+	if p.Title != nil {
+		bkm.Title = strings.TrimSpace(*p.Title)
+	}
+	if bkm.Title == "" {
+		bkm.Title = bkm.URL
+	}
+	if p.Description != nil {
+		bkm.Description = *p.Description
+	}
+	if p.Tags != nil {
+		if bkm.Tags, ok = normalizeTags(*p.Tags); !ok {
+			ncFail(w, functionName, "tag with a comma", http.StatusBadRequest)
+			return false
+		}
+	}
+	if p.Folders != nil && len(*p.Folders) != 0 {
+		if bkm.Folder = env.ncWritableFolder(w, functionName, (*p.Folders)[0]); bkm.Folder == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// NextcloudHandler serves the Nextcloud Bookmarks REST API v2 subset
+// under nextcloudAPIPath, so that the Nextcloud Bookmarks clients can use GoBkm:
+// the bookmark, bookmark/{id}, tag, tag/{name}, folder and folder/{id} endpoints.
+// A bookmark is in a single folder, the first one given,
+// and the smart folders are left out of the folders hierarchy.
+func (env *Env) NextcloudHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, nextcloudAPIPath), "/")
+	log.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   path,
+	}).Debug("NextcloudHandler")
+
+	// The endpoint and its optional id.
+	endpoint, id := path, ""
+	if i := strings.Index(path, "/"); i != -1 {
+		endpoint, id = path[:i], path[i+1:]
+	}
+	route := r.Method + " " + endpoint
+	if id != "" {
+		route += "/{id}"
+	}
+
+	routes := map[string]func(http.ResponseWriter, *http.Request, string){
+		"GET bookmark":         env.ncGetBookmarks,
+		"POST bookmark":        env.ncAddBookmark,
+		"GET bookmark/{id}":    env.ncGetBookmark,
+		"PUT bookmark/{id}":    env.ncUpdateBookmark,
+		"DELETE bookmark/{id}": env.ncDeleteBookmark,
+		"GET tag":              env.ncGetTags,
+		"PUT tag/{id}":         env.ncRenameTag,
+		"DELETE tag/{id}":      env.ncDeleteTag,
+		"GET folder":           env.ncGetFolders,
+		"POST folder":          env.ncAddFolder,
+		"GET folder/{id}":      env.ncGetFolder,
+		"PUT folder/{id}":      env.ncUpdateFolder,
+		"DELETE folder/{id}":   env.ncDeleteFolder,
+	}
+	if handler, ok := routes[route]; ok {
+		handler(w, r, id)
+		return
+	}
+	ncFail(w, "NextcloudHandler", "no such endpoint "+route, http.StatusNotFound)
+}
+
+// ncGetBookmarks returns a page of the bookmarks, the last modified first,
+// with the optional parameters:
+// page (0 by default, -1 for all the bookmarks) and limit (10 by default),
+// sortby (title, added, lastmodified or clickcount), folder, url,
+// tags[] and search[] words, all of them or any of them with conjunction=or,
+// and untagged=true for the bookmarks without tags.
+func (env *Env) ncGetBookmarks(w http.ResponseWriter, r *http.Request, _ string) {
+	var err error
+	params := r.URL.Query()
+	log.WithFields(log.Fields{
+		"params": params,
+	}).Debug("ncGetBookmarks:Query parameter")
+
+	// Parameters check.
+	page, limit := 0, ncDefaultLimit
+	if p := params.Get("page"); p != "" {
+		if page, err = strconv.Atoi(p); err != nil {
+			ncFail(w, "ncGetBookmarks", "page Atoi conversion", http.StatusBadRequest)
+			return
+		}
+	}
+	if l := params.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			ncFail(w, "ncGetBookmarks", "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	q := types.BookmarkQuery{Sort: types.SortUpdated, Desc: true}
+	switch params.Get("sortby") {
+	case "title":
+		q.Sort, q.Desc = types.SortTitle, false
+	case "added":
+		q.Sort = types.SortCreated
+	case "clickcount":
+		q.Sort = types.SortVisits
+	}
+	if f := params.Get("folder"); f != "" {
+		folderID, err := strconv.Atoi(f)
+		if err != nil {
+			ncFail(w, "ncGetBookmarks", "folder Atoi conversion", http.StatusBadRequest)
+			return
+		}
+		fld := env.DB.GetFolder(gobkmFolderID(folderID))
+		if env.ncFlushErrors(w, "ncGetBookmarks", "folder not found") {
+			return
+		}
+		if fld.Query != "" {
+			sq, err := smartFolderQuery(fld)
+			if err != nil {
+				ncFail(w, "ncGetBookmarks", err.Error(), http.StatusInternalServerError)
+				return
+			}
+			sq.Sort, sq.Desc = q.Sort, q.Desc
+			q = sq
+		} else {
+			q.FolderID = fld.Id
+		}
+	}
+
+	// Getting the bookmarks.
+	var bkms []*types.Bookmark
+	if u := params.Get("url"); u != "" {
+		bkms = env.DB.FindBookmarksByURL(u)
+	} else {
+		bkms = env.DB.QueryBookmarks(q)
+	}
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncGetBookmarks", "bookmark not found") {
+		return
+	}
+
+	// Filtering and paging them.
+	untagged := params.Get("untagged") == "true"
+	or := params.Get("conjunction") == "or"
+	result := []ncBookmark{}
+	for _, bkm := range bkms {
+		if (untagged && len(bkm.Tags) != 0) || !matchBookmark(bkm, params["tags[]"], params["search[]"], or) {
+			continue
+		}
+		result = append(result, ncBookmarkOf(bkm, q.FolderID))
+	}
+	if page >= 0 {
+		start, end := page*limit, (page+1)*limit
+		if start > len(result) {
+			start = len(result)
+		}
+		if end > len(result) {
+			end = len(result)
+		}
+		result = result[start:end]
+	}
+
+	ncWrite(w, "ncGetBookmarks", ncResponse{Status: "success", Data: result})
+}
+
+// ncGetBookmark returns the bookmark id.
+func (env *Env) ncGetBookmark(w http.ResponseWriter, r *http.Request, id string) {
+	bookmarkID, ok := ncParseID(w, "ncGetBookmark", id)
+	if !ok {
+		return
+	}
+
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncGetBookmark", "bookmark not found") {
+		return
+	}
+
+	ncWrite(w, "ncGetBookmark", ncResponse{Status: "success", Item: ncBookmarkOf(bkm, 1)})
+}
+
+// ncAddBookmark creates a bookmark with the url parameter and the optional
+// title, description, tags and folders parameters, and returns it.
+func (env *Env) ncAddBookmark(w http.ResponseWriter, r *http.Request, _ string) {
+	// Parameters check.
+	p, err := ncBookmarkParamsFromRequest(r)
+	if err != nil {
+		ncFail(w, "ncAddBookmark", "parameters decode error", http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{
+		"p": p,
+	}).Debug("ncAddBookmark:Query parameter")
+	if p.URL == nil {
+		ncFail(w, "ncAddBookmark", "url empty", http.StatusBadRequest)
+		return
+	}
+	var bkm types.Bookmark
+	if !env.ncApplyBookmarkParams(w, "ncAddBookmark", &bkm, p) {
+		return
+	}
+
+	// Saving the bookmark into the DB, getting its id.
+	bkm.Id = int(env.DB.SaveBookmark(&bkm))
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncAddBookmark", "bookmark not found") {
+		return
+	}
+
+	item := ncBookmarkOf(&bkm, 1)
+	// Updating the bookmark favicon.
+	goBackground(func() { env.UpdateBookmarkFavicon(&bkm) })
+
+	ncWrite(w, "ncAddBookmark", ncResponse{Status: "success", Item: item})
+}
+
+// ncUpdateBookmark updates the bookmark id with the given parameters,
+// the missing ones being unchanged, and returns it.
+func (env *Env) ncUpdateBookmark(w http.ResponseWriter, r *http.Request, id string) {
+	bookmarkID, ok := ncParseID(w, "ncUpdateBookmark", id)
+	if !ok {
+		return
+	}
+	// Parameters check.
+	p, err := ncBookmarkParamsFromRequest(r)
+	if err != nil {
+		ncFail(w, "ncUpdateBookmark", "parameters decode error", http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{
+		"p": p,
+	}).Debug("ncUpdateBookmark:Query parameter")
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncUpdateBookmark", "bookmark not found") {
+		return
+	}
+	oldURL, oldFolderID := bkm.URL, 1
+	if bkm.Folder != nil {
+		oldFolderID = bkm.Folder.Id
+	}
+	if !env.ncApplyBookmarkParams(w, "ncUpdateBookmark", bkm, p) {
+		return
+	}
+
+	// Updating it, after the last bookmark of its new folder.
+	env.DB.UpdateBookmark(bkm)
+	if bkm.Folder != nil && bkm.Folder.Id != oldFolderID {
+		env.DB.PositionBookmark(bkm, 0)
+	}
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncUpdateBookmark", "bookmark not found") {
+		return
+	}
+
+	item := ncBookmarkOf(bkm, 1)
+	// Updating the bookmark favicon.
+	if bkm.URL != oldURL {
+		goBackground(func() { env.UpdateBookmarkFavicon(bkm) })
+	}
+
+	ncWrite(w, "ncUpdateBookmark", ncResponse{Status: "success", Item: item})
+}
+
+// ncDeleteBookmark deletes the bookmark id.
+func (env *Env) ncDeleteBookmark(w http.ResponseWriter, r *http.Request, id string) {
+	bookmarkID, ok := ncParseID(w, "ncDeleteBookmark", id)
+	if !ok {
+		return
+	}
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Deleting it.
+	env.DB.DeleteBookmark(bkm)
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncDeleteBookmark", "bookmark not found") {
+		return
+	}
+
+	ncWrite(w, "ncDeleteBookmark", ncResponse{Status: "success"})
+}
+
+// ncGetTags returns the tags, sorted.
+func (env *Env) ncGetTags(w http.ResponseWriter, r *http.Request, _ string) {
+	tags := env.DB.GetTags()
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncGetTags", "tag not found") {
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	ncWrite(w, "ncGetTags", tags)
+}
+
+// ncRetag replaces the tag oldTag of the bookmarks by the tag newTag,
+// the tag being removed if newTag is empty.
+func (env *Env) ncRetag(w http.ResponseWriter, functionName string, oldTag string, newTag string) {
+	n := env.retagBookmarks(oldTag, newTag)
+	// Datastore error check.
+	if env.ncFlushErrors(w, functionName, "bookmark not found") {
+		return
+	}
+	if n == 0 {
+		ncFail(w, functionName, "tag not found", http.StatusNotFound)
+		return
+	}
+
+	ncWrite(w, functionName, ncResponse{Status: "success"})
+}
+
+// ncRenameTag renames the tag name with the name parameter.
+func (env *Env) ncRenameTag(w http.ResponseWriter, r *http.Request, name string) {
+	var p struct {
+		Name *string `json:"name"`
+	}
+	// Parameters check.
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			ncFail(w, "ncRenameTag", "parameters decode error", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if r.ParseForm() == nil {
+			p.Name = formValue(r.Form, "name")
+		}
+	}
+	log.WithFields(log.Fields{
+		"name": name,
+		"p":    p,
+	}).Debug("ncRenameTag:Query parameter")
+	if p.Name == nil {
+		ncFail(w, "ncRenameTag", "name empty", http.StatusBadRequest)
+		return
+	}
+	tags, ok := normalizeTags([]string{name, *p.Name})
+	if !ok || len(tags) == 0 {
+		ncFail(w, "ncRenameTag", "invalid tag name", http.StatusBadRequest)
+		return
+	}
+	// Nothing to do if the name is unchanged.
+	if len(tags) == 1 {
+		ncWrite(w, "ncRenameTag", ncResponse{Status: "success"})
+		return
+	}
+
+	env.ncRetag(w, "ncRenameTag", tags[0], tags[1])
+}
+
+// ncDeleteTag removes the tag name from its bookmarks.
+func (env *Env) ncDeleteTag(w http.ResponseWriter, r *http.Request, name string) {
+	tags, ok := normalizeTags([]string{name})
+	if !ok || len(tags) == 0 {
+		ncFail(w, "ncDeleteTag", "invalid tag name", http.StatusBadRequest)
+		return
+	}
+
+	env.ncRetag(w, "ncDeleteTag", tags[0], "")
+}
+
+// ncFolderTree returns the subfolders of the given folder id without the smart folders,
+// with their subfolders down to the given number of layers, all of them if 0.
+func (env *Env) ncFolderTree(id int, layers int) []*ncFolderNode {
+	children := []*ncFolderNode{}
+	for _, sub := range env.DB.GetFolderSubfolders(id) {
+		if sub.Query != "" {
+			continue
+		}
+		node := &ncFolderNode{ncFolder: ncFolder{ID: sub.Id, Title: sub.Title, ParentFolder: ncFolderID(id)}, Children: []*ncFolderNode{}}
+		if layers != 1 {
+			node.Children = env.ncFolderTree(sub.Id, layers-1)
+		}
+		children = append(children, node)
+	}
+	return children
+}
+
+// ncGetFolders returns the folders hierarchy of the optional root folder,
+// the root folder by default, down to the optional number of layers.
+func (env *Env) ncGetFolders(w http.ResponseWriter, r *http.Request, _ string) {
+	var err error
+	root, layers := ncRootFolder, 0
+	// GET parameters retrieval.
+	rootParam := r.URL.Query().Get("root")
+	layersParam := r.URL.Query().Get("layers")
+	log.WithFields(log.Fields{
+		"rootParam":   rootParam,
+		"layersParam": layersParam,
+	}).Debug("ncGetFolders:Query parameter")
+
+	// Parameters check.
+	if rootParam != "" {
+		if root, err = strconv.Atoi(rootParam); err != nil {
+			ncFail(w, "ncGetFolders", "root Atoi conversion", http.StatusBadRequest)
+			return
+		}
+	}
+	if layersParam != "" {
+		if layers, err = strconv.Atoi(layersParam); err != nil || layers < 0 {
+			ncFail(w, "ncGetFolders", "invalid layers", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Checking the root folder and building its hierarchy.
+	env.DB.GetFolder(gobkmFolderID(root))
+	tree := env.ncFolderTree(gobkmFolderID(root), layers)
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncGetFolders", "folder not found") {
+		return
+	}
+
+	ncWrite(w, "ncGetFolders", ncResponse{Status: "success", Data: tree})
+}
+
+// ncGetFolder returns the folder id.
+func (env *Env) ncGetFolder(w http.ResponseWriter, r *http.Request, id string) {
+	folderID, ok := ncParseID(w, "ncGetFolder", id)
+	if !ok {
+		return
+	}
+
+	fld := env.DB.GetFolder(gobkmFolderID(folderID))
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncGetFolder", "folder not found") {
+		return
+	}
+
+	ncWrite(w, "ncGetFolder", ncResponse{Status: "success", Item: ncFolderOf(fld)})
+}
+
+// ncAddFolder creates a folder with the title parameter
+// in the optional parent_folder, the root folder by default, and returns it.
+func (env *Env) ncAddFolder(w http.ResponseWriter, r *http.Request, _ string) {
+	// Parameters check.
+	p, err := ncFolderParamsFromRequest(r)
+	if err != nil {
+		ncFail(w, "ncAddFolder", "parameters decode error", http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{
+		"p": p,
+	}).Debug("ncAddFolder:Query parameter")
+	if p.Title == nil || strings.TrimSpace(*p.Title) == "" {
+		ncFail(w, "ncAddFolder", "title empty", http.StatusBadRequest)
+		return
+	}
+	parentID := ncRootFolder
+	if p.ParentFolder != nil {
+		parentID = *p.ParentFolder
+	}
+	parent := env.ncWritableFolder(w, "ncAddFolder", parentID)
+	if parent == nil {
+		return
+	}
+
+	// Saving the folder into the DB, getting its id,
+	// and updating it for the subfolders count of its parent.
+	fld := types.Folder{Title: strings.TrimSpace(*p.Title), Parent: parent}
+	fld.Id = int(env.DB.SaveFolder(&fld))
+	env.DB.UpdateFolder(&fld)
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncAddFolder", "folder not found") {
+		return
+	}
+
+	ncWrite(w, "ncAddFolder", ncResponse{Status: "success", Item: ncFolderOf(&fld)})
+}
+
+// ncUpdateFolder renames the folder id with the title parameter
+// and moves it into the parent_folder parameter, after its last subfolder.
+func (env *Env) ncUpdateFolder(w http.ResponseWriter, r *http.Request, id string) {
+	folderID, ok := ncParseID(w, "ncUpdateFolder", id)
+	if !ok {
+		return
+	}
+	// Parameters check.
+	p, err := ncFolderParamsFromRequest(r)
+	if err != nil {
+		ncFail(w, "ncUpdateFolder", "parameters decode error", http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{
+		"p": p,
+	}).Debug("ncUpdateFolder:Query parameter")
+	if gobkmFolderID(folderID) == 1 {
+		ncFail(w, "ncUpdateFolder", "the root folder can not be changed", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the folder.
+	fld := env.ncWritableFolder(w, "ncUpdateFolder", folderID)
+	if fld == nil {
+		return
+	}
+	if p.Title != nil {
+		if fld.Title = strings.TrimSpace(*p.Title); fld.Title == "" {
+			ncFail(w, "ncUpdateFolder", "title empty", http.StatusBadRequest)
+			return
+		}
+	}
+	moved := false
+	if p.ParentFolder != nil && gobkmFolderID(*p.ParentFolder) != fld.Parent.Id {
+		parent := env.ncWritableFolder(w, "ncUpdateFolder", *p.ParentFolder)
+		if parent == nil {
+			return
+		}
+		if isSubfolder(parent, fld.Id) {
+			ncFail(w, "ncUpdateFolder", "can not move a folder into itself or one of its subfolders", http.StatusBadRequest)
+			return
+		}
+		fld.Parent, moved = parent, true
+	}
+
+	// Updating it.
+	env.DB.UpdateFolder(fld)
+	if moved {
+		env.DB.PositionFolder(fld, 0)
+	}
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncUpdateFolder", "folder not found") {
+		return
+	}
+
+	ncWrite(w, "ncUpdateFolder", ncResponse{Status: "success", Item: ncFolderOf(fld)})
+}
+
+// ncDeleteFolder deletes the folder id with its content.
+func (env *Env) ncDeleteFolder(w http.ResponseWriter, r *http.Request, id string) {
+	folderID, ok := ncParseID(w, "ncDeleteFolder", id)
+	if !ok {
+		return
+	}
+	if gobkmFolderID(folderID) == 1 {
+		ncFail(w, "ncDeleteFolder", "the root folder can not be deleted", http.StatusBadRequest)
+		return
+	}
+
+	// Getting the folder.
+	fld := env.DB.GetFolder(folderID)
+	// Deleting it.
+	env.DB.DeleteFolder(fld)
+	// Datastore error check.
+	if env.ncFlushErrors(w, "ncDeleteFolder", "folder not found") {
+		return
+	}
+
+	ncWrite(w, "ncDeleteFolder", ncResponse{Status: "success"})
+}
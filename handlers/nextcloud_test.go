@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// ncTestResponse is a Nextcloud API response with its data and item undecoded.
+type ncTestResponse struct {
+	Status string
+	Data   json.RawMessage
+	Item   json.RawMessage
+}
+
+// serveNC returns the response of the Nextcloud handler to the method request
+// of the endpoint path, with the given body: JSON if it starts with {, a form otherwise.
+func serveNC(t *testing.T, env *Env, method string, path string, body string) (*httptest.ResponseRecorder, ncTestResponse) {
+	r := httptest.NewRequest(method, nextcloudAPIPath+path, strings.NewReader(body))
+	if strings.HasPrefix(body, "{") {
+		r.Header.Set("Content-Type", "application/json")
+	} else if body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	env.NextcloudHandler(w, r)
+
+	var resp ncTestResponse
+	if path != "tag" {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return w, resp
+}
+
+// ncTreeString returns the given folders as title[subfolders] comma separated items.
+func ncTreeString(nodes []*ncFolderNode) string {
+	var items []string
+	for _, n := range nodes {
+		items = append(items, fmt.Sprintf("%s(%d)[%s]", n.Title, n.ParentFolder, ncTreeString(n.Children)))
+	}
+	return strings.Join(items, ",")
+}
+
+func TestNextcloudBookmarks(t *testing.T) {
+	env := newTestEnv(t)
+	it := saveFolder(t, env, "IT", nil)
+	smart := saveFolder(t, env, "Starred", nil)
+	smart.Query = "starred=true"
+	env.DB.UpdateFolder(smart)
+	now := time.Now()
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/", Folder: it, Tags: []string{"lang"}, Starred: true, CreatedAt: now.Add(-time.Hour)})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Rust", URL: "https://rust-lang.org/", Folder: it, Tags: []string{"lang", "safe"}, CreatedAt: now})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "News", URL: "https://news.example.com/", CreatedAt: now.Add(-2 * time.Hour)})
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	itID, smartID := strconv.Itoa(it.Id), strconv.Itoa(smart.Id)
+	tests := []struct {
+		query  string
+		status int
+		titles string
+	}{
+		{"sortby=title", http.StatusOK, "[Go News Rust]"},
+		{"sortby=added", http.StatusOK, "[Rust Go News]"},
+		{"sortby=title&limit=2&page=1", http.StatusOK, "[Rust]"},
+		{"sortby=title&limit=1&page=-1", http.StatusOK, "[Go News Rust]"},
+		{"sortby=title&page=5", http.StatusOK, "[]"},
+		{"sortby=title&folder=" + itID, http.StatusOK, "[Go Rust]"},
+		{"sortby=title&folder=-1", http.StatusOK, "[News]"},
+		{"folder=" + smartID, http.StatusOK, "[Go]"},
+		{"sortby=title&tags[]=lang", http.StatusOK, "[Go Rust]"},
+		{"sortby=title&tags[]=lang&tags[]=safe", http.StatusOK, "[Rust]"},
+		{"sortby=title&tags[]=safe&search[]=news&conjunction=or", http.StatusOK, "[News Rust]"},
+		{"sortby=title&search[]=GOLANG", http.StatusOK, "[Go]"},
+		{"untagged=true", http.StatusOK, "[News]"},
+		{"url=https://rust-lang.org/", http.StatusOK, "[Rust]"},
+		{"folder=99", http.StatusNotFound, ""},
+		{"folder=x", http.StatusBadRequest, ""},
+		{"page=x", http.StatusBadRequest, ""},
+		{"limit=0", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w, resp := serveNC(t, env, "GET", "bookmark?"+tt.query, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.query, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			if resp.Status != "error" {
+				t.Errorf("%s: response status %q, want error", tt.query, resp.Status)
+			}
+			continue
+		}
+		var bkms []ncBookmark
+		if err := json.Unmarshal(resp.Data, &bkms); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var titles []string
+		for _, b := range bkms {
+			titles = append(titles, b.Title)
+		}
+		if got := fmt.Sprintf("%v", titles); got != tt.titles {
+			t.Errorf("%s: bookmarks %s, want %s", tt.query, got, tt.titles)
+		}
+	}
+
+	// The root folder is -1, the other folders keep their id.
+	_, resp := serveNC(t, env, "GET", "bookmark/3", "")
+	var b ncBookmark
+	if err := json.Unmarshal(resp.Item, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Title != "News" || fmt.Sprint(b.Folders) != "[-1]" || fmt.Sprint(b.Tags) != "[]" {
+		t.Errorf("News bookmark %+v", b)
+	}
+	for path, status := range map[string]int{"bookmark/99": http.StatusNotFound, "bookmark/x": http.StatusBadRequest, "bookmarks": http.StatusNotFound} {
+		if w, _ := serveNC(t, env, "GET", path, ""); w.Code != status {
+			t.Errorf("GET %s: status %d, want %d", path, w.Code, status)
+		}
+	}
+}
+
+func TestNextcloudBookmarkChanges(t *testing.T) {
+	env := newTestEnv(t)
+	it := saveFolder(t, env, "IT", nil)
+	smart := saveFolder(t, env, "Starred", nil)
+	smart.Query = "starred=true"
+	env.DB.UpdateFolder(smart)
+	itID, smartID := strconv.Itoa(it.Id), strconv.Itoa(smart.Id)
+
+	// Creating bookmarks from JSON and forms.
+	tests := []struct {
+		body   string
+		status int
+		want   string // the bookmark title, folders and tags
+	}{
+		{`{"url":"https://golang.org/","title":" Go ","tags":["Lang","lang"," go "],"folders":[` + itID + `]}`, http.StatusOK, "Go [" + itID + "] [lang go]"},
+		{"url=https%3A%2F%2Fnews.example.com%2F&tags[]=news", http.StatusOK, "https://news.example.com/ [-1] [news]"},
+		{`{"title":"No URL"}`, http.StatusBadRequest, ""},
+		{`{"url":""}`, http.StatusBadRequest, ""},
+		{`{"url":"https://example.com/","tags":["a,b"]}`, http.StatusBadRequest, ""},
+		{`{"url":"https://example.com/","folders":[` + smartID + `]}`, http.StatusBadRequest, ""},
+		{`{"url":"https://example.com/","folders":[99]}`, http.StatusNotFound, ""},
+		{"url=https%3A%2F%2Fexample.com%2F&folders[]=x", http.StatusBadRequest, ""},
+		{`{"url":`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w, resp := serveNC(t, env, "POST", "bookmark", tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var b ncBookmark
+		if err := json.Unmarshal(resp.Item, &b); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%s %v %v", b.Title, b.Folders, b.Tags); resp.Status != "success" || got != tt.want {
+			t.Errorf("%s: bookmark %s, want %s", tt.body, got, tt.want)
+		}
+	}
+	background.Wait()
+	if n := len(env.DB.QueryBookmarks(types.BookmarkQuery{})); n != 2 {
+		t.Fatalf("%d bookmarks, want 2", n)
+	}
+
+	// Updating the Go bookmark, the missing parameters being unchanged.
+	updates := []struct {
+		path, body string
+		status     int
+		want       string
+	}{
+		{"bookmark/1", `{"title":"Golang"}`, http.StatusOK, "Golang https://golang.org/ [" + itID + "] [lang go]"},
+		{"bookmark/1", "folders[]=-1&tags[]=", http.StatusOK, "Golang https://golang.org/ [-1] []"},
+		{"bookmark/1", `{"url":"https://go.dev/","folders":[]}`, http.StatusOK, "Golang https://go.dev/ [-1] []"},
+		{"bookmark/1", `{"folders":[` + smartID + `]}`, http.StatusBadRequest, ""},
+		{"bookmark/99", `{"title":"Missing"}`, http.StatusNotFound, ""},
+		{"bookmark/x", `{"title":"Missing"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range updates {
+		w, resp := serveNC(t, env, "PUT", tt.path, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.path, tt.body, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var b ncBookmark
+		if err := json.Unmarshal(resp.Item, &b); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%s %s %v %v", b.Title, b.URL, b.Folders, b.Tags); got != tt.want {
+			t.Errorf("%s %s: bookmark %s, want %s", tt.path, tt.body, got, tt.want)
+		}
+	}
+	background.Wait()
+	if bkm := env.DB.GetBookmark(1); bkm.Folder.Id != 1 || bkm.URL != "https://go.dev/" {
+		t.Errorf("updated bookmark %s in folder %d", bkm.URL, bkm.Folder.Id)
+	}
+
+	// Deleting it.
+	if w, resp := serveNC(t, env, "DELETE", "bookmark/1", ""); w.Code != http.StatusOK || resp.Status != "success" {
+		t.Errorf("delete status %d %q", w.Code, resp.Status)
+	}
+	if w, _ := serveNC(t, env, "DELETE", "bookmark/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("deleted bookmark delete status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestNextcloudTags(t *testing.T) {
+	env := newTestEnv(t)
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/", Tags: []string{"lang", "go"}})
+	env.DB.SaveBookmark(&types.Bookmark{Title: "Rust", URL: "https://rust-lang.org/", Tags: []string{"lang"}})
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	// tags returns the tags of the tag endpoint.
+	tags := func() string {
+		w, _ := serveNC(t, env, "GET", "tag", "")
+		var tags []string
+		if err := json.Unmarshal(w.Body.Bytes(), &tags); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(tags)
+	}
+	if got := tags(); got != "[go lang]" {
+		t.Errorf("tags %s, want [go lang]", got)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		tags               string
+	}{
+		{"PUT", "tag/lang", `{"name":"Language"}`, http.StatusOK, "[go language]"},
+		{"PUT", "tag/go", "name=golang", http.StatusOK, "[golang language]"},
+		{"PUT", "tag/golang", "name=golang", http.StatusOK, "[golang language]"},
+		{"PUT", "tag/missing", "name=other", http.StatusNotFound, "[golang language]"},
+		{"PUT", "tag/golang", "", http.StatusBadRequest, "[golang language]"},
+		{"PUT", "tag/golang", "name=a%2Cb", http.StatusBadRequest, "[golang language]"},
+		{"DELETE", "tag/language", "", http.StatusOK, "[golang]"},
+		{"DELETE", "tag/language", "", http.StatusNotFound, "[golang]"},
+		{"DELETE", "tag/golang", "", http.StatusOK, "[]"},
+	}
+	for _, tt := range tests {
+		if w, _ := serveNC(t, env, tt.method, tt.path, tt.body); w.Code != tt.status {
+			t.Errorf("%s %s %s: status %d, want %d", tt.method, tt.path, tt.body, w.Code, tt.status)
+		}
+		if got := tags(); got != tt.tags {
+			t.Errorf("%s %s %s: tags %s, want %s", tt.method, tt.path, tt.body, got, tt.tags)
+		}
+	}
+}
+
+func TestNextcloudFolders(t *testing.T) {
+	env := newTestEnv(t)
+	it := saveFolder(t, env, "IT", nil)
+	dev := saveFolder(t, env, "Development", it)
+	smart := saveFolder(t, env, "Starred", nil)
+	smart.Query = "starred=true"
+	env.DB.UpdateFolder(smart)
+	saveBookmark(t, env, "Go", "https://golang.org/", dev)
+	itID, devID, smartID := strconv.Itoa(it.Id), strconv.Itoa(dev.Id), strconv.Itoa(smart.Id)
+
+	// The hierarchy, without the smart folder.
+	hierarchy := []struct {
+		query  string
+		status int
+		tree   string
+	}{
+		{"", http.StatusOK, "IT(-1)[Development(" + itID + ")[]]"},
+		{"layers=1", http.StatusOK, "IT(-1)[]"},
+		{"root=" + itID, http.StatusOK, "Development(" + itID + ")[]"},
+		{"root=99", http.StatusNotFound, ""},
+		{"root=x", http.StatusBadRequest, ""},
+		{"layers=-1", http.StatusBadRequest, ""},
+	}
+	for _, tt := range hierarchy {
+		w, resp := serveNC(t, env, "GET", "folder?"+tt.query, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.query, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var nodes []*ncFolderNode
+		if err := json.Unmarshal(resp.Data, &nodes); err != nil {
+			t.Fatal(err)
+		}
+		if got := ncTreeString(nodes); got != tt.tree {
+			t.Errorf("%s: folders %s, want %s", tt.query, got, tt.tree)
+		}
+	}
+
+	// Creating, getting, renaming and moving the folders.
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string // the folder title and parent
+	}{
+		{"POST", "folder", `{"title":" News "}`, http.StatusOK, "News -1"},
+		{"POST", "folder", "title=Rust&parent_folder=" + devID, http.StatusOK, "Rust " + devID},
+		{"POST", "folder", `{"title":" "}`, http.StatusBadRequest, ""},
+		{"POST", "folder", `{"title":"Smart","parent_folder":` + smartID + `}`, http.StatusBadRequest, ""},
+		{"POST", "folder", `{"title":"Missing","parent_folder":99}`, http.StatusNotFound, ""},
+		{"GET", "folder/" + devID, "", http.StatusOK, "Development " + itID},
+		{"GET", "folder/99", "", http.StatusNotFound, ""},
+		{"PUT", "folder/" + devID, `{"title":"Dev","parent_folder":-1}`, http.StatusOK, "Dev -1"},
+		{"PUT", "folder/" + devID, `{"title":""}`, http.StatusBadRequest, ""},
+		{"PUT", "folder/" + itID, `{"parent_folder":` + devID + `}`, http.StatusOK, "IT " + devID},
+		{"PUT", "folder/" + devID, `{"parent_folder":` + itID + `}`, http.StatusBadRequest, ""},
+		{"PUT", "folder/" + itID, `{"parent_folder":` + itID + `}`, http.StatusBadRequest, ""},
+		{"PUT", "folder/" + smartID, `{"title":"Stars"}`, http.StatusBadRequest, ""},
+		{"PUT", "folder/-1", `{"title":"Root"}`, http.StatusBadRequest, ""},
+		{"PUT", "folder/99", `{"title":"Missing"}`, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w, resp := serveNC(t, env, tt.method, tt.path, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s %s %s: status %d, want %d", tt.method, tt.path, tt.body, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var f ncFolder
+		if err := json.Unmarshal(resp.Item, &f); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%s %d", f.Title, f.ParentFolder); got != tt.want {
+			t.Errorf("%s %s %s: folder %s, want %s", tt.method, tt.path, tt.body, got, tt.want)
+		}
+	}
+	_, resp := serveNC(t, env, "GET", "folder", "")
+	var nodes []*ncFolderNode
+	if err := json.Unmarshal(resp.Data, &nodes); err != nil {
+		t.Fatal(err)
+	}
+	want := "Dev(-1)[IT(" + devID + ")[],Rust(" + devID + ")[]],News(-1)[]"
+	if got := ncTreeString(nodes); got != want {
+		t.Errorf("folders %s, want %s", got, want)
+	}
+
+	// Deleting them with their content, but not the root folder.
+	deletions := []struct {
+		path   string
+		status int
+	}{
+		{"folder/-1", http.StatusBadRequest},
+		{"folder/" + devID, http.StatusOK},
+		{"folder/" + devID, http.StatusNotFound},
+		{"folder/x", http.StatusBadRequest},
+	}
+	for _, tt := range deletions {
+		if w, _ := serveNC(t, env, "DELETE", tt.path, ""); w.Code != tt.status {
+			t.Errorf("DELETE %s: status %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+	if n := len(env.DB.QueryBookmarks(types.BookmarkQuery{})); n != 0 {
+		t.Errorf("%d bookmarks left in the deleted folder", n)
+	}
+}
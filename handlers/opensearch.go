@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxSuggestions is the maximum number of search suggestions.
+const maxSuggestions = 10
+
+// openSearchDescription is the OpenSearch description document
+// declaring GoBkm as a browser search engine.
+type openSearchDescription struct {
+	XMLName       xml.Name        `xml:"OpenSearchDescription"`
+	Xmlns         string          `xml:"xmlns,attr"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	InputEncoding string          `xml:"InputEncoding"`
+	Image         openSearchImage `xml:"Image"`
+	URLs          []openSearchURL `xml:"Url"`
+}
+
+// openSearchImage is the icon of the search engine.
+type openSearchImage struct {
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+	Type   string `xml:"type,attr"`
+	URL    string `xml:",chardata"`
+}
+
+// openSearchURL is a search or suggestions URL template.
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Rel      string `xml:"rel,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+// baseURL returns the application URL without its trailing slash.
+func (env *Env) baseURL() string {
+	return strings.TrimSuffix(env.GoBkmProxyURL, "/")
+}
+
+// OpenSearchHandler returns the OpenSearch description of GoBkm.
+// The searches go to the /k/ keywords resolver and the suggestions
+// are the titles of the matching bookmarks.
+func (env *Env) OpenSearchHandler(w http.ResponseWriter, r *http.Request) {
+	base := env.baseURL()
+	osd := openSearchDescription{
+		Xmlns:         "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:     "GoBkm",
+		Description:   "GoBkm bookmarks and keywords",
+		InputEncoding: "UTF-8",
+		Image:         openSearchImage{Width: 16, Height: 16, Type: "image/png", URL: base + "/img/favicon-16x16.png"},
+		URLs: []openSearchURL{
+			{Type: "text/html", Template: base + "/k/?q={searchTerms}"},
+			{Type: "application/x-suggestions+json", Template: base + "/suggestBookmarks/?q={searchTerms}"},
+			{Type: "application/opensearchdescription+xml", Rel: "self", Template: base + "/opensearch.xml"},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		failHTTP(w, "OpenSearchHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(osd); err != nil {
+		failHTTP(w, "OpenSearchHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SuggestBookmarksHandler returns the bookmarks matching the q parameter
+// in the OpenSearch suggestions format: the query, the bookmarks titles,
+// their URLs and their /go/ URLs.
+// A title submitted to the /k/ resolver opens its bookmark.
+func (env *Env) SuggestBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// GET parameters retrieval.
+	qParam := r.URL.Query()["q"]
+	log.WithFields(log.Fields{
+		"qParam": qParam,
+	}).Debug("SuggestBookmarksHandler:Query parameter")
+
+	q := ""
+	if len(qParam) != 0 {
+		q = strings.TrimSpace(qParam[0])
+	}
+	titles, descriptions, urls := []string{}, []string{}, []string{}
+
+	// Searching the bookmarks.
+	if q != "" {
+		bkms := env.DB.SearchBookmarks(q)
+		// Datastore error check.
+		if err = env.DB.FlushErrors(); err != nil {
+			failHTTP(w, "SuggestBookmarksHandler", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i, bkm := range bkms {
+			if i == maxSuggestions {
+				break
+			}
+			titles = append(titles, bkm.Title)
+			descriptions = append(descriptions, bkm.URL)
+			urls = append(urls, env.baseURL()+"/go/"+strconv.Itoa(bkm.Id))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-suggestions+json")
+	if err = json.NewEncoder(w).Encode([]interface{}{q, titles, descriptions, urls}); err != nil {
+		failHTTP(w, "SuggestBookmarksHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
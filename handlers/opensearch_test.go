@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestOpenSearchHandler(t *testing.T) {
+	env := newTestEnv(t)
+	env.GoBkmProxyURL = "https://bkm.example.com/"
+
+	w := serve(env.OpenSearchHandler, "GET", "/opensearch.xml", "")
+	if ct := w.Header().Get("Content-Type"); ct != "application/opensearchdescription+xml; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	var osd openSearchDescription
+	if err := xml.Unmarshal(w.Body.Bytes(), &osd); err != nil {
+		t.Fatal(err)
+	}
+	templates := map[string]string{}
+	for _, u := range osd.URLs {
+		templates[u.Type] = u.Template
+	}
+	want := map[string]string{
+		"text/html":                             "https://bkm.example.com/k/?q={searchTerms}",
+		"application/x-suggestions+json":        "https://bkm.example.com/suggestBookmarks/?q={searchTerms}",
+		"application/opensearchdescription+xml": "https://bkm.example.com/opensearch.xml",
+	}
+	if !reflect.DeepEqual(templates, want) {
+		t.Errorf("templates %v, want %v", templates, want)
+	}
+}
+
+func TestSuggestBookmarksHandler(t *testing.T) {
+	env := newTestEnv(t)
+	saveBookmark(t, env, "Go blog", "https://blog.golang.org/", nil)
+	saveBookmark(t, env, "Rust book", "https://doc.rust-lang.org/book/", nil)
+	for i := 0; i < maxSuggestions+2; i++ {
+		saveBookmark(t, env, fmt.Sprintf("Many %02d", i), fmt.Sprintf("https://many.example.com/%d", i), nil)
+	}
+
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"go", `["go",["Go blog"],["https://blog.golang.org/"],["http://gobkm.test/go/1"]]`},
+		{"+", `["",[],[],[]]`},
+		{"nothing", `["nothing",[],[],[]]`},
+	}
+	for _, tt := range tests {
+		w := serve(env.SuggestBookmarksHandler, "GET", "/suggestBookmarks/?q="+tt.q, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("%q: status %d", tt.q, w.Code)
+			continue
+		}
+		if got := w.Body.String(); got != tt.want+"\n" {
+			t.Errorf("%q: suggestions %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	// At most maxSuggestions suggestions.
+	var suggestions []interface{}
+	w := serve(env.SuggestBookmarksHandler, "GET", "/suggestBookmarks/?q=many", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &suggestions); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(suggestions[1].([]interface{})); n != maxSuggestions {
+		t.Errorf("%d suggestions, want %d", n, maxSuggestions)
+	}
+}
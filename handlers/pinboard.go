@@ -0,0 +1,675 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The Pinboard v1 API for the Pinboard clients, answering in XML
+// unless the format=json parameter is given, like Pinboard.
+// The Pinboard description is the bookmark title and its extended
+// description the bookmark note.
+const (
+	pinboardAPIPath = "/v1/"
+	// pbDefaultRecent and pbMaxRecent are the default and maximum numbers of recent posts.
+	pbDefaultRecent = 15
+	pbMaxRecent     = 100
+	// pbMaxTags is the maximum number of tags of the posts filters.
+	pbMaxTags = 3
+)
+
+// Pinboard result codes.
+const (
+	pbDone          = "done"
+	pbAlreadyExists = "item already exists"
+	pbNotFound      = "item not found"
+)
+
+// pbPost is a Pinboard post, a bookmark.
+type pbPost struct {
+	XMLName     xml.Name `json:"-" xml:"post"`
+	Href        string   `json:"href" xml:"href,attr"`
+	Description string   `json:"description" xml:"description,attr"`
+	Extended    string   `json:"extended" xml:"extended,attr"`
+	Meta        string   `json:"meta" xml:"meta,attr"`
+	Hash        string   `json:"hash" xml:"hash,attr"`
+	Time        string   `json:"time" xml:"time,attr"`
+	Shared      string   `json:"shared" xml:"shared,attr"`
+	ToRead      string   `json:"toread" xml:"toread,attr"`
+	Tags        string   `json:"tags" xml:"tag,attr"`
+}
+
+// pbPosts is a list of Pinboard posts.
+type pbPosts struct {
+	XMLName xml.Name `json:"-" xml:"posts"`
+	Date    string   `json:"date" xml:"dt,attr"`
+	User    string   `json:"user" xml:"user,attr"`
+	Posts   []pbPost `json:"posts" xml:"post"`
+}
+
+// pbResult is the result code of a Pinboard posts operation.
+type pbResult struct {
+	XMLName xml.Name `json:"-" xml:"result"`
+	Code    string   `json:"result_code" xml:"code,attr"`
+}
+
+// pbTextResult is the result of the other Pinboard operations.
+type pbTextResult struct {
+	XMLName xml.Name `json:"-" xml:"result"`
+	Result  string   `json:"result" xml:",chardata"`
+}
+
+// pbUpdate is the last update time of the posts.
+type pbUpdate struct {
+	XMLName    xml.Name `json:"-" xml:"update"`
+	UpdateTime string   `json:"update_time" xml:"time,attr"`
+}
+
+// pbTags is the XML list of the tags with their number of posts.
+type pbTags struct {
+	XMLName xml.Name `xml:"tags"`
+	Tags    []pbTag  `xml:"tag"`
+}
+
+// pbTag is a tag with its number of posts.
+type pbTag struct {
+	Count int    `xml:"count,attr"`
+	Tag   string `xml:"tag,attr"`
+}
+
+// pbDates is the XML list of the days with their number of posts.
+type pbDates struct {
+	XMLName xml.Name `xml:"dates"`
+	User    string   `xml:"user,attr"`
+	Tag     string   `xml:"tag,attr"`
+	Dates   []pbDate `xml:"date"`
+}
+
+// pbDate is a day with its number of posts.
+type pbDate struct {
+	Count int    `xml:"count,attr"`
+	Date  string `xml:"date,attr"`
+}
+
+// pbHash returns the MD5 hex digest of the given string.
+func pbHash(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// pbTime returns the given time as a Pinboard time.
+func pbTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// pbYesNo returns the Pinboard boolean of the given boolean.
+func pbYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// splitPinboardTags returns the tags of the given tags parameter,
+// separated by spaces or commas.
+func splitPinboardTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// pbPostOf returns the Pinboard post of the given bookmark.
+// The meta signature changes when the bookmark changes.
+func pbPostOf(bkm *types.Bookmark) pbPost {
+	tags := strings.Join(bkm.Tags, " ")
+	return pbPost{
+		Href:        bkm.URL,
+		Description: bkm.Title,
+		Extended:    bkm.Description,
+		Meta:        pbHash(fmt.Sprintf("%s\n%s\n%s\n%t\n%d", bkm.Title, bkm.Description, tags, bkm.Unread, bkm.UpdatedAt.Unix())),
+		Hash:        pbHash(bkm.URL),
+		Time:        pbTime(bkm.CreatedAt),
+		Shared:      "no",
+		ToRead:      pbYesNo(bkm.Unread),
+		Tags:        tags,
+	}
+}
+
+// pbWrite sends the given response, jsonV as JSON with the format=json
+// parameter and xmlV as XML otherwise.
+func pbWrite(w http.ResponseWriter, r *http.Request, functionName string, jsonV interface{}, xmlV interface{}) {
+	var err error
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(jsonV)
+	} else {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		if _, err = fmt.Fprint(w, xml.Header); err == nil {
+			err = xml.NewEncoder(w).Encode(xmlV)
+		}
+	}
+	if err != nil {
+		failHTTP(w, functionName, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// pbUser returns the user of the auth_token parameter, user:token.
+func pbUser(r *http.Request) string {
+	authToken := r.URL.Query().Get("auth_token")
+	if i := strings.Index(authToken, ":"); i != -1 {
+		return authToken[:i]
+	}
+	return "gobkm"
+}
+
+// pbPostsOf returns the posts of the given bookmarks and date.
+func pbPostsOf(r *http.Request, date string, bkms []*types.Bookmark) pbPosts {
+	posts := pbPosts{Date: date, User: pbUser(r), Posts: []pbPost{}}
+	for _, bkm := range bkms {
+		posts.Posts = append(posts.Posts, pbPostOf(bkm))
+	}
+	return posts
+}
+
+// pbFilterTags returns the tag parameter of the request, up to pbMaxTags tags,
+// or fails the request and returns false if there are too many of them.
+func pbFilterTags(w http.ResponseWriter, r *http.Request, functionName string) ([]string, bool) {
+	tags := splitPinboardTags(r.URL.Query().Get("tag"))
+	if len(tags) > pbMaxTags {
+		failHTTP(w, functionName, "at most 3 tags expected", http.StatusBadRequest)
+		return nil, false
+	}
+	return tags, true
+}
+
+// pbTaggedPosts returns the bookmarks with all the given tags, the last added first.
+func (env *Env) pbTaggedPosts(tags []string) []*types.Bookmark {
+	var bkms []*types.Bookmark
+	for _, bkm := range env.DB.QueryBookmarks(types.BookmarkQuery{Sort: types.SortCreated, Desc: true}) {
+		if matchBookmark(bkm, tags, nil, false) {
+			bkms = append(bkms, bkm)
+		}
+	}
+	return bkms
+}
+
+// PinboardHandler serves the Pinboard v1 API under pinboardAPIPath,
+// so that the Pinboard clients can use GoBkm: the posts/update, posts/add,
+// posts/delete, posts/get, posts/recent, posts/dates, posts/all, tags/get,
+// tags/delete, tags/rename and user/api_token methods.
+// The auth_token parameter must be the PinboardToken if it is set.
+func (env *Env) PinboardHandler(w http.ResponseWriter, r *http.Request) {
+	method := strings.Trim(strings.TrimPrefix(r.URL.Path, pinboardAPIPath), "/")
+	log.WithFields(log.Fields{
+		"method": method,
+	}).Debug("PinboardHandler")
+
+	// Token check.
+	if env.PinboardToken != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("auth_token")), []byte(env.PinboardToken)) != 1 {
+		failHTTP(w, "PinboardHandler", "API requires authentication", http.StatusUnauthorized)
+		return
+	}
+
+	methods := map[string]func(http.ResponseWriter, *http.Request){
+		"posts/update":   env.pbPostsUpdate,
+		"posts/add":      env.pbPostsAdd,
+		"posts/delete":   env.pbPostsDelete,
+		"posts/get":      env.pbPostsGet,
+		"posts/recent":   env.pbPostsRecent,
+		"posts/dates":    env.pbPostsDates,
+		"posts/all":      env.pbPostsAll,
+		"tags/get":       env.pbTagsGet,
+		"tags/delete":    env.pbTagsDelete,
+		"tags/rename":    env.pbTagsRename,
+		"user/api_token": env.pbUserAPIToken,
+	}
+	if handler, ok := methods[method]; ok {
+		handler(w, r)
+		return
+	}
+	failHTTP(w, "PinboardHandler", "no such method "+method, http.StatusNotFound)
+}
+
+// pbPostsUpdate returns the last update time of the bookmarks.
+func (env *Env) pbPostsUpdate(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// Getting the last updated bookmark.
+	bkms := env.DB.QueryBookmarks(types.BookmarkQuery{Sort: types.SortUpdated, Desc: true, Limit: 1})
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbPostsUpdate", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	update := pbUpdate{UpdateTime: pbTime(time.Unix(0, 0))}
+	if len(bkms) != 0 {
+		update.UpdateTime = pbTime(bkms[0].UpdatedAt)
+	}
+	pbWrite(w, r, "pbPostsUpdate", update, update)
+}
+
+// pbFindPosts returns the bookmarks of exactly the URL u, as for Pinboard,
+// not the ones of the same canonical URL.
+func (env *Env) pbFindPosts(u string) []*types.Bookmark {
+	var bkms []*types.Bookmark
+	for _, bkm := range env.DB.FindBookmarksByURL(u) {
+		if bkm.URL == u {
+			bkms = append(bkms, bkm)
+		}
+	}
+	return bkms
+}
+
+// pbPostsAdd adds a bookmark of the url parameter to the root folder,
+// with the optional description (title), extended (note), tags, dt (creation time)
+// and toread parameters. It replaces the bookmark of the same URL unless replace=no,
+// keeping its folder and creation time.
+func (env *Env) pbPostsAdd(w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		ok        bool
+		createdAt time.Time
+	)
+	// GET parameters retrieval.
+	params := r.URL.Query()
+	log.WithFields(log.Fields{
+		"url":         params.Get("url"),
+		"description": params.Get("description"),
+		"tags":        params.Get("tags"),
+		"dt":          params.Get("dt"),
+		"replace":     params.Get("replace"),
+		"toread":      params.Get("toread"),
+	}).Debug("pbPostsAdd:Query parameter")
+
+	// Parameters check.
+	if params.Get("url") == "" {
+		pbWrite(w, r, "pbPostsAdd", pbResult{Code: "missing url"}, pbResult{Code: "missing url"})
+		return
+	}
+	if dt := params.Get("dt"); dt != "" {
+		if createdAt, err = parseQueryDate(dt); err != nil {
+			failHTTP(w, "pbPostsAdd", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Getting the bookmark of the same URL.
+	bkms := env.pbFindPosts(params.Get("url"))
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbPostsAdd", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bkm := &types.Bookmark{CreatedAt: createdAt}
+	if len(bkms) != 0 {
+		if params.Get("replace") == "no" {
+			pbWrite(w, r, "pbPostsAdd", pbResult{Code: pbAlreadyExists}, pbResult{Code: pbAlreadyExists})
+			return
+		}
+		bkm = bkms[0]
+	}
+	oldURL := bkm.URL
+	bkm.URL = params.Get("url")
+	if bkm.Title = strings.TrimSpace(params.Get("description")); bkm.Title == "" {
+		bkm.Title = bkm.URL
+	}
+	bkm.Description = params.Get("extended")
+	if bkm.Tags, ok = normalizeTags(splitPinboardTags(params.Get("tags"))); !ok {
+		failHTTP(w, "pbPostsAdd", "tag with a comma", http.StatusBadRequest)
+		return
+	}
+	bkm.Unread = params.Get("toread") == "yes"
+
+	// Saving the bookmark into the DB.
+	if bkm.Id == 0 {
+		bkm.Id = int(env.DB.SaveBookmark(bkm))
+	} else {
+		env.DB.UpdateBookmark(bkm)
+	}
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbPostsAdd", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Updating the bookmark favicon.
+	if bkm.URL != oldURL {
+		goBackground(func() { env.UpdateBookmarkFavicon(bkm) })
+	}
+
+	pbWrite(w, r, "pbPostsAdd", pbResult{Code: pbDone}, pbResult{Code: pbDone})
+}
+
+// pbPostsDelete deletes the bookmarks of the url parameter.
+func (env *Env) pbPostsDelete(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// GET parameters retrieval.
+	u := r.URL.Query().Get("url")
+	log.WithFields(log.Fields{
+		"url": u,
+	}).Debug("pbPostsDelete:Query parameter")
+
+	// Getting the bookmarks of the URL.
+	bkms := env.pbFindPosts(u)
+	// Deleting them.
+	for _, bkm := range bkms {
+		env.DB.DeleteBookmark(bkm)
+	}
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbPostsDelete", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := pbResult{Code: pbDone}
+	if len(bkms) == 0 || u == "" {
+		result.Code = pbNotFound
+	}
+	pbWrite(w, r, "pbPostsDelete", result, result)
+}
+
+// pbPostsGet returns the bookmarks of the url parameter, or the bookmarks added
+// on the dt day, the last day with bookmarks by default, with the optional tag parameter.
+func (env *Env) pbPostsGet(w http.ResponseWriter, r *http.Request) {
+	var (
+		err  error
+		day  time.Time
+		bkms []*types.Bookmark
+	)
+	// GET parameters retrieval.
+	u := r.URL.Query().Get("url")
+	dt := r.URL.Query().Get("dt")
+	log.WithFields(log.Fields{
+		"url": u,
+		"dt":  dt,
+	}).Debug("pbPostsGet:Query parameter")
+
+	// Parameters check.
+	tags, ok := pbFilterTags(w, r, "pbPostsGet")
+	if !ok {
+		return
+	}
+	if dt != "" {
+		if day, err = parseQueryDate(dt); err != nil {
+			failHTTP(w, "pbPostsGet", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Getting the bookmarks.
+	if u != "" {
+		bkms = env.pbFindPosts(u)
+	} else {
+		for _, bkm := range env.pbTaggedPosts(tags) {
+			// The last day with bookmarks by default.
+			if day.IsZero() {
+				day = bkm.CreatedAt
+			}
+			if bkm.CreatedAt.UTC().Format("2006-01-02") == day.UTC().Format("2006-01-02") {
+				bkms = append(bkms, bkm)
+			}
+		}
+	}
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbPostsGet", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	date := ""
+	if len(bkms) != 0 {
+		date = pbTime(bkms[0].CreatedAt)
+	}
+	posts := pbPostsOf(r, date, bkms)
+	pbWrite(w, r, "pbPostsGet", posts, posts)
+}
+
+// pbPostsRecent returns the last added bookmarks, with the optional
+// tag and count (15 by default, up to 100) parameters.
+func (env *Env) pbPostsRecent(w http.ResponseWriter, r *http.Request) {
+	var err error
+	count := pbDefaultRecent
+	// Parameters check.
+	tags, ok := pbFilterTags(w, r, "pbPostsRecent")
+	if !ok {
+		return
+	}
+	if c := r.URL.Query().Get("count"); c != "" {
+		if count, err = strconv.Atoi(c); err != nil || count < 1 || count > pbMaxRecent {
+			failHTTP(w, "pbPostsRecent", "count between 1 and 100 expected", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Getting the bookmarks.
+	bkms := env.pbTaggedPosts(tags)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbPostsRecent", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(bkms) > count {
+		bkms = bkms[:count]
+	}
+
+	posts := pbPostsOf(r, pbTime(time.Now()), bkms)
+	pbWrite(w, r, "pbPostsRecent", posts, posts)
+}
+
+// pbPostsDates returns the number of bookmarks added each day,
+// with the optional tag parameter.
+func (env *Env) pbPostsDates(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// Parameters check.
+	tags, ok := pbFilterTags(w, r, "pbPostsDates")
+	if !ok {
+		return
+	}
+
+	// Getting the bookmarks.
+	bkms := env.pbTaggedPosts(tags)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbPostsDates", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Counting them by day, the last day first.
+	counts := make(map[string]int)
+	xmlDates := pbDates{User: pbUser(r), Tag: strings.Join(tags, " ")}
+	for _, bkm := range bkms {
+		day := bkm.CreatedAt.UTC().Format("2006-01-02")
+		if counts[day] == 0 {
+			xmlDates.Dates = append(xmlDates.Dates, pbDate{Date: day})
+		}
+		counts[day]++
+	}
+	jsonDates := make(map[string]string)
+	for i, d := range xmlDates.Dates {
+		xmlDates.Dates[i].Count = counts[d.Date]
+		jsonDates[d.Date] = strconv.Itoa(counts[d.Date])
+	}
+
+	pbWrite(w, r, "pbPostsDates", map[string]interface{}{"user": xmlDates.User, "tag": xmlDates.Tag, "dates": jsonDates}, xmlDates)
+}
+
+// pbPostsAll returns the bookmarks, the last added first, with the optional
+// tag, start (offset), results (number of bookmarks), fromdt and todt parameters.
+func (env *Env) pbPostsAll(w http.ResponseWriter, r *http.Request) {
+	var (
+		err          error
+		start        int
+		results      = -1
+		fromDt, toDt time.Time
+	)
+	// GET parameters retrieval.
+	params := r.URL.Query()
+	log.WithFields(log.Fields{
+		"start":   params.Get("start"),
+		"results": params.Get("results"),
+		"fromdt":  params.Get("fromdt"),
+		"todt":    params.Get("todt"),
+	}).Debug("pbPostsAll:Query parameter")
+
+	// Parameters check.
+	tags, ok := pbFilterTags(w, r, "pbPostsAll")
+	if !ok {
+		return
+	}
+	if s := params.Get("start"); s != "" {
+		if start, err = strconv.Atoi(s); err != nil || start < 0 {
+			failHTTP(w, "pbPostsAll", "invalid start", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := params.Get("results"); s != "" {
+		if results, err = strconv.Atoi(s); err != nil || results < 0 {
+			failHTTP(w, "pbPostsAll", "invalid results", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := params.Get("fromdt"); s != "" {
+		if fromDt, err = parseQueryDate(s); err != nil {
+			failHTTP(w, "pbPostsAll", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := params.Get("todt"); s != "" {
+		if toDt, err = parseQueryDate(s); err != nil {
+			failHTTP(w, "pbPostsAll", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Getting the bookmarks.
+	var bkms []*types.Bookmark
+	for _, bkm := range env.pbTaggedPosts(tags) {
+		if (fromDt.IsZero() || !bkm.CreatedAt.Before(fromDt)) && (toDt.IsZero() || !bkm.CreatedAt.After(toDt)) {
+			bkms = append(bkms, bkm)
+		}
+	}
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbPostsAll", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if start > len(bkms) {
+		start = len(bkms)
+	}
+	bkms = bkms[start:]
+	if results >= 0 && results < len(bkms) {
+		bkms = bkms[:results]
+	}
+
+	// A JSON array of posts.
+	posts := pbPostsOf(r, pbTime(time.Now()), bkms)
+	pbWrite(w, r, "pbPostsAll", posts.Posts, posts)
+}
+
+// pbTagsGet returns the tags with their number of bookmarks.
+func (env *Env) pbTagsGet(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// Getting the bookmarks.
+	bkms := env.DB.QueryBookmarks(types.BookmarkQuery{})
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "pbTagsGet", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Counting their tags.
+	counts := make(map[string]int)
+	for _, bkm := range bkms {
+		for _, t := range bkm.Tags {
+			counts[t]++
+		}
+	}
+	jsonTags := make(map[string]string)
+	var xmlTags pbTags
+	for t, c := range counts {
+		jsonTags[t] = strconv.Itoa(c)
+		xmlTags.Tags = append(xmlTags.Tags, pbTag{Count: c, Tag: t})
+	}
+	sort.Slice(xmlTags.Tags, func(i, j int) bool { return xmlTags.Tags[i].Tag < xmlTags.Tags[j].Tag })
+
+	pbWrite(w, r, "pbTagsGet", jsonTags, xmlTags)
+}
+
+// pbRetag replaces the tag oldTag of the bookmarks by the tag newTag,
+// the tag being removed if newTag is empty.
+func (env *Env) pbRetag(w http.ResponseWriter, r *http.Request, functionName string, oldTag string, newTag string) {
+	// Parameters check.
+	oldTags, okOld := normalizeTags([]string{oldTag})
+	newTags, okNew := normalizeTags([]string{newTag})
+	if !okOld || !okNew || len(oldTags) == 0 {
+		failHTTP(w, functionName, "invalid tag", http.StatusBadRequest)
+		return
+	}
+	newTag = ""
+	if len(newTags) != 0 {
+		newTag = newTags[0]
+	}
+
+	// Nothing to do if the tag is unchanged.
+	if newTag != oldTags[0] {
+		env.retagBookmarks(oldTags[0], newTag)
+		// Datastore error check.
+		if err := env.DB.FlushErrors(); err != nil {
+			failHTTP(w, functionName, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := pbTextResult{Result: pbDone}
+	pbWrite(w, r, functionName, result, result)
+}
+
+// pbTagsDelete removes the tag parameter from its bookmarks.
+func (env *Env) pbTagsDelete(w http.ResponseWriter, r *http.Request) {
+	// GET parameters retrieval.
+	tag := r.URL.Query().Get("tag")
+	log.WithFields(log.Fields{
+		"tag": tag,
+	}).Debug("pbTagsDelete:Query parameter")
+
+	env.pbRetag(w, r, "pbTagsDelete", tag, "")
+}
+
+// pbTagsRename renames the old tag parameter with the new parameter.
+func (env *Env) pbTagsRename(w http.ResponseWriter, r *http.Request) {
+	// GET parameters retrieval.
+	oldTag := r.URL.Query().Get("old")
+	newTag := r.URL.Query().Get("new")
+	log.WithFields(log.Fields{
+		"old": oldTag,
+		"new": newTag,
+	}).Debug("pbTagsRename:Query parameter")
+
+	// Parameters check.
+	if strings.TrimSpace(newTag) == "" {
+		failHTTP(w, "pbTagsRename", "new empty", http.StatusBadRequest)
+		return
+	}
+
+	env.pbRetag(w, r, "pbTagsRename", oldTag, newTag)
+}
+
+// pbUserAPIToken returns the token of the auth_token parameter.
+func (env *Env) pbUserAPIToken(w http.ResponseWriter, r *http.Request) {
+	result := pbTextResult{Result: strings.TrimPrefix(r.URL.Query().Get("auth_token"), pbUser(r)+":")}
+	pbWrite(w, r, "pbUserAPIToken", result, result)
+}
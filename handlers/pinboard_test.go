@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pbGet returns the JSON result of the Pinboard method with the given parameters,
+// or fails the test if the status is not 200.
+func pbGet(t *testing.T, env *Env, method string, params string, v interface{}) {
+	w := serve(env.PinboardHandler, "GET", pinboardAPIPath+method+"?format=json&"+params, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s %s: status %d", method, params, w.Code)
+	}
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: %v", method, params, err)
+	}
+}
+
+// pbPostsString returns the given posts as description(time,tags,toread) items.
+func pbPostsString(posts []pbPost) string {
+	var items []string
+	for _, p := range posts {
+		items = append(items, fmt.Sprintf("%s(%s,%s,%s)", p.Description, p.Time, p.Tags, p.ToRead))
+	}
+	return strings.Join(items, " ")
+}
+
+func TestPinboardAuth(t *testing.T) {
+	env := newTestEnv(t)
+	env.PinboardToken = "alice:TOKEN"
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/v1/posts/update", http.StatusUnauthorized},
+		{"/v1/posts/update?auth_token=alice:OTHER", http.StatusUnauthorized},
+		{"/v1/posts/update?auth_token=alice:TOKEN", http.StatusOK},
+		{"/v1/posts/unknown?auth_token=alice:TOKEN", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(env.PinboardHandler, "GET", tt.target, ""); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+		}
+	}
+
+	var token pbTextResult
+	pbGet(t, env, "user/api_token", "auth_token=alice:TOKEN", &token)
+	if token.Result != "TOKEN" {
+		t.Errorf("api token %q, want TOKEN", token.Result)
+	}
+	var update pbUpdate
+	pbGet(t, env, "posts/update", "auth_token=alice:TOKEN", &update)
+	if update.UpdateTime != "1970-01-01T00:00:00Z" {
+		t.Errorf("empty update time %s", update.UpdateTime)
+	}
+}
+
+func TestPinboardPosts(t *testing.T) {
+	env := newTestEnv(t)
+
+	// Adding the posts, the ones of exactly the same URL being replaced.
+	adds := []struct {
+		params string
+		status int
+		code   string
+	}{
+		{"url=https%3A%2F%2Fgolang.org%2F&description=Go&extended=fast&tags=Lang,go&dt=2020-01-02T10:00:00Z&toread=yes", http.StatusOK, pbDone},
+		{"url=https%3A%2F%2Fgolang.org%2F%3Futm_source%3Dfeed&description=Go+feed&dt=2020-01-03T10:00:00Z", http.StatusOK, pbDone},
+		{"url=https%3A%2F%2Frust-lang.org%2F&description=Rust&tags=lang+safe&dt=2020-01-03T12:00:00Z", http.StatusOK, pbDone},
+		{"url=https%3A%2F%2Fgolang.org%2F&description=Other&replace=no", http.StatusOK, pbAlreadyExists},
+		{"url=https%3A%2F%2Fgolang.org%2F&description=Golang&tags=lang", http.StatusOK, pbDone},
+		{"description=No+URL", http.StatusOK, "missing url"},
+		{"url=https%3A%2F%2Fexample.com%2F&dt=yesterday", http.StatusBadRequest, ""},
+	}
+	for _, tt := range adds {
+		w := serve(env.PinboardHandler, "GET", "/v1/posts/add?format=json&"+tt.params, "")
+		if w.Code != tt.status {
+			t.Errorf("add %s: status %d, want %d", tt.params, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var result pbResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Code != tt.code {
+			t.Errorf("add %s: result %q, want %q", tt.params, result.Code, tt.code)
+		}
+	}
+	background.Wait()
+
+	tests := []struct {
+		method, params string
+		posts          string
+	}{
+		{"posts/get", "url=https%3A%2F%2Fgolang.org%2F", "Golang(2020-01-02T10:00:00Z,lang,no)"},
+		{"posts/get", "", "Rust(2020-01-03T12:00:00Z,lang safe,no) Go feed(2020-01-03T10:00:00Z,,no)"},
+		{"posts/get", "dt=2020-01-02", "Golang(2020-01-02T10:00:00Z,lang,no)"},
+		{"posts/get", "tag=lang", "Rust(2020-01-03T12:00:00Z,lang safe,no)"},
+		{"posts/get", "dt=2020-01-01", ""},
+		{"posts/recent", "count=2", "Rust(2020-01-03T12:00:00Z,lang safe,no) Go feed(2020-01-03T10:00:00Z,,no)"},
+		{"posts/recent", "tag=lang", "Rust(2020-01-03T12:00:00Z,lang safe,no) Golang(2020-01-02T10:00:00Z,lang,no)"},
+		{"posts/recent", "tag=lang,safe", "Rust(2020-01-03T12:00:00Z,lang safe,no)"},
+	}
+	for _, tt := range tests {
+		var posts pbPosts
+		pbGet(t, env, tt.method, tt.params, &posts)
+		if got := pbPostsString(posts.Posts); got != tt.posts {
+			t.Errorf("%s %s: posts %s, want %s", tt.method, tt.params, got, tt.posts)
+		}
+	}
+
+	all := []struct {
+		params string
+		posts  string
+	}{
+		{"", "Rust Go feed Golang"},
+		{"start=1&results=1", "Go feed"},
+		{"start=5", ""},
+		{"fromdt=2020-01-03", "Rust Go feed"},
+		{"todt=2020-01-02T23:00:00Z", "Golang"},
+		{"tag=safe", "Rust"},
+	}
+	for _, tt := range all {
+		var posts []pbPost
+		pbGet(t, env, "posts/all", tt.params, &posts)
+		var titles []string
+		for _, p := range posts {
+			titles = append(titles, p.Description)
+		}
+		if got := strings.Join(titles, " "); got != tt.posts {
+			t.Errorf("posts/all %s: posts %s, want %s", tt.params, got, tt.posts)
+		}
+	}
+
+	var dates struct {
+		Dates map[string]string
+	}
+	pbGet(t, env, "posts/dates", "", &dates)
+	if want := map[string]string{"2020-01-03": "2", "2020-01-02": "1"}; !reflect.DeepEqual(dates.Dates, want) {
+		t.Errorf("posts/dates %v, want %v", dates.Dates, want)
+	}
+
+	for _, target := range []string{"/v1/posts/get?tag=a+b+c+d", "/v1/posts/get?dt=x", "/v1/posts/recent?count=101", "/v1/posts/all?start=-1", "/v1/posts/all?results=x", "/v1/posts/all?fromdt=x"} {
+		if w := serve(env.PinboardHandler, "GET", target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// XML by default.
+	w := serve(env.PinboardHandler, "GET", "/v1/posts/get?url=https%3A%2F%2Frust-lang.org%2F&auth_token=alice:TOKEN", "")
+	var posts pbPosts
+	if err := xml.Unmarshal(w.Body.Bytes(), &posts); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "text/xml; charset=utf-8" || posts.User != "alice" || len(posts.Posts) != 1 || posts.Posts[0].Hash != pbHash("https://rust-lang.org/") {
+		t.Errorf("XML posts %s %+v", w.Header().Get("Content-Type"), posts)
+	}
+
+	// Deleting the posts of exactly the URL.
+	deletes := []struct {
+		params string
+		code   string
+	}{
+		{"url=https%3A%2F%2Fgolang.org%2F", pbDone},
+		{"url=https%3A%2F%2Fgolang.org%2F", pbNotFound},
+		{"", pbNotFound},
+	}
+	for _, tt := range deletes {
+		var result pbResult
+		pbGet(t, env, "posts/delete", tt.params, &result)
+		if result.Code != tt.code {
+			t.Errorf("delete %s: result %q, want %q", tt.params, result.Code, tt.code)
+		}
+	}
+	var left []pbPost
+	pbGet(t, env, "posts/all", "", &left)
+	if got := pbPostsString(left); got != "Rust(2020-01-03T12:00:00Z,lang safe,no) Go feed(2020-01-03T10:00:00Z,,no)" {
+		t.Errorf("posts left %s", got)
+	}
+}
+
+func TestPinboardTags(t *testing.T) {
+	env := newTestEnv(t)
+	for _, params := range []string{"url=https%3A%2F%2Fgolang.org%2F&tags=lang+go", "url=https%3A%2F%2Frust-lang.org%2F&tags=lang"} {
+		serve(env.PinboardHandler, "GET", "/v1/posts/add?"+params, "")
+	}
+	background.Wait()
+
+	var tags map[string]string
+	pbGet(t, env, "tags/get", "", &tags)
+	if want := map[string]string{"go": "1", "lang": "2"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags %v, want %v", tags, want)
+	}
+	w := serve(env.PinboardHandler, "GET", "/v1/tags/get", "")
+	var xmlTags pbTags
+	if err := xml.Unmarshal(w.Body.Bytes(), &xmlTags); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(xmlTags.Tags); got != "[{1 go} {2 lang}]" {
+		t.Errorf("XML tags %s", got)
+	}
+
+	tests := []struct {
+		method, params string
+		status         int
+		tags           map[string]string
+	}{
+		{"tags/rename", "old=lang&new=Language", http.StatusOK, map[string]string{"go": "1", "language": "2"}},
+		{"tags/rename", "old=go&new=go", http.StatusOK, map[string]string{"go": "1", "language": "2"}},
+		{"tags/rename", "old=go&new=+", http.StatusBadRequest, map[string]string{"go": "1", "language": "2"}},
+		{"tags/rename", "old=&new=go", http.StatusBadRequest, map[string]string{"go": "1", "language": "2"}},
+		{"tags/delete", "tag=go", http.StatusOK, map[string]string{"language": "2"}},
+		{"tags/delete", "tag=", http.StatusBadRequest, map[string]string{"language": "2"}},
+	}
+	for _, tt := range tests {
+		if w := serve(env.PinboardHandler, "GET", pinboardAPIPath+tt.method+"?"+tt.params, ""); w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.params, w.Code, tt.status)
+		}
+		tags = nil
+		pbGet(t, env, "tags/get", "", &tags)
+		if !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("%s %s: tags %v, want %v", tt.method, tt.params, tags, tt.tags)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// queryDateLayouts are the accepted layouts of the date parameters.
+var queryDateLayouts = []string{"2006-01-02", time.RFC3339}
+
+// parseQueryDate parses a date parameter.
+func parseQueryDate(s string) (time.Time, error) {
+	for _, layout := range queryDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid date " + s + ", expecting YYYY-MM-DD or RFC 3339")
+}
+
+// bookmarkQueryFromRequest returns the bookmark query of the optional request parameters.
+func bookmarkQueryFromRequest(r *http.Request) (types.BookmarkQuery, error) {
+	return bookmarkQueryFromValues(r.URL.Query())
+}
+
+// bookmarkQueryFromValues returns the bookmark query of the optional parameters:
+// sort (title, created, updated, visited, visits, frecency or manual), order (asc or desc),
+// search, under (a folder id), tag, starred=true, unread=true, archived=true
+// and the createdAfter, createdBefore, updatedBefore and notVisitedSince dates.
+func bookmarkQueryFromValues(params url.Values) (types.BookmarkQuery, error) {
+	var (
+		q   types.BookmarkQuery
+		err error
+	)
+	log.WithFields(log.Fields{
+		"sort":            params["sort"],
+		"order":           params["order"],
+		"search":          params["search"],
+		"under":           params["under"],
+		"tag":             params["tag"],
+		"starred":         params["starred"],
+		"unread":          params["unread"],
+		"archived":        params["archived"],
+		"createdAfter":    params["createdAfter"],
+		"createdBefore":   params["createdBefore"],
+		"updatedBefore":   params["updatedBefore"],
+		"notVisitedSince": params["notVisitedSince"],
+	}).Debug("bookmarkQueryFromValues:Query parameter")
+
+	// Parameters check.
+	switch s := params.Get("sort"); s {
+	case "", types.SortTitle, types.SortCreated, types.SortUpdated, types.SortVisited, types.SortVisits, types.SortFrecency, types.SortManual:
+		q.Sort = s
+	default:
+		return q, errors.New("unknown sort " + s)
+	}
+	switch o := params.Get("order"); o {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, errors.New("unknown order " + o)
+	}
+	q.Search = params.Get("search")
+	if u := params.Get("under"); u != "" {
+		if q.Under, err = strconv.Atoi(u); err != nil {
+			return q, errors.New("under Atoi conversion")
+		}
+	}
+	q.Tag = strings.ToLower(strings.TrimSpace(params.Get("tag")))
+	q.Starred = params.Get("starred") == "true"
+	q.Unread = params.Get("unread") == "true"
+	q.Archived = params.Get("archived") == "true"
+
+	dates := []struct {
+		name string
+		t    *time.Time
+	}{
+		{"createdAfter", &q.CreatedAfter},
+		{"createdBefore", &q.CreatedBefore},
+		{"updatedBefore", &q.UpdatedBefore},
+		{"notVisitedSince", &q.NotVisitedSince},
+	}
+	for _, d := range dates {
+		if v := params.Get(d.name); v != "" {
+			if *d.t, err = parseQueryDate(v); err != nil {
+				return q, err
+			}
+		}
+	}
+	return q, nil
+}
+
+// sortFolders sorts the given folders according to the sort and order parameters of the bookmark query.
+// The folders are never visited, the visited, visits and frecency sorts sort them by title.
+func sortFolders(flds []*types.Folder, q types.BookmarkQuery) {
+	less := func(i, j int) bool {
+		switch q.Sort {
+		case types.SortManual:
+			return flds[i].Position < flds[j].Position
+		case types.SortCreated:
+			return flds[i].CreatedAt.Before(flds[j].CreatedAt)
+		case types.SortUpdated:
+			return flds[i].UpdatedAt.Before(flds[j].UpdatedAt)
+		default:
+			return flds[i].Title < flds[j].Title
+		}
+	}
+	if q.Desc {
+		sort.SliceStable(flds, func(i, j int) bool { return less(j, i) })
+	} else {
+		sort.SliceStable(flds, less)
+	}
+}
+
+// isFolderSortMode returns true if s is a folder sort mode.
+func isFolderSortMode(s string) bool {
+	switch s {
+	case "", types.SortManual, types.SortTitle, types.SortCreated, types.SortVisits, types.SortFrecency:
+		return true
+	}
+	return false
+}
+
+// folderSortQuery sorts the bookmark query by the sort mode of the given folder,
+// the most recent and most visited first.
+func folderSortQuery(q *types.BookmarkQuery, fld *types.Folder) {
+	q.SortByFolder(fld.SortMode)
+}
+
+// matchBookmark returns true if the given bookmark has all the given tags
+// and its title, URL or note contains all the given words, case insensitively.
+// With anyOf, one of the tags or words is enough.
+func matchBookmark(bkm *types.Bookmark, tags []string, words []string, anyOf bool) bool {
+	if len(tags) == 0 && len(words) == 0 {
+		return true
+	}
+	text := strings.ToLower(bkm.Title + "\n" + bkm.URL + "\n" + bkm.Description)
+	for _, t := range tags {
+		has := false
+		for _, bt := range bkm.Tags {
+			has = has || bt == strings.ToLower(t)
+		}
+		if has == anyOf {
+			return anyOf
+		}
+	}
+	for _, w := range words {
+		if strings.Contains(text, strings.ToLower(w)) == anyOf {
+			return anyOf
+		}
+	}
+	return !anyOf
+}
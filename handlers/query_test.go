@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+func TestBookmarkQueryFromValues(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    types.BookmarkQuery
+		wantErr bool
+	}{
+		{query: "", want: types.BookmarkQuery{}},
+		{query: "sort=created&order=desc", want: types.BookmarkQuery{Sort: types.SortCreated, Desc: true}},
+		{query: "sort=visited&order=asc", want: types.BookmarkQuery{Sort: types.SortVisited}},
+		{query: "search=go&under=2&tag=+Lang+&starred=true", want: types.BookmarkQuery{Search: "go", Under: 2, Tag: "lang", Starred: true}},
+		{
+			query: "createdAfter=2026-01-01&createdBefore=2026-02-01T10:00:00Z&updatedBefore=2026-03-01&notVisitedSince=2026-04-01",
+			want: types.BookmarkQuery{
+				CreatedAfter:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+				CreatedBefore:   time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC),
+				UpdatedBefore:   time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+				NotVisitedSince: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{query: "sort=size", wantErr: true},
+		{query: "order=random", wantErr: true},
+		{query: "under=x", wantErr: true},
+		{query: "createdAfter=01/01/2026", wantErr: true},
+	}
+	for _, tt := range tests {
+		params, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := bookmarkQueryFromValues(params)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: query = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// readingListQuery selects the reading list: the unread bookmarks, the last added first.
+var readingListQuery = types.BookmarkQuery{Unread: true, Sort: types.SortCreated, Desc: true}
+
+// bookmarkFromRequest returns the bookmark of the bookmarkId request parameter,
+// or nil after failing the request.
+func (env *Env) bookmarkFromRequest(w http.ResponseWriter, r *http.Request, functionName string) *types.Bookmark {
+	var (
+		err        error
+		bookmarkID int
+	)
+	// GET parameters retrieval.
+	bookmarkIDParam := r.URL.Query()["bookmarkId"]
+	log.WithFields(log.Fields{
+		"bookmarkIdParam": bookmarkIDParam,
+	}).Debug(functionName + ":Query parameter")
+
+	// Parameters check.
+	if len(bookmarkIDParam) == 0 {
+		failHTTP(w, functionName, "bookmarkId empty", http.StatusBadRequest)
+		return nil
+	}
+	// bookmarkId int convertion.
+	if bookmarkID, err = strconv.Atoi(bookmarkIDParam[0]); err != nil {
+		failHTTP(w, functionName, "bookmarkId Atoi conversion", http.StatusBadRequest)
+		return nil
+	}
+
+	// Getting the bookmark.
+	bkm := env.DB.GetBookmark(bookmarkID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err == sql.ErrNoRows {
+		failHTTP(w, functionName, "bookmark not found", http.StatusNotFound)
+		return nil
+	} else if err != nil {
+		failHTTP(w, functionName, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return bkm
+}
+
+// updateReadState saves the read state of the given bookmark and responds with it.
+func (env *Env) updateReadState(w http.ResponseWriter, functionName string, bkm *types.Bookmark) {
+	var err error
+
+	env.DB.UpdateBookmark(bkm)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, functionName, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(bkm); err != nil {
+		failHTTP(w, functionName, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetReadingListHandler returns the reading list bookmarks, the last added first.
+func (env *Env) GetReadingListHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Getting the bookmarks.
+	bkms := env.DB.QueryBookmarks(readingListQuery)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "GetReadingListHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(bkms); err != nil {
+		failHTTP(w, "GetReadingListHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ReadBookmarkHandler marks the given bookmark as read,
+// or as unread with the unread=true parameter, and returns it.
+func (env *Env) ReadBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	bkm := env.bookmarkFromRequest(w, r, "ReadBookmarkHandler")
+	if bkm == nil {
+		return
+	}
+	bkm.Unread = r.URL.Query().Get("unread") == "true"
+	if bkm.Unread {
+		// Back in the reading list.
+		bkm.Archived = false
+	}
+	env.updateReadState(w, "ReadBookmarkHandler", bkm)
+}
+
+// ArchiveBookmarkHandler archives the given bookmark, marking it as read,
+// or unarchives it with the archived=false parameter, and returns it.
+func (env *Env) ArchiveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	bkm := env.bookmarkFromRequest(w, r, "ArchiveBookmarkHandler")
+	if bkm == nil {
+		return
+	}
+	bkm.Archived = r.URL.Query().Get("archived") != "false"
+	if bkm.Archived {
+		bkm.Unread = false
+	}
+	env.updateReadState(w, "ArchiveBookmarkHandler", bkm)
+}
+
+// ReadingListFeedHandler returns the Atom feed of the reading list.
+func (env *Env) ReadingListFeedHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Getting the bookmarks.
+	bkms := env.DB.QueryBookmarks(readingListQuery)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "ReadingListFeedHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.serveAtomFeed(w, r, "ReadingListFeedHandler", "GoBkm reading list", env.baseURL()+"/readingList.atom", bkms)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// readingList returns the titles of the reading list of env.
+func readingList(t *testing.T, env *Env) []string {
+	var bkms []*types.Bookmark
+	w := serve(env.GetReadingListHandler, "GET", "/getReadingList/", "")
+	if err := json.NewDecoder(w.Body).Decode(&bkms); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, bkm := range bkms {
+		titles = append(titles, bkm.Title)
+	}
+	return titles
+}
+
+func TestReadingList(t *testing.T) {
+	env := newTestEnv(t)
+	now := time.Now()
+	for i, title := range []string{"First", "Second", "Third"} {
+		env.DB.SaveBookmark(&types.Bookmark{Title: title, URL: "https://example.com/" + title, Unread: true, CreatedAt: now.Add(time.Duration(i) * time.Hour)})
+	}
+	saveBookmark(t, env, "Read", "https://example.com/read", nil)
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readingList(t, env), []string{"Third", "Second", "First"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reading list %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		handler  http.HandlerFunc
+		target   string
+		status   int
+		unread   bool // the bookmark states after the request
+		archived bool
+		list     []string
+	}{
+		{env.ReadBookmarkHandler, "/readBookmark/?bookmarkId=3", http.StatusOK, false, false, []string{"Second", "First"}},
+		{env.ReadBookmarkHandler, "/readBookmark/?bookmarkId=3&unread=true", http.StatusOK, true, false, []string{"Third", "Second", "First"}},
+		{env.ArchiveBookmarkHandler, "/archiveBookmark/?bookmarkId=3", http.StatusOK, false, true, []string{"Second", "First"}},
+		{env.ArchiveBookmarkHandler, "/archiveBookmark/?bookmarkId=3&archived=false", http.StatusOK, false, false, []string{"Second", "First"}},
+		{env.ArchiveBookmarkHandler, "/archiveBookmark/?bookmarkId=3", http.StatusOK, false, true, []string{"Second", "First"}},
+		{env.ReadBookmarkHandler, "/readBookmark/?bookmarkId=3&unread=true", http.StatusOK, true, false, []string{"Third", "Second", "First"}},
+		{env.ReadBookmarkHandler, "/readBookmark/?bookmarkId=9", http.StatusNotFound, true, false, []string{"Third", "Second", "First"}},
+		{env.ArchiveBookmarkHandler, "/archiveBookmark/", http.StatusBadRequest, true, false, []string{"Third", "Second", "First"}},
+	}
+	for _, tt := range tests {
+		w := serve(tt.handler, "GET", tt.target, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			// The handlers respond with the updated bookmark.
+			var bkm types.Bookmark
+			if err := json.NewDecoder(w.Body).Decode(&bkm); err != nil {
+				t.Fatal(err)
+			}
+			if bkm.Id != 3 || bkm.Unread != tt.unread || bkm.Archived != tt.archived {
+				t.Errorf("%s: responded bookmark %d unread %t archived %t", tt.target, bkm.Id, bkm.Unread, bkm.Archived)
+			}
+		}
+		if bkm := env.DB.GetBookmark(3); bkm.Unread != tt.unread || bkm.Archived != tt.archived {
+			t.Errorf("%s: bookmark unread %t archived %t, want %t and %t", tt.target, bkm.Unread, bkm.Archived, tt.unread, tt.archived)
+		}
+		if got := readingList(t, env); !reflect.DeepEqual(got, tt.list) {
+			t.Errorf("%s: reading list %v, want %v", tt.target, got, tt.list)
+		}
+	}
+
+	// The feed has the reading list entries.
+	w := serve(env.ReadingListFeedHandler, "GET", "/readingList.atom", "")
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.ID != "http://gobkm.test/readingList.atom" || len(feed.Entries) != 3 || feed.Entries[0].Title != "Third" {
+		t.Errorf("reading list feed %s with %d entries", feed.ID, len(feed.Entries))
+	}
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// shareTokenSize is the number of random bytes of the share tokens.
+const shareTokenSize = 18
+
+// shareFormats are the export formats of the shares downloads.
+var shareFormats = []string{exportFormatHTML, exportFormatJSON}
+
+// shareDataStruct is used to pass the shared tree to the share template.
+type shareDataStruct struct {
+	Token     string
+	Tree      *exportBookmarksStruct
+	ExpiresAt time.Time
+}
+
+// shareFuncs are the share template functions.
+var shareFuncs = template.FuncMap{
+	// description renders a bookmark description as sanitized HTML.
+	"description": func(description string) template.HTML {
+		return template.HTML(renderDescription(description))
+	},
+	// favicon returns a bookmark favicon, a base64 encoded image, as an URL.
+	"favicon": func(favicon string) template.URL {
+		if !strings.HasPrefix(favicon, "data:image/") {
+			return ""
+		}
+		return template.URL(favicon)
+	},
+}
+
+// newShareToken returns a new unguessable share token.
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pruneShareTree removes from the given tree the bookmarks outside of the folder id
+// and its subfolders, selected by the smart folders queries.
+func pruneShareTree(eb *exportBookmarksStruct, id int) {
+	// The bookmarks of the other folders are in the tree folder.
+	if eb.Fld.Query != "" {
+		var bkms []*types.Bookmark
+		for _, bkm := range eb.Bkms {
+			// The folders of the smart folders bookmarks are retrieved,
+			// a bookmark without folder is not known to be inside.
+			if bkm.Folder != nil && isSubfolder(bkm.Folder, id) {
+				bkms = append(bkms, bkm)
+			}
+		}
+		eb.Bkms = bkms
+	}
+	for _, sub := range eb.Sub {
+		pruneShareTree(sub, id)
+	}
+}
+
+// buildShareTree returns the bookmarks and folders tree of the given share,
+// without the folders above the shared folder.
+func (env *Env) buildShareTree(sh *types.Share) *exportBookmarksStruct {
+	fld := *sh.Folder
+	fld.Parent = nil
+	eb := env.buildExportTree(&fld)
+	pruneShareTree(eb, fld.Id)
+	return eb
+}
+
+// AddShareHandler creates a share link for the given folder and its subfolders,
+// expiring at the optional expiresAt date, and returns the share.
+func (env *Env) AddShareHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err      error
+		folderID int
+		sh       types.Share
+	)
+	// GET parameters retrieval.
+	folderIDParam := r.URL.Query()["folderId"]
+	expiresAtParam := r.URL.Query()["expiresAt"]
+	log.WithFields(log.Fields{
+		"folderIdParam":  folderIDParam,
+		"expiresAtParam": expiresAtParam,
+	}).Debug("AddShareHandler:Query parameter")
+
+	// Parameters check.
+	if len(folderIDParam) == 0 {
+		failHTTP(w, "AddShareHandler", "folderId empty", http.StatusBadRequest)
+		return
+	}
+	// folderId int convertion.
+	if folderID, err = strconv.Atoi(folderIDParam[0]); err != nil {
+		failHTTP(w, "AddShareHandler", "folderId Atoi conversion", http.StatusBadRequest)
+		return
+	}
+	if len(expiresAtParam) != 0 && expiresAtParam[0] != "" {
+		if sh.ExpiresAt, err = parseQueryDate(expiresAtParam[0]); err != nil {
+			failHTTP(w, "AddShareHandler", err.Error(), http.StatusBadRequest)
+			return
+		}
+		if sh.ExpiresAt.Before(time.Now()) {
+			failHTTP(w, "AddShareHandler", "expiresAt in the past", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Getting the folder.
+	if sh.Folder = env.DB.GetFolder(folderID); sh.Folder == nil {
+		if err = env.DB.FlushErrors(); err != nil {
+			failHTTP(w, "AddShareHandler", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		failHTTP(w, "AddShareHandler", "folder not found", http.StatusNotFound)
+		return
+	}
+	// Smart folders select bookmarks outside of their subtree.
+	if sh.Folder.Query != "" {
+		failHTTP(w, "AddShareHandler", "smart folders can not be shared", http.StatusBadRequest)
+		return
+	}
+	// Saving the share.
+	if sh.Token, err = newShareToken(); err != nil {
+		failHTTP(w, "AddShareHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	env.DB.SaveShare(&sh)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "AddShareHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(sh); err != nil {
+		failHTTP(w, "AddShareHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetSharesHandler returns the shares not expired, the last created first.
+func (env *Env) GetSharesHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Getting the shares.
+	shs := env.DB.GetShares()
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "GetSharesHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(shs); err != nil {
+		failHTTP(w, "GetSharesHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DeleteShareHandler revokes the share of the given token.
+func (env *Env) DeleteShareHandler(w http.ResponseWriter, r *http.Request) {
+	// GET parameters retrieval.
+	tokenParam := r.URL.Query()["token"]
+	log.WithFields(log.Fields{
+		"tokenParam": tokenParam,
+	}).Debug("DeleteShareHandler:Query parameter")
+
+	// Parameters check.
+	if len(tokenParam) == 0 || tokenParam[0] == "" {
+		failHTTP(w, "DeleteShareHandler", "token empty", http.StatusBadRequest)
+		return
+	}
+
+	env.DB.DeleteShare(tokenParam[0])
+	// Datastore error check.
+	if err := env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "DeleteShareHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ShareHandler serves the read-only page of the shared folder /share/{token},
+// or downloads its bookmarks with the format parameter, html (Netscape) or json.
+// Only the shared folder and its subfolders are reachable.
+func (env *Env) ShareHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// Path and GET parameters retrieval.
+	token := strings.TrimPrefix(r.URL.Path, "/share/")
+	format := r.URL.Query().Get("format")
+	log.WithFields(log.Fields{
+		"format": format,
+	}).Debug("ShareHandler:Query parameter")
+
+	// Getting the share.
+	sh := env.DB.GetShare(token)
+	// Datastore error check, the shared folder may be deleted.
+	if err = env.DB.FlushErrors(); err != nil && err != sql.ErrNoRows {
+		failHTTP(w, "ShareHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The same answer for unknown, revoked, expired and deleted shares.
+	if err == sql.ErrNoRows || sh == nil || sh.Folder == nil {
+		failHTTP(w, "ShareHandler", "share not found", http.StatusNotFound)
+		return
+	}
+	if format != "" {
+		found := false
+		for _, f := range shareFormats {
+			found = found || f == format
+		}
+		if !found {
+			failHTTP(w, "ShareHandler", "unknown share format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Building the shared tree.
+	eb := env.buildShareTree(sh)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "ShareHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Downloading it.
+	if format != "" {
+		f := exportFormats[format]
+		w.Header().Set("Content-Disposition", "attachment; filename=gobkm-share."+f.extension)
+		w.Header().Set("Content-Type", f.contentType)
+		if err = f.write(w, eb); err != nil {
+			// Just logging the error, the response is already started.
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("ShareHandler")
+		}
+		return
+	}
+
+	// or rendering it.
+	htmlTpl := template.New("share").Funcs(shareFuncs)
+	if htmlTpl, err = htmlTpl.Parse(env.TplShareData); err != nil {
+		failHTTP(w, "ShareHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = htmlTpl.Execute(w, shareDataStruct{Token: sh.Token, Tree: eb, ExpiresAt: sh.ExpiresAt}); err != nil {
+		failHTTP(w, "ShareHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+func TestShareDeletedFolder(t *testing.T) {
+	env := newTestEnv(t)
+	fld := saveFolder(t, env, "shared", nil)
+	env.DB.SaveShare(&types.Share{Token: "token", Folder: fld})
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(env.ShareHandler, http.MethodGet, "/share/token?format=json", ""); w.Code != http.StatusOK {
+		t.Fatalf("share answered %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := serve(env.DeleteFolderHandler, http.MethodGet, "/deleteFolder/?folderId="+strconv.Itoa(fld.Id), ""); w.Code != http.StatusOK {
+		t.Fatalf("deleteFolder answered %d: %s", w.Code, w.Body)
+	}
+	// The new folder may get the id of the deleted one.
+	other := saveFolder(t, env, "private", nil)
+	w := serve(env.ShareHandler, http.MethodGet, "/share/token?format=json", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("share of the deleted folder %d, new folder %d: answered %d, want %d", fld.Id, other.Id, w.Code, http.StatusNotFound)
+	}
+	if strings.Contains(w.Body.String(), "private") {
+		t.Errorf("share of the deleted folder publishes the new folder")
+	}
+}
+
+func TestShareTree(t *testing.T) {
+	env := newTestEnv(t)
+	fld := saveFolder(t, env, "shared", nil)
+	sub := saveFolder(t, env, "sub", fld)
+	smart := &types.Folder{Title: "smart", Parent: fld, Query: "search=secret"}
+	env.DB.SaveFolder(smart)
+	saveBookmark(t, env, "inside", "https://inside.example.com/", sub)
+	saveBookmark(t, env, "secret outside", "https://outside.example.com/", nil)
+	saveBookmark(t, env, "secret inside", "https://secret.example.com/", sub)
+	env.DB.SaveShare(&types.Share{Token: "token", Folder: fld})
+	if err := env.DB.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		code   int
+		want   []string
+		absent []string
+	}{
+		{target: "/share/token?format=json", code: http.StatusOK, want: []string{"inside.example.com", "secret.example.com"}, absent: []string{"outside.example.com"}},
+		{target: "/share/unknown?format=json", code: http.StatusNotFound},
+		{target: "/share/token?format=unknown", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := serve(env.ShareHandler, http.MethodGet, tt.target, "")
+		if w.Code != tt.code {
+			t.Errorf("%s: answered %d, want %d", tt.target, w.Code, tt.code)
+			continue
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: %s not shared", tt.target, s)
+			}
+		}
+		for _, s := range tt.absent {
+			if strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: %s shared", tt.target, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// wsCloseTimeout is the delay to send the websocket close message on shutdown.
+	wsCloseTimeout = time.Second
+	// shutdownGrace is the delay for the cancelled jobs to stop on shutdown.
+	shutdownGrace = 5 * time.Second
+)
+
+// errShutdownGrace is the error of the jobs still running after shutdownGrace.
+var errShutdownGrace = errors.New("background jobs still running after cancellation")
+
+var (
+	// background counts the running background jobs, awaited by Shutdown:
+	// favicon fetchings, imports and backups.
+	background      sync.WaitGroup
+	backgroundMutex sync.Mutex
+	// shutdown is closed by Shutdown, no background job starting after.
+	shutdown = make(chan struct{})
+)
+
+// startBackground registers a new background job, that must call background.Done
+// when over, and returns false when shutting down.
+func startBackground() bool {
+	backgroundMutex.Lock()
+	defer backgroundMutex.Unlock()
+
+	select {
+	case <-shutdown:
+		return false
+	default:
+	}
+	background.Add(1)
+	return true
+}
+
+// goBackground runs f in a background job, f being skipped when shutting down.
+func goBackground(f func()) {
+	if !startBackground() {
+		log.Debug("goBackground:shutting down, job skipped")
+		return
+	}
+	go func() {
+		defer background.Done()
+		f()
+	}()
+}
+
+// cancelImportJobs cancels the running import jobs.
+func cancelImportJobs() {
+	importJobsMutex.Lock()
+	defer importJobsMutex.Unlock()
+
+	for _, j := range importJobs {
+		if j.state().Status == types.ImportJobRunning {
+			j.cancel()
+		}
+	}
+}
+
+// closeWebsocket closes the websocket connection, telling the client
+// the server is going away.
+func closeWebsocket() {
+	wsmutex.Lock()
+	defer wsmutex.Unlock()
+
+	if wsconn == nil {
+		return
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+	if err := wsconn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsCloseTimeout)); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Debug("closeWebsocket:WriteControl")
+	}
+	wsconn.Close()
+	wsconn = nil
+}
+
+// Shutdown stops the background jobs and closes the websocket connection.
+// The new jobs are refused and the running ones awaited until ctx is done.
+// The import jobs are then cancelled and the jobs awaited again, at most shutdownGrace.
+// The error is ctx.Err() if the jobs are over only once the imports cancelled,
+// errShutdownGrace if they are still running.
+func Shutdown(ctx context.Context) error {
+	backgroundMutex.Lock()
+	close(shutdown)
+	backgroundMutex.Unlock()
+	defer closeWebsocket()
+
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Stopping the imports, a cancelled import stopping at its next item.
+	cancelImportJobs()
+	timer := time.NewTimer(shutdownGrace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return ctx.Err()
+	case <-timer.C:
+		return errShutdownGrace
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// restartAfterTest reopens the background jobs once the test shut them down.
+func restartAfterTest(t *testing.T) {
+	t.Cleanup(func() { shutdown = make(chan struct{}) })
+}
+
+func TestShutdown(t *testing.T) {
+	restartAfterTest(t)
+	env := newTestEnv(t)
+
+	// A websocket client and a running job.
+	server := httptest.NewServer(http.HandlerFunc(env.SocketHandler))
+	defer server.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	release := make(chan struct{})
+	goBackground(func() { <-release })
+
+	result := make(chan error)
+	go func() { result <- Shutdown(context.Background()) }()
+	<-shutdown
+
+	// The new jobs are refused, the running one awaited.
+	if startBackground() {
+		background.Done()
+		t.Error("job started while shutting down")
+	}
+	skipped := true
+	goBackground(func() { skipped = false })
+	select {
+	case err = <-result:
+		t.Fatalf("Shutdown before the job is over: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err = <-result; err != nil {
+		t.Errorf("Shutdown error %v", err)
+	}
+	if !skipped {
+		t.Error("job run while shutting down")
+	}
+
+	// The client told the server is going away.
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err = client.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("websocket read error %v, want going away", err)
+	}
+}
+
+func TestShutdownCancelsImports(t *testing.T) {
+	restartAfterTest(t)
+
+	// An import stopping only once cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	job := newImportJob(cancel)
+	defer func() {
+		importJobsMutex.Lock()
+		delete(importJobs, job.job.Id)
+		importJobsMutex.Unlock()
+	}()
+	stopped := false
+	goBackground(func() {
+		<-ctx.Done()
+		stopped = true
+	})
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), 0)
+	defer cancelExpired()
+	if err := Shutdown(expired); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !stopped {
+		t.Error("import not awaited")
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// parseSmartQuery returns the given smart folder query without its leading ?,
+// or an error if it is empty or not a valid bookmark query.
+func parseSmartQuery(query string) (string, error) {
+	query = strings.TrimPrefix(strings.TrimSpace(query), "?")
+	if query == "" {
+		return "", errors.New("query empty")
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", err
+	}
+	if _, err = bookmarkQueryFromValues(params); err != nil {
+		return "", err
+	}
+	return query, nil
+}
+
+// smartFolderQuery returns the bookmark query of the given smart folder,
+// sorted by the folder sort mode if the query has no sort parameter.
+func smartFolderQuery(fld *types.Folder) (types.BookmarkQuery, error) {
+	params, err := url.ParseQuery(fld.Query)
+	if err != nil {
+		return types.BookmarkQuery{}, err
+	}
+	q, err := bookmarkQueryFromValues(params)
+	if err != nil {
+		return q, err
+	}
+	if q.Sort == "" {
+		folderSortQuery(&q, fld)
+	}
+	return q, nil
+}
+
+// readOnlyFolder fails the request and returns true if the folder id
+// is not found or is a smart folder, nothing being moved into smart folders.
+// The 0 id, the root folder, is never read-only.
+func (env *Env) readOnlyFolder(w http.ResponseWriter, functionName string, id int) bool {
+	if id == 0 {
+		return false
+	}
+	fld := env.DB.GetFolder(id)
+	// Datastore error check.
+	if err := env.DB.FlushErrors(); err == sql.ErrNoRows {
+		failHTTP(w, functionName, "folder not found", http.StatusNotFound)
+		return true
+	} else if err != nil {
+		failHTTP(w, functionName, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if fld.Query != "" {
+		failHTTP(w, functionName, "smart folders are read-only", http.StatusBadRequest)
+		return true
+	}
+	return false
+}
+
+// AddSmartFolderHandler creates a smart folder in the root folder.
+// Its bookmarks are the bookmarks selected by the query parameter,
+// bookmark query parameters such as search=golang&starred=true,
+// evaluated each time the folder is opened.
+func (env *Env) AddSmartFolderHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err   error
+		query string
+	)
+	// GET parameters retrieval.
+	folderName := r.URL.Query()["folderName"]
+	queryParam := r.URL.Query()["query"]
+	log.WithFields(log.Fields{
+		"folderName": folderName,
+		"queryParam": queryParam,
+	}).Debug("AddSmartFolderHandler:Query parameter")
+
+	// Parameters check.
+	if len(folderName) == 0 || folderName[0] == "" {
+		failHTTP(w, "AddSmartFolderHandler", "folderName empty", http.StatusBadRequest)
+		return
+	}
+	if len(queryParam) == 0 {
+		failHTTP(w, "AddSmartFolderHandler", "query empty", http.StatusBadRequest)
+		return
+	}
+	if query, err = parseSmartQuery(queryParam[0]); err != nil {
+		failHTTP(w, "AddSmartFolderHandler", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Getting the root folder.
+	rootFolder := env.DB.GetFolder(1)
+	// Creating the smart folder.
+	newFolder := types.Folder{Title: folderName[0], Parent: rootFolder, Query: query}
+	// Saving the folder into the DB, getting its id.
+	newFolder.Id = int(env.DB.SaveFolder(&newFolder))
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "AddSmartFolderHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(types.Folder{Id: newFolder.Id, Title: newFolder.Title, Query: newFolder.Query}); err != nil {
+		failHTTP(w, "AddSmartFolderHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// UpdateSmartFolderHandler changes the query of the given smart folder.
+func (env *Env) UpdateSmartFolderHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		err      error
+		folderID int
+		query    string
+	)
+	// GET parameters retrieval.
+	folderIDParam := r.URL.Query()["folderId"]
+	queryParam := r.URL.Query()["query"]
+	log.WithFields(log.Fields{
+		"folderIdParam": folderIDParam,
+		"queryParam":    queryParam,
+	}).Debug("UpdateSmartFolderHandler:Query parameter")
+
+	// Parameters check.
+	if len(folderIDParam) == 0 || len(queryParam) == 0 {
+		failHTTP(w, "UpdateSmartFolderHandler", "folderId or query empty", http.StatusBadRequest)
+		return
+	}
+	// folderId int convertion.
+	if folderID, err = strconv.Atoi(folderIDParam[0]); err != nil {
+		failHTTP(w, "UpdateSmartFolderHandler", "folderId Atoi conversion", http.StatusBadRequest)
+		return
+	}
+	if query, err = parseSmartQuery(queryParam[0]); err != nil {
+		failHTTP(w, "UpdateSmartFolderHandler", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Getting the folder.
+	fld := env.DB.GetFolder(folderID)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err == sql.ErrNoRows {
+		failHTTP(w, "UpdateSmartFolderHandler", "folder not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		failHTTP(w, "UpdateSmartFolderHandler", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fld.Query == "" {
+		failHTTP(w, "UpdateSmartFolderHandler", "not a smart folder", http.StatusBadRequest)
+		return
+	}
+
+	// Updating it.
+	fld.Query = query
+	env.DB.UpdateFolder(fld)
+	// Datastore error check.
+	if err = env.DB.FlushErrors(); err != nil {
+		failHTTP(w, "UpdateSmartFolderHandler", err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+func TestParseSmartQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "?search=go&starred=true", want: "search=go&starred=true"},
+		{query: " tag=lang ", want: "tag=lang"},
+		{query: "?", wantErr: true},
+		{query: "sort=size", wantErr: true},
+		{query: "createdAfter=yesterday", wantErr: true},
+		{query: "search=%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSmartQuery(tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSmartQuery(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseSmartQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// folderBookmarks returns the titles of the bookmarks of the folder id
+// with the given extra parameters.
+func folderBookmarks(t *testing.T, env *Env, id int, params string) []string {
+	w := serve(env.GetFolderBookmarksHandler, "GET", "/getFolderBookmarks/?folderId="+strconv.Itoa(id)+params, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("folder %d bookmarks status %d", id, w.Code)
+	}
+	var bkms []*types.Bookmark
+	if err := json.NewDecoder(w.Body).Decode(&bkms); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, bkm := range bkms {
+		titles = append(titles, bkm.Title)
+	}
+	return titles
+}
+
+func TestSmartFolders(t *testing.T) {
+	env := newTestEnv(t)
+	it := saveFolder(t, env, "IT", nil)
+	saveBookmark(t, env, "Go blog", "https://blog.golang.org/", it)
+	saveBookmark(t, env, "Go tour", "https://tour.golang.org/", nil)
+	saveBookmark(t, env, "Rust book", "https://doc.rust-lang.org/book/", it)
+
+	// Creating the smart folder.
+	w := serve(env.AddSmartFolderHandler, "GET", "/addSmartFolder/?folderName=Go&query="+"%3Fsearch%3Dgo", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("add smart folder status %d", w.Code)
+	}
+	var smart types.Folder
+	if err := json.NewDecoder(w.Body).Decode(&smart); err != nil {
+		t.Fatal(err)
+	}
+	if smart.Query != "search=go" {
+		t.Errorf("smart folder query %q, want %q", smart.Query, "search=go")
+	}
+	for _, target := range []string{"/addSmartFolder/?query=search%3Dgo", "/addSmartFolder/?folderName=Go", "/addSmartFolder/?folderName=Go&query=sort%3Dsize"} {
+		if w = serve(env.AddSmartFolderHandler, "GET", target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// Its bookmarks are evaluated at each request, in any folder.
+	if got, want := folderBookmarks(t, env, smart.Id, ""), []string{"Go blog", "Go tour"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("smart folder bookmarks %v, want %v", got, want)
+	}
+	saveBookmark(t, env, "Go spec", "https://golang.org/ref/spec", it)
+	if got, want := folderBookmarks(t, env, smart.Id, "&sort=title&order=desc"), []string{"Go tour", "Go spec", "Go blog"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted smart folder bookmarks %v, want %v", got, want)
+	}
+
+	// Updating its query.
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/updateSmartFolder/?folderId=" + strconv.Itoa(smart.Id) + "&query=search%3Drust", http.StatusOK},
+		{"/updateSmartFolder/?folderId=" + strconv.Itoa(it.Id) + "&query=search%3Drust", http.StatusBadRequest},
+		{"/updateSmartFolder/?folderId=99&query=search%3Drust", http.StatusNotFound},
+		{"/updateSmartFolder/?folderId=" + strconv.Itoa(smart.Id) + "&query=", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w = serve(env.UpdateSmartFolderHandler, "GET", tt.target, ""); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+		}
+	}
+	if got, want := folderBookmarks(t, env, smart.Id, ""), []string{"Rust book"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("updated smart folder bookmarks %v, want %v", got, want)
+	}
+
+	// Nothing is added or moved into it.
+	smartID := strconv.Itoa(smart.Id)
+	readOnly := []struct {
+		h      http.HandlerFunc
+		target string
+	}{
+		{env.AddBookmarkHandler, "/addBookmark/?bookmarkUrl=https%3A%2F%2Fexample.com%2F&destinationFolderId=" + smartID},
+		{env.MoveBookmarkHandler, "/moveBookmark/?bookmarkId=1&destinationFolderId=" + smartID},
+		{env.MoveFolderHandler, "/moveFolder/?sourceFolderId=" + strconv.Itoa(it.Id) + "&destinationFolderId=" + smartID},
+	}
+	for _, tt := range readOnly {
+		if w = serve(tt.h, "GET", tt.target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, http.StatusBadRequest)
+		}
+	}
+	if got, want := folderBookmarks(t, env, it.Id, ""), []string{"Go blog", "Go spec", "Rust book"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IT folder bookmarks %v, want %v", got, want)
+	}
+
+	// The missing folders are not found.
+	if w = serve(env.GetFolderBookmarksHandler, "GET", "/getFolderBookmarks/?folderId=99", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing folder bookmarks status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
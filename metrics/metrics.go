@@ -0,0 +1,280 @@
+// Package metrics provides the counters, gauges and histograms of the application,
+// exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets of the durations, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// labelEscaper escapes the label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var (
+	// registry are the exposed metrics, in registration order.
+	registry      []metric
+	registryMutex sync.Mutex
+)
+
+// metric is a registered metric.
+type metric interface {
+	write(w io.Writer)
+}
+
+// register adds m to the exposed metrics.
+func register(m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registry = append(registry, m)
+}
+
+// desc is the description of a metric.
+type desc struct {
+	name   string
+	help   string
+	kind   string // counter, gauge or histogram
+	labels []string
+}
+
+// header writes the HELP and TYPE lines of the metric.
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+// labelPairs returns the {name="value",...} labels of a sample,
+// the extra name and value pairs being added last.
+func labelPairs(names []string, values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a sample value.
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// seriesKey returns the key of the label values, checking their number.
+func (d *desc) seriesKey(values []string) string {
+	if len(values) != len(d.labels) {
+		panic("metrics: " + d.name + ": wrong number of label values")
+	}
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	desc
+	mutex  sync.Mutex
+	series map[string]*counter
+}
+
+// counter is the value of a CounterVec for some label values.
+type counter struct {
+	values []string
+	value  float64
+}
+
+// NewCounterVec registers and returns a counter with the given labels.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, kind: "counter", labels: labels}, series: make(map[string]*counter)}
+	register(c)
+	return c
+}
+
+// Add adds v to the counter of the given label values.
+func (c *CounterVec) Add(v float64, values ...string) {
+	key := c.seriesKey(values)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counter{values: values}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Inc increments the counter of the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var keys []string
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	c.header(w)
+	for _, key := range keys {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelPairs(c.labels, s.values), formatFloat(s.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogram
+}
+
+// histogram is the distribution of a HistogramVec for some label values.
+type histogram struct {
+	values []string
+	counts []uint64 // by bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers and returns a histogram of the given increasing
+// bucket upper bounds, with the given labels.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{desc: desc{name: name, help: help, kind: "histogram", labels: labels}, buckets: buckets, series: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+// Observe adds the value v to the histogram of the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.seriesKey(values)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var keys []string
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h.header(w)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, s.values, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, s.values), s.count)
+	}
+}
+
+// GaugeFunc is a gauge computed when exposed.
+type GaugeFunc struct {
+	desc
+	f func() (float64, error)
+}
+
+// NewGaugeFunc registers a gauge of the value returned by f,
+// not exposed when f fails.
+func NewGaugeFunc(name string, help string, f func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, kind: "gauge"}, f: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	v, err := g.f()
+	if err != nil {
+		return
+	}
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(v))
+}
+
+// GaugeFuncs are gauges computed together when exposed.
+type GaugeFuncs struct {
+	descs []desc
+	f     func() ([]float64, error)
+}
+
+// NewGaugeFuncs registers the gauges of the given names and helps, of the values
+// returned by f in the same order, computed once for all the gauges,
+// not exposed when f fails.
+func NewGaugeFuncs(names []string, helps []string, f func() ([]float64, error)) *GaugeFuncs {
+	if len(names) != len(helps) {
+		panic("metrics: wrong number of gauge helps")
+	}
+	g := &GaugeFuncs{f: f}
+	for i, name := range names {
+		g.descs = append(g.descs, desc{name: name, help: helps[i], kind: "gauge"})
+	}
+	register(g)
+	return g
+}
+
+func (g *GaugeFuncs) write(w io.Writer) {
+	values, err := g.f()
+	if err != nil || len(values) != len(g.descs) {
+		return
+	}
+	for i := range g.descs {
+		g.descs[i].header(w)
+		fmt.Fprintf(w, "%s %s\n", g.descs[i].name, formatFloat(values[i]))
+	}
+}
+
+// Handler returns the handler exposing the registered metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryMutex.Lock()
+		metrics := append([]metric(nil), registry...)
+		registryMutex.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, m := range metrics {
+			m.write(bw)
+		}
+		bw.Flush()
+	})
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// exposeOnly returns the exposition of the metrics registered by register only.
+func exposeOnly(t *testing.T, register func()) string {
+	registryMutex.Lock()
+	saved := registry
+	registry = nil
+	registryMutex.Unlock()
+	defer func() {
+		registryMutex.Lock()
+		registry = saved
+		registryMutex.Unlock()
+	}()
+
+	register()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type %s", ct)
+	}
+	return w.Body.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	got := exposeOnly(t, func() {
+		c := NewCounterVec("test_total", "Test counter.", "handler", "code")
+		c.Inc("/b", "200")
+		c.Add(2.5, "/a", "404")
+		c.Inc("/b", "200")
+		c.Inc(`"q"\`+"\n", "500")
+		NewCounterVec("test_empty_total", "Empty counter.")
+	})
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{handler="\"q\"\\\n",code="500"} 1
+test_total{handler="/a",code="404"} 2.5
+test_total{handler="/b",code="200"} 2
+# HELP test_empty_total Empty counter.
+# TYPE test_empty_total counter
+`
+	if got != want {
+		t.Errorf("exposition\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	got := exposeOnly(t, func() {
+		h := NewHistogramVec("test_seconds", "Test histogram.", []float64{.1, 1}, "method")
+		for _, v := range []float64{.05, .1, .5, 3} {
+			h.Observe(v, "Get")
+		}
+	})
+	want := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{method="Get",le="0.1"} 2
+test_seconds_bucket{method="Get",le="1"} 3
+test_seconds_bucket{method="Get",le="+Inf"} 4
+test_seconds_sum{method="Get"} 3.65
+test_seconds_count{method="Get"} 4
+`
+	if got != want {
+		t.Errorf("exposition\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeFuncs(t *testing.T) {
+	var calls int
+	failing := false
+	register := func() {
+		NewGaugeFunc("test_clients", "Test gauge.", func() (float64, error) { return 1, nil })
+		NewGaugeFunc("test_failing", "Failing gauge.", func() (float64, error) { return 0, errors.New("failing") })
+		NewGaugeFuncs([]string{"test_bookmarks", "test_folders"}, []string{"Bookmarks.", "Folders."}, func() ([]float64, error) {
+			calls++
+			if failing {
+				return nil, errors.New("failing")
+			}
+			return []float64{12, 3}, nil
+		})
+	}
+
+	// The values computed once for all the gauges, not exposed on errors.
+	want := `# HELP test_clients Test gauge.
+# TYPE test_clients gauge
+test_clients 1
+# HELP test_bookmarks Bookmarks.
+# TYPE test_bookmarks gauge
+test_bookmarks 12
+# HELP test_folders Folders.
+# TYPE test_folders gauge
+test_folders 3
+`
+	if got := exposeOnly(t, register); got != want || calls != 1 {
+		t.Errorf("exposition in %d calls\n%s\nwant\n%s", calls, got, want)
+	}
+	failing = true
+	want = `# HELP test_clients Test gauge.
+# TYPE test_clients gauge
+test_clients 1
+`
+	if got := exposeOnly(t, register); got != want {
+		t.Errorf("exposition on errors\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWrongLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func()
+	}{
+		{"counter", func() { NewCounterVec("test_total", "Test.", "a").Inc() }},
+		{"histogram", func() { NewHistogramVec("test_seconds", "Test.", DefaultBuckets).Observe(1, "a") }},
+		{"gauges", func() { NewGaugeFuncs([]string{"a", "b"}, []string{"A."}, nil) }},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", tt.name)
+				}
+			}()
+			exposeOnly(t, tt.f)
+		}()
+	}
+}
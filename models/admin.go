@@ -0,0 +1,427 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Checks of CheckDatabase.
+const (
+	CheckIntegrity         = "integrity"          // SQLite file corruption, not repaired
+	CheckOrphanedFolder    = "orphaned folder"    // folder without parent, moved to the root folder
+	CheckOrphanedBookmark  = "orphaned bookmark"  // bookmark without folder, moved to the root folder
+	CheckUnreachableFolder = "unreachable folder" // folder of a parent cycle, moved to the root folder
+	CheckChildCount        = "child count"        // wrong nbChildrenFolders, recomputed
+	CheckFavicon           = "dangling favicon"   // favicon not a base64 data URL, removed
+	CheckReference         = "dangling reference" // visit, share or sync id of a missing item, deleted
+)
+
+// reachableFolders selects the ids of the folders reachable from the root folder
+// or from an orphaned folder.
+const reachableFolders = "WITH RECURSIVE reachable(id) AS (SELECT id FROM folder WHERE id=1 OR parentFolderId IS NULL OR parentFolderId NOT IN (SELECT id FROM folder) UNION SELECT f.id FROM folder f JOIN reachable ON f.parentFolderId=reachable.id) SELECT id FROM reachable"
+
+// Problem is an inconsistency found by CheckDatabase.
+type Problem struct {
+	Check  string // one of the Check* constants
+	Id     int    // the folder, bookmark or row id, 0 for the whole database
+	Detail string
+}
+
+// checker checks and repairs the database within a transaction.
+type checker struct {
+	tx       *sql.Tx
+	repair   bool
+	problems []Problem
+}
+
+// queryProblems adds a problem of the given check for each row of the query
+// selecting an id and a detail, and returns their ids.
+func (c *checker) queryProblems(check string, query string) ([]int, error) {
+	rows, err := c.tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		p := Problem{Check: check}
+		if err = rows.Scan(&p.Id, &p.Detail); err != nil {
+			return nil, err
+		}
+		c.problems = append(c.problems, p)
+		ids = append(ids, p.Id)
+	}
+	return ids, rows.Err()
+}
+
+// repairIDs runs the repair query for each of the given ids.
+func (c *checker) repairIDs(query string, ids []int) error {
+	if !c.repair {
+		return nil
+	}
+	for _, id := range ids {
+		if _, err := c.tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTree checks the folders and bookmarks without parent folder
+// and the folders of parent cycles.
+func (c *checker) checkTree() error {
+	ids, err := c.queryProblems(CheckOrphanedFolder, "SELECT id, title FROM folder WHERE id!=1 AND (parentFolderId IS NULL OR parentFolderId NOT IN (SELECT id FROM folder))")
+	if err == nil {
+		err = c.repairIDs("UPDATE folder SET parentFolderId=1 WHERE id=?", ids)
+	}
+	if err != nil {
+		return err
+	}
+	if ids, err = c.queryProblems(CheckOrphanedBookmark, "SELECT id, title FROM bookmark WHERE folderId IS NULL OR folderId NOT IN (SELECT id FROM folder)"); err == nil {
+		err = c.repairIDs("UPDATE bookmark SET folderId=1 WHERE id=?", ids)
+	}
+	if err != nil {
+		return err
+	}
+
+	if ids, err = c.queryProblems(CheckUnreachableFolder, "SELECT id, title FROM folder WHERE id NOT IN ("+reachableFolders+") ORDER BY id"); err != nil || !c.repair {
+		return err
+	}
+	// Breaking the cycles one folder at a time,
+	// the other folders of a cycle being reachable again.
+	for len(ids) != 0 {
+		if _, err = c.tx.Exec("UPDATE folder SET parentFolderId=1 WHERE id=?", ids[0]); err != nil {
+			return err
+		}
+		var rows *sql.Rows
+		if rows, err = c.tx.Query("SELECT id FROM folder WHERE id NOT IN (" + reachableFolders + ") ORDER BY id"); err != nil {
+			return err
+		}
+		ids = ids[:0]
+		for rows.Next() {
+			var id int
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkChildCounts checks the folders nbChildrenFolders, once the tree is repaired.
+func (c *checker) checkChildCounts() error {
+	ids, err := c.queryProblems(CheckChildCount, "SELECT id, title || ': ' || ifnull(nbChildrenFolders, 'NULL') || ' instead of ' || (SELECT count(*) FROM folder f WHERE f.parentFolderId=folder.id) FROM folder WHERE nbChildrenFolders IS NOT (SELECT count(*) FROM folder f WHERE f.parentFolderId=folder.id)")
+	if err != nil {
+		return err
+	}
+	return c.repairIDs("UPDATE folder SET nbChildrenFolders=(SELECT count(*) FROM folder f WHERE f.parentFolderId=folder.id) WHERE id=?", ids)
+}
+
+// validFavicon returns true if favicon is a base64 encoded image data URL.
+func validFavicon(favicon string) bool {
+	if !strings.HasPrefix(favicon, "data:image/") {
+		return false
+	}
+	i := strings.Index(favicon, ";base64,")
+	if i < 0 {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(favicon[i+len(";base64,"):])
+	return err == nil
+}
+
+// checkFavicons checks the bookmarks favicons.
+func (c *checker) checkFavicons() error {
+	rows, err := c.tx.Query("SELECT id, title, favicon FROM bookmark WHERE favicon IS NOT NULL AND favicon!=''")
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var (
+			p       = Problem{Check: CheckFavicon}
+			favicon string
+		)
+		if err = rows.Scan(&p.Id, &p.Detail, &favicon); err != nil {
+			rows.Close()
+			return err
+		}
+		if !validFavicon(favicon) {
+			c.problems = append(c.problems, p)
+			ids = append(ids, p.Id)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return c.repairIDs("UPDATE bookmark SET favicon='' WHERE id=?", ids)
+}
+
+// checkReferences checks the visits, shares and sync ids
+// of missing bookmarks and folders.
+func (c *checker) checkReferences() error {
+	for _, table := range []string{"visit", "share", "syncid"} {
+		rows, err := c.tx.Query("PRAGMA foreign_key_check(" + table + ")")
+		if err != nil {
+			return err
+		}
+		var ids []int
+		for rows.Next() {
+			var (
+				t, parent string
+				rowid     int
+				fkid      int
+			)
+			if err = rows.Scan(&t, &rowid, &parent, &fkid); err != nil {
+				rows.Close()
+				return err
+			}
+			c.problems = append(c.problems, Problem{Check: CheckReference, Id: rowid, Detail: table + " row " + strconv.Itoa(rowid) + " of a missing " + parent})
+			ids = append(ids, rowid)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if err = c.repairIDs("DELETE FROM "+table+" WHERE rowid=?", ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckDatabase returns the problems found in the database:
+// SQLite corruption, orphaned folders and bookmarks, unreachable subtrees,
+// wrong child counts, dangling favicons and dangling references.
+// With repair all but the corruption are repaired, in a single transaction.
+func (db *SQLiteDataStore) CheckDatabase(repair bool) []Problem {
+	log.WithFields(log.Fields{
+		"repair": repair,
+	}).Debug("CheckDatabase")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return nil
+	}
+
+	var (
+		rows     *sql.Rows
+		problems []Problem
+	)
+	if rows, db.err = db.Query("PRAGMA integrity_check"); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("CheckDatabase:integrity_check query error")
+		return nil
+	}
+	for rows.Next() {
+		p := Problem{Check: CheckIntegrity}
+		if db.err = rows.Scan(&p.Detail); db.err != nil {
+			rows.Close()
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("CheckDatabase:error scanning the integrity_check row")
+			return nil
+		}
+		if p.Detail != "ok" {
+			problems = append(problems, p)
+		}
+	}
+	rows.Close()
+	if db.err = rows.Err(); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("CheckDatabase:error looping the integrity_check rows")
+		return nil
+	}
+
+	var tx *sql.Tx
+	if tx, db.err = db.Begin(); db.err != nil {
+		log.Error("CheckDatabase: transaction begin failed")
+		return nil
+	}
+	c := &checker{tx: tx, repair: repair, problems: problems}
+	if db.err = c.checkTree(); db.err == nil {
+		if db.err = c.checkChildCounts(); db.err == nil {
+			if db.err = c.checkFavicons(); db.err == nil {
+				db.err = c.checkReferences()
+			}
+		}
+	}
+	// Rolling back on errors or when only checking, or commit.
+	if db.err != nil || !repair {
+		if db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("CheckDatabase: query error")
+		}
+		if err := tx.Rollback(); err != nil {
+			// Just logging the error.
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("CheckDatabase: transaction rollback error")
+		}
+		if db.err != nil {
+			return nil
+		}
+		return c.problems
+	}
+	if db.err = tx.Commit(); db.err != nil {
+		log.Error("CheckDatabase: transaction commit error")
+		return nil
+	}
+	return c.problems
+}
+
+// backupDatabase copies the main database of src into dst
+// with the SQLite online backup API.
+func backupDatabase(dst *sql.DB, src *sql.DB) error {
+	ctx := context.Background()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dstDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			d, ok := dstDriverConn.(*sqlite3.SQLiteConn)
+			s, ok2 := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("not a SQLite connection")
+			}
+			b, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+			for done := false; !done; {
+				if done, err = b.Step(-1); err != nil {
+					b.Finish()
+					return err
+				}
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// Backup copies the database into the SQLite file path,
+// replacing its content, while the database is in use.
+func (db *SQLiteDataStore) Backup(path string) {
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Debug("Backup")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	var dst *sql.DB
+	if dst, db.err = sql.Open(dbdriver, path); db.err != nil {
+		log.Error("Backup: error opening the backup file")
+		return
+	}
+	defer dst.Close()
+	if db.err = backupDatabase(dst, db.DB); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("Backup: backup error")
+	}
+}
+
+// Restore replaces the database content by the content of the SQLite file path,
+// checking first that it is a sound GoBkm database.
+func (db *SQLiteDataStore) Restore(path string) {
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Debug("Restore")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	var (
+		src    *sql.DB
+		result string
+		count  int
+	)
+	// Opening read only, not to create a missing file.
+	if src, db.err = sql.Open(dbdriver, "file:"+path+"?mode=ro"); db.err != nil {
+		log.Error("Restore: error opening the backup file")
+		return
+	}
+	defer src.Close()
+	if db.err = src.QueryRow("PRAGMA integrity_check").Scan(&result); db.err != nil {
+		log.Error("Restore: integrity_check query error")
+		return
+	}
+	if result != "ok" {
+		db.err = errors.New("corrupted backup: " + result)
+		return
+	}
+	if db.err = src.QueryRow("SELECT count(*) FROM folder WHERE id=1").Scan(&count); db.err != nil || count != 1 {
+		log.Error("Restore: not a GoBkm database")
+		db.err = errors.New("not a GoBkm database: " + path)
+		return
+	}
+	if db.err = backupDatabase(db.DB, src); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("Restore: backup error")
+	}
+}
+
+// Vacuum rebuilds the database file, reclaiming the free space.
+func (db *SQLiteDataStore) Vacuum() {
+	log.Debug("Vacuum")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	if _, db.err = db.Exec("VACUUM"); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("Vacuum: query error")
+	}
+}
+
+// Ready returns an error if the database is not reachable or has migrations
+// not applied. The datastore error is left untouched, the readiness being
+// checked while serving the requests.
+func (db *SQLiteDataStore) Ready() error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version != len(migrations) {
+		return errors.New("database version " + strconv.Itoa(version) + ", expecting " + strconv.Itoa(len(migrations)))
+	}
+	return nil
+}
+
+// Counts returns the number of bookmarks and of folders, the root folder excluded.
+// The datastore error is left untouched, as for Ready.
+func (db *SQLiteDataStore) Counts() (bookmarks int, folders int, err error) {
+	err = db.QueryRow("SELECT (SELECT COUNT(*) FROM bookmark), (SELECT COUNT(*) FROM folder WHERE id != 1)").Scan(&bookmarks, &folders)
+	return bookmarks, folders, err
+}
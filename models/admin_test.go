@@ -0,0 +1,228 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// corrupt executes the given queries without the foreign keys checks.
+func corrupt(t *testing.T, db *SQLiteDataStore, queries ...string) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys=ON")
+	for _, q := range queries {
+		if _, err = conn.ExecContext(ctx, q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+}
+
+// problemsString returns the sorted checks and ids of the given problems.
+func problemsString(problems []Problem) string {
+	var items []string
+	for _, p := range problems {
+		items = append(items, fmt.Sprintf("%s %d", p.Check, p.Id))
+	}
+	sort.Strings(items)
+	return fmt.Sprint(items)
+}
+
+func TestCheckDatabase(t *testing.T) {
+	db := newTestDatastore(t)
+	a := &types.Folder{Title: "A"}
+	a.Id = int(db.SaveFolder(a))
+	b := &types.Folder{Title: "B", Parent: a}
+	b.Id = int(db.SaveFolder(b))
+	c := &types.Folder{Title: "C", Parent: a}
+	c.Id = int(db.SaveFolder(c))
+	d := &types.Folder{Title: "D"}
+	d.Id = int(db.SaveFolder(d))
+	db.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/", Folder: a, Favicon: "data:image/png;base64,iVBORw=="})
+	db.SaveBookmark(&types.Bookmark{Title: "Icon", URL: "https://example.com/", Folder: a})
+	db.SaveBookmark(&types.Bookmark{Title: "Orphan", URL: "https://orphan.example.com/", Folder: a})
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if problems := db.CheckDatabase(false); len(problems) != 0 {
+		t.Fatalf("new database problems %v", problems)
+	}
+
+	// B and C in a cycle, D in a missing folder, and dangling rows.
+	corrupt(t, db,
+		fmt.Sprintf("UPDATE folder SET parentFolderId=%d WHERE id=%d", c.Id, b.Id),
+		fmt.Sprintf("UPDATE folder SET parentFolderId=%d WHERE id=%d", b.Id, c.Id),
+		fmt.Sprintf("UPDATE folder SET parentFolderId=99 WHERE id=%d", d.Id),
+		"UPDATE bookmark SET folderId=98 WHERE id=3",
+		"UPDATE bookmark SET favicon='data:image/png;base64,!' WHERE id=1",
+		"UPDATE bookmark SET favicon='https://example.com/favicon.ico' WHERE id=2",
+		"INSERT INTO visit(bookmarkId, day, count) VALUES(97, '2020-01-01', 1)",
+		"INSERT INTO share(token, folderId, createdAt) VALUES('t', 96, 0)",
+		"INSERT INTO syncid(bookmarkId) VALUES(95)",
+	)
+
+	// The child counts of the root folder, A, B and C are wrong,
+	// only the ones of the root folder, A and B once the tree is repaired.
+	problems := fmt.Sprintf("dangling favicon 1 dangling favicon 2 dangling reference 1 dangling reference 1 dangling reference 1 orphaned bookmark 3 orphaned folder %d unreachable folder %d unreachable folder %d", d.Id, b.Id, c.Id)
+	tests := []struct {
+		repair bool
+		want   string
+	}{
+		{false, fmt.Sprintf("[child count 1 child count %d child count %d child count %d %s]", a.Id, b.Id, c.Id, problems)},
+		// Checking only changes nothing.
+		{false, fmt.Sprintf("[child count 1 child count %d child count %d child count %d %s]", a.Id, b.Id, c.Id, problems)},
+		{true, fmt.Sprintf("[child count 1 child count %d child count %d %s]", a.Id, b.Id, problems)},
+	}
+	for i, tt := range tests {
+		got := db.CheckDatabase(tt.repair)
+		if err := db.FlushErrors(); err != nil {
+			t.Fatal(err)
+		}
+		if s := problemsString(got); s != tt.want {
+			t.Errorf("%d: CheckDatabase(%t) = %s, want %s", i, tt.repair, s, tt.want)
+		}
+	}
+	if problems := db.CheckDatabase(false); len(problems) != 0 {
+		t.Errorf("repaired database problems %v", problems)
+	}
+
+	// The repaired items are in the root folder, the valid favicon kept.
+	for _, id := range []int{b.Id, d.Id} {
+		if fld := db.GetFolder(id); fld.Parent == nil || fld.Parent.Id != 1 {
+			t.Errorf("repaired folder %d parent %v", id, fld.Parent)
+		}
+	}
+	if fld := db.GetFolder(c.Id); fld.Parent == nil || fld.Parent.Id != b.Id {
+		t.Errorf("folder C parent %v, want B", fld.Parent)
+	}
+	if bkm := db.GetBookmark(3); bkm.Folder == nil || bkm.Folder.Id != 1 {
+		t.Errorf("repaired bookmark folder %v", bkm.Folder)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM bookmark WHERE favicon!=''"); n != 0 {
+		t.Errorf("%d dangling favicons left", n)
+	}
+	if n := count(t, db, "SELECT (SELECT COUNT(*) FROM visit) + (SELECT COUNT(*) FROM share) + (SELECT COUNT(*) FROM syncid)"); n != 0 {
+		t.Errorf("%d dangling references left", n)
+	}
+}
+
+func TestValidFavicon(t *testing.T) {
+	tests := []struct {
+		favicon string
+		want    bool
+	}{
+		{"data:image/png;base64,iVBORw==", true},
+		{"data:image/svg+xml;base64,PHN2Zz4=", true},
+		{"data:image/png;base64,!", false},
+		{"data:image/png,raw", false},
+		{"data:text/html;base64,PGI+", false},
+		{"https://example.com/favicon.ico", false},
+	}
+	for _, tt := range tests {
+		if got := validFavicon(tt.favicon); got != tt.want {
+			t.Errorf("validFavicon(%q) = %t, want %t", tt.favicon, got, tt.want)
+		}
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	db := newTestDatastore(t)
+	db.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/"})
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	backup := filepath.Join(dir, "backup.db")
+
+	// Backing up, then restoring the backup.
+	db.Backup(backup)
+	if err = db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	db.SaveBookmark(&types.Bookmark{Title: "Rust", URL: "https://rust-lang.org/"})
+	db.Restore(backup)
+	if err = db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(db.QueryBookmarks(types.BookmarkQuery{})); fmt.Sprint(got) != "[Go]" {
+		t.Errorf("restored bookmarks %v, want [Go]", got)
+	}
+	db.Vacuum()
+	if err = db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restoring anything else fails, the database unchanged.
+	other := filepath.Join(dir, "other.db")
+	otherDB, err := sql.Open(dbdriver, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = otherDB.Exec("CREATE TABLE folder (id integer)"); err != nil {
+		t.Fatal(err)
+	}
+	otherDB.Close()
+	text := filepath.Join(dir, "text.db")
+	if err = ioutil.WriteFile(text, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{other, text, filepath.Join(dir, "missing.db")} {
+		db.Restore(path)
+		if err = db.FlushErrors(); err == nil {
+			t.Errorf("Restore(%s) without error", filepath.Base(path))
+		}
+	}
+	if got := titles(db.QueryBookmarks(types.BookmarkQuery{})); fmt.Sprint(got) != "[Go]" {
+		t.Errorf("bookmarks %v after the failed restores, want [Go]", got)
+	}
+}
+
+func TestReadyCounts(t *testing.T) {
+	db := newTestDatastore(t)
+	it := &types.Folder{Title: "IT"}
+	it.Id = int(db.SaveFolder(it))
+	db.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/", Folder: it})
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ready(); err != nil {
+		t.Errorf("Ready() = %v", err)
+	}
+	if bookmarks, folders, err := db.Counts(); bookmarks != 1 || folders != 1 || err != nil {
+		t.Errorf("Counts() = %d, %d, %v, want 1, 1, nil", bookmarks, folders, err)
+	}
+
+	// The datastore error untouched.
+	db.GetBookmark(99)
+	if _, err := db.Exec("PRAGMA user_version=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ready(); err == nil {
+		t.Error("Ready() of a database not migrated without error")
+	}
+	if err := db.FlushErrors(); err != sql.ErrNoRows {
+		t.Errorf("datastore error %v, want %v", err, sql.ErrNoRows)
+	}
+	db.Close()
+	if err := db.Ready(); err == nil {
+		t.Error("Ready() of a closed database without error")
+	}
+	if _, _, err := db.Counts(); err == nil {
+		t.Error("Counts() of a closed database without error")
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrFolderCycle is the error of a folder moved into itself or one of its subfolders.
+var ErrFolderCycle = errors.New("can not move a folder into itself or one of its subfolders")
+
+// retag returns the given tags with the add tags and without the remove tags.
+func retag(tags []string, add []string, remove []string) []string {
+	var result []string
+	has := make(map[string]bool)
+	for _, t := range remove {
+		has[t] = true
+	}
+	for _, t := range append(tags, add...) {
+		if !has[t] {
+			has[t] = true
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// execBatch executes the given batch query. checkRows makes the query fail
+// with sql.ErrNoRows if it does not affect any row, its item being missing.
+func execBatch(tx *sql.Tx, checkRows bool, query string, args ...interface{}) error {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if !checkRows {
+		return nil
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = sql.ErrNoRows
+	}
+	return err
+}
+
+// applyBatchOperation applies the given operation within the tx transaction.
+// Deleting a missing item, such as a bookmark of a folder deleted
+// by a previous operation, is not an error.
+func applyBatchOperation(tx *sql.Tx, op types.BatchOperation, now time.Time) error {
+	table, id := "bookmark", op.BookmarkId
+	if op.FolderId != 0 {
+		table, id = "folder", op.FolderId
+	}
+
+	switch op.Op {
+	case types.BatchMove:
+		dstID := op.DestinationFolderId
+		if dstID == 0 {
+			dstID = 1
+		}
+		// The folder subtree as modified by the previous operations
+		// must not contain the destination.
+		if table == "folder" {
+			var cycle bool
+			if err := tx.QueryRow("SELECT EXISTS("+subtreeFolders+" WHERE id=?)", id, dstID).Scan(&cycle); err != nil {
+				return err
+			}
+			if cycle {
+				return ErrFolderCycle
+			}
+		}
+		parentColumn := parentColumns[table]
+		// Moving the item after the last item of the destination folder.
+		return execBatch(tx, true, "UPDATE "+table+" SET "+parentColumn+"=?, position=(SELECT COALESCE(MAX(position), 0) + 1 FROM "+table+" WHERE "+parentColumn+" IS ?), updatedAt=? WHERE id=?",
+			dstID, dstID, now.Unix(), id)
+	case types.BatchDelete:
+		// Deleting the folder content and the dependent rows too.
+		queries := deleteBookmarkQueries
+		if table == "folder" {
+			queries = deleteFolderQueries
+		}
+		for _, query := range queries {
+			if err := execBatch(tx, false, query, id); err != nil {
+				return err
+			}
+		}
+	case types.BatchStar:
+		return execBatch(tx, true, "UPDATE bookmark SET starred=?, updatedAt=? WHERE id=?", op.Starred, now.Unix(), id)
+	case types.BatchTag:
+		var tags string
+		if err := tx.QueryRow("SELECT tags FROM bookmark WHERE id=?", id).Scan(&tags); err != nil {
+			return err
+		}
+		return execBatch(tx, true, "UPDATE bookmark SET tags=?, updatedAt=? WHERE id=?", joinTags(retag(splitTags(tags), op.Tags, op.Untags)), now.Unix(), id)
+	case types.BatchRename:
+		return execBatch(tx, true, "UPDATE "+table+" SET title=?, updatedAt=? WHERE id=?", op.Title, now.Unix(), id)
+	}
+	return nil
+}
+
+// ApplyBatch applies the given operations in order in a single transaction,
+// rolled back if one of them fails. An operation on a missing item
+// fails with sql.ErrNoRows, a folder moved into its own subtree with ErrFolderCycle.
+// The operations are expected to be valid: star and tag on bookmarks,
+// rename with a title.
+func (db *SQLiteDataStore) ApplyBatch(ops []types.BatchOperation) {
+	log.WithFields(log.Fields{
+		"ops": ops,
+	}).Debug("ApplyBatch")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, db.err = db.Begin(); db.err != nil {
+		log.Error("ApplyBatch: transaction begin failed")
+		return
+	}
+	now := time.Now()
+	for _, op := range ops {
+		db.err = applyBatchOperation(tx, op, now)
+		// The number of subfolders of the folders.
+		if db.err == nil && op.FolderId != 0 && (op.Op == types.BatchMove || op.Op == types.BatchDelete) {
+			_, db.err = tx.Exec("UPDATE folder SET nbChildrenFolders=(SELECT count(*) FROM folder f WHERE f.parentFolderId=folder.id)")
+		}
+		if db.err != nil {
+			log.WithFields(log.Fields{
+				"op":  op,
+				"err": db.err,
+			}).Error("ApplyBatch: operation error")
+			if err := tx.Rollback(); err != nil {
+				// Just logging the error.
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("ApplyBatch: transaction rollback error")
+			}
+			return
+		}
+	}
+	if db.err = tx.Commit(); db.err != nil {
+		log.Error("ApplyBatch: transaction commit error")
+	}
+}
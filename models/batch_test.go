@@ -0,0 +1,156 @@
+package models
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// dump returns the folders and bookmarks rows, for comparisons.
+func dump(t *testing.T, db *SQLiteDataStore) []string {
+	var lines []string
+	for _, query := range []string{
+		"SELECT 'folder '||id||' '||title||' '||IFNULL(parentFolderId, '')||' '||position FROM folder ORDER BY id",
+		"SELECT 'bookmark '||id||' '||title||' '||folderId||' '||starred||' '||tags||' '||position FROM bookmark ORDER BY id",
+		"SELECT 'visit '||bookmarkId||' '||day||' '||count FROM visit ORDER BY bookmarkId, day",
+	} {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var line string
+			if err = rows.Scan(&line); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, line)
+		}
+		if err = rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lines
+}
+
+func TestApplyBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// ops are built from the a, b and c folders, b in a and c in b,
+		// the d root folder, and the x bookmark in a.
+		ops     func(a, b, c, d, x int) []types.BatchOperation
+		wantErr error
+	}{
+		{
+			name: "mixed batch failing last",
+			ops: func(a, b, c, d, x int) []types.BatchOperation {
+				return []types.BatchOperation{
+					{Op: types.BatchStar, BookmarkId: x, Starred: true},
+					{Op: types.BatchTag, BookmarkId: x, Tags: []string{"go"}},
+					{Op: types.BatchRename, FolderId: a, Title: "renamed"},
+					{Op: types.BatchMove, FolderId: c, DestinationFolderId: d},
+					{Op: types.BatchDelete, FolderId: b},
+					{Op: types.BatchRename, BookmarkId: 999, Title: "missing"},
+				}
+			},
+			wantErr: sql.ErrNoRows,
+		},
+		{
+			name: "folder moved into its subfolder",
+			ops: func(a, b, c, d, x int) []types.BatchOperation {
+				return []types.BatchOperation{
+					{Op: types.BatchStar, BookmarkId: x, Starred: true},
+					{Op: types.BatchMove, FolderId: a, DestinationFolderId: c},
+				}
+			},
+			wantErr: ErrFolderCycle,
+		},
+		{
+			name: "folder moved into a folder moved into it",
+			ops: func(a, b, c, d, x int) []types.BatchOperation {
+				return []types.BatchOperation{
+					{Op: types.BatchMove, FolderId: d, DestinationFolderId: c},
+					{Op: types.BatchMove, FolderId: a, DestinationFolderId: d},
+				}
+			},
+			wantErr: ErrFolderCycle,
+		},
+		{
+			name: "folder moved into itself",
+			ops: func(a, b, c, d, x int) []types.BatchOperation {
+				return []types.BatchOperation{
+					{Op: types.BatchMove, FolderId: d, DestinationFolderId: d},
+				}
+			},
+			wantErr: ErrFolderCycle,
+		},
+	}
+	for _, tt := range tests {
+		db := newTestDatastore(t)
+		a := &types.Folder{Title: "a"}
+		a.Id = int(db.SaveFolder(a))
+		b := &types.Folder{Title: "b", Parent: a}
+		b.Id = int(db.SaveFolder(b))
+		c := &types.Folder{Title: "c", Parent: b}
+		c.Id = int(db.SaveFolder(c))
+		d := &types.Folder{Title: "d"}
+		d.Id = int(db.SaveFolder(d))
+		x := &types.Bookmark{Title: "x", URL: "https://x.example.com/", Folder: a}
+		x.Id = int(db.SaveBookmark(x))
+		db.VisitBookmark(x.Id)
+		if err := db.FlushErrors(); err != nil {
+			t.Fatal(err)
+		}
+		before := dump(t, db)
+
+		db.ApplyBatch(tt.ops(a.Id, b.Id, c.Id, d.Id, x.Id))
+		if err := db.FlushErrors(); err != tt.wantErr {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if after := dump(t, db); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: batch partly applied\nbefore %v\nafter  %v", tt.name, before, after)
+		}
+	}
+}
+
+func TestApplyBatchApplied(t *testing.T) {
+	db := newTestDatastore(t)
+	a := &types.Folder{Title: "a"}
+	a.Id = int(db.SaveFolder(a))
+	b := &types.Folder{Title: "b", Parent: a}
+	b.Id = int(db.SaveFolder(b))
+	x := &types.Bookmark{Title: "x", URL: "https://x.example.com/", Folder: b, Tags: []string{"old"}}
+	x.Id = int(db.SaveBookmark(x))
+	y := &types.Bookmark{Title: "y", URL: "https://y.example.com/", Folder: b}
+	y.Id = int(db.SaveBookmark(y))
+
+	db.ApplyBatch([]types.BatchOperation{
+		{Op: types.BatchStar, BookmarkId: x.Id, Starred: true},
+		{Op: types.BatchTag, BookmarkId: x.Id, Tags: []string{"go"}, Untags: []string{"old"}},
+		{Op: types.BatchMove, BookmarkId: x.Id},
+		{Op: types.BatchRename, FolderId: a.Id, Title: "renamed"},
+		{Op: types.BatchMove, FolderId: b.Id},
+		{Op: types.BatchDelete, FolderId: b.Id},
+		// Already deleted with its folder.
+		{Op: types.BatchDelete, BookmarkId: y.Id},
+	})
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	bkm := db.GetBookmark(x.Id)
+	fld := db.GetFolder(a.Id)
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if !bkm.Starred || !reflect.DeepEqual(bkm.Tags, []string{"go"}) || bkm.Folder == nil || bkm.Folder.Id != 1 {
+		t.Errorf("bookmark not starred, tagged and moved: %+v", bkm)
+	}
+	if fld.Title != "renamed" || fld.NbChildrenFolders != 0 {
+		t.Errorf("folder not renamed or its subfolder not deleted: %+v", fld)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM bookmark WHERE id=?", y.Id); n != 0 {
+		t.Errorf("bookmark of the deleted folder not deleted")
+	}
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Fragment rules of the Canonicalizer.
+const (
+	FragmentsKeep   = "keep"
+	FragmentsStrip  = "strip"
+	FragmentsRoutes = "routes" // keeps the single page applications routes: #/... and #!...
+)
+
+// Canonicalizer normalizes the bookmarks URLs so that the same page
+// saved with different URLs can be detected as a duplicate.
+// The scheme and host are lowered, the default ports,
+// the path trailing slash and the empty query parameters removed
+// and the query parameters sorted.
+type Canonicalizer struct {
+	// KeepScheme considers the http and https URLs different.
+	KeepScheme bool
+	// StripParams are the removed query parameters,
+	// a trailing * matches the parameters with the given prefix.
+	StripParams []string
+	// Fragments is one of the Fragments* rules.
+	Fragments string
+}
+
+// DefaultCanonicalizer strips the common tracking parameters
+// and the fragments but the routes.
+var DefaultCanonicalizer = Canonicalizer{
+	StripParams: []string{"utm_*", "fbclid", "gclid", "dclid", "msclkid", "mc_cid", "mc_eid", "igshid", "yclid", "_hsenc", "_hsmi"},
+	Fragments:   FragmentsRoutes,
+}
+
+// stripParam returns true if the query parameter p is removed.
+func (c Canonicalizer) stripParam(p string) bool {
+	for _, s := range c.StripParams {
+		if (strings.HasSuffix(s, "*") && strings.HasPrefix(p, strings.TrimSuffix(s, "*"))) || p == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonicalize returns the canonical form of the given URL,
+// the trimmed URL itself if it is not an absolute http(s) URL.
+func (c Canonicalizer) Canonicalize(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return rawURL
+	}
+
+	// Scheme, host and port.
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (port == "80" && u.Scheme == "http") || (port == "443" && u.Scheme == "https") {
+		port = ""
+	}
+	if !c.KeepScheme {
+		u.Scheme = "https"
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 address.
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	u.User = nil
+
+	// Path.
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+
+	// Query parameters, url.Values.Encode sorts them by key.
+	q := u.Query()
+	for p, vals := range q {
+		if c.stripParam(p) || len(vals) == 1 && vals[0] == "" {
+			delete(q, p)
+			continue
+		}
+		sort.Strings(vals)
+	}
+	u.RawQuery = q.Encode()
+	u.ForceQuery = false
+
+	// Fragment.
+	switch c.Fragments {
+	case FragmentsKeep:
+	case FragmentsRoutes:
+		if !strings.HasPrefix(u.Fragment, "/") && !strings.HasPrefix(u.Fragment, "!") {
+			u.Fragment = ""
+		}
+	default:
+		u.Fragment = ""
+	}
+
+	return u.String()
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// canonicalizeURLs updates the bookmarks canonical URLs
+// not matching the Canonicalizer, for the bookmarks created before
+// the canonical URLs or when the Canonicalizer rules change.
+func (db *SQLiteDataStore) canonicalizeURLs() {
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	var (
+		rows *sql.Rows
+		tx   *sql.Tx
+	)
+	// The canonical URLs to update by bookmark id.
+	updated := make(map[int]string)
+
+	if rows, db.err = db.Query("SELECT id, url, canonicalURL FROM bookmark"); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("canonicalizeURLs:SELECT query error")
+		return
+	}
+	for rows.Next() {
+		var (
+			id                int
+			url, canonicalURL string
+		)
+		if db.err = rows.Scan(&id, &url, &canonicalURL); db.err != nil {
+			rows.Close()
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("canonicalizeURLs:error scanning the query result row")
+			return
+		}
+		if c := db.Canonicalizer.Canonicalize(url); c != canonicalURL {
+			updated[id] = c
+		}
+	}
+	if db.err = rows.Err(); db.err != nil {
+		rows.Close()
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("canonicalizeURLs:error looping rows")
+		return
+	}
+	rows.Close()
+	if len(updated) == 0 {
+		return
+	}
+	log.WithFields(log.Fields{
+		"count": len(updated),
+	}).Info("canonicalizeURLs: updating the canonical URLs")
+
+	if tx, db.err = db.Begin(); db.err != nil {
+		log.Error("canonicalizeURLs: transaction begin failed")
+		return
+	}
+	for id, canonicalURL := range updated {
+		if _, db.err = tx.Exec("UPDATE bookmark SET canonicalURL=? WHERE id=?", canonicalURL, id); db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("canonicalizeURLs:UPDATE query error")
+			if err := tx.Rollback(); err != nil {
+				// Just logging the error.
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("canonicalizeURLs: transaction rollback error")
+			}
+			return
+		}
+	}
+	if db.err = tx.Commit(); db.err != nil {
+		log.Error("canonicalizeURLs: transaction commit error")
+	}
+}
+
+// FindBookmarksByURL returns the bookmarks with the canonical URL of the given URL,
+// with their folder, the oldest first.
+func (db *SQLiteDataStore) FindBookmarksByURL(url string) []*types.Bookmark {
+	log.WithFields(log.Fields{
+		"url": url,
+	}).Debug("FindBookmarksByURL")
+	return db.queryBookmarks("FindBookmarksByURL", true, "SELECT "+bookmarkColumns+" FROM bookmark WHERE canonicalURL=? ORDER BY createdAt, id", db.Canonicalizer.Canonicalize(url))
+}
+
+// GetDuplicateBookmarks returns the groups of bookmarks sharing the same canonical URL,
+// with their folder, the oldest first.
+func (db *SQLiteDataStore) GetDuplicateBookmarks() [][]*types.Bookmark {
+	bkms := db.queryBookmarks("GetDuplicateBookmarks", true, "SELECT "+bookmarkColumns+" FROM bookmark WHERE canonicalURL IN (SELECT canonicalURL FROM bookmark GROUP BY canonicalURL HAVING COUNT(*) > 1) ORDER BY canonicalURL, createdAt, id")
+
+	var groups [][]*types.Bookmark
+	for i, bkm := range bkms {
+		if i == 0 || bkm.CanonicalURL != bkms[i-1].CanonicalURL {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], bkm)
+	}
+	return groups
+}
+
+// MergeBookmarks updates the kept bookmark, adds the visits of the removed
+// bookmarks to its visits and deletes the removed bookmarks in a single transaction.
+// The kept bookmark VisitCount, CreatedAt and LastVisitedAt are updated too.
+func (db *SQLiteDataStore) MergeBookmarks(keep *types.Bookmark, removed []*types.Bookmark) {
+	log.WithFields(log.Fields{
+		"keep":    keep,
+		"removed": removed,
+	}).Debug("MergeBookmarks")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+	if len(removed) == 0 {
+		return
+	}
+	for _, b := range removed {
+		if b.Id == keep.Id {
+			db.err = errors.New("MergeBookmarks: the kept bookmark is also removed")
+			return
+		}
+	}
+
+	var (
+		tx  *sql.Tx
+		ids []string
+	)
+	for _, b := range removed {
+		ids = append(ids, strconv.Itoa(b.Id))
+	}
+	// Integers only, safe to inline.
+	removedIDs := "(" + strings.Join(ids, ",") + ")"
+	folderID := 1
+	if keep.Folder != nil {
+		folderID = keep.Folder.Id
+	}
+	keep.CanonicalURL = db.Canonicalizer.Canonicalize(keep.URL)
+
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		// Visits of the days without visits of the kept bookmark.
+		{"INSERT OR IGNORE INTO visit(bookmarkId, day, count) SELECT DISTINCT ?, day, 0 FROM visit WHERE bookmarkId IN " + removedIDs,
+			[]interface{}{keep.Id}},
+		{"UPDATE visit SET count=count+(SELECT COALESCE(SUM(v.count), 0) FROM visit v WHERE v.day=visit.day AND v.bookmarkId IN " + removedIDs + ") WHERE bookmarkId=?",
+			[]interface{}{keep.Id}},
+		{"DELETE FROM visit WHERE bookmarkId IN " + removedIDs, nil},
+		{"DELETE FROM syncid WHERE bookmarkId IN " + removedIDs, nil},
+		{"DELETE FROM bookmark WHERE id IN " + removedIDs, nil},
+		// Updated last as it may take the keyword of a removed bookmark.
+		{"UPDATE bookmark SET title=?, url=?, canonicalURL=?, folderId=?, starred=?, favicon=?, description=?, keyword=?, unread=?, archived=?, tags=?, createdAt=?, updatedAt=?, lastVisitedAt=?, visitCount=? WHERE id=?",
+			[]interface{}{keep.Title, keep.URL, keep.CanonicalURL, folderID, keep.Starred, keep.Favicon, keep.Description, keep.Keyword, keep.Unread, keep.Archived, joinTags(keep.Tags), keep.CreatedAt.Unix(), keep.UpdatedAt.Unix(), nullUnixTime(keep.LastVisitedAt), keep.VisitCount, keep.Id}},
+	}
+
+	if tx, db.err = db.Begin(); db.err != nil {
+		log.Error("MergeBookmarks: transaction begin failed")
+		return
+	}
+	for _, q := range queries {
+		if _, db.err = tx.Exec(q.query, q.args...); db.err != nil {
+			log.WithFields(log.Fields{
+				"query": q.query,
+				"err":   db.err,
+			}).Error("MergeBookmarks: query error")
+			if err := tx.Rollback(); err != nil {
+				// Just logging the error.
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("MergeBookmarks: transaction rollback error")
+			}
+			return
+		}
+	}
+	if db.err = tx.Commit(); db.err != nil {
+		log.Error("MergeBookmarks: transaction commit error")
+	}
+}
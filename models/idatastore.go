@@ -7,16 +7,29 @@ import (
 // Datastore is a folders and bookmarks storage interface
 type Datastore interface {
 	FlushErrors() error
+	Session() Datastore
+	lastError() error
+	Canonicalize(string) string
 
 	SearchBookmarks(string) []*types.Bookmark
 	GetAllBookmarks() []*types.Bookmark
 	GetBookmark(int) *types.Bookmark
+	GetBookmarkByKeyword(string) *types.Bookmark
 	GetFolderBookmarks(int) []*types.Bookmark
 	GetNoIconBookmarks() []*types.Bookmark
 	GetStarredBookmarks() []*types.Bookmark
+	QueryBookmarks(types.BookmarkQuery) []*types.Bookmark
 	SaveBookmark(*types.Bookmark) int64
 	UpdateBookmark(*types.Bookmark)
 	DeleteBookmark(*types.Bookmark)
+	VisitBookmark(int)
+	GetBookmarkVisits(int) []types.Visit
+	FindBookmarksByURL(string) []*types.Bookmark
+	GetDuplicateBookmarks() [][]*types.Bookmark
+	MergeBookmarks(*types.Bookmark, []*types.Bookmark)
+	PositionBookmark(*types.Bookmark, int)
+	ApplyBatch([]types.BatchOperation)
+	GetTags() []string
 
 	GetFolder(int) *types.Folder
 	GetFolderSubfolders(int) []*types.Folder
@@ -24,4 +37,18 @@ type Datastore interface {
 	SaveFolder(*types.Folder) int64
 	UpdateFolder(*types.Folder)
 	DeleteFolder(*types.Folder)
+	PositionFolder(*types.Folder, int)
+	SortFolder(int, string)
+
+	GetShare(string) *types.Share
+	GetShares() []*types.Share
+	SaveShare(*types.Share)
+	DeleteShare(string)
+
+	GetSyncTree() *types.SyncFolder
+	ApplySyncTree(*types.SyncFolder)
+
+	Backup(string)
+	Ready() error
+	Counts() (int, int, error)
 }
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/tbellembois/gobkm/metrics"
+	"github.com/tbellembois/gobkm/types"
+)
+
+var (
+	datastoreDuration = metrics.NewHistogramVec("gobkm_datastore_duration_seconds", "Duration of the datastore calls.", metrics.DefaultBuckets, "method")
+	datastoreErrors   = metrics.NewCounterVec("gobkm_datastore_errors_total", "Number of the datastore calls in error, the missing items excluded.", "method")
+)
+
+// metricsDatastore is a Datastore measuring the calls duration
+// and counting their errors, by method.
+type metricsDatastore struct {
+	ds Datastore
+}
+
+// NewMetricsDatastore returns the Datastore ds measured by the metrics.
+func NewMetricsDatastore(ds Datastore) Datastore {
+	return &metricsDatastore{ds: ds}
+}
+
+// observe records the duration of the method call since start, and its error:
+// the error of ds set by the call, before being the error before it.
+// A missing item is not an error of the datastore.
+// The error is left to the caller, for FlushErrors.
+func (m *metricsDatastore) observe(method string, start time.Time, before error) {
+	datastoreDuration.Observe(time.Since(start).Seconds(), method)
+	if err := m.ds.lastError(); err != nil && err != before && err != sql.ErrNoRows {
+		datastoreErrors.Inc(method)
+	}
+}
+
+// Session returns a measured session of ds.
+func (m *metricsDatastore) Session() Datastore {
+	return &metricsDatastore{ds: m.ds.Session()}
+}
+
+// Canonicalize returns the canonical URL of ds, not measured.
+func (m *metricsDatastore) Canonicalize(url string) string {
+	return m.ds.Canonicalize(url)
+}
+
+func (m *metricsDatastore) lastError() error {
+	return m.ds.lastError()
+}
+
+// FlushErrors returns the last error of ds and flushes it.
+func (m *metricsDatastore) FlushErrors() error {
+	return m.ds.FlushErrors()
+}
+
+// Ready returns the readiness of ds, measured.
+func (m *metricsDatastore) Ready() error {
+	start := time.Now()
+	err := m.ds.Ready()
+	datastoreDuration.Observe(time.Since(start).Seconds(), "Ready")
+	if err != nil {
+		datastoreErrors.Inc("Ready")
+	}
+	return err
+}
+
+// Counts returns the counts of ds, measured.
+func (m *metricsDatastore) Counts() (int, int, error) {
+	start := time.Now()
+	bookmarks, folders, err := m.ds.Counts()
+	datastoreDuration.Observe(time.Since(start).Seconds(), "Counts")
+	if err != nil {
+		datastoreErrors.Inc("Counts")
+	}
+	return bookmarks, folders, err
+}
+
+// The Datastore methods.
+
+func (m *metricsDatastore) SearchBookmarks(search string) []*types.Bookmark {
+	defer m.observe("SearchBookmarks", time.Now(), m.ds.lastError())
+	return m.ds.SearchBookmarks(search)
+}
+
+func (m *metricsDatastore) GetAllBookmarks() []*types.Bookmark {
+	defer m.observe("GetAllBookmarks", time.Now(), m.ds.lastError())
+	return m.ds.GetAllBookmarks()
+}
+
+func (m *metricsDatastore) GetBookmark(id int) *types.Bookmark {
+	defer m.observe("GetBookmark", time.Now(), m.ds.lastError())
+	return m.ds.GetBookmark(id)
+}
+
+func (m *metricsDatastore) GetBookmarkByKeyword(keyword string) *types.Bookmark {
+	defer m.observe("GetBookmarkByKeyword", time.Now(), m.ds.lastError())
+	return m.ds.GetBookmarkByKeyword(keyword)
+}
+
+func (m *metricsDatastore) GetFolderBookmarks(id int) []*types.Bookmark {
+	defer m.observe("GetFolderBookmarks", time.Now(), m.ds.lastError())
+	return m.ds.GetFolderBookmarks(id)
+}
+
+func (m *metricsDatastore) GetNoIconBookmarks() []*types.Bookmark {
+	defer m.observe("GetNoIconBookmarks", time.Now(), m.ds.lastError())
+	return m.ds.GetNoIconBookmarks()
+}
+
+func (m *metricsDatastore) GetStarredBookmarks() []*types.Bookmark {
+	defer m.observe("GetStarredBookmarks", time.Now(), m.ds.lastError())
+	return m.ds.GetStarredBookmarks()
+}
+
+func (m *metricsDatastore) QueryBookmarks(q types.BookmarkQuery) []*types.Bookmark {
+	defer m.observe("QueryBookmarks", time.Now(), m.ds.lastError())
+	return m.ds.QueryBookmarks(q)
+}
+
+func (m *metricsDatastore) SaveBookmark(b *types.Bookmark) int64 {
+	defer m.observe("SaveBookmark", time.Now(), m.ds.lastError())
+	return m.ds.SaveBookmark(b)
+}
+
+func (m *metricsDatastore) UpdateBookmark(b *types.Bookmark) {
+	defer m.observe("UpdateBookmark", time.Now(), m.ds.lastError())
+	m.ds.UpdateBookmark(b)
+}
+
+func (m *metricsDatastore) DeleteBookmark(b *types.Bookmark) {
+	defer m.observe("DeleteBookmark", time.Now(), m.ds.lastError())
+	m.ds.DeleteBookmark(b)
+}
+
+func (m *metricsDatastore) VisitBookmark(id int) {
+	defer m.observe("VisitBookmark", time.Now(), m.ds.lastError())
+	m.ds.VisitBookmark(id)
+}
+
+func (m *metricsDatastore) GetBookmarkVisits(id int) []types.Visit {
+	defer m.observe("GetBookmarkVisits", time.Now(), m.ds.lastError())
+	return m.ds.GetBookmarkVisits(id)
+}
+
+func (m *metricsDatastore) FindBookmarksByURL(url string) []*types.Bookmark {
+	defer m.observe("FindBookmarksByURL", time.Now(), m.ds.lastError())
+	return m.ds.FindBookmarksByURL(url)
+}
+
+func (m *metricsDatastore) GetDuplicateBookmarks() [][]*types.Bookmark {
+	defer m.observe("GetDuplicateBookmarks", time.Now(), m.ds.lastError())
+	return m.ds.GetDuplicateBookmarks()
+}
+
+func (m *metricsDatastore) MergeBookmarks(b *types.Bookmark, duplicates []*types.Bookmark) {
+	defer m.observe("MergeBookmarks", time.Now(), m.ds.lastError())
+	m.ds.MergeBookmarks(b, duplicates)
+}
+
+func (m *metricsDatastore) PositionBookmark(b *types.Bookmark, position int) {
+	defer m.observe("PositionBookmark", time.Now(), m.ds.lastError())
+	m.ds.PositionBookmark(b, position)
+}
+
+func (m *metricsDatastore) ApplyBatch(ops []types.BatchOperation) {
+	defer m.observe("ApplyBatch", time.Now(), m.ds.lastError())
+	m.ds.ApplyBatch(ops)
+}
+
+func (m *metricsDatastore) GetTags() []string {
+	defer m.observe("GetTags", time.Now(), m.ds.lastError())
+	return m.ds.GetTags()
+}
+
+func (m *metricsDatastore) GetFolder(id int) *types.Folder {
+	defer m.observe("GetFolder", time.Now(), m.ds.lastError())
+	return m.ds.GetFolder(id)
+}
+
+func (m *metricsDatastore) GetFolderSubfolders(id int) []*types.Folder {
+	defer m.observe("GetFolderSubfolders", time.Now(), m.ds.lastError())
+	return m.ds.GetFolderSubfolders(id)
+}
+
+func (m *metricsDatastore) GetRootFolders() []*types.Folder {
+	defer m.observe("GetRootFolders", time.Now(), m.ds.lastError())
+	return m.ds.GetRootFolders()
+}
+
+func (m *metricsDatastore) SaveFolder(f *types.Folder) int64 {
+	defer m.observe("SaveFolder", time.Now(), m.ds.lastError())
+	return m.ds.SaveFolder(f)
+}
+
+func (m *metricsDatastore) UpdateFolder(f *types.Folder) {
+	defer m.observe("UpdateFolder", time.Now(), m.ds.lastError())
+	m.ds.UpdateFolder(f)
+}
+
+func (m *metricsDatastore) DeleteFolder(f *types.Folder) {
+	defer m.observe("DeleteFolder", time.Now(), m.ds.lastError())
+	m.ds.DeleteFolder(f)
+}
+
+func (m *metricsDatastore) PositionFolder(f *types.Folder, position int) {
+	defer m.observe("PositionFolder", time.Now(), m.ds.lastError())
+	m.ds.PositionFolder(f, position)
+}
+
+func (m *metricsDatastore) SortFolder(id int, mode string) {
+	defer m.observe("SortFolder", time.Now(), m.ds.lastError())
+	m.ds.SortFolder(id, mode)
+}
+
+func (m *metricsDatastore) GetShare(token string) *types.Share {
+	defer m.observe("GetShare", time.Now(), m.ds.lastError())
+	return m.ds.GetShare(token)
+}
+
+func (m *metricsDatastore) GetShares() []*types.Share {
+	defer m.observe("GetShares", time.Now(), m.ds.lastError())
+	return m.ds.GetShares()
+}
+
+func (m *metricsDatastore) SaveShare(s *types.Share) {
+	defer m.observe("SaveShare", time.Now(), m.ds.lastError())
+	m.ds.SaveShare(s)
+}
+
+func (m *metricsDatastore) DeleteShare(token string) {
+	defer m.observe("DeleteShare", time.Now(), m.ds.lastError())
+	m.ds.DeleteShare(token)
+}
+
+func (m *metricsDatastore) GetSyncTree() *types.SyncFolder {
+	defer m.observe("GetSyncTree", time.Now(), m.ds.lastError())
+	return m.ds.GetSyncTree()
+}
+
+func (m *metricsDatastore) ApplySyncTree(tree *types.SyncFolder) {
+	defer m.observe("ApplySyncTree", time.Now(), m.ds.lastError())
+	m.ds.ApplySyncTree(tree)
+}
+
+func (m *metricsDatastore) Backup(path string) {
+	defer m.observe("Backup", time.Now(), m.ds.lastError())
+	m.ds.Backup(path)
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"bufio"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/metrics"
+)
+
+// metricValue returns the exposed value of the sample of the given name and labels,
+// 0 if not exposed.
+func metricValue(t *testing.T, sample string) float64 {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), sample+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(scanner.Text(), sample+" "), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetricsDatastore(t *testing.T) {
+	db := newTestDatastore(t)
+	m := NewMetricsDatastore(db)
+
+	tests := []struct {
+		name    string
+		method  string
+		f       func()
+		errors  float64
+		wantErr bool
+	}{
+		{"found", "GetFolder", func() { m.GetFolder(1) }, 0, false},
+		// A missing item is not an error of the datastore.
+		{"missing item", "GetBookmark", func() { m.GetBookmark(99) }, 0, true},
+		{"session", "GetAllBookmarks", func() { m.Session().GetAllBookmarks() }, 0, false},
+		{"error", "GetStarredBookmarks", func() {
+			db.Close()
+			m.GetStarredBookmarks()
+		}, 1, true},
+		// Counted once, by the call in error.
+		{"past error", "GetTags", func() {
+			m.GetStarredBookmarks()
+			m.GetTags()
+		}, 0, true},
+	}
+	for _, tt := range tests {
+		calls := `gobkm_datastore_duration_seconds_count{method="` + tt.method + `"}`
+		errors := `gobkm_datastore_errors_total{method="` + tt.method + `"}`
+		callsBefore, errorsBefore := metricValue(t, calls), metricValue(t, errors)
+		tt.f()
+		if err := m.FlushErrors(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if got := fmt.Sprint(metricValue(t, calls)-callsBefore, metricValue(t, errors)-errorsBefore); got != fmt.Sprint(1, tt.errors) {
+			t.Errorf("%s: %s calls and errors %s, want %s", tt.name, tt.method, got, fmt.Sprint(1, tt.errors))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// migrations are the database schema changes, applied in order
+// on top of the tables created by CreateDatabase.
+// The database user_version is the number of migrations applied.
+var migrations = []string{
+	// 1: bookmarks and folders timestamps, unix times in seconds.
+	// The existing rows are considered created and updated now.
+	`ALTER TABLE folder ADD COLUMN createdAt integer NOT NULL DEFAULT 0;
+	ALTER TABLE folder ADD COLUMN updatedAt integer NOT NULL DEFAULT 0;
+	ALTER TABLE bookmark ADD COLUMN createdAt integer NOT NULL DEFAULT 0;
+	ALTER TABLE bookmark ADD COLUMN updatedAt integer NOT NULL DEFAULT 0;
+	ALTER TABLE bookmark ADD COLUMN lastVisitedAt integer;
+	UPDATE folder SET createdAt=strftime('%s','now'), updatedAt=strftime('%s','now');
+	UPDATE bookmark SET createdAt=strftime('%s','now'), updatedAt=strftime('%s','now');`,
+	// 2: bookmarks Markdown descriptions.
+	`ALTER TABLE bookmark ADD COLUMN description string NOT NULL DEFAULT '';`,
+	// 3: manual order positions within the parent folder, initialized
+	// in title order, folders sort modes and bookmarks visit counts.
+	`ALTER TABLE folder ADD COLUMN position real NOT NULL DEFAULT 0;
+	ALTER TABLE folder ADD COLUMN sortMode string NOT NULL DEFAULT '';
+	ALTER TABLE bookmark ADD COLUMN position real NOT NULL DEFAULT 0;
+	ALTER TABLE bookmark ADD COLUMN visitCount integer NOT NULL DEFAULT 0;
+	UPDATE folder SET position=(SELECT COUNT(*) FROM folder f WHERE f.parentFolderId IS folder.parentFolderId AND (f.title < folder.title OR (f.title = folder.title AND f.id <= folder.id)));
+	UPDATE bookmark SET position=(SELECT COUNT(*) FROM bookmark b WHERE b.folderId IS bookmark.folderId AND (b.title < bookmark.title OR (b.title = bookmark.title AND b.id <= bookmark.id)));`,
+	// 4: bookmarks visits per day, days as YYYY-MM-DD local dates.
+	// The known visits are recorded on the last visit day.
+	`CREATE TABLE visit ( bookmarkId integer NOT NULL, day string NOT NULL, count integer NOT NULL DEFAULT 0, PRIMARY KEY (bookmarkId, day), FOREIGN KEY (bookmarkId) references bookmark(id) ON DELETE CASCADE);
+	INSERT INTO visit(bookmarkId, day, count) SELECT id, date(lastVisitedAt, 'unixepoch', 'localtime'), MAX(visitCount, 1) FROM bookmark WHERE lastVisitedAt IS NOT NULL;`,
+	// 5: bookmarks canonical URLs, set by canonicalizeURLs.
+	`ALTER TABLE bookmark ADD COLUMN canonicalURL string NOT NULL DEFAULT '';
+	CREATE INDEX bookmark_canonicalURL ON bookmark(canonicalURL);`,
+	// 6: bookmarks lowercase keywords, unique when set.
+	`ALTER TABLE bookmark ADD COLUMN keyword string NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX bookmark_keyword ON bookmark(keyword) WHERE keyword != '';`,
+	// 7: bookmarks reading list unread and archived states.
+	`ALTER TABLE bookmark ADD COLUMN unread integer NOT NULL DEFAULT 0;
+	ALTER TABLE bookmark ADD COLUMN archived integer NOT NULL DEFAULT 0;`,
+	// 8: smart folders queries.
+	`ALTER TABLE folder ADD COLUMN query string NOT NULL DEFAULT '';`,
+	// 9: bookmarks tags, comma separated.
+	`ALTER TABLE bookmark ADD COLUMN tags string NOT NULL DEFAULT '';`,
+	// 10: folders public share links, deleted when revoked.
+	`CREATE TABLE share ( token string PRIMARY KEY, folderId integer NOT NULL, createdAt integer NOT NULL, expiresAt integer, FOREIGN KEY (folderId) references folder(id) ON DELETE CASCADE);`,
+	// 11: folders and bookmarks sync ids, never reused.
+	`CREATE TABLE syncid ( id integer PRIMARY KEY AUTOINCREMENT, folderId integer UNIQUE, bookmarkId integer UNIQUE, FOREIGN KEY (folderId) references folder(id) ON DELETE CASCADE, FOREIGN KEY (bookmarkId) references bookmark(id) ON DELETE CASCADE);`,
+}
+
+// migrateDatabase applies the migrations not applied yet,
+// each one in its own transaction.
+func (db *SQLiteDataStore) migrateDatabase() {
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	var (
+		version int
+		tx      *sql.Tx
+	)
+	if db.err = db.QueryRow("PRAGMA user_version").Scan(&version); db.err != nil {
+		log.Error("migrateDatabase: error getting the database version")
+		return
+	}
+
+	for ; version < len(migrations); version++ {
+		log.WithFields(log.Fields{
+			"version": version + 1,
+		}).Info("migrateDatabase: applying migration")
+
+		if tx, db.err = db.Begin(); db.err != nil {
+			log.Error("migrateDatabase: transaction begin failed")
+			return
+		}
+		if _, db.err = tx.Exec(migrations[version]); db.err == nil {
+			// PRAGMA does not accept parameters.
+			_, db.err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(version+1))
+		}
+		if db.err != nil {
+			log.WithFields(log.Fields{
+				"version": version + 1,
+				"err":     db.err,
+			}).Error("migrateDatabase: migration error")
+			if err := tx.Rollback(); err != nil {
+				// Just logging the error.
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("migrateDatabase: transaction rollback error")
+			}
+			return
+		}
+		if db.err = tx.Commit(); db.err != nil {
+			log.Error("migrateDatabase: transaction commit error")
+			return
+		}
+	}
+}
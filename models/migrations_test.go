@@ -0,0 +1,67 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := NewDBstore(filepath.Join(dir, "bkm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A database of the first GoBkm version.
+	for _, query := range []string{
+		"CREATE TABLE folder ( id integer PRIMARY KEY, title string NOT NULL, parentFolderId integer, nbChildrenFolders integer, FOREIGN KEY (parentFolderId) references folder(id) ON DELETE CASCADE)",
+		"CREATE TABLE bookmark ( id integer PRIMARY KEY, title string NOT NULL, url string NOT NULL, favicon string, starred integer, folderId integer, FOREIGN KEY (folderId) references folder(id) ON DELETE CASCADE)",
+		`INSERT INTO folder(id, title) values(1, "/")`,
+		`INSERT INTO folder(id, title, parentFolderId) values(2, "Zeta", 1), (3, "Alpha", 1)`,
+		`INSERT INTO bookmark(id, title, url, favicon, starred, folderId) values(1, "Go", "https://golang.org/?utm_source=feed", "", 0, 2), (2, "C", "https://c.example.com/", "", 1, 2)`,
+	} {
+		if _, err = db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Migrating twice, the second time doing nothing.
+	for i := 0; i < 2; i++ {
+		db.CreateDatabase()
+		if err = db.FlushErrors(); err != nil {
+			t.Fatalf("CreateDatabase %d error %v", i, err)
+		}
+		if v := count(t, db, "PRAGMA user_version"); v != len(migrations) {
+			t.Errorf("CreateDatabase %d: user_version %d, want %d", i, v, len(migrations))
+		}
+	}
+
+	// The existing rows are kept and completed.
+	if n := count(t, db, "SELECT COUNT(*) FROM folder"); n != 3 {
+		t.Errorf("%d folders, want 3", n)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM bookmark WHERE createdAt = 0 OR updatedAt = 0"); n != 0 {
+		t.Errorf("%d bookmarks without timestamps", n)
+	}
+	// The positions follow the titles.
+	if p := count(t, db, "SELECT position FROM folder WHERE id = 3"); p != 1 {
+		t.Errorf("Alpha folder position %d, want 1", p)
+	}
+	if p := count(t, db, "SELECT position FROM bookmark WHERE id = 1"); p != 2 {
+		t.Errorf("Go bookmark position %d, want 2", p)
+	}
+	bkm := db.GetBookmark(1)
+	if err = db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if bkm.CanonicalURL != "https://golang.org" {
+		t.Errorf("canonical URL %q, want %q", bkm.CanonicalURL, "https://golang.org")
+	}
+}
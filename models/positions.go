@@ -0,0 +1,228 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Bookmarks and folders are manually ordered by their real position column.
+// An item moved between two others takes the middle of their positions
+// so that a move updates a single row. When two positions become too close
+// the items of the folder are renumbered 1, 2, 3...
+
+// minPositionGap is the minimum gap between two positions before renumbering.
+const minPositionGap = 1e-9
+
+// parentColumns are the parent folder columns of the positioned tables.
+var parentColumns = map[string]string{
+	"bookmark": "folderId",
+	"folder":   "parentFolderId",
+}
+
+// endPosition returns the position after the last item of the given table
+// in the parent folder parentID, the item id excepted.
+func (db *SQLiteDataStore) endPosition(table string, parentID int, id int) float64 {
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return 0
+	}
+
+	var position float64
+	if db.err = db.QueryRow("SELECT COALESCE(MAX(position), 0) + 1 FROM "+table+" WHERE "+parentColumns[table]+" IS ? AND id!=?", parentID, id).Scan(&position); db.err != nil {
+		log.WithFields(log.Fields{
+			"table": table,
+			"err":   db.err,
+		}).Error("endPosition:SELECT query error")
+		return 0
+	}
+	return position
+}
+
+// renumberPositions sets the positions of the items of the given table
+// in the parent folder parentID to 1, 2, 3... keeping their order.
+func (db *SQLiteDataStore) renumberPositions(table string, parentID int) {
+	log.WithFields(log.Fields{
+		"table":    table,
+		"parentID": parentID,
+	}).Debug("renumberPositions")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	var (
+		rows *sql.Rows
+		ids  []int
+		tx   *sql.Tx
+	)
+	if rows, db.err = db.Query("SELECT id FROM "+table+" WHERE "+parentColumns[table]+" IS ? ORDER BY position, id", parentID); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("renumberPositions:SELECT query error")
+		return
+	}
+	for rows.Next() {
+		var id int
+		if db.err = rows.Scan(&id); db.err != nil {
+			rows.Close()
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("renumberPositions:error scanning the query result row")
+			return
+		}
+		ids = append(ids, id)
+	}
+	if db.err = rows.Err(); db.err != nil {
+		rows.Close()
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("renumberPositions:error looping rows")
+		return
+	}
+	rows.Close()
+
+	if tx, db.err = db.Begin(); db.err != nil {
+		log.Error("renumberPositions: transaction begin failed")
+		return
+	}
+	for i, id := range ids {
+		if _, db.err = tx.Exec("UPDATE "+table+" SET position=? WHERE id=?", i+1, id); db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("renumberPositions:UPDATE query error")
+			if err := tx.Rollback(); err != nil {
+				// Just logging the error.
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("renumberPositions: transaction rollback error")
+			}
+			return
+		}
+	}
+	if db.err = tx.Commit(); db.err != nil {
+		log.Error("renumberPositions: transaction commit error")
+	}
+}
+
+// setPosition moves the item id of the given table before the item beforeID
+// of the parent folder parentID, or after the last item if beforeID is 0
+// or not in the parent folder, and returns its new position.
+func (db *SQLiteDataStore) setPosition(table string, id int, parentID int, beforeID int) float64 {
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return 0
+	}
+
+	var (
+		position float64
+		next     float64
+		previous sql.NullFloat64
+	)
+	parentColumn := parentColumns[table]
+
+	if beforeID != 0 && beforeID != id {
+		db.err = db.QueryRow("SELECT position FROM "+table+" WHERE id=? AND "+parentColumn+" IS ?", beforeID, parentID).Scan(&next)
+		if db.err == sql.ErrNoRows {
+			db.err = nil
+			beforeID = 0
+		} else if db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("setPosition:SELECT next position query error")
+			return 0
+		}
+	}
+
+	switch {
+	case beforeID == id:
+		// Nothing to move.
+		if db.err = db.QueryRow("SELECT position FROM "+table+" WHERE id=?", id).Scan(&position); db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("setPosition:SELECT position query error")
+		}
+		return position
+	case beforeID == 0:
+		if position = db.endPosition(table, parentID, id); db.err != nil {
+			return 0
+		}
+	default:
+		if db.err = db.QueryRow("SELECT MAX(position) FROM "+table+" WHERE "+parentColumn+" IS ? AND position<? AND id!=?", parentID, next, id).Scan(&previous); db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("setPosition:SELECT previous position query error")
+			return 0
+		}
+		switch {
+		case !previous.Valid:
+			// First item.
+			position = next - 1
+		case next-previous.Float64 < minPositionGap:
+			// No room left between the two items.
+			db.renumberPositions(table, parentID)
+			return db.setPosition(table, id, parentID, beforeID)
+		default:
+			position = (previous.Float64 + next) / 2
+		}
+	}
+
+	if _, db.err = db.Exec("UPDATE "+table+" SET position=? WHERE id=?", position, id); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("setPosition:UPDATE query error")
+		return 0
+	}
+	return position
+}
+
+// PositionBookmark moves the given bookmark before the bookmark beforeID
+// of its folder, or after the last one if beforeID is 0.
+func (db *SQLiteDataStore) PositionBookmark(b *types.Bookmark, beforeID int) {
+	log.WithFields(log.Fields{
+		"b":        b,
+		"beforeID": beforeID,
+	}).Debug("PositionBookmark")
+
+	folderID := 1
+	if b.Folder != nil {
+		folderID = b.Folder.Id
+	}
+	b.Position = db.setPosition("bookmark", b.Id, folderID, beforeID)
+}
+
+// PositionFolder moves the given folder before the folder beforeID
+// of its parent, or after the last one if beforeID is 0.
+func (db *SQLiteDataStore) PositionFolder(f *types.Folder, beforeID int) {
+	log.WithFields(log.Fields{
+		"f":        f,
+		"beforeID": beforeID,
+	}).Debug("PositionFolder")
+
+	parentID := 1
+	if f.Parent != nil {
+		parentID = f.Parent.Id
+	}
+	f.Position = db.setPosition("folder", f.Id, parentID, beforeID)
+}
+
+// SortFolder sets the sort mode of the folder with the given id.
+func (db *SQLiteDataStore) SortFolder(id int, sortMode string) {
+	log.WithFields(log.Fields{
+		"id":       id,
+		"sortMode": sortMode,
+	}).Debug("SortFolder")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	// Executing the query.
+	if _, db.err = db.Exec("UPDATE folder SET sortMode=? WHERE id=?", sortMode, id); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("SortFolder:UPDATE query error")
+	}
+}
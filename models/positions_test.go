@@ -0,0 +1,75 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+func TestFolderContentOrder(t *testing.T) {
+	db := newTestDatastore(t)
+	parent := &types.Folder{Title: "parent"}
+	parent.Id = int(db.SaveFolder(parent))
+	// Saved in manual order c, a, b, c being the newest.
+	now := time.Now()
+	var folders []*types.Folder
+	for i, title := range []string{"c", "a", "b"} {
+		createdAt := now.Add(time.Duration([]int{2, 0, 1}[i]) * time.Hour)
+		fld := &types.Folder{Title: title, Parent: parent, CreatedAt: createdAt}
+		fld.Id = int(db.SaveFolder(fld))
+		folders = append(folders, fld)
+		db.SaveBookmark(&types.Bookmark{Title: title, URL: "https://" + title + ".example.com/", Folder: parent, CreatedAt: createdAt})
+	}
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sortMode string
+		// before moves the b folder before the c folder.
+		before bool
+		want   []string
+	}{
+		{"", false, []string{"a", "b", "c"}},
+		{types.SortTitle, false, []string{"a", "b", "c"}},
+		{types.SortManual, false, []string{"c", "a", "b"}},
+		{types.SortCreated, false, []string{"c", "b", "a"}},
+		{types.SortManual, true, []string{"b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		db.SortFolder(parent.Id, tt.sortMode)
+		if tt.before {
+			db.PositionFolder(folders[2], folders[0].Id)
+		}
+		var subfolders, bookmarks []string
+		for _, fld := range db.GetFolderSubfolders(parent.Id) {
+			subfolders = append(subfolders, fld.Title)
+		}
+		for _, bkm := range db.GetFolderBookmarks(parent.Id) {
+			bookmarks = append(bookmarks, bkm.Title)
+		}
+		if err := db.FlushErrors(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(subfolders, tt.want) {
+			t.Errorf("sort mode %q: subfolders %v, want %v", tt.sortMode, subfolders, tt.want)
+		}
+		if !tt.before && !reflect.DeepEqual(bookmarks, tt.want) {
+			t.Errorf("sort mode %q: bookmarks %v, want %v", tt.sortMode, bookmarks, tt.want)
+		}
+	}
+
+	// The root folders are sorted by the root folder sort mode.
+	db.SortFolder(1, types.SortManual)
+	last := &types.Folder{Title: "0 last"}
+	db.SaveFolder(last)
+	roots := db.GetRootFolders()
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[1].Title != last.Title {
+		t.Errorf("manual root folders %v, want %s last", roots, last.Title)
+	}
+}
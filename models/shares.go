@@ -0,0 +1,146 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// shareColumns are the share columns scanned by scanShare.
+const shareColumns = "token, folderId, createdAt, expiresAt"
+
+// scanShare scans a row of shareColumns into a new Share
+// and returns it with its folder id. The folder is not retrieved.
+func scanShare(s scanner) (*types.Share, int, error) {
+	var (
+		folderID  int
+		createdAt int64
+		expiresAt sql.NullInt64
+	)
+	sh := new(types.Share)
+	if err := s.Scan(&sh.Token, &folderID, &createdAt, &expiresAt); err != nil {
+		return nil, 0, err
+	}
+	sh.CreatedAt = unixTime(createdAt)
+	sh.ExpiresAt = unixTime(expiresAt.Int64)
+	return sh, folderID, nil
+}
+
+// GetShare returns the share with the given token, with its folder,
+// or nil if there is none or if it has expired.
+func (db *SQLiteDataStore) GetShare(token string) *types.Share {
+	log.WithFields(log.Fields{
+		"token": token,
+	}).Debug("GetShare")
+	// Leaving silently on past errors...
+	if db.err != nil || token == "" {
+		return nil
+	}
+
+	var (
+		sh       *types.Share
+		folderID int
+	)
+	sh, folderID, db.err = scanShare(db.QueryRow("SELECT "+shareColumns+" FROM share WHERE token=? AND (expiresAt IS NULL OR expiresAt > ?)", token, time.Now().Unix()))
+	switch {
+	case db.err == sql.ErrNoRows:
+		// Not an error, shares are looked up by untrusted tokens.
+		db.err = nil
+		return nil
+	case db.err != nil:
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetShare:SELECT query error")
+		return nil
+	}
+	sh.Folder = db.GetFolder(folderID)
+	return sh
+}
+
+// GetShares returns the shares not expired, with their folder, the last created first.
+func (db *SQLiteDataStore) GetShares() []*types.Share {
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return nil
+	}
+
+	var (
+		rows      *sql.Rows
+		shs       []*types.Share
+		folderIDs []int
+	)
+	if rows, db.err = db.Query("SELECT "+shareColumns+" FROM share WHERE expiresAt IS NULL OR expiresAt > ? ORDER BY createdAt DESC", time.Now().Unix()); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetShares:SELECT query error")
+		return nil
+	}
+	for rows.Next() {
+		var (
+			sh       *types.Share
+			folderID int
+		)
+		if sh, folderID, db.err = scanShare(rows); db.err != nil {
+			rows.Close()
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("GetShares:error scanning the query result row")
+			return nil
+		}
+		shs = append(shs, sh)
+		folderIDs = append(folderIDs, folderID)
+	}
+	if db.err = rows.Err(); db.err != nil {
+		rows.Close()
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetShares:error looping rows")
+		return nil
+	}
+	// Building the folders once the rows are read,
+	// GetFolder running queries itself.
+	rows.Close()
+	for i, sh := range shs {
+		sh.Folder = db.GetFolder(folderIDs[i])
+	}
+	return shs
+}
+
+// SaveShare saves the given new share.
+// CreatedAt is set to now.
+func (db *SQLiteDataStore) SaveShare(sh *types.Share) {
+	log.WithFields(log.Fields{
+		"sh": sh,
+	}).Debug("SaveShare")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	sh.CreatedAt = time.Now()
+	if _, db.err = db.Exec("INSERT INTO share(token, folderId, createdAt, expiresAt) values(?,?,?,?)", sh.Token, sh.Folder.Id, sh.CreatedAt.Unix(), nullUnixTime(sh.ExpiresAt)); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("SaveShare:INSERT query error")
+	}
+}
+
+// DeleteShare revokes the share with the given token.
+func (db *SQLiteDataStore) DeleteShare(token string) {
+	log.WithFields(log.Fields{
+		"token": token,
+	}).Debug("DeleteShare")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	if _, db.err = db.Exec("DELETE FROM share WHERE token=?", token); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("DeleteShare:DELETE query error")
+	}
+}
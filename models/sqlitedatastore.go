@@ -2,6 +2,9 @@ package models
 
 import (
 	"database/sql"
+	"sort"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	_ "github.com/mattn/go-sqlite3" // register sqlite3 driver
@@ -10,6 +13,32 @@ import (
 
 const (
 	dbdriver = "sqlite3"
+	// bookmarkColumns are the bookmark columns scanned by scanBookmark.
+	bookmarkColumns = "id, title, url, favicon, starred, folderId, createdAt, updatedAt, lastVisitedAt, description, visitCount, position, canonicalURL, keyword, unread, archived, tags"
+	// folderColumns are the folder columns scanned by scanFolder.
+	folderColumns = "id, title, parentFolderId, nbChildrenFolders, createdAt, updatedAt, position, sortMode, query"
+	// subtreeFolders selects the ids of the folder of the ? id and of its subfolders.
+	subtreeFolders = "WITH RECURSIVE subtree(id) AS (SELECT ? UNION SELECT f.id FROM folder f JOIN subtree ON f.parentFolderId=subtree.id) SELECT id FROM subtree"
+)
+
+// The deletions of the bookmark or folder of the ? id with their dependent rows,
+// not relying on the foreign keys cascades, off on the databases
+// opened without _foreign_keys=1. The folders are deleted with their
+// subfolders and bookmarks.
+var (
+	deleteBookmarkQueries = []string{
+		"DELETE FROM visit WHERE bookmarkId=?",
+		"DELETE FROM syncid WHERE bookmarkId=?",
+		"DELETE FROM bookmark WHERE id=?",
+	}
+	deleteFolderQueries = []string{
+		"DELETE FROM visit WHERE bookmarkId IN (SELECT id FROM bookmark WHERE folderId IN (" + subtreeFolders + "))",
+		"DELETE FROM syncid WHERE bookmarkId IN (SELECT id FROM bookmark WHERE folderId IN (" + subtreeFolders + "))",
+		"DELETE FROM bookmark WHERE folderId IN (" + subtreeFolders + ")",
+		"DELETE FROM syncid WHERE folderId IN (" + subtreeFolders + ")",
+		"DELETE FROM share WHERE folderId IN (" + subtreeFolders + ")",
+		"DELETE FROM folder WHERE id IN (" + subtreeFolders + ")",
+	}
 )
 
 // SQLiteDataStore implements the Datastore interface
@@ -17,10 +46,13 @@ const (
 type SQLiteDataStore struct {
 	*sql.DB
 	err error
+	// Canonicalizer computes the bookmarks canonical URLs.
+	Canonicalizer Canonicalizer
 }
 
 // NewDBstore returns a database connection to the given dataSourceName
-// ie. a path to the sqlite database file.
+// ie. a path to the sqlite database file. The foreign keys are enabled
+// on each connection of the pool.
 func NewDBstore(dataSourceName string) (*SQLiteDataStore, error) {
 	log.WithFields(log.Fields{
 		"dataSourceName": dataSourceName,
@@ -31,13 +63,35 @@ func NewDBstore(dataSourceName string) (*SQLiteDataStore, error) {
 		err error
 	)
 
-	if db, err = sql.Open(dbdriver, dataSourceName); err != nil {
+	sep := "?"
+	if strings.Contains(dataSourceName, "?") {
+		sep = "&"
+	}
+	if db, err = sql.Open(dbdriver, dataSourceName+sep+"_foreign_keys=1"); err != nil {
 		log.WithFields(log.Fields{
 			"dataSourceName": dataSourceName,
 		}).Error("NewDBstore:error opening the database")
 		return nil, err
 	}
-	return &SQLiteDataStore{db, nil}, nil
+	return &SQLiteDataStore{DB: db, Canonicalizer: DefaultCanonicalizer}, nil
+}
+
+// Session returns a datastore sharing the database connections
+// with its own error, for the background jobs not to mix their errors
+// with the ones of the requests.
+func (db *SQLiteDataStore) Session() Datastore {
+	return &SQLiteDataStore{DB: db.DB, Canonicalizer: db.Canonicalizer}
+}
+
+// Canonicalize returns the canonical form of the given URL, as stored
+// in the bookmarks canonical URLs.
+func (db *SQLiteDataStore) Canonicalize(url string) string {
+	return db.Canonicalizer.Canonicalize(url)
+}
+
+// lastError returns the last DB error without flushing it.
+func (db *SQLiteDataStore) lastError() error {
+	return db.err
 }
 
 // FlushErrors returns the last DB errors and flushes it.
@@ -53,11 +107,6 @@ func (db *SQLiteDataStore) FlushErrors() error {
 // CreateDatabase creates the database tables.
 func (db *SQLiteDataStore) CreateDatabase() {
 	log.Info("Creating database")
-	// Activate the foreign keys feature.
-	if _, db.err = db.Exec("PRAGMA foreign_keys = ON"); db.err != nil {
-		log.Error("CreateDatabase: error executing the PRAGMA request")
-		return
-	}
 
 	// Tables creation if needed.
 	if _, db.err = db.Exec("CREATE TABLE IF NOT EXISTS folder ( id integer PRIMARY KEY, title string NOT NULL, parentFolderId integer, nbChildrenFolders integer, FOREIGN KEY (parentFolderId) references folder(id) ON DELETE CASCADE)"); db.err != nil {
@@ -68,6 +117,14 @@ func (db *SQLiteDataStore) CreateDatabase() {
 		log.Error("CreateDatabase: error executing the CREATE TABLE request for table bookmark")
 		return
 	}
+	// Schema changes since the tables creation.
+	if db.migrateDatabase(); db.err != nil {
+		return
+	}
+	// Canonical URLs of the new bookmarks or of a new Canonicalizer.
+	if db.canonicalizeURLs(); db.err != nil {
+		return
+	}
 	// Looking for folders.
 	var count int
 	if db.err = db.QueryRow("SELECT COUNT(*) as count FROM folder").Scan(&count); db.err != nil {
@@ -79,7 +136,7 @@ func (db *SQLiteDataStore) CreateDatabase() {
 		log.Info("CreateDatabase: folder table not empty, leaving")
 		return
 	}
-	if _, db.err = db.Exec("INSERT INTO folder(id, title) values(\"1\", \"/\")"); db.err != nil {
+	if _, db.err = db.Exec("INSERT INTO folder(id, title, nbChildrenFolders, createdAt, updatedAt) values(\"1\", \"/\", 0, strftime('%s','now'), strftime('%s','now'))"); db.err != nil {
 		log.Error("CreateDatabase: error inserting the root folder")
 		return
 	}
@@ -124,6 +181,87 @@ func (db *SQLiteDataStore) PopulateDatabase() {
 	}
 }
 
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// unixTime returns the time of the given unix time in seconds,
+// or the zero time for 0.
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// nullUnixTime returns the unix time in seconds of t,
+// or nil (NULL) for the zero time.
+func nullUnixTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+// joinTags returns the given tags as stored in the tags column.
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// splitTags returns the tags of the given tags column.
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+// scanBookmark scans a row of bookmarkColumns into a new Bookmark
+// and returns it with its folder id. The folder is not retrieved.
+func scanBookmark(s scanner) (*types.Bookmark, int, error) {
+	var (
+		folderID      sql.NullInt64
+		starred       sql.NullInt64
+		createdAt     int64
+		updatedAt     int64
+		lastVisitedAt sql.NullInt64
+		tags          string
+	)
+	bkm := new(types.Bookmark)
+	if err := s.Scan(&bkm.Id, &bkm.Title, &bkm.URL, &bkm.Favicon, &starred, &folderID, &createdAt, &updatedAt, &lastVisitedAt, &bkm.Description, &bkm.VisitCount, &bkm.Position, &bkm.CanonicalURL, &bkm.Keyword, &bkm.Unread, &bkm.Archived, &tags); err != nil {
+		return nil, 0, err
+	}
+	bkm.Tags = splitTags(tags)
+	// Starred bookmark ?
+	if int(starred.Int64) != 0 {
+		bkm.Starred = true
+	}
+	bkm.CreatedAt = unixTime(createdAt)
+	bkm.UpdatedAt = unixTime(updatedAt)
+	bkm.LastVisitedAt = unixTime(lastVisitedAt.Int64)
+	return bkm, int(folderID.Int64), nil
+}
+
+// scanFolder scans a row of folderColumns into a new Folder
+// and returns it with its parent folder id. The parent is not retrieved.
+func scanFolder(s scanner) (*types.Folder, int, error) {
+	var (
+		parentFldID       sql.NullInt64
+		nbChildrenFolders sql.NullInt64
+		createdAt         int64
+		updatedAt         int64
+	)
+	fld := new(types.Folder)
+	if err := s.Scan(&fld.Id, &fld.Title, &parentFldID, &nbChildrenFolders, &createdAt, &updatedAt, &fld.Position, &fld.SortMode, &fld.Query); err != nil {
+		return nil, 0, err
+	}
+	fld.NbChildrenFolders = int(nbChildrenFolders.Int64)
+	fld.CreatedAt = unixTime(createdAt)
+	fld.UpdatedAt = unixTime(updatedAt)
+	return fld, int(parentFldID.Int64), nil
+}
+
 // GetBookmark returns a Bookmark instance with the given id.
 func (db *SQLiteDataStore) GetBookmark(id int) *types.Bookmark {
 	log.WithFields(log.Fields{
@@ -134,14 +272,12 @@ func (db *SQLiteDataStore) GetBookmark(id int) *types.Bookmark {
 		return nil
 	}
 
+	// Querying the bookmark.
 	var (
-		folderID sql.NullInt64
-		starred  sql.NullInt64
+		bkm      *types.Bookmark
+		folderID int
 	)
-
-	// Querying the bookmark.
-	bkm := new(types.Bookmark)
-	db.err = db.QueryRow("SELECT id, title, url, favicon, starred, folderId FROM bookmark WHERE id=?", id).Scan(&bkm.Id, &bkm.Title, &bkm.URL, &bkm.Favicon, &starred, &folderID)
+	bkm, folderID, db.err = scanBookmark(db.QueryRow("SELECT "+bookmarkColumns+" FROM bookmark WHERE id=?", id))
 	switch {
 	case db.err == sql.ErrNoRows:
 		log.WithFields(log.Fields{
@@ -160,13 +296,9 @@ func (db *SQLiteDataStore) GetBookmark(id int) *types.Bookmark {
 			"folderId": folderID,
 			"Favicon":  bkm.Favicon,
 		}).Debug("GetBookmark:bookmark found")
-		// Starred bookmark ?
-		if int(starred.Int64) != 0 {
-			bkm.Starred = true
-		}
 		// Retrieving the parent folder if it is not the root (/).
-		if folderID.Int64 != 0 {
-			bkm.Folder = db.GetFolder(int(folderID.Int64))
+		if folderID != 0 {
+			bkm.Folder = db.GetFolder(folderID)
 			if db.err != nil {
 				log.WithFields(log.Fields{
 					"err": db.err,
@@ -189,9 +321,11 @@ func (db *SQLiteDataStore) GetFolder(id int) *types.Folder {
 	}
 
 	// Querying the folder.
-	var parentFldID sql.NullInt64
-	fld := new(types.Folder)
-	db.err = db.QueryRow("SELECT id, title, parentFolderId FROM folder WHERE id=?", id).Scan(&fld.Id, &fld.Title, &parentFldID)
+	var (
+		fld         *types.Folder
+		parentFldID int
+	)
+	fld, parentFldID, db.err = scanFolder(db.QueryRow("SELECT "+folderColumns+" FROM folder WHERE id=?", id))
 	switch {
 	case db.err == sql.ErrNoRows:
 		log.WithFields(log.Fields{
@@ -210,432 +344,373 @@ func (db *SQLiteDataStore) GetFolder(id int) *types.Folder {
 			"parentFldId": parentFldID,
 		}).Debug("GetFolder:folder found")
 		// recursively retrieving the parents
-		if parentFldID.Int64 != 0 {
-			fld.Parent = db.GetFolder(int(parentFldID.Int64))
+		if parentFldID != 0 {
+			fld.Parent = db.GetFolder(parentFldID)
 		}
 	}
 	return fld
 }
 
-// GetStarredBookmarks returns the starred bookmarks.
-func (db *SQLiteDataStore) GetStarredBookmarks() []*types.Bookmark {
+// queryBookmarks returns the bookmarks of the given query selecting bookmarkColumns.
+// With withFolder the bookmarks folders are retrieved.
+// functionName is used for logging.
+func (db *SQLiteDataStore) queryBookmarks(functionName string, withFolder bool, query string, args ...interface{}) []*types.Bookmark {
 	// Leaving silently on past errors...
 	if db.err != nil {
 		return nil
 	}
-
-	// Querying the bookmarks.
 	var (
 		rows *sql.Rows
 		bkms []*types.Bookmark
 	)
-	rows, db.err = db.Query("SELECT id, title, url, favicon, starred, folderId FROM bookmark WHERE starred ORDER BY title")
-	defer func() {
-		if db.err = rows.Close(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetStarredBookmarks:error closing rows")
-		}
-	}()
 
-	switch {
-	case db.err == sql.ErrNoRows:
-		log.Debug("GetStarredBookmarks:no bookmarks")
-		return nil
-	case db.err != nil:
+	// Querying the bookmarks.
+	if rows, db.err = db.Query(query, args...); db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
-		}).Error("GetStarredBookmarks:SELECT query error")
-		return nil
-	default:
-		for rows.Next() {
-			// Building a new Bookmark instance with each row.
-			bkm := new(types.Bookmark)
-			var fldID sql.NullInt64
-			db.err = rows.Scan(&bkm.Id, &bkm.Title, &bkm.URL, &bkm.Favicon, &bkm.Starred, &fldID)
-			if db.err != nil {
-				log.WithFields(log.Fields{
-					"err": db.err,
-				}).Error("GetStarredBookmarks:error scanning the query result row")
-				return nil
-			}
-			// Retrieving the bookmark folder.
-			bkm.Folder = db.GetFolder(int(fldID.Int64))
-			bkms = append(bkms, bkm)
-		}
-		if db.err = rows.Err(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetStarredBookmarks:error looping rows")
-			return nil
-		}
-		return bkms
-	}
-}
-
-// GetNoIconBookmarks returns the bookmarks with no favicon.
-func (db *SQLiteDataStore) GetNoIconBookmarks() []*types.Bookmark {
-	// Leaving silently on past errors...
-	if db.err != nil {
+		}).Error(functionName + ":SELECT query error")
 		return nil
 	}
-	var (
-		rows *sql.Rows
-		bkms []*types.Bookmark
-	)
-
-	// Querying the bookmarks.
-	rows, db.err = db.Query("SELECT id, title, url, favicon, starred, folderId FROM bookmark WHERE favicon='' ORDER BY title")
 	defer func() {
-		if db.err = rows.Close(); db.err != nil {
+		if err := rows.Close(); err != nil {
 			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetNoIconBookmarks:error closing rows")
+				"err": err,
+			}).Error(functionName + ":error closing rows")
 		}
 	}()
 
-	switch {
-	case db.err == sql.ErrNoRows:
-		log.Debug("GetNoIconBookmarks:no bookmarks")
-		return nil
-	case db.err != nil:
-		log.WithFields(log.Fields{
-			"err": db.err,
-		}).Error("GetNoIconBookmarks:SELECT query error")
-		return nil
-	default:
-		for rows.Next() {
-			// Building a new Bookmark instance with each row.
-			bkm := new(types.Bookmark)
-			var fldID sql.NullInt64
-			var starred sql.NullInt64
-			db.err = rows.Scan(&bkm.Id, &bkm.Title, &bkm.URL, &bkm.Favicon, &starred, &fldID)
-			if db.err != nil {
-				log.WithFields(log.Fields{
-					"err": db.err,
-				}).Error("GetNoIconBookmarks:error scanning the query result row")
-				return nil
-			}
-			// Starred bookmark ?
-			if int(starred.Int64) != 0 {
-				bkm.Starred = true
-			}
-			// Retrieving the bookmark folder.
-			bkm.Folder = db.GetFolder(int(fldID.Int64))
-			bkms = append(bkms, bkm)
-		}
-		if db.err = rows.Err(); db.err != nil {
+	// Building the folders once the rows are read,
+	// GetFolder running queries itself.
+	var folderIDs []int
+	for rows.Next() {
+		// Building a new Bookmark instance with each row.
+		var (
+			bkm   *types.Bookmark
+			fldID int
+		)
+		if bkm, fldID, db.err = scanBookmark(rows); db.err != nil {
 			log.WithFields(log.Fields{
 				"err": db.err,
-			}).Error("GetNoIconBookmarks:error looping rows")
+			}).Error(functionName + ":error scanning the query result row")
 			return nil
 		}
-		return bkms
+		bkms = append(bkms, bkm)
+		folderIDs = append(folderIDs, fldID)
+	}
+	if db.err = rows.Err(); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error(functionName + ":error looping rows")
+		return nil
+	}
+
+	// Retrieving the bookmarks folders.
+	if withFolder {
+		for i, bkm := range bkms {
+			bkm.Folder = db.GetFolder(folderIDs[i])
+		}
 	}
+	return bkms
 }
 
-// GetAllBookmarks returns all the bookmarks as an array of *Bookmark.
-func (db *SQLiteDataStore) GetAllBookmarks() []*types.Bookmark {
+// queryFolders returns the folders of the given query selecting folderColumns.
+// The parent folders are not retrieved.
+// functionName is used for logging.
+func (db *SQLiteDataStore) queryFolders(functionName string, query string, args ...interface{}) []*types.Folder {
 	// Leaving silently on past errors...
 	if db.err != nil {
 		return nil
 	}
 	var (
 		rows *sql.Rows
-		bkms []*types.Bookmark
+		flds []*types.Folder
 	)
 
-	// Querying the bokmarks.
-	rows, db.err = db.Query("SELECT * FROM bookmark ORDER BY title")
+	// Querying the folders.
+	if rows, db.err = db.Query(query, args...); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error(functionName + ":SELECT query error")
+		return nil
+	}
 	defer func() {
-		if db.err = rows.Close(); db.err != nil {
+		if err := rows.Close(); err != nil {
 			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetAllBookmarks:error closing rows")
+				"err": err,
+			}).Error(functionName + ":error closing rows")
 		}
 	}()
 
-	switch {
-	case db.err == sql.ErrNoRows:
-		log.Debug("GetAllBookmarks:no bookmarks")
-		return nil
-	case db.err != nil:
-		log.WithFields(log.Fields{
-			"err": db.err,
-		}).Error("GetAllBookmarks:SELECT query error")
-		return nil
-	default:
-		for rows.Next() {
-			bkm := new(types.Bookmark)
-			var fldID sql.NullInt64
-			var starred sql.NullInt64
-			// Building a new Bookmark instance with each row.
-			db.err = rows.Scan(&bkm.Id, &bkm.Title, &bkm.URL, &bkm.Favicon, &starred, &fldID)
-			if db.err != nil {
-				log.WithFields(log.Fields{
-					"err": db.err,
-				}).Error("GetAllBookmarks:error scanning the query result row")
-				return nil
-			}
-			// Starred bookmark ?
-			if int(starred.Int64) != 0 {
-				bkm.Starred = true
-			}
-			// Retrieving the bookmark folder.
-			bkm.Folder = db.GetFolder(int(fldID.Int64))
-			bkms = append(bkms, bkm)
-		}
-		if db.err = rows.Err(); db.err != nil {
+	for rows.Next() {
+		// Building a new Folder instance with each row.
+		var fld *types.Folder
+		if fld, _, db.err = scanFolder(rows); db.err != nil {
 			log.WithFields(log.Fields{
 				"err": db.err,
-			}).Error("GetAllBookmarks:error looping rows")
+			}).Error(functionName + ":error scanning the query result row")
 			return nil
 		}
-		return bkms
+		flds = append(flds, fld)
+	}
+	if db.err = rows.Err(); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error(functionName + ":error looping rows")
+		return nil
 	}
+	return flds
+}
+
+// GetStarredBookmarks returns the starred bookmarks.
+func (db *SQLiteDataStore) GetStarredBookmarks() []*types.Bookmark {
+	return db.queryBookmarks("GetStarredBookmarks", true, "SELECT "+bookmarkColumns+" FROM bookmark WHERE starred ORDER BY title")
+}
+
+// GetNoIconBookmarks returns the bookmarks with no favicon.
+func (db *SQLiteDataStore) GetNoIconBookmarks() []*types.Bookmark {
+	return db.queryBookmarks("GetNoIconBookmarks", true, "SELECT "+bookmarkColumns+" FROM bookmark WHERE favicon='' ORDER BY title")
 }
 
-// SearchBookmarks returns the bookmarks with the title containing the given string.
+// GetAllBookmarks returns all the bookmarks as an array of *Bookmark.
+func (db *SQLiteDataStore) GetAllBookmarks() []*types.Bookmark {
+	return db.queryBookmarks("GetAllBookmarks", true, "SELECT "+bookmarkColumns+" FROM bookmark ORDER BY title")
+}
+
+// SearchBookmarks returns the bookmarks with the title or description containing the given string.
 func (db *SQLiteDataStore) SearchBookmarks(s string) []*types.Bookmark {
 	log.WithFields(log.Fields{
 		"s": s,
 	}).Debug("SearchBookmarks")
-	// Leaving silently on past errors...
-	if db.err != nil {
-		return nil
-	}
-	var (
-		rows *sql.Rows
-		bkms []*types.Bookmark
-	)
+	return db.queryBookmarks("SearchBookmarks", false, "SELECT "+bookmarkColumns+" FROM bookmark WHERE title LIKE ? OR description LIKE ? ORDER BY title", "%"+s+"%", "%"+s+"%")
+}
 
-	// Querying the bookmarks.
-	rows, db.err = db.Query("SELECT id, title, url, favicon, starred, folderId FROM bookmark WHERE title LIKE ? ORDER BY title", "%"+s+"%")
-	defer func() {
-		if db.err = rows.Close(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("SearchBookmarks:error closing rows")
-		}
-	}()
-	switch {
-	case db.err == sql.ErrNoRows:
-		log.Debug("SearchBookmarks:no bookmarks")
+// GetBookmarkByKeyword returns the bookmark with the given keyword, with its folder,
+// or nil if there is none.
+func (db *SQLiteDataStore) GetBookmarkByKeyword(keyword string) *types.Bookmark {
+	log.WithFields(log.Fields{
+		"keyword": keyword,
+	}).Debug("GetBookmarkByKeyword")
+	if keyword == "" {
 		return nil
-	case db.err != nil:
-		log.WithFields(log.Fields{
-			"err": db.err,
-		}).Error("SearchBookmarks:SELECT query error")
+	}
+	bkms := db.queryBookmarks("GetBookmarkByKeyword", true, "SELECT "+bookmarkColumns+" FROM bookmark WHERE keyword=?", keyword)
+	if len(bkms) == 0 {
 		return nil
-	default:
-		for rows.Next() {
-			// Building a new Bookmark instance with each row.
-			bkm := new(types.Bookmark)
-			var parentFldID sql.NullInt64
-			var starred sql.NullInt64
-			db.err = rows.Scan(&bkm.Id, &bkm.Title, &bkm.URL, &bkm.Favicon, &starred, &parentFldID)
-			// Starred bookmark ?
-			if int(starred.Int64) != 0 {
-				bkm.Starred = true
-			}
-			if db.err != nil {
-				log.WithFields(log.Fields{
-					"err": db.err,
-				}).Error("SearchBookmarks:error scanning the query result row")
-				return nil
-			}
-			log.WithFields(log.Fields{
-				"bkm": bkm,
-			}).Debug("SearchBookmarks:bookmark found")
-			bkms = append(bkms, bkm)
-		}
-		if db.err = rows.Err(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("SearchBookmarks:error looping rows")
-			return nil
-		}
-		return bkms
 	}
+	return bkms[0]
 }
 
-// GetFolderBookmarks returns the bookmarks of the given folder id.
-func (db *SQLiteDataStore) GetFolderBookmarks(id int) []*types.Bookmark {
-	log.WithFields(log.Fields{
-		"id": id,
-	}).Debug("GetFolderBookmarks")
+// GetTags returns the tags of the bookmarks, sorted.
+func (db *SQLiteDataStore) GetTags() []string {
+	log.Debug("GetTags")
 	// Leaving silently on past errors...
 	if db.err != nil {
 		return nil
 	}
-	var (
-		rows *sql.Rows
-		bkms []*types.Bookmark
-	)
 
-	// Querying the bookmarks.
-	rows, db.err = db.Query("SELECT id, title, url, favicon, starred, folderId FROM bookmark WHERE folderId is ? ORDER BY title", id)
-	defer func() {
-		if db.err = rows.Close(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetFolderBookmarks:error closing rows")
-		}
-	}()
-
-	switch {
-	case db.err == sql.ErrNoRows:
-		log.Debug("GetFolderBookmarks:no bookmarks")
-		return nil
-	case db.err != nil:
+	var rows *sql.Rows
+	if rows, db.err = db.Query("SELECT DISTINCT tags FROM bookmark WHERE tags!=''"); db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
-		}).Error("GetFolderBookmarks:SELECT query error")
+		}).Error("GetTags:SELECT query error")
 		return nil
-	default:
-		for rows.Next() {
-			// Building a new Bookmark instance with each row.
-			bkm := new(types.Bookmark)
-			var parentFldID sql.NullInt64
-			var starred sql.NullInt64
-			db.err = rows.Scan(&bkm.Id, &bkm.Title, &bkm.URL, &bkm.Favicon, &starred, &parentFldID)
-			// Starred bookmark ?
-			if int(starred.Int64) != 0 {
-				bkm.Starred = true
-			}
-			if db.err != nil {
-				log.WithFields(log.Fields{
-					"err": db.err,
-				}).Error("GetFolderBookmarks:error scanning the query result row")
-				return nil
-			}
-			log.WithFields(log.Fields{
-				"bkm": bkm,
-			}).Debug("GetFolderBookmarks:bookmark found")
-			bkms = append(bkms, bkm)
-		}
-		if db.err = rows.Err(); db.err != nil {
+	}
+	defer rows.Close()
+
+	var tags []string
+	has := make(map[string]bool)
+	for rows.Next() {
+		var t string
+		if db.err = rows.Scan(&t); db.err != nil {
 			log.WithFields(log.Fields{
 				"err": db.err,
-			}).Error("GetFolderBookmarks:error looping rows")
+			}).Error("GetTags:error scanning the query result row")
 			return nil
 		}
-		return bkms
+		for _, tag := range splitTags(t) {
+			if !has[tag] {
+				has[tag] = true
+				tags = append(tags, tag)
+			}
+		}
 	}
+	if db.err = rows.Err(); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetTags:error looping rows")
+		return nil
+	}
+	sort.Strings(tags)
+	return tags
 }
 
-// GetFolderSubfolders returns the children folders as an array of *Folder
-func (db *SQLiteDataStore) GetFolderSubfolders(id int) []*types.Folder {
+// GetFolderBookmarks returns the bookmarks of the given folder id,
+// sorted by the folder sort mode.
+func (db *SQLiteDataStore) GetFolderBookmarks(id int) []*types.Bookmark {
 	log.WithFields(log.Fields{
 		"id": id,
-	}).Debug("GetChildrenFolders")
+	}).Debug("GetFolderBookmarks")
+	q := types.BookmarkQuery{FolderID: id}
+	q.SortByFolder(db.folderSortMode("GetFolderBookmarks", id))
+	return db.QueryBookmarks(q)
+}
+
+// folderSortMode returns the sort mode of the folder id, SortTitle if it is not found.
+func (db *SQLiteDataStore) folderSortMode(functionName string, id int) string {
 	// Leaving silently on past errors...
 	if db.err != nil {
-		return nil
+		return ""
 	}
-
-	var (
-		rows *sql.Rows
-		flds []*types.Folder
-	)
-	// Querying the folders.
-	rows, db.err = db.Query("SELECT id, title, parentFolderId, nbChildrenFolders FROM folder WHERE parentFolderId is ? ORDER BY title", id)
-	defer func() {
-		if db.err = rows.Close(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetFolderSubfolders:error closing rows")
-		}
-	}()
-	switch {
-	case db.err == sql.ErrNoRows:
-		log.Debug("GetChildrenFolders:no folders")
-		return nil
-	case db.err != nil:
+	var sortMode string
+	if err := db.QueryRow("SELECT sortMode FROM folder WHERE id=?", id).Scan(&sortMode); err != nil && err != sql.ErrNoRows {
+		db.err = err
 		log.WithFields(log.Fields{
 			"err": db.err,
-		}).Error("GetChildrenFolders:SELECT query error")
-		return nil
-	default:
-		for rows.Next() {
-			// Building a new Folder instance with each row.
-			fld := new(types.Folder)
-			var parentFldID sql.NullInt64
-			db.err = rows.Scan(&fld.Id, &fld.Title, &parentFldID, &fld.NbChildrenFolders)
-			if db.err != nil {
-				log.WithFields(log.Fields{
-					"err": db.err,
-				}).Error("GetChildrenFolders:error scanning the query result row")
-				return nil
-			}
-			flds = append(flds, fld)
-		}
-		if db.err = rows.Err(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetChildrenFolders:error looping rows")
-			return nil
-		}
-		return flds
+		}).Error(functionName + ":folder sort mode query error")
 	}
+	return sortMode
 }
 
-// GetRootFolders returns the root folders as an array of *Folder
-func (db *SQLiteDataStore) GetRootFolders() []*types.Folder {
-	// Leaving silently on past errors...
-	if db.err != nil {
-		return nil
+// folderSortColumns are the folder columns of the folder sort modes, title otherwise.
+var folderSortColumns = map[string]string{
+	types.SortManual:  "position",
+	types.SortCreated: "createdAt DESC",
+}
+
+// querySubfolders returns the children folders of the folder id,
+// sorted by its sort mode, by title for the equal values.
+func (db *SQLiteDataStore) querySubfolders(functionName string, id int) []*types.Folder {
+	sortColumn, ok := folderSortColumns[db.folderSortMode(functionName, id)]
+	if !ok {
+		sortColumn = "title"
 	}
+	return db.queryFolders(functionName, "SELECT "+folderColumns+" FROM folder WHERE parentFolderId is ? ORDER BY "+sortColumn+", title", id)
+}
+
+// bookmarkSortColumns are the bookmark columns of the types.Sort* sort orders.
+var bookmarkSortColumns = map[string]string{
+	types.SortTitle:   "title",
+	types.SortCreated: "createdAt",
+	types.SortUpdated: "updatedAt",
+	types.SortVisited: "lastVisitedAt",
+	types.SortVisits:  "visitCount",
+	types.SortManual:  "position",
+}
+
+// visitDayLayout is the layout of the visit table days.
+const visitDayLayout = "2006-01-02"
+
+// frecencyColumn is the bookmarks frecency: their visits weighted by age,
+// 100 the last 4 days, 70 the last 2 weeks, 50 the last month,
+// 30 the last 3 months and 10 before. It takes the frecencyArgs.
+const frecencyColumn = "(SELECT COALESCE(SUM(visit.count * CASE WHEN visit.day >= ? THEN 100 WHEN visit.day >= ? THEN 70 WHEN visit.day >= ? THEN 50 WHEN visit.day >= ? THEN 30 ELSE 10 END), 0) FROM visit WHERE visit.bookmarkId = bookmark.id)"
+
+// frecencyArgs returns the frecencyColumn days arguments at the given time.
+func frecencyArgs(now time.Time) []interface{} {
+	var args []interface{}
+	for _, days := range []int{4, 14, 31, 90} {
+		args = append(args, now.AddDate(0, 0, -days).Format(visitDayLayout))
+	}
+	return args
+}
+
+// QueryBookmarks returns the bookmarks selected and sorted by the given query.
+// The bookmarks folders are retrieved unless the query selects a folder.
+func (db *SQLiteDataStore) QueryBookmarks(q types.BookmarkQuery) []*types.Bookmark {
+	log.WithFields(log.Fields{
+		"q": q,
+	}).Debug("QueryBookmarks")
+
 	var (
-		rows *sql.Rows
-		flds []*types.Folder
+		where []string
+		args  []interface{}
 	)
+	if q.FolderID != 0 {
+		where = append(where, "folderId is ?")
+		args = append(args, q.FolderID)
+	}
+	if q.Under != 0 {
+		where = append(where, "folderId IN ("+subtreeFolders+")")
+		args = append(args, q.Under)
+	}
+	if q.Tag != "" {
+		where = append(where, "instr(','||tags||',', ?) > 0")
+		args = append(args, ","+q.Tag+",")
+	}
+	if q.Search != "" {
+		where = append(where, "(title LIKE ? OR description LIKE ?)")
+		args = append(args, "%"+q.Search+"%", "%"+q.Search+"%")
+	}
+	if q.Starred {
+		where = append(where, "starred")
+	}
+	if q.Unread {
+		where = append(where, "unread")
+	}
+	if q.Archived {
+		where = append(where, "archived")
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "createdAt > ?")
+		args = append(args, q.CreatedAfter.Unix())
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "createdAt < ?")
+		args = append(args, q.CreatedBefore.Unix())
+	}
+	if !q.UpdatedBefore.IsZero() {
+		where = append(where, "updatedAt < ?")
+		args = append(args, q.UpdatedBefore.Unix())
+	}
+	if !q.NotVisitedSince.IsZero() {
+		where = append(where, "(lastVisitedAt IS NULL OR lastVisitedAt < ?)")
+		args = append(args, q.NotVisitedSince.Unix())
+	}
 
-	// Querying the folders.
-	rows, db.err = db.Query("SELECT id, title, parentFolderId, nbChildrenFolders FROM folder WHERE parentFolderId==1 ORDER BY title")
-	defer func() {
-		if db.err = rows.Close(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetNoIconBookmarks:error closing rows")
-		}
-	}()
+	query := "SELECT " + bookmarkColumns + " FROM bookmark"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Sorting, by title for the equal values.
+	sortColumn, ok := bookmarkSortColumns[q.Sort]
 	switch {
-	case db.err == sql.ErrNoRows:
-		log.Debug("GetRootFolders:no folders")
-		return nil
-	case db.err != nil:
-		log.WithFields(log.Fields{
-			"err": db.err,
-		}).Error("GetRootFolders:SELECT query error")
-		return nil
-	default:
-		for rows.Next() {
-			// Building a new Folder instance with each row.
-			fld := new(types.Folder)
-			var parentFldID sql.NullInt64
-			db.err = rows.Scan(&fld.Id, &fld.Title, &parentFldID, &fld.NbChildrenFolders)
-			if db.err != nil {
-				log.WithFields(log.Fields{
-					"err": db.err,
-				}).Error("GetRootFolders:error scanning the query result row")
-				return nil
-			}
-			flds = append(flds, fld)
-		}
-		if db.err = rows.Err(); db.err != nil {
-			log.WithFields(log.Fields{
-				"err": db.err,
-			}).Error("GetRootFolders:error looping rows")
-			return nil
-		}
-		return flds
+	case q.Sort == types.SortFrecency:
+		sortColumn = frecencyColumn
+		args = append(args, frecencyArgs(time.Now())...)
+	case !ok:
+		sortColumn = "title"
 	}
+	if q.Desc {
+		sortColumn += " DESC"
+	}
+	query += " ORDER BY " + sortColumn + ", title"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	return db.queryBookmarks("QueryBookmarks", q.FolderID == 0, query, args...)
+}
+
+// GetFolderSubfolders returns the children folders as an array of *Folder,
+// sorted by the folder sort mode.
+func (db *SQLiteDataStore) GetFolderSubfolders(id int) []*types.Folder {
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Debug("GetChildrenFolders")
+	return db.querySubfolders("GetChildrenFolders", id)
+}
+
+// GetRootFolders returns the root folders as an array of *Folder,
+// sorted by the root folder sort mode.
+func (db *SQLiteDataStore) GetRootFolders() []*types.Folder {
+	return db.querySubfolders("GetRootFolders", 1)
 }
 
 // SaveFolder saves the given new Folder into the db and returns the folder id.
 // Called only on folder creation or rename
 // so only the Title has to be set.
+// CreatedAt and UpdatedAt are set to now unless already set (imports).
 func (db *SQLiteDataStore) SaveFolder(f *types.Folder) int64 {
 	log.WithFields(log.Fields{
 		"f": f,
@@ -648,7 +723,8 @@ func (db *SQLiteDataStore) SaveFolder(f *types.Folder) int64 {
 
 	// Preparing the query.
 	// id will be auto incremented
-	if stmt, db.err = db.Prepare("INSERT INTO folder(title, parentFolderId, nbChildrenFolders) values(?,?,?)"); db.err != nil {
+	// A new folder has no children.
+	if stmt, db.err = db.Prepare("INSERT INTO folder(title, parentFolderId, nbChildrenFolders, createdAt, updatedAt, position, sortMode, query) values(?,?,0,?,?,?,?,?)"); db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
 		}).Error("SaveFolder:SELECT request prepare error")
@@ -662,24 +738,46 @@ func (db *SQLiteDataStore) SaveFolder(f *types.Folder) int64 {
 		}
 	}()
 
-	// Executing the query.
-	var res sql.Result
+	// Setting the timestamps.
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now()
+	}
+	if f.UpdatedAt.IsZero() {
+		f.UpdatedAt = f.CreatedAt
+	}
+	parentID := 1
 	if f.Parent != nil {
-		res, db.err = stmt.Exec(f.Title, f.Parent.Id, f.NbChildrenFolders)
-	} else {
-		res, db.err = stmt.Exec(f.Title, 1, f.NbChildrenFolders)
+		parentID = f.Parent.Id
 	}
-	id, _ := res.LastInsertId() // we should check the error here too...
+	// Appending the folder to its parent unless already positioned.
+	if f.Position == 0 {
+		if f.Position = db.endPosition("folder", parentID, 0); db.err != nil {
+			return 0
+		}
+	}
+
+	// Executing the query.
+	var res sql.Result
+	res, db.err = stmt.Exec(f.Title, parentID, f.CreatedAt.Unix(), f.UpdatedAt.Unix(), f.Position, f.SortMode, f.Query)
 	if db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
 		}).Error("SaveFolder:INSERT query error")
 		return 0
 	}
+	// Updating the nbChildrenFolders of the parent.
+	if _, db.err = db.Exec("UPDATE folder SET nbChildrenFolders=(SELECT count(*) from folder WHERE parentFolderId=?) WHERE id=?", parentID, parentID); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("SaveFolder:UPDATE parent request error")
+		return 0
+	}
+	id, _ := res.LastInsertId() // we should check the error here too...
 	return id
 }
 
-// UpdateBookmark updates the given bookmark.
+// UpdateBookmark updates the given bookmark and sets its UpdatedAt to now.
+// CreatedAt and LastVisitedAt are not updated.
 func (db *SQLiteDataStore) UpdateBookmark(b *types.Bookmark) {
 	log.WithFields(log.Fields{
 		"b": b,
@@ -704,7 +802,7 @@ func (db *SQLiteDataStore) UpdateBookmark(b *types.Bookmark) {
 	}
 
 	// Preparing the update request.
-	stmt, db.err = tx.Prepare("UPDATE bookmark SET title=?, url=?, folderId=?, starred=?, favicon=? WHERE id=?")
+	stmt, db.err = tx.Prepare("UPDATE bookmark SET title=?, url=?, canonicalURL=?, folderId=?, starred=?, favicon=?, description=?, keyword=?, unread=?, archived=?, tags=?, updatedAt=? WHERE id=?")
 	if db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
@@ -720,10 +818,12 @@ func (db *SQLiteDataStore) UpdateBookmark(b *types.Bookmark) {
 	}()
 
 	// Executing the query.
+	b.UpdatedAt = time.Now()
+	b.CanonicalURL = db.Canonicalizer.Canonicalize(b.URL)
 	if b.Folder != nil {
-		_, db.err = stmt.Exec(b.Title, b.URL, b.Folder.Id, b.Starred, b.Favicon, b.Id)
+		_, db.err = stmt.Exec(b.Title, b.URL, b.CanonicalURL, b.Folder.Id, b.Starred, b.Favicon, b.Description, b.Keyword, b.Unread, b.Archived, joinTags(b.Tags), b.UpdatedAt.Unix(), b.Id)
 	} else {
-		_, db.err = stmt.Exec(b.Title, b.URL, 1, b.Starred, b.Favicon, b.Id)
+		_, db.err = stmt.Exec(b.Title, b.URL, b.CanonicalURL, 1, b.Starred, b.Favicon, b.Description, b.Keyword, b.Unread, b.Archived, joinTags(b.Tags), b.UpdatedAt.Unix(), b.Id)
 	}
 	// Rolling back on errors, or commit.
 	if db.err != nil {
@@ -747,6 +847,7 @@ func (db *SQLiteDataStore) UpdateBookmark(b *types.Bookmark) {
 }
 
 // SaveBookmark saves the new given Bookmark into the db
+// CreatedAt and UpdatedAt are set to now unless already set (imports).
 func (db *SQLiteDataStore) SaveBookmark(b *types.Bookmark) int64 {
 	log.WithFields(log.Fields{
 		"b": b,
@@ -758,7 +859,7 @@ func (db *SQLiteDataStore) SaveBookmark(b *types.Bookmark) int64 {
 
 	// Preparing the query.
 	var stmt *sql.Stmt
-	stmt, db.err = db.Prepare("INSERT INTO bookmark(title, url, folderId, favicon) values(?,?,?,?)")
+	stmt, db.err = db.Prepare("INSERT INTO bookmark(title, url, canonicalURL, folderId, favicon, starred, description, keyword, unread, archived, tags, createdAt, updatedAt, lastVisitedAt, position) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
 	if db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
@@ -773,13 +874,28 @@ func (db *SQLiteDataStore) SaveBookmark(b *types.Bookmark) int64 {
 		}
 	}()
 
-	// Executing the query.
-	var res sql.Result
+	// Setting the timestamps.
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now()
+	}
+	if b.UpdatedAt.IsZero() {
+		b.UpdatedAt = b.CreatedAt
+	}
+	folderID := 1
 	if b.Folder != nil {
-		res, db.err = stmt.Exec(b.Title, b.URL, b.Folder.Id, b.Favicon)
-	} else {
-		res, db.err = stmt.Exec(b.Title, b.URL, 1, b.Favicon)
+		folderID = b.Folder.Id
 	}
+	// Appending the bookmark to its folder unless already positioned.
+	if b.Position == 0 {
+		if b.Position = db.endPosition("bookmark", folderID, 0); db.err != nil {
+			return 0
+		}
+	}
+
+	// Executing the query.
+	var res sql.Result
+	b.CanonicalURL = db.Canonicalizer.Canonicalize(b.URL)
+	res, db.err = stmt.Exec(b.Title, b.URL, b.CanonicalURL, folderID, b.Favicon, b.Starred, b.Description, b.Keyword, b.Unread, b.Archived, joinTags(b.Tags), b.CreatedAt.Unix(), b.UpdatedAt.Unix(), nullUnixTime(b.LastVisitedAt), b.Position)
 	if db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
@@ -800,18 +916,130 @@ func (db *SQLiteDataStore) DeleteBookmark(b *types.Bookmark) {
 		return
 	}
 
-	// Executing the query.
-	_, db.err = db.Exec("DELETE from bookmark WHERE id=?", b.Id)
+	// Executing the queries.
+	db.deleteItem("DeleteBookmark", deleteBookmarkQueries, b.Id)
+}
+
+// deleteItem executes the given deletion queries of the item id
+// in a single transaction.
+func (db *SQLiteDataStore) deleteItem(method string, queries []string, id int) {
+	var tx *sql.Tx
+	if tx, db.err = db.Begin(); db.err != nil {
+		log.Error(method + ": transaction begin failed")
+		return
+	}
+	for _, query := range queries {
+		if _, db.err = tx.Exec(query, id); db.err != nil {
+			log.WithFields(log.Fields{
+				"query": query,
+				"err":   db.err,
+			}).Error(method + ":DELETE query error")
+			if err := tx.Rollback(); err != nil {
+				// Just logging the error.
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error(method + ": transaction rollback error")
+			}
+			return
+		}
+	}
+	if db.err = tx.Commit(); db.err != nil {
+		log.Error(method + ": transaction commit error")
+	}
+}
+
+// VisitBookmark sets the LastVisitedAt of the bookmark with the given id to now,
+// increments its VisitCount and its number of visits of the day.
+func (db *SQLiteDataStore) VisitBookmark(id int) {
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Debug("VisitBookmark")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, db.err = db.Begin(); db.err != nil {
+		log.Error("VisitBookmark: transaction begin failed")
+		return
+	}
+	now := time.Now()
+	day := now.Format(visitDayLayout)
+	if _, db.err = tx.Exec("UPDATE bookmark SET lastVisitedAt=?, visitCount=visitCount+1 WHERE id=?", now.Unix(), id); db.err == nil {
+		if _, db.err = tx.Exec("INSERT OR IGNORE INTO visit(bookmarkId, day, count) values(?,?,0)", id, day); db.err == nil {
+			_, db.err = tx.Exec("UPDATE visit SET count=count+1 WHERE bookmarkId=? AND day=?", id, day)
+		}
+	}
+	// Rolling back on errors, or commit.
 	if db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
-		}).Error("DeleteBookmark:DELETE query error")
+		}).Error("VisitBookmark: query error")
+		if err := tx.Rollback(); err != nil {
+			// Just logging the error.
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("VisitBookmark: transaction rollback error")
+		}
 		return
 	}
-	return
+	if db.err = tx.Commit(); db.err != nil {
+		log.Error("VisitBookmark: transaction commit error")
+	}
+}
+
+// GetBookmarkVisits returns the number of visits per day
+// of the bookmark with the given id, the oldest first.
+func (db *SQLiteDataStore) GetBookmarkVisits(id int) []types.Visit {
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Debug("GetBookmarkVisits")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return nil
+	}
+
+	var (
+		rows   *sql.Rows
+		visits []types.Visit
+	)
+	if rows, db.err = db.Query("SELECT day, count FROM visit WHERE bookmarkId=? ORDER BY day", id); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetBookmarkVisits:SELECT query error")
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			v   types.Visit
+			day string
+		)
+		if db.err = rows.Scan(&day, &v.Count); db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("GetBookmarkVisits:error scanning the query result row")
+			return nil
+		}
+		if v.Day, db.err = time.ParseInLocation(visitDayLayout, day, time.Local); db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("GetBookmarkVisits:error parsing the day")
+			return nil
+		}
+		visits = append(visits, v)
+	}
+	if db.err = rows.Err(); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetBookmarkVisits:error looping rows")
+		return nil
+	}
+	return visits
 }
 
-// UpdateFolder updates the given folder.
+// UpdateFolder updates the given folder and sets its UpdatedAt to now.
 func (db *SQLiteDataStore) UpdateFolder(f *types.Folder) {
 	log.WithFields(log.Fields{
 		"f": f,
@@ -836,7 +1064,7 @@ func (db *SQLiteDataStore) UpdateFolder(f *types.Folder) {
 
 	// Preparing the update request for the folder.
 	var stmt *sql.Stmt
-	stmt, db.err = db.Prepare("UPDATE folder SET title=?, parentFolderId=?, nbChildrenFolders=(SELECT count(*) from folder WHERE parentFolderId=?) WHERE id=?")
+	stmt, db.err = db.Prepare("UPDATE folder SET title=?, parentFolderId=?, nbChildrenFolders=(SELECT count(*) from folder WHERE parentFolderId=?), sortMode=?, query=?, updatedAt=? WHERE id=?")
 	if db.err != nil {
 		log.WithFields(log.Fields{
 			"err": db.err,
@@ -852,10 +1080,11 @@ func (db *SQLiteDataStore) UpdateFolder(f *types.Folder) {
 	}()
 
 	// Executing the query.
+	f.UpdatedAt = time.Now()
 	if f.Parent != nil {
-		_, db.err = stmt.Exec(f.Title, f.Parent.Id, f.Id, f.Id)
+		_, db.err = stmt.Exec(f.Title, f.Parent.Id, f.Id, f.SortMode, f.Query, f.UpdatedAt.Unix(), f.Id)
 	} else {
-		_, db.err = stmt.Exec(f.Title, 1, f.Id, f.Id)
+		_, db.err = stmt.Exec(f.Title, 1, f.Id, f.SortMode, f.Query, f.UpdatedAt.Unix(), f.Id)
 	}
 	if db.err != nil {
 		log.WithFields(log.Fields{
@@ -908,13 +1137,6 @@ func (db *SQLiteDataStore) DeleteFolder(f *types.Folder) {
 		return
 	}
 
-	// Executing the query.
-	_, db.err = db.Exec("DELETE from folder WHERE id=?", f.Id)
-	if db.err != nil {
-		log.WithFields(log.Fields{
-			"err": db.err,
-		}).Error("DeleteFolder:DELETE query error")
-		return
-	}
-	return
+	// Executing the queries, the folder content and its shares too.
+	db.deleteItem("DeleteFolder", deleteFolderQueries, f.Id)
 }
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// newTestDatastore returns the datastore of a new database
+// with its root folder, removed at the end of the test.
+func newTestDatastore(t *testing.T) *SQLiteDataStore {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := NewDBstore(filepath.Join(dir, "bkm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.CreateDatabase()
+	if err = db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// count returns the number of rows of the query.
+func count(t *testing.T, db *SQLiteDataStore, query string, args ...interface{}) int {
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDeleteDependentRows(t *testing.T) {
+	tests := []struct {
+		name   string
+		delete func(db *SQLiteDataStore, fld *types.Folder, bkm *types.Bookmark)
+		left   map[string]int // the rows left by table
+	}{
+		{
+			name:   "folder",
+			delete: func(db *SQLiteDataStore, fld *types.Folder, bkm *types.Bookmark) { db.DeleteFolder(fld) },
+			left:   map[string]int{"folder": 1, "bookmark": 0, "visit": 0, "syncid": 0, "share": 0},
+		},
+		{
+			name:   "bookmark",
+			delete: func(db *SQLiteDataStore, fld *types.Folder, bkm *types.Bookmark) { db.DeleteBookmark(bkm) },
+			left:   map[string]int{"folder": 3, "bookmark": 0, "visit": 0, "syncid": 2, "share": 1},
+		},
+	}
+	for _, tt := range tests {
+		db := newTestDatastore(t)
+		// The deletions must not rely on the foreign keys cascades.
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+			t.Fatal(err)
+		}
+
+		fld := &types.Folder{Title: "shared"}
+		fld.Id = int(db.SaveFolder(fld))
+		sub := &types.Folder{Title: "sub", Parent: fld}
+		sub.Id = int(db.SaveFolder(sub))
+		bkm := &types.Bookmark{Title: "Go", URL: "https://golang.org/", Folder: sub}
+		bkm.Id = int(db.SaveBookmark(bkm))
+		db.VisitBookmark(bkm.Id)
+		db.SaveShare(&types.Share{Token: "token", Folder: fld})
+		// Giving sync ids to all the items.
+		db.GetSyncTree()
+		if err := db.FlushErrors(); err != nil {
+			t.Fatal(err)
+		}
+
+		tt.delete(db, fld, bkm)
+		if err := db.FlushErrors(); err != nil {
+			t.Errorf("%s: delete error %v", tt.name, err)
+			continue
+		}
+		for table, want := range tt.left {
+			if n := count(t, db, "SELECT COUNT(*) FROM "+table); n != want {
+				t.Errorf("%s: %d %s rows left, want %d", tt.name, n, table, want)
+			}
+		}
+	}
+}
+
+func TestForeignKeysEnabled(t *testing.T) {
+	db := newTestDatastore(t)
+	// Checking several connections of the pool.
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+		var on int
+		if err = conn.QueryRowContext(context.Background(), "PRAGMA foreign_keys").Scan(&on); err != nil {
+			t.Fatal(err)
+		}
+		if on != 1 {
+			t.Errorf("connection %d: foreign keys off", i)
+		}
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// titles returns the titles of the given bookmarks.
+func titles(bkms []*types.Bookmark) []string {
+	var t []string
+	for _, b := range bkms {
+		t = append(t, b.Title)
+	}
+	return t
+}
+
+func TestQueryBookmarksDates(t *testing.T) {
+	db := newTestDatastore(t)
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	for _, b := range []*types.Bookmark{
+		{Title: "Alpha", URL: "https://alpha.example.com/", CreatedAt: date("2026-03-01"), UpdatedAt: date("2026-03-01")},
+		{Title: "Beta", URL: "https://beta.example.com/", CreatedAt: date("2026-01-01"), UpdatedAt: date("2026-04-01")},
+		{Title: "Gamma", URL: "https://gamma.example.com/", CreatedAt: date("2026-02-01"), UpdatedAt: date("2026-02-01")},
+	} {
+		b.Id = int(db.SaveBookmark(b))
+		if b.Title == "Gamma" {
+			db.VisitBookmark(b.Id)
+		}
+	}
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    types.BookmarkQuery
+		want []string
+	}{
+		{"title", types.BookmarkQuery{}, []string{"Alpha", "Beta", "Gamma"}},
+		{"title desc", types.BookmarkQuery{Desc: true}, []string{"Gamma", "Beta", "Alpha"}},
+		{"created", types.BookmarkQuery{Sort: types.SortCreated}, []string{"Beta", "Gamma", "Alpha"}},
+		{"created desc", types.BookmarkQuery{Sort: types.SortCreated, Desc: true}, []string{"Alpha", "Gamma", "Beta"}},
+		{"updated", types.BookmarkQuery{Sort: types.SortUpdated}, []string{"Gamma", "Alpha", "Beta"}},
+		{"visited desc", types.BookmarkQuery{Sort: types.SortVisited, Desc: true, Limit: 1}, []string{"Gamma"}},
+		{"created after", types.BookmarkQuery{CreatedAfter: date("2026-01-15")}, []string{"Alpha", "Gamma"}},
+		{"created before", types.BookmarkQuery{CreatedBefore: date("2026-02-15")}, []string{"Beta", "Gamma"}},
+		{"created between", types.BookmarkQuery{CreatedAfter: date("2026-01-15"), CreatedBefore: date("2026-02-15")}, []string{"Gamma"}},
+		{"updated before", types.BookmarkQuery{UpdatedBefore: date("2026-03-15")}, []string{"Alpha", "Gamma"}},
+		{"not visited since", types.BookmarkQuery{NotVisitedSince: time.Now().Add(-time.Hour)}, []string{"Alpha", "Beta"}},
+	}
+	for _, tt := range tests {
+		got := titles(db.QueryBookmarks(tt.q))
+		if err := db.FlushErrors(); err != nil {
+			t.Errorf("%s: QueryBookmarks error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: QueryBookmarks = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Updating a bookmark sets its update time.
+	bkm := db.GetBookmark(1)
+	bkm.Title = "Alpha 2"
+	db.UpdateBookmark(bkm)
+	if bkm = db.GetBookmark(1); time.Since(bkm.UpdatedAt) > time.Minute || !bkm.CreatedAt.Equal(date("2026-03-01")) {
+		t.Errorf("updated bookmark created %v, updated %v", bkm.CreatedAt, bkm.UpdatedAt)
+	}
+}
+
+func TestVisitsAndFrecency(t *testing.T) {
+	db := newTestDatastore(t)
+	now := time.Now()
+	// The visits by bookmark, days ago and count.
+	visits := []struct {
+		title string
+		days  int
+		count int
+	}{
+		{"Old", 100, 5},   // 5*10
+		{"Today", 0, 1},   // 1*100
+		{"Recent", 10, 3}, // 3*70
+	}
+	for _, v := range visits {
+		id := db.SaveBookmark(&types.Bookmark{Title: v.title, URL: "https://" + v.title + ".example.com/"})
+		if _, err := db.Exec("INSERT INTO visit(bookmarkId, day, count) values(?,?,?)", id, now.AddDate(0, 0, -v.days).Format(visitDayLayout), v.count); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("UPDATE bookmark SET visitCount=? WHERE id=?", v.count, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		q    types.BookmarkQuery
+		want []string
+	}{
+		{types.BookmarkQuery{Sort: types.SortFrecency, Desc: true}, []string{"Recent", "Today", "Old"}},
+		{types.BookmarkQuery{Sort: types.SortFrecency}, []string{"Old", "Today", "Recent"}},
+		{types.BookmarkQuery{Sort: types.SortVisits, Desc: true}, []string{"Old", "Recent", "Today"}},
+	}
+	for _, tt := range tests {
+		if got := titles(db.QueryBookmarks(tt.q)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sort %s desc %v: QueryBookmarks = %v, want %v", tt.q.Sort, tt.q.Desc, got, tt.want)
+		}
+	}
+
+	// Recording visits of the Today bookmark.
+	db.VisitBookmark(2)
+	db.VisitBookmark(2)
+	bkm := db.GetBookmark(2)
+	days := db.GetBookmarkVisits(2)
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if bkm.VisitCount != 3 || time.Since(bkm.LastVisitedAt) > time.Minute {
+		t.Errorf("visited bookmark count %d, last visit %v", bkm.VisitCount, bkm.LastVisitedAt)
+	}
+	if len(days) != 1 || days[0].Count != 3 || days[0].Day.Format(visitDayLayout) != now.Format(visitDayLayout) {
+		t.Errorf("GetBookmarkVisits = %v, want 3 visits today", days)
+	}
+}
@@ -0,0 +1,315 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/tbellembois/gobkm/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The synchronized tree is the tree of the root folder without the smart folders.
+// The sync ids of the syncid table identify its folders and bookmarks
+// for the synchronization clients, the GoBkm ids of the folders
+// and bookmarks sharing the same sequence.
+
+// syncItem is the current state of a synchronized folder or bookmark.
+type syncItem struct {
+	id       int // the folder or bookmark id
+	parentID int
+	title    string
+	url      string
+	position float64
+}
+
+// syncer applies a synchronized tree within a transaction.
+type syncer struct {
+	tx            *sql.Tx
+	now           int64
+	canonicalizer Canonicalizer
+	// The current folders and bookmarks by sync id.
+	folders   map[int]syncItem
+	bookmarks map[int]syncItem
+	// The sync ids of the tree items found in the current ones.
+	seenFolders   map[int]bool
+	seenBookmarks map[int]bool
+}
+
+// GetSyncTree returns the synchronized tree, giving a sync id
+// to the folders and bookmarks without one.
+// The folders and bookmarks are in their manual order.
+func (db *SQLiteDataStore) GetSyncTree() *types.SyncFolder {
+	log.Debug("GetSyncTree")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return nil
+	}
+
+	var rows *sql.Rows
+	// New items sync ids.
+	if _, db.err = db.Exec("INSERT INTO syncid(folderId) SELECT id FROM folder WHERE id!=1 AND query='' AND id NOT IN (SELECT folderId FROM syncid WHERE folderId IS NOT NULL)"); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetSyncTree:folders INSERT query error")
+		return nil
+	}
+	if _, db.err = db.Exec("INSERT INTO syncid(bookmarkId) SELECT id FROM bookmark WHERE id NOT IN (SELECT bookmarkId FROM syncid WHERE bookmarkId IS NOT NULL)"); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetSyncTree:bookmarks INSERT query error")
+		return nil
+	}
+
+	// The folders by id, linked to their parent once all read.
+	root := &types.SyncFolder{}
+	folders := map[int]*types.SyncFolder{1: root}
+	var (
+		folderIDs []int
+		parentIDs []int
+	)
+	if rows, db.err = db.Query("SELECT s.id, f.id, f.title, f.parentFolderId FROM folder f JOIN syncid s ON s.folderId=f.id ORDER BY f.position, f.title"); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetSyncTree:folders SELECT query error")
+		return nil
+	}
+	for rows.Next() {
+		var (
+			f        types.SyncFolder
+			id       int
+			parentID sql.NullInt64
+		)
+		if db.err = rows.Scan(&f.SyncId, &id, &f.Title, &parentID); db.err != nil {
+			rows.Close()
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("GetSyncTree:error scanning the folders row")
+			return nil
+		}
+		folders[id] = &f
+		folderIDs = append(folderIDs, id)
+		parentIDs = append(parentIDs, int(parentID.Int64))
+	}
+	if db.err = rows.Err(); db.err != nil {
+		rows.Close()
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetSyncTree:error looping the folders rows")
+		return nil
+	}
+	rows.Close()
+	for i, id := range folderIDs {
+		parent, ok := folders[parentIDs[i]]
+		if !ok {
+			parent = root
+		}
+		parent.Folders = append(parent.Folders, folders[id])
+	}
+
+	if rows, db.err = db.Query("SELECT s.id, b.title, b.url, b.folderId FROM bookmark b JOIN syncid s ON s.bookmarkId=b.id ORDER BY b.position, b.title"); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetSyncTree:bookmarks SELECT query error")
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			b        types.SyncBookmark
+			folderID sql.NullInt64
+		)
+		if db.err = rows.Scan(&b.SyncId, &b.Title, &b.URL, &folderID); db.err != nil {
+			log.WithFields(log.Fields{
+				"err": db.err,
+			}).Error("GetSyncTree:error scanning the bookmarks row")
+			return nil
+		}
+		folder, ok := folders[int(folderID.Int64)]
+		if !ok {
+			folder = root
+		}
+		folder.Bookmarks = append(folder.Bookmarks, &b)
+	}
+	if db.err = rows.Err(); db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("GetSyncTree:error looping the bookmarks rows")
+		return nil
+	}
+	return root
+}
+
+// loadSyncItems returns the current items of the given query selecting
+// the sync id, id, parent id, title, url and position of the synchronized items.
+func loadSyncItems(tx *sql.Tx, query string) (map[int]syncItem, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make(map[int]syncItem)
+	for rows.Next() {
+		var (
+			syncID   int
+			it       syncItem
+			parentID sql.NullInt64
+		)
+		if err = rows.Scan(&syncID, &it.id, &parentID, &it.title, &it.url, &it.position); err != nil {
+			return nil, err
+		}
+		it.parentID = int(parentID.Int64)
+		items[syncID] = it
+	}
+	return items, rows.Err()
+}
+
+// mapSyncID gives the sync id syncID to the new item id of the given column,
+// folderId or bookmarkId, or a new sync id if syncID is already taken.
+func (s *syncer) mapSyncID(column string, syncID int, id int) error {
+	if syncID > 0 {
+		res, err := s.tx.Exec("INSERT OR IGNORE INTO syncid(id, "+column+") values(?,?)", syncID, id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return err
+		}
+	}
+	_, err := s.tx.Exec("INSERT INTO syncid("+column+") values(?)", id)
+	return err
+}
+
+// applyFolder creates, moves, renames and orders the content of the given
+// tree folder, the folder parentID.
+func (s *syncer) applyFolder(f *types.SyncFolder, parentID int) error {
+	for i, sub := range f.Folders {
+		position := float64(i + 1)
+		it, ok := s.folders[sub.SyncId]
+		id := it.id
+		switch {
+		case ok && !s.seenFolders[sub.SyncId]:
+			s.seenFolders[sub.SyncId] = true
+			if it.title != sub.Title || it.parentID != parentID || it.position != position {
+				if _, err := s.tx.Exec("UPDATE folder SET title=?, parentFolderId=?, position=?, updatedAt=? WHERE id=?", sub.Title, parentID, position, s.now, id); err != nil {
+					return err
+				}
+			}
+		default:
+			res, err := s.tx.Exec("INSERT INTO folder(title, parentFolderId, nbChildrenFolders, createdAt, updatedAt, position) values(?,?,0,?,?,?)", sub.Title, parentID, s.now, s.now, position)
+			if err != nil {
+				return err
+			}
+			lastID, _ := res.LastInsertId()
+			id = int(lastID)
+			if err = s.mapSyncID("folderId", sub.SyncId, id); err != nil {
+				return err
+			}
+		}
+		if err := s.applyFolder(sub, id); err != nil {
+			return err
+		}
+	}
+
+	for i, b := range f.Bookmarks {
+		position := float64(i + 1)
+		it, ok := s.bookmarks[b.SyncId]
+		switch {
+		case ok && !s.seenBookmarks[b.SyncId]:
+			s.seenBookmarks[b.SyncId] = true
+			if it.title != b.Title || it.url != b.URL || it.parentID != parentID || it.position != position {
+				if _, err := s.tx.Exec("UPDATE bookmark SET title=?, url=?, canonicalURL=?, folderId=?, position=?, updatedAt=? WHERE id=?", b.Title, b.URL, s.canonicalizer.Canonicalize(b.URL), parentID, position, s.now, it.id); err != nil {
+					return err
+				}
+			}
+		default:
+			res, err := s.tx.Exec("INSERT INTO bookmark(title, url, canonicalURL, folderId, favicon, starred, createdAt, updatedAt, position) values(?,?,?,?,'',0,?,?,?)", b.Title, b.URL, s.canonicalizer.Canonicalize(b.URL), parentID, s.now, s.now, position)
+			if err != nil {
+				return err
+			}
+			lastID, _ := res.LastInsertId()
+			if err = s.mapSyncID("bookmarkId", b.SyncId, int(lastID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteMissing deletes the synchronized folders and bookmarks
+// not found in the applied tree, the folders with their content.
+func (s *syncer) deleteMissing() error {
+	for syncID, it := range s.bookmarks {
+		if s.seenBookmarks[syncID] {
+			continue
+		}
+		for _, query := range deleteBookmarkQueries {
+			if _, err := s.tx.Exec(query, it.id); err != nil {
+				return err
+			}
+		}
+	}
+	for syncID, it := range s.folders {
+		if s.seenFolders[syncID] {
+			continue
+		}
+		// The seen items are already moved out of the folder.
+		for _, query := range deleteFolderQueries {
+			if _, err := s.tx.Exec(query, it.id); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := s.tx.Exec("UPDATE folder SET nbChildrenFolders=(SELECT count(*) FROM folder f WHERE f.parentFolderId=folder.id)")
+	return err
+}
+
+// ApplySyncTree applies the given synchronized tree as a diff in a single transaction:
+// the items with a known sync id are moved, renamed and ordered as in the tree,
+// the items with an unknown sync id are created and the known ones missing
+// from the tree are deleted. The items created since the last GetSyncTree,
+// without sync id, are kept.
+func (db *SQLiteDataStore) ApplySyncTree(root *types.SyncFolder) {
+	log.Debug("ApplySyncTree")
+	// Leaving silently on past errors...
+	if db.err != nil {
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, db.err = db.Begin(); db.err != nil {
+		log.Error("ApplySyncTree: transaction begin failed")
+		return
+	}
+	s := &syncer{
+		tx:            tx,
+		now:           time.Now().Unix(),
+		canonicalizer: db.Canonicalizer,
+		seenFolders:   make(map[int]bool),
+		seenBookmarks: make(map[int]bool),
+	}
+	if s.folders, db.err = loadSyncItems(tx, "SELECT s.id, f.id, f.parentFolderId, f.title, '', f.position FROM folder f JOIN syncid s ON s.folderId=f.id WHERE f.query=''"); db.err == nil {
+		if s.bookmarks, db.err = loadSyncItems(tx, "SELECT s.id, b.id, b.folderId, b.title, b.url, b.position FROM bookmark b JOIN syncid s ON s.bookmarkId=b.id"); db.err == nil {
+			if db.err = s.applyFolder(root, 1); db.err == nil {
+				db.err = s.deleteMissing()
+			}
+		}
+	}
+	// Rolling back on errors, or commit.
+	if db.err != nil {
+		log.WithFields(log.Fields{
+			"err": db.err,
+		}).Error("ApplySyncTree: query error")
+		if err := tx.Rollback(); err != nil {
+			// Just logging the error.
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("ApplySyncTree: transaction rollback error")
+		}
+		return
+	}
+	if db.err = tx.Commit(); db.err != nil {
+		log.Error("ApplySyncTree: transaction commit error")
+	}
+}
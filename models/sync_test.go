@@ -0,0 +1,166 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tbellembois/gobkm/types"
+)
+
+// syncTreeString returns the given tree as folder[content] and bookmark(url)
+// comma separated items, with their sync ids if withIDs.
+func syncTreeString(f *types.SyncFolder, withIDs bool) string {
+	var items []string
+	for _, sub := range f.Folders {
+		item := sub.Title + "[" + syncTreeString(sub, withIDs) + "]"
+		if withIDs {
+			item = fmt.Sprintf("%d:%s", sub.SyncId, item)
+		}
+		items = append(items, item)
+	}
+	for _, b := range f.Bookmarks {
+		item := b.Title + "(" + b.URL + ")"
+		if withIDs {
+			item = fmt.Sprintf("%d:%s", b.SyncId, item)
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ",")
+}
+
+// newSyncDatastore returns a datastore of the folders IT and IT/Development
+// with a bookmark in each and one in the root folder, and a smart folder.
+func newSyncDatastore(t *testing.T) *SQLiteDataStore {
+	db := newTestDatastore(t)
+	it := &types.Folder{Title: "IT"}
+	it.Id = int(db.SaveFolder(it))
+	dev := &types.Folder{Title: "Development", Parent: it}
+	dev.Id = int(db.SaveFolder(dev))
+	db.SaveFolder(&types.Folder{Title: "Starred", Query: "starred=true"})
+	db.SaveBookmark(&types.Bookmark{Title: "Go", URL: "https://golang.org/", Folder: dev})
+	db.SaveBookmark(&types.Bookmark{Title: "News", URL: "https://news.example.com/", Folder: it})
+	db.SaveBookmark(&types.Bookmark{Title: "Root", URL: "https://root.example.com/"})
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestGetSyncTree(t *testing.T) {
+	db := newSyncDatastore(t)
+
+	want := "1:IT[2:Development[3:Go(https://golang.org/)],4:News(https://news.example.com/)],5:Root(https://root.example.com/)"
+	for i := 0; i < 2; i++ {
+		root := db.GetSyncTree()
+		if err := db.FlushErrors(); err != nil {
+			t.Fatal(err)
+		}
+		// The sync ids are stable, the smart folders not synchronized.
+		if got := syncTreeString(root, true); got != want {
+			t.Errorf("GetSyncTree %d = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestApplySyncTree(t *testing.T) {
+	tests := []struct {
+		name string
+		// tree returns the tree to apply from the current one,
+		// the one of newSyncDatastore.
+		tree func(root *types.SyncFolder) *types.SyncFolder
+		want string
+	}{
+		{
+			name: "unchanged",
+			tree: func(root *types.SyncFolder) *types.SyncFolder { return root },
+			want: "IT[Development[Go(https://golang.org/)],News(https://news.example.com/)],Root(https://root.example.com/)",
+		},
+		{
+			name: "renamed, moved and ordered",
+			tree: func(root *types.SyncFolder) *types.SyncFolder {
+				it := root.Folders[0]
+				dev := it.Folders[0]
+				dev.Title = "Dev"
+				dev.Bookmarks[0].Title, dev.Bookmarks[0].URL = "Golang", "https://go.dev/"
+				// Development out of IT, Root into IT before News.
+				it.Folders = nil
+				it.Bookmarks = append([]*types.SyncBookmark{root.Bookmarks[0]}, it.Bookmarks...)
+				root.Folders = []*types.SyncFolder{dev, it}
+				root.Bookmarks = nil
+				return root
+			},
+			want: "Dev[Golang(https://go.dev/)],IT[Root(https://root.example.com/),News(https://news.example.com/)]",
+		},
+		{
+			name: "created",
+			tree: func(root *types.SyncFolder) *types.SyncFolder {
+				root.Folders = append(root.Folders, &types.SyncFolder{SyncId: 100, Title: "New", Bookmarks: []*types.SyncBookmark{{SyncId: 101, Title: "Rust", URL: "https://rust-lang.org/"}}})
+				// An item given twice is created the second time.
+				root.Bookmarks = append(root.Bookmarks, &types.SyncBookmark{SyncId: 5, Title: "Root", URL: "https://root.example.com/"})
+				return root
+			},
+			want: "IT[Development[Go(https://golang.org/)],News(https://news.example.com/)],New[Rust(https://rust-lang.org/)],Root(https://root.example.com/),Root(https://root.example.com/)",
+		},
+		{
+			name: "deleted",
+			tree: func(root *types.SyncFolder) *types.SyncFolder {
+				// IT with its content, but News moved out.
+				root.Bookmarks = append(root.Bookmarks, root.Folders[0].Bookmarks[0])
+				root.Folders = nil
+				return root
+			},
+			want: "Root(https://root.example.com/),News(https://news.example.com/)",
+		},
+	}
+	for _, tt := range tests {
+		db := newSyncDatastore(t)
+		root := db.GetSyncTree()
+		// A bookmark created since, unknown to the client.
+		db.SaveBookmark(&types.Bookmark{Title: "Later", URL: "https://later.example.com/"})
+
+		db.ApplySyncTree(tt.tree(root))
+		if err := db.FlushErrors(); err != nil {
+			t.Errorf("%s: ApplySyncTree error %v", tt.name, err)
+			continue
+		}
+		root = db.GetSyncTree()
+		if err := db.FlushErrors(); err != nil {
+			t.Fatal(err)
+		}
+		// The later bookmark keeps its position, compared apart.
+		got := syncTreeString(root, false)
+		later := "Later(https://later.example.com/)"
+		if !strings.Contains(got, later) {
+			t.Errorf("%s: tree %s without %s", tt.name, got, later)
+		}
+		if got = strings.Replace(strings.Replace(got, ","+later, "", 1), later+",", "", 1); got != tt.want {
+			t.Errorf("%s: tree %s, want %s", tt.name, got, tt.want)
+		}
+		// The smart folder is kept, and nothing orphaned.
+		if n := count(t, db, "SELECT COUNT(*) FROM folder WHERE query != ''"); n != 1 {
+			t.Errorf("%s: %d smart folders, want 1", tt.name, n)
+		}
+		if n := count(t, db, "SELECT COUNT(*) FROM bookmark WHERE folderId NOT IN (SELECT id FROM folder)"); n != 0 {
+			t.Errorf("%s: %d orphaned bookmarks", tt.name, n)
+		}
+		if n := count(t, db, "SELECT COUNT(*) FROM syncid WHERE folderId NOT IN (SELECT id FROM folder) OR bookmarkId NOT IN (SELECT id FROM bookmark)"); n != 0 {
+			t.Errorf("%s: %d orphaned sync ids", tt.name, n)
+		}
+	}
+}
+
+func TestApplySyncTreeClientIDs(t *testing.T) {
+	db := newSyncDatastore(t)
+	root := db.GetSyncTree()
+	root.Bookmarks = append(root.Bookmarks, &types.SyncBookmark{SyncId: 100, Title: "Rust", URL: "https://rust-lang.org/"})
+	db.ApplySyncTree(root)
+	if err := db.FlushErrors(); err != nil {
+		t.Fatal(err)
+	}
+	// The created bookmark keeps the client sync id.
+	root = db.GetSyncTree()
+	if got := syncTreeString(root, true); !strings.HasSuffix(got, ",100:Rust(https://rust-lang.org/)") {
+		t.Errorf("tree %s, want the 100 sync id of Rust", got)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/tbellembois/gobkm/handlers"
+)
+
+// readHeaderTimeout is the delay to read the request headers.
+const readHeaderTimeout = 10 * time.Second
+
+// newServer returns the server of the address and handler, with the timeouts of sf.
+func (sf *serverFlags) newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       *sf.readTimeout,
+		WriteTimeout:      *sf.writeTimeout,
+		IdleTimeout:       *sf.idleTimeout,
+	}
+}
+
+// serve serves the default mux, measured, on HTTP, or on HTTPS with a certificate,
+// until SIGINT or SIGTERM, then shuts down gracefully within sf.shutdownTimeout:
+// the in-flight requests then the background jobs are drained, the websocket
+// and the database closed. A second signal stops immediately.
+func serve(sf *serverFlags) error {
+	var (
+		err     error
+		servers []*http.Server
+		errs    = make(chan error, 2)
+	)
+
+	server := sf.newServer(*sf.listen, handlers.InstrumentMux(http.DefaultServeMux))
+	servers = append(servers, server)
+	if *sf.tlsCert == "" {
+		go func() { errs <- server.ListenAndServe() }()
+	} else {
+		if server.TLSConfig, err = sf.tlsConfig(); err != nil {
+			return err
+		}
+		go func() { errs <- server.ListenAndServeTLS("", "") }()
+		if *sf.httpRedirect != "" {
+			redirect := sf.newServer(*sf.httpRedirect, redirectHTTPS(*sf.listen))
+			servers = append(servers, redirect)
+			go func() { errs <- redirect.ListenAndServe() }()
+		}
+	}
+
+	// Waiting for a server error or a stop signal.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-errs:
+		return err
+	case sig := <-stop:
+		log.WithFields(log.Fields{
+			"signal":  sig,
+			"timeout": *sf.shutdownTimeout,
+		}).Info("serve:shutting down")
+	}
+	signal.Stop(stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *sf.shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err = s.Shutdown(ctx); err != nil {
+			log.WithFields(log.Fields{
+				"addr": s.Addr,
+				"err":  err,
+			}).Error("serve:server shutdown")
+		}
+	}
+	if err = handlers.Shutdown(ctx); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("serve:background jobs shutdown")
+	}
+	// The queries in progress are over before the database is closed.
+	if err = datastore.Close(); err != nil {
+		return err
+	}
+	log.Info("serve:stopped")
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tbellembois/gobkm/models"
+)
+
+func TestServeShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if datastore, err = models.NewDBstore(filepath.Join(dir, "bkm.db")); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	// Keeping the test alive whenever the signal comes.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	entered := make(chan struct{})
+	http.HandleFunc("/test/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	stopped := make(chan error)
+	go func() { stopped <- serve(newTestServerFlags(t, "-listen", addr, "-shutdowntimeout", "5s")) }()
+
+	// A request in flight when the server is stopped.
+	responses := make(chan string)
+	go func() {
+		for {
+			resp, err := http.Get("http://" + addr + "/test/slow")
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			responses <- string(body)
+			return
+		}
+	}()
+	<-entered
+	tick := time.NewTicker(20 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		select {
+		case err = <-stopped:
+		case <-tick.C:
+			continue
+		}
+		break
+	}
+	if err != nil {
+		t.Fatalf("serve error %v", err)
+	}
+	if body := <-responses; body != "done" {
+		t.Errorf("in-flight request response %q, want done", body)
+	}
+	if err = datastore.Ping(); err == nil {
+		t.Error("database still open")
+	}
+	if _, err = http.Get("http://" + addr + "/test/slow"); err == nil {
+		t.Error("request served once stopped")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gopherjs/gopherjs/js"
 	"github.com/gopherjs/websocket"
@@ -22,6 +23,7 @@ const (
 	ClassRenameOver              = "rename-over"
 	ClassDeleteOver              = "delete-over"
 	ClassItemFolder              = "folder"
+	ClassReadingListItem         = "reading-list-item"
 	ClassItemFolderAwesome       = "fa"
 	ClassItemFolderAwesomeOpen   = "fa-folder-open-o"
 	ClassItemFolderAwesomeClosed = "fa-folder-o"
@@ -32,12 +34,27 @@ const (
 	ClassItemBookmarkLinkEdited  = "bookmark-link-edited"
 	ClassBookmarkStarred         = "fa fa-star"
 	ClassBookmarkNotStarred      = "fa fa-star-o"
+	ClassBookmarkNote            = "bookmark-note fa fa-sticky-note-o"
+	ClassBookmarkDescription     = "bookmark-description"
+	ClassDropBefore              = "drop-before"
+	ClassDuplicateWarning        = "duplicate-warning"
+	ClassDuplicatesGroup         = "duplicates-group"
+	ClassSelectedItem            = "selected-item"
+	ClassShareItem               = "share-item"
 )
 
+// folderSortModes are the folders sort modes, in the order
+// they are cycled through with the "s" key.
+var folderSortModes = []string{"title", "manual", "created", "visits", "frecency"}
+
 var (
 	w           dom.Window
 	d           dom.Document
 	changeTimer int
+	// finishedImportJobs are the ids of the import jobs that are over.
+	finishedImportJobs = make(map[int]bool)
+	// lastSelectedID is the id of the last item selected with ctrl or shift click.
+	lastSelectedID string
 )
 
 type folderStruct struct {
@@ -154,6 +171,7 @@ func resetAll() {
 	clearSearchResults()
 	hideImport()
 	hideRenameBox()
+	hideDescriptionBox()
 	enableItem("add-folder")
 	enableItem("add-folder-button")
 
@@ -189,6 +207,11 @@ func setRenameFormValue(val string) {
 func setRenameHiddenFormValue(val string) {
 	setItemValue("rename-hidden-input-box-form", val)
 }
+func hideDescriptionBox() {
+	hideItem("description-input-box")
+	resetItemValue("description-hidden-input-box-form")
+	d.GetElementByID("description-input-box-form").(*dom.HTMLTextAreaElement).Value = ""
+}
 func hideImport() {
 	hideItem("import-input-box")
 }
@@ -204,23 +227,29 @@ func clearSearchResults() {
 	d.GetElementByID("search-result").SetInnerHTML("")
 }
 
-func displaySubfolder(pFldID string, fldID string, fldTitle string, nbChildrenFolders int) {
+// refreshTree reloads the folders tree from the root folder.
+func refreshTree() {
+	undisplayChildrenFolders("1")
+	d.GetElementByID("folder-1").(*dom.HTMLDivElement).Click()
+}
+
+func displaySubfolder(pFldID string, fldID string, fldTitle string, nbChildrenFolders int, fldSortMode string, fldQuery string) {
 	if d.GetElementByID("folder-"+fldID) != nil {
 		return
 	}
 
-	newFld := createFolder(fldID, fldTitle, nbChildrenFolders)
+	newFld := createFolder(fldID, fldTitle, nbChildrenFolders, fldSortMode, fldQuery)
 
 	d.GetElementByID("subfolders-" + pFldID).AppendChild(newFld.fld)
 	d.GetElementByID("subfolders-" + pFldID).AppendChild(newFld.subFlds)
 }
 
-func displayBookmark(pFldID string, bkmID string, bkmTitle string, bkmURL string, bkmFavicon string, bkmStarred bool) {
+func displayBookmark(pFldID string, bkmID string, bkmTitle string, bkmURL string, bkmFavicon string, bkmStarred bool, bkmDescription string, bkmKeyword string, bkmTags []string) {
 	if d.GetElementByID("bookmark-"+bkmID) != nil {
 		return
 	}
 
-	newBkm := createBookmark(bkmID, bkmTitle, bkmURL, bkmFavicon, bkmStarred, false)
+	newBkm := createBookmark(bkmID, bkmTitle, bkmURL, bkmFavicon, bkmStarred, false, bkmDescription, bkmKeyword, bkmTags)
 
 	d.GetElementByID("subfolders-" + pFldID).AppendChild(newBkm)
 }
@@ -230,11 +259,41 @@ func displayBookmark(pFldID string, bkmID string, bkmTitle string, bkmURL string
 //
 func keyDownItem(e dom.Event) {
 	ke := e.(*dom.KeyboardEvent)
-	if ke.KeyCode == 82 {
+	if ke.KeyCode == 82 && e.Target().(dom.HTMLElement).ID() != "folder-1" {
 		e.PreventDefault()
 		id := e.Target().(dom.HTMLElement).ID()
 		dropRename(string(id))
 	}
+	// "d" edits the bookmarks description.
+	if ke.KeyCode == 68 && strings.HasPrefix(e.Target().(dom.HTMLElement).ID(), "bookmark-link-") {
+		e.PreventDefault()
+		editDescription(e.Target().(dom.HTMLElement).ID())
+	}
+	// "k" edits the bookmarks keyword.
+	if ke.KeyCode == 75 && strings.HasPrefix(e.Target().(dom.HTMLElement).ID(), "bookmark-link-") {
+		e.PreventDefault()
+		keywordBookmark(e.Target().(dom.HTMLElement))
+	}
+	// "t" tags the bookmark, or the selected bookmarks.
+	if ke.KeyCode == 84 && strings.HasPrefix(e.Target().(dom.HTMLElement).ID(), "bookmark-link-") {
+		e.PreventDefault()
+		tagBookmarks(e.Target().(dom.HTMLElement))
+	}
+	// "s" changes the folders sort mode.
+	if ke.KeyCode == 83 && strings.HasPrefix(e.Target().(dom.HTMLElement).ID(), "folder-") {
+		e.PreventDefault()
+		sortFolder(e.Target().(dom.HTMLElement))
+	}
+	// "f" opens the Atom feed of the folder.
+	if ke.KeyCode == 70 && strings.HasPrefix(e.Target().(dom.HTMLElement).ID(), "folder-") {
+		e.PreventDefault()
+		openInParent("/feeds/folder/" + strings.Split(e.Target().(dom.HTMLElement).ID(), "-")[1] + ".atom")
+	}
+	// "l" creates a share link of the folder.
+	if ke.KeyCode == 76 && strings.HasPrefix(e.Target().(dom.HTMLElement).ID(), "folder-") {
+		e.PreventDefault()
+		shareFolder(e.Target().(dom.HTMLElement))
+	}
 }
 
 func mouseOverItem(e dom.Event) {
@@ -244,12 +303,43 @@ func mouseOverItem(e dom.Event) {
 
 func dragOverItem(e dom.Event) {
 	e.PreventDefault()
-	addClass(e.Target().(dom.HTMLElement), ClassItemOver)
+	if isDropBefore(e) {
+		removeClass(e.Target().(dom.HTMLElement), ClassItemOver)
+		addClass(e.Target().(dom.HTMLElement), ClassDropBefore)
+	} else {
+		removeClass(e.Target().(dom.HTMLElement), ClassDropBefore)
+		addClass(e.Target().(dom.HTMLElement), ClassItemOver)
+	}
 }
 
 func leaveItem(e dom.Event) {
 	e.PreventDefault()
 	removeClass(e.Target().(dom.HTMLElement), ClassItemOver)
+	removeClass(e.Target().(dom.HTMLElement), ClassDropBefore)
+}
+
+func dragOverBookmark(e dom.Event) {
+	e.PreventDefault()
+	if bkm := getClosest(e.Target(), "."+ClassItemBookmark); bkm != nil {
+		addClass(bkm.(dom.HTMLElement), ClassDropBefore)
+	}
+}
+
+func leaveBookmark(e dom.Event) {
+	e.PreventDefault()
+	if bkm := getClosest(e.Target(), "."+ClassItemBookmark); bkm != nil {
+		removeClass(bkm.(dom.HTMLElement), ClassDropBefore)
+	}
+}
+
+// isDropBefore returns true if the drag event is over the top third of a folder
+// but the root one, a folder dropped there is moved before and not into the folder.
+func isDropBefore(e dom.Event) bool {
+	el := e.Target().(dom.HTMLElement)
+	if el.ID() == "folder-1" {
+		return false
+	}
+	return e.(*dom.DragEvent).Get("offsetY").Float() < el.OffsetHeight()/3
 }
 
 func overRename(e dom.Event) {
@@ -329,16 +419,23 @@ func createCloseDivButton(divID string) dom.HTMLElement {
 	return b
 }
 
-func createBookmark(bkmID string, bkmTitle string, bkmURL string, bkmFavicon string, bkmStarred bool, starred bool) dom.HTMLElement {
+func createBookmark(bkmID string, bkmTitle string, bkmURL string, bkmFavicon string, bkmStarred bool, starred bool, bkmDescription string, bkmKeyword string, bkmTags []string) dom.HTMLElement {
 	// Link (actually a clickable div).
 	//a := d.CreateElement("div").(*dom.HTMLDivElement)
 	a := d.CreateElement("span").(*dom.HTMLSpanElement)
 	a.SetTitle(bkmURL)
+	if len(bkmTags) != 0 {
+		a.SetTitle(bkmURL + "\ntags: " + strings.Join(bkmTags, ", "))
+	}
 	a.SetAttribute("tabindex", "0")
 	a.AppendChild(d.CreateTextNode(bkmTitle))
-	a.AddEventListener("click", false, func(e dom.Event) { openInParent(bkmURL) })
 	// Main div.
 	md := d.CreateElement("div").(*dom.HTMLDivElement)
+	if !starred {
+		// Selecting before opening.
+		a.AddEventListener("click", false, func(e dom.Event) { selectItem(e, md) })
+	}
+	a.AddEventListener("click", false, func(e dom.Event) { openInParent(goURL(bkmID)) })
 	md.SetClass(ClassItemBookmark)
 	// Favicon.
 	fav := d.CreateElement("img").(*dom.HTMLImageElement)
@@ -347,6 +444,16 @@ func createBookmark(bkmID string, bkmTitle string, bkmURL string, bkmFavicon str
 	// Star.
 	str := d.CreateElement("div").(*dom.HTMLDivElement)
 	str.AddEventListener("click", false, func(e dom.Event) { starBookmark(bkmID, false) })
+	// Description and keyword.
+	a.SetAttribute("data-description", bkmDescription)
+	a.SetAttribute("data-keyword", bkmKeyword)
+	a.SetAttribute("data-tags", strings.Join(bkmTags, ", "))
+	note := d.CreateElement("div").(*dom.HTMLDivElement)
+	note.SetClass(ClassBookmarkNote)
+	note.SetTitle("show the note")
+	if bkmDescription == "" {
+		note.Style().SetProperty("display", "none", "")
+	}
 
 	if starred {
 		a.SetID("bookmark-starred-link-" + bkmID)
@@ -359,6 +466,8 @@ func createBookmark(bkmID string, bkmTitle string, bkmURL string, bkmFavicon str
 		a.SetID("bookmark-link-" + bkmID)
 		a.SetClass("bookmark-link")
 		md.SetID("bookmark-" + bkmID)
+		note.SetID("bookmark-note-" + bkmID)
+		note.AddEventListener("click", false, func(e dom.Event) { toggleDescription(bkmID) })
 		md.SetDraggable(true)
 		str.SetID("bookmark-star-" + bkmID)
 		if bkmStarred {
@@ -368,6 +477,9 @@ func createBookmark(bkmID string, bkmTitle string, bkmURL string, bkmFavicon str
 		}
 		md.AddEventListener("dragstart", false, dragStartItem)
 		md.AddEventListener("drag", false, dragItem)
+		md.AddEventListener("dragover", false, func(e dom.Event) { dragOverBookmark(e) })
+		md.AddEventListener("dragleave", false, func(e dom.Event) { leaveBookmark(e) })
+		md.AddEventListener("drop", false, func(e dom.Event) { dropBookmark(e) })
 		a.AddEventListener("mouseover", false, func(e dom.Event) { mouseOverItem(e) })
 		a.AddEventListener("keydown", false, func(e dom.Event) { keyDownItem(e) })
 	}
@@ -375,15 +487,23 @@ func createBookmark(bkmID string, bkmTitle string, bkmURL string, bkmFavicon str
 	md.AppendChild(str)
 	md.AppendChild(fav)
 	md.AppendChild(a)
+	if !starred {
+		md.AppendChild(note)
+	}
 
 	return md
 }
 
-func createFolder(fldID string, fldTitle string, nbChildrenFolders int) folderStruct {
+func createFolder(fldID string, fldTitle string, nbChildrenFolders int, fldSortMode string, fldQuery string) folderStruct {
 	// Main div.
 	md := d.CreateElement("div").(*dom.HTMLDivElement)
 	md.SetTitle(fldTitle)
+	md.SetAttribute("data-sort-mode", fldSortMode)
 	md.SetClass(ClassItemFolder + " " + ClassItemFolderClosed)
+	// Smart folders content is their saved search.
+	if fldQuery != "" {
+		md.SetAttribute("data-query", fldQuery)
+	}
 	md.SetAttribute("tabindex", "0")
 	md.SetID("folder-" + fldID)
 	md.SetDraggable(true)
@@ -394,6 +514,8 @@ func createFolder(fldID string, fldTitle string, nbChildrenFolders int) folderSt
 	md.AppendChild(d.CreateTextNode(" " + fldTitle))
 	md.AppendChild(ul)
 
+	// Selecting before opening.
+	md.AddEventListener("click", false, func(e dom.Event) { selectItem(e, md) })
 	md.AddEventListener("click", false, func(e dom.Event) { getChildrenItems(e, fldID) })
 	md.AddEventListener("mouseover", false, func(e dom.Event) { mouseOverItem(e) })
 	md.AddEventListener("keydown", false, func(e dom.Event) { keyDownItem(e) })
@@ -446,25 +568,561 @@ func sendRequest(url string, args []arg) *http.Response {
 	return resp
 }
 
+func setImportFolder(fldID string, fldTitle string) {
+	d.GetElementByID("import-folder-id").(*dom.HTMLInputElement).Value = fldID
+	d.GetElementByID("import-folder-title").SetTextContent(fldTitle)
+}
+
+func displayImportReport(report *types.ImportReport) {
+	r := d.GetElementByID("import-report")
+	r.SetInnerHTML("")
+
+	summary := fmt.Sprintf("%d created, %d skipped, %d updated, %d malformed", len(report.Created), len(report.Skipped), len(report.Updated), len(report.Malformed))
+	if report.DryRun {
+		summary = "dry run: " + summary
+	}
+	r.AppendChild(d.CreateTextNode(summary))
+
+	// Malformed entries are always shown, other details only for dry runs.
+	actions := []string{"malformed", "created", "skipped", "updated"}
+	for i, entries := range [][]types.ImportReportEntry{report.Malformed, report.Created, report.Skipped, report.Updated} {
+		if i > 0 && !report.DryRun {
+			break
+		}
+		for _, entry := range entries {
+			div := d.CreateElement("div").(*dom.HTMLDivElement)
+			text := fmt.Sprintf("%s %s %s: %s", actions[i], entry.Type, entry.Path, entry.Title)
+			if entry.Reason != "" {
+				text += " (" + entry.Reason + ")"
+			}
+			div.SetTextContent(text)
+			r.AppendChild(div)
+		}
+	}
+}
+
+// displayImportJob displays the progress of the given import job,
+// and its report when it is over.
+func displayImportJob(job types.ImportJob) {
+	// The progress messages may be received after the final one.
+	if job.Status == types.ImportJobRunning && finishedImportJobs[job.Id] {
+		return
+	}
+
+	r := d.GetElementByID("import-report")
+	r.SetInnerHTML("")
+
+	if job.Status == types.ImportJobRunning {
+		r.AppendChild(d.CreateTextNode(fmt.Sprintf("importing: %d folders, %d bookmarks, %d malformed ", job.Folders, job.Bookmarks, job.Malformed)))
+		c := d.CreateElement("button").(*dom.HTMLButtonElement)
+		c.SetTextContent("cancel")
+		c.AddEventListener("click", false, func(e dom.Event) {
+			e.PreventDefault()
+			go func() {
+				if resp := sendRequest("/cancelImportJob/", []arg{{key: "jobId", val: strconv.Itoa(job.Id)}}); resp != nil {
+					resp.Body.Close()
+				}
+			}()
+		})
+		r.AppendChild(c)
+		return
+	}
+	finishedImportJobs[job.Id] = true
+
+	go func() {
+		var (
+			err  error
+			resp *http.Response
+		)
+
+		// Getting the job report.
+		if resp = sendRequest("/getImportJob/", []arg{{key: "jobId", val: strconv.Itoa(job.Id)}}); resp.StatusCode != http.StatusOK {
+			fmt.Println("getImportJob response code error")
+			return
+		}
+		defer resp.Body.Close()
+
+		if err = json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			fmt.Println("getImportJob JSON decoder error", err.Error())
+			return
+		}
+		if job.Report != nil {
+			displayImportReport(job.Report)
+		}
+		if job.Status != types.ImportJobDone {
+			r.InsertBefore(d.CreateTextNode("import "+job.Status+" "+job.Error+": "), r.FirstChild())
+		}
+
+		setItemValue("import-button", "import")
+		enableItem("import-button")
+		if job.Report != nil && !job.Report.DryRun {
+			refreshTree()
+		}
+	}()
+}
+
+// displayBackupStatus shows an alert when the last scheduled backup failed.
+func displayBackupStatus(status types.BackupStatus) {
+	if !status.Enabled || status.Error == "" {
+		hideItem("backup-alert")
+		return
+	}
+	last := "never"
+	if !status.LastSuccess.IsZero() {
+		last = status.LastSuccess.Format("2006-01-02 15:04")
+	}
+	d.GetElementByID("backup-alert").SetTextContent(" backup failed: " + status.Error + " - last successful backup: " + last)
+	showItem("backup-alert")
+}
+
+// getBackupStatus displays the state of the scheduled backups.
+func getBackupStatus() {
+	var status types.BackupStatus
+
+	resp := sendRequest("/getBackupStatus/", nil)
+	if resp == nil || resp.StatusCode != http.StatusOK {
+		fmt.Println("getBackupStatus response code error")
+		return
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		fmt.Println("getBackupStatus JSON decoder error", err.Error())
+		return
+	}
+	displayBackupStatus(status)
+}
+
 func importBookmarks(e dom.Event) {
 	e.PreventDefault()
 	go func() {
 		setWait()
-		setItemValue("import-button", "importing...")
+		defer unsetWait()
 
 		fileSelect := d.GetElementByID("import-file").(*dom.HTMLInputElement)
 		file := fileSelect.Files()[0]
 
-		req := xhr.NewRequest("POST", "/import/")
-		if err := req.Send(file.Object); err != nil {
+		fldID := d.GetElementByID("import-folder-id").(*dom.HTMLInputElement).Value
+		mode := d.GetElementByID("import-mode").(*dom.HTMLSelectElement).Value
+		dryRun := d.GetElementByID("import-dryrun").(*dom.HTMLInputElement).Checked
+
+		req := xhr.NewRequest("POST", fmt.Sprintf("/import/?folderId=%s&mode=%s&dryRun=%t", fldID, mode, dryRun))
+		if err := req.Send(file.Object); err != nil || req.Status != http.StatusAccepted {
 			fmt.Println("importBookmarks response code error")
 			return
 		}
 
-		unsetWait()
-		setItemValue("import-button", "import")
+		// The job progress is then received through the websocket.
+		var job types.ImportJob
+		if err := json.Unmarshal([]byte(req.ResponseText), &job); err != nil {
+			fmt.Println("importBookmarks JSON decoder error", err.Error())
+			return
+		}
+		setItemValue("import-button", "importing...")
+		disableItem("import-button")
+		displayImportJob(job)
+	}()
+}
+
+// exportURL returns the export URL for the selected format and the given scope parameter.
+func exportURL(scope string) string {
+	format := d.GetElementByID("export-format").(*dom.HTMLSelectElement).Value
+	return "/export/?format=" + format + scope
+}
+
+func exportBookmarks() {
+	var scope string
+	switch d.GetElementByID("export-scope").(*dom.HTMLSelectElement).Value {
+	case "folder":
+		// The last opened folder is also the import destination.
+		scope = "&folderId=" + d.GetElementByID("import-folder-id").(*dom.HTMLInputElement).Value
+	case "starred":
+		scope = "&starred=true"
+	}
+	openInParent(exportURL(scope))
+}
+
+// sortArgs returns the request arguments of the selected sort order,
+// such as created:desc, none for the folders sort modes.
+func sortArgs() []arg {
+	v := d.GetElementByID("sort-order").(*dom.HTMLSelectElement).Value
+	if v == "" {
+		return nil
+	}
+	s := strings.Split(v, ":")
+	args := []arg{{key: "sort", val: s[0]}}
+	if len(s) > 1 {
+		args = append(args, arg{key: "order", val: s[1]})
+	}
+	return args
+}
+
+// goURL returns the URL of the given bookmark through the
+// visits recording redirect.
+func goURL(bkmID string) string {
+	return "/go/" + bkmID
+}
+
+// displaySearchResults displays the given bookmarks in the search results,
+// with an export link of exportScope if not empty.
+func displaySearchResults(dataBkm []types.Bookmark, exportScope string) {
+	b := createCloseDivButton("search-result")
+	d.GetElementByID("search-result").AppendChild(b)
+	// Search results export link.
+	if exportScope != "" {
+		ex := d.CreateElement("div").(*dom.HTMLDivElement)
+		ex.SetClass("fa fa-floppy-o")
+		ex.SetID("export-search-result")
+		ex.SetTitle("export the search results")
+		ex.AddEventListener("click", false, func(e dom.Event) { openInParent(exportURL(exportScope)) })
+		d.GetElementByID("search-result").AppendChild(ex)
+		// Saving the search as a smart folder.
+		sf := d.CreateElement("div").(*dom.HTMLDivElement)
+		sf.SetClass("fa fa-filter")
+		sf.SetID("save-search-result")
+		sf.SetTitle("save the search as a smart folder")
+		sf.AddEventListener("click", false, func(e dom.Event) { addSmartFolder(strings.TrimPrefix(exportScope, "&")) })
+		d.GetElementByID("search-result").AppendChild(sf)
+	}
+	for _, bkm := range dataBkm {
+		newBkm := createBookmark(strconv.Itoa(bkm.Id), bkm.Title, bkm.URL, bkm.Favicon, bkm.Starred, false, bkm.Description, bkm.Keyword, bkm.Tags)
+		d.GetElementByID("search-result").AppendChild(newBkm)
+	}
+}
+
+// displayDuplicateWarning warns in the search results that
+// a new bookmark is already bookmarked as the given bookmarks.
+func displayDuplicateWarning(dataBkm []*types.Bookmark) {
+	clearSearchResults()
+	d.GetElementByID("search-result").AppendChild(createCloseDivButton("search-result"))
+	for _, bkm := range dataBkm {
+		msg := d.CreateElement("div").(*dom.HTMLDivElement)
+		msg.SetClass(ClassDuplicateWarning)
+		fldTitle := "/"
+		if bkm.Folder != nil {
+			fldTitle = bkm.Folder.Title
+		}
+		msg.SetTextContent("already bookmarked in folder " + fldTitle + ": " + bkm.Title)
+		d.GetElementByID("search-result").AppendChild(msg)
+	}
+}
+
+// findDuplicates displays the groups of duplicate bookmarks
+// with a merge button for each group.
+func findDuplicates() {
+
+	go func() {
+
+		setWait()
+		hideRenameBox()
+		hideImport()
+		defer unsetWait()
+
+		var (
+			err    error
+			resp   *http.Response
+			groups [][]types.Bookmark
+		)
+
+		if resp = sendRequest("/getDuplicates/", nil); resp.StatusCode != http.StatusOK {
+			fmt.Println("getDuplicates response code error")
+			return
+		}
+		defer resp.Body.Close()
+
+		if err = json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+			fmt.Println("getDuplicates JSON decoder error", err.Error())
+			return
+		}
+
+		clearSearchResults()
+		d.GetElementByID("search-result").AppendChild(createCloseDivButton("search-result"))
+		if len(groups) == 0 {
+			msg := d.CreateElement("div").(*dom.HTMLDivElement)
+			msg.SetTextContent("no duplicate bookmarks")
+			d.GetElementByID("search-result").AppendChild(msg)
+		}
+		for _, group := range groups {
+			d.GetElementByID("search-result").AppendChild(createDuplicatesGroup(group))
+		}
+	}()
+}
+
+// createDuplicatesGroup returns a group of duplicate bookmarks
+// with their merge button.
+func createDuplicatesGroup(group []types.Bookmark) dom.HTMLElement {
+	gd := d.CreateElement("div").(*dom.HTMLDivElement)
+	gd.SetClass(ClassDuplicatesGroup)
+
+	var args []arg
+	for _, bkm := range group {
+		args = append(args, arg{key: "bookmarkId", val: strconv.Itoa(bkm.Id)})
+	}
+	mb := d.CreateElement("button").(*dom.HTMLButtonElement)
+	mb.SetTextContent("merge")
+	mb.SetTitle("keep the oldest bookmark with the best title, all the notes and visits")
+	mb.AddEventListener("click", false, func(e dom.Event) {
+		go func() {
+			resp := sendRequest("/mergeDuplicates/", args)
+			if resp == nil || resp.StatusCode != http.StatusOK {
+				fmt.Println("mergeDuplicates response code error")
+				return
+			}
+			defer resp.Body.Close()
+			gd.ParentNode().RemoveChild(gd)
+			refreshTree()
+		}()
+	})
+	gd.AppendChild(mb)
+
+	for _, bkm := range group {
+		fldTitle := "/"
+		if bkm.Folder != nil {
+			fldTitle = bkm.Folder.Title
+		}
+		fd := d.CreateElement("div").(*dom.HTMLDivElement)
+		fd.SetTextContent("in folder " + fldTitle + ":")
+		gd.AppendChild(fd)
+		gd.AppendChild(createBookmark(strconv.Itoa(bkm.Id), bkm.Title, bkm.URL, bkm.Favicon, bkm.Starred, false, bkm.Description, bkm.Keyword, bkm.Tags))
+	}
+	return gd
+}
+
+// readingList displays the unread bookmarks, the last added first,
+// with their mark as read and archive buttons.
+func readingList() {
+
+	go func() {
+
+		setWait()
+		hideRenameBox()
+		hideImport()
+		defer unsetWait()
+
+		var (
+			err     error
+			resp    *http.Response
+			dataBkm []types.Bookmark
+		)
+
+		if resp = sendRequest("/getReadingList/", nil); resp.StatusCode != http.StatusOK {
+			fmt.Println("getReadingList response code error")
+			return
+		}
+		defer resp.Body.Close()
+
+		if err = json.NewDecoder(resp.Body).Decode(&dataBkm); err != nil {
+			fmt.Println("getReadingList JSON decoder error", err.Error())
+			return
+		}
+
+		clearSearchResults()
+		d.GetElementByID("search-result").AppendChild(createCloseDivButton("search-result"))
+		feed := d.CreateElement("div").(*dom.HTMLDivElement)
+		feed.SetClass("fa fa-rss")
+		feed.SetTitle("reading list Atom feed")
+		feed.AddEventListener("click", false, func(e dom.Event) { openInParent("/readingList.atom") })
+		d.GetElementByID("search-result").AppendChild(feed)
+		if len(dataBkm) == 0 {
+			msg := d.CreateElement("div").(*dom.HTMLDivElement)
+			msg.SetTextContent("nothing to read")
+			d.GetElementByID("search-result").AppendChild(msg)
+		}
+		for _, bkm := range dataBkm {
+			d.GetElementByID("search-result").AppendChild(createReadingListItem(bkm))
+		}
+	}()
+}
+
+// createReadingListItem returns a reading list bookmark
+// with its mark as read and archive buttons.
+func createReadingListItem(bkm types.Bookmark) dom.HTMLElement {
+	item := d.CreateElement("div").(*dom.HTMLDivElement)
+	item.SetClass(ClassReadingListItem)
+
+	bkmID := strconv.Itoa(bkm.Id)
+	for _, action := range []struct {
+		url, class, title string
+	}{
+		{"/archiveBookmark/", "fa fa-archive", "archive"},
+		{"/readBookmark/", "fa fa-check", "mark as read"},
+	} {
+		action := action
+		b := d.CreateElement("span").(*dom.HTMLSpanElement)
+		b.SetClass(action.class)
+		b.SetTitle(action.title)
+		b.AddEventListener("click", false, func(e dom.Event) {
+			go func() {
+				resp := sendRequest(action.url, []arg{{key: "bookmarkId", val: bkmID}})
+				if resp == nil || resp.StatusCode != http.StatusOK {
+					fmt.Println(action.url + " response code error")
+					return
+				}
+				defer resp.Body.Close()
+				item.ParentNode().RemoveChild(item)
+			}()
+		})
+		item.AppendChild(b)
+	}
+	item.AppendChild(createBookmark(bkmID, bkm.Title, bkm.URL, bkm.Favicon, bkm.Starred, false, bkm.Description, bkm.Keyword, bkm.Tags))
+	return item
+}
+
+// shareURL returns the URL of the share link of the given token.
+func shareURL(token string) string {
+	return js.Global.Get("GoBkmProxyURL").String() + "/share/" + token
+}
+
+// shareFolder prompts for the expiry date of a share link of the given folder element,
+// creates it and displays the shares.
+func shareFolder(fld dom.HTMLElement) {
+	exp := js.Global.Call("prompt", "share link expiry date YYYY-MM-DD, empty for none", "")
+	if exp == nil || exp == js.Undefined {
+		// Cancelled.
+		return
+	}
+
+	go func() {
+
+		var resp *http.Response
+
+		fldIDDigit := strings.Split(fld.ID(), "-")[1]
+		expiresAt := strings.TrimSpace(exp.String())
+
+		if resp = sendRequest("/addShare/", []arg{{key: "folderId", val: fldIDDigit}, {key: "expiresAt", val: url.QueryEscape(expiresAt)}}); resp.StatusCode != http.StatusOK {
+			fmt.Println("addShare response code error")
+			if resp.StatusCode == http.StatusBadRequest {
+				js.Global.Call("alert", "invalid expiry date "+expiresAt)
+			}
+			return
+		}
+		defer resp.Body.Close()
+
+		fmt.Println("folder " + fldIDDigit + " shared")
+		displayShares()
+	}()
+
+}
+
+// displayShares displays the share links not expired
+// with their revoke buttons.
+func displayShares() {
+
+	go func() {
+
+		setWait()
+		hideRenameBox()
 		hideImport()
-		d.GetElementByID("folder-1").(*dom.HTMLDivElement).Click()
+		defer unsetWait()
+
+		var (
+			err    error
+			resp   *http.Response
+			shares []types.Share
+		)
+
+		if resp = sendRequest("/getShares/", nil); resp.StatusCode != http.StatusOK {
+			fmt.Println("getShares response code error")
+			return
+		}
+		defer resp.Body.Close()
+
+		if err = json.NewDecoder(resp.Body).Decode(&shares); err != nil {
+			fmt.Println("getShares JSON decoder error", err.Error())
+			return
+		}
+
+		clearSearchResults()
+		d.GetElementByID("search-result").AppendChild(createCloseDivButton("search-result"))
+		if len(shares) == 0 {
+			msg := d.CreateElement("div").(*dom.HTMLDivElement)
+			msg.SetTextContent("no share links, create one with the l key over a folder")
+			d.GetElementByID("search-result").AppendChild(msg)
+		}
+		for _, sh := range shares {
+			d.GetElementByID("search-result").AppendChild(createShareItem(sh))
+		}
+	}()
+}
+
+// createShareItem returns a share link with its revoke button.
+func createShareItem(sh types.Share) dom.HTMLElement {
+	item := d.CreateElement("div").(*dom.HTMLDivElement)
+	item.SetClass(ClassShareItem)
+
+	b := d.CreateElement("span").(*dom.HTMLSpanElement)
+	b.SetClass("fa fa-ban")
+	b.SetTitle("revoke")
+	b.AddEventListener("click", false, func(e dom.Event) {
+		go func() {
+			resp := sendRequest("/deleteShare/", []arg{{key: "token", val: sh.Token}})
+			if resp == nil || resp.StatusCode != http.StatusOK {
+				fmt.Println("deleteShare response code error")
+				return
+			}
+			defer resp.Body.Close()
+			item.ParentNode().RemoveChild(item)
+		}()
+	})
+	item.AppendChild(b)
+
+	fldTitle := "/"
+	if sh.Folder != nil {
+		fldTitle = sh.Folder.Title
+	}
+	until := ""
+	if !sh.ExpiresAt.IsZero() {
+		until = " until " + sh.ExpiresAt.Format("2006-01-02")
+	}
+	a := d.CreateElement("a").(*dom.HTMLAnchorElement)
+	a.Href = shareURL(sh.Token)
+	a.Target = "_blank"
+	a.SetTextContent(fldTitle + until)
+	a.SetTitle(a.Href)
+	item.AppendChild(a)
+	return item
+}
+
+// staleBookmarks displays the bookmarks added and not visited
+// for the selected number of months.
+func staleBookmarks() {
+
+	go func() {
+
+		setWait()
+		hideRenameBox()
+		hideImport()
+		defer unsetWait()
+
+		var (
+			err     error
+			resp    *http.Response
+			dataBkm []types.Bookmark
+		)
+
+		sel := d.GetElementByID("stale-filter").(*dom.HTMLSelectElement)
+		months, _ := strconv.Atoi(sel.Value)
+		sel.Set("value", "")
+		if months == 0 {
+			return
+		}
+		since := time.Now().AddDate(0, -months, 0).Format("2006-01-02")
+
+		// Getting the bookmarks.
+		args := append([]arg{{key: "notVisitedSince", val: since}, {key: "createdBefore", val: since}}, sortArgs()...)
+		if resp = sendRequest("/getBookmarks/", args); resp.StatusCode != http.StatusOK {
+			fmt.Println("getBookmarks response code error")
+			return
+		}
+		defer resp.Body.Close()
+
+		if err = json.NewDecoder(resp.Body).Decode(&dataBkm); err != nil {
+			fmt.Println("getBookmarks JSON decoder error", err.Error())
+			return
+		}
+
+		clearSearchResults()
+		displaySearchResults(dataBkm, "")
 	}()
 }
 
@@ -486,7 +1144,7 @@ func searchBookmark() {
 		s := d.GetElementByID("search-form-input").(*dom.HTMLInputElement).Value
 
 		// Searching the bookmarks.
-		if resp = sendRequest("/searchBookmarks/", []arg{{key: "search", val: s}}); resp.StatusCode != http.StatusOK {
+		if resp = sendRequest("/searchBookmarks/", append([]arg{{key: "search", val: s}}, sortArgs()...)); resp.StatusCode != http.StatusOK {
 			fmt.Println("searchBookmarks response code error")
 			return
 		}
@@ -497,13 +1155,7 @@ func searchBookmark() {
 			return
 		}
 
-		b := createCloseDivButton("search-result")
-		d.GetElementByID("search-result").AppendChild(b)
-		for _, bkm := range dataBkm {
-			//displaySubfolder(fldIDDigit, strconv.Itoa(fld.Id), fld.Title, fld.NbChildrenFolders)
-			newBkm := createBookmark(strconv.Itoa(bkm.Id), bkm.Title, bkm.URL, bkm.Favicon, bkm.Starred, false)
-			d.GetElementByID("search-result").AppendChild(newBkm)
-		}
+		displaySearchResults(dataBkm, "&search="+url.QueryEscape(s))
 		d.GetElementByID("search-form-input").(*dom.HTMLInputElement).Set("value", "")
 	}()
 }
@@ -549,7 +1201,7 @@ func starBookmark(bkmID string, forceUnstar bool) {
 				fmt.Println("starBookmark JSON decoder error")
 				return
 			}
-			newBkm := createBookmark(bkmID, data.Title, data.URL, data.Favicon, data.Starred, true)
+			newBkm := createBookmark(bkmID, data.Title, data.URL, data.Favicon, data.Starred, true, data.Description, data.Keyword, data.Tags)
 
 			li := d.CreateElement("li").(*dom.HTMLLIElement)
 			li.AppendChild(newBkm)
@@ -584,7 +1236,249 @@ func addFolder(e dom.Event) {
 			return
 		}
 
-		newFld := createFolder(strconv.Itoa(int(data.Id)), data.Title, 0)
+		newFld := createFolder(strconv.Itoa(int(data.Id)), data.Title, 0, data.SortMode, data.Query)
+
+		rootFld := d.GetElementByID("subfolders-1")
+		rootFld.InsertBefore(newFld.fld, rootFld.FirstChild())
+		rootFld.InsertBefore(newFld.subFlds, rootFld.FirstChild())
+	}()
+}
+
+// selectItem toggles the selection of the given folder or bookmark item with ctrl (or cmd) click,
+// or selects the items between the last selected one and it with shift click.
+// A click without them clears the selection.
+func selectItem(e dom.Event, item dom.HTMLElement) {
+	me := e.(*dom.MouseEvent)
+	if !me.CtrlKey && !me.MetaKey && !me.ShiftKey {
+		clearSelection()
+		return
+	}
+	e.PreventDefault()
+	e.StopImmediatePropagation()
+
+	if me.ShiftKey && lastSelectedID != "" && lastSelectedID != item.ID() && isSelected(lastSelectedID) {
+		// Selecting the displayed items between them, in the tree order.
+		inRange := false
+		for _, el := range d.QuerySelectorAll("#subfolders-1 div." + ClassItemFolder + ", #subfolders-1 div." + ClassItemBookmark) {
+			bound := el.ID() == item.ID() || el.ID() == lastSelectedID
+			if bound || inRange {
+				addClass(el.(dom.HTMLElement), ClassSelectedItem)
+			}
+			if bound {
+				inRange = !inRange
+			}
+		}
+	} else if hasClass(item, ClassSelectedItem) {
+		removeClass(item, ClassSelectedItem)
+	} else {
+		addClass(item, ClassSelectedItem)
+	}
+	lastSelectedID = item.ID()
+}
+
+// selectedItems returns the selected folder and bookmark items.
+func selectedItems() []dom.HTMLElement {
+	var sel []dom.HTMLElement
+	for _, el := range d.GetElementsByClassName(ClassSelectedItem) {
+		sel = append(sel, el.(dom.HTMLElement))
+	}
+	return sel
+}
+
+// isSelected returns true if the item of the given id is selected.
+func isSelected(itemID string) bool {
+	item := d.GetElementByID(itemID)
+	return item != nil && hasClass(item.(dom.HTMLElement), ClassSelectedItem)
+}
+
+// clearSelection unselects all the items.
+func clearSelection() {
+	for _, el := range selectedItems() {
+		removeClass(el, ClassSelectedItem)
+	}
+	lastSelectedID = ""
+}
+
+// itemOperation returns the given batch operation on the given folder or bookmark item.
+func itemOperation(item dom.HTMLElement, op string) types.BatchOperation {
+	sl := strings.Split(item.ID(), "-")
+	id, _ := strconv.Atoi(sl[len(sl)-1])
+	if strings.HasPrefix(item.ID(), "folder-") {
+		return types.BatchOperation{Op: op, FolderId: id}
+	}
+	return types.BatchOperation{Op: op, BookmarkId: id}
+}
+
+// sendBatch applies the given operations in a single request.
+func sendBatch(ops []types.BatchOperation) bool {
+	body, err := json.Marshal(ops)
+	if err != nil {
+		fmt.Println("batch JSON encoder error")
+		return false
+	}
+	req := xhr.NewRequest("POST", "/batch/")
+	req.SetRequestHeader("Content-Type", "application/json")
+	if err = req.Send(string(body)); err != nil || req.Status != http.StatusOK {
+		fmt.Println("batch response code error")
+		return false
+	}
+	return true
+}
+
+// removeItems removes the given folder and bookmark items from the tree.
+func removeItems(items []dom.HTMLElement) {
+	for _, item := range items {
+		if strings.HasPrefix(item.ID(), "folder-") {
+			if children := d.GetElementByID("subfolders-" + strings.Split(item.ID(), "-")[1]); children != nil {
+				children.ParentNode().RemoveChild(children)
+			}
+		}
+		if item.ParentNode() != nil {
+			item.ParentNode().RemoveChild(item)
+		}
+	}
+}
+
+// moveSelection moves the selected items into the folder item they are dropped on.
+func moveSelection(droppedItem dom.HTMLElement, sel []dom.HTMLElement) {
+	defer func() {
+		removeClass(droppedItem, ClassItemOver)
+		removeClass(droppedItem, ClassDropBefore)
+	}()
+
+	fldIDDigit := strings.Split(droppedItem.ID(), "-")[1]
+	fldID, _ := strconv.Atoi(fldIDDigit)
+	var (
+		ops   []types.BatchOperation
+		moved []dom.HTMLElement
+	)
+	for _, item := range sel {
+		removeClass(item, ClassDraggedItem)
+		if item.ID() == droppedItem.ID() {
+			continue
+		}
+		op := itemOperation(item, types.BatchMove)
+		op.DestinationFolderId = fldID
+		ops = append(ops, op)
+		moved = append(moved, item)
+	}
+	if len(ops) == 0 || !sendBatch(ops) {
+		return
+	}
+
+	clearSelection()
+	removeItems(moved)
+	if hasChildrenFolders(fldIDDigit) {
+		reloadFolder(fldIDDigit)
+	}
+}
+
+// deleteSelection deletes the selected items.
+func deleteSelection(sel []dom.HTMLElement) {
+	defer removeClass(d.GetElementByID("delete-box").(dom.HTMLElement), ClassDeleteOver)
+
+	var ops []types.BatchOperation
+	for _, item := range sel {
+		ops = append(ops, itemOperation(item, types.BatchDelete))
+	}
+	if !sendBatch(ops) {
+		return
+	}
+
+	clearSelection()
+	removeItems(sel)
+}
+
+// tagBookmarks adds the prompted tags to the bookmark of the given link element,
+// or to the selected bookmarks if it is selected. The tags starting with - are removed.
+func tagBookmarks(el dom.HTMLElement) {
+	t := js.Global.Call("prompt", "tags separated by commas, -tag removes a tag", el.GetAttribute("data-tags"))
+	if t == nil || t == js.Undefined {
+		// Cancelled.
+		return
+	}
+	var tags, untags []string
+	for _, tag := range strings.Split(t.String(), ",") {
+		if tag = strings.TrimSpace(tag); strings.HasPrefix(tag, "-") {
+			untags = append(untags, strings.TrimPrefix(tag, "-"))
+		} else if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	items := []dom.HTMLElement{el.ParentElement().(dom.HTMLElement)}
+	if isSelected(items[0].ID()) {
+		items = selectedItems()
+	}
+
+	go func() {
+		var ops []types.BatchOperation
+		for _, item := range items {
+			if !strings.HasPrefix(item.ID(), "bookmark-") {
+				continue
+			}
+			op := itemOperation(item, types.BatchTag)
+			op.Tags, op.Untags = tags, untags
+			ops = append(ops, op)
+		}
+		if len(ops) == 0 || !sendBatch(ops) {
+			return
+		}
+
+		// Updating the displayed tags.
+		for _, op := range ops {
+			link := d.GetElementByID("bookmark-link-" + strconv.Itoa(op.BookmarkId)).(dom.HTMLElement)
+			removed := make(map[string]bool)
+			for _, tag := range op.Untags {
+				removed[strings.ToLower(tag)] = true
+			}
+			var newTags []string
+			for _, tag := range append(strings.Split(link.GetAttribute("data-tags"), ","), op.Tags...) {
+				if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && !removed[tag] {
+					removed[tag] = true
+					newTags = append(newTags, tag)
+				}
+			}
+			link.SetAttribute("data-tags", strings.Join(newTags, ", "))
+			bkmURL := strings.Split(link.Title(), "\n")[0]
+			if len(newTags) != 0 {
+				link.SetTitle(bkmURL + "\ntags: " + strings.Join(newTags, ", "))
+			} else {
+				link.SetTitle(bkmURL)
+			}
+		}
+	}()
+}
+
+// addSmartFolder creates a smart folder of the given query,
+// its name being prompted.
+func addSmartFolder(query string) {
+	name := js.Global.Call("prompt", "smart folder name")
+	if name == nil || name == js.Undefined || strings.TrimSpace(name.String()) == "" {
+		// Cancelled.
+		return
+	}
+
+	go func() {
+
+		var (
+			err  error
+			resp *http.Response
+			data types.Folder // returned struct from server
+		)
+
+		if resp = sendRequest("/addSmartFolder/", []arg{{key: "folderName", val: url.QueryEscape(name.String())}, {key: "query", val: url.QueryEscape(query)}}); resp.StatusCode != http.StatusOK {
+			fmt.Println("addSmartFolder response code error")
+			return
+		}
+		defer resp.Body.Close()
+
+		if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+			fmt.Println("addSmartFolder JSON decoder error")
+			return
+		}
+
+		newFld := createFolder(strconv.Itoa(data.Id), data.Title, 0, data.SortMode, data.Query)
 
 		rootFld := d.GetElementByID("subfolders-1")
 		rootFld.InsertBefore(newFld.fld, rootFld.FirstChild())
@@ -598,6 +1492,11 @@ func dropDelete(e dom.Event) {
 
 	e.PreventDefault()
 	draggedItemID := e.(*dom.DragEvent).Get("dataTransfer").Call("getData", "draggedItemID").String()
+	// Deleting the whole selection when dragging one of its items.
+	if sel := selectedItems(); len(sel) > 1 && isSelected(draggedItemID) {
+		go deleteSelection(sel)
+		return
+	}
 
 	go func() {
 
@@ -643,6 +1542,12 @@ func dropFolder(e dom.Event) {
 	// Putting the following instruction inside the go routine does not work. I don't know why.
 	u := e.(*dom.DragEvent).Get("dataTransfer").Call("getData", "URL").String()
 	draggedItemID := e.(*dom.DragEvent).Get("dataTransfer").Call("getData", "draggedItemID").String()
+	// Moving the whole selection when dragging one of its items.
+	if sel := selectedItems(); len(sel) > 1 && isSelected(draggedItemID) {
+		go moveSelection(e.Target().(dom.HTMLElement), sel)
+		return
+	}
+	before := isDropBefore(e)
 
 	u = url.QueryEscape(u)
 
@@ -667,12 +1572,38 @@ func dropFolder(e dom.Event) {
 
 		defer func() {
 			removeClass(droppedItem, ClassItemOver)
+			removeClass(droppedItem, ClassDropBefore)
 			if draggedItem != nil {
 				removeClass(draggedItem.(*dom.HTMLDivElement), ClassDraggedItem)
 			}
 		}()
 
-		if draggedItem != nil && strings.HasPrefix(draggedItemID, "folder") {
+		if draggedItem != nil && strings.HasPrefix(draggedItemID, "folder") && before {
+
+			// Can not move a folder before itself or one of its children.
+			if draggedItemIDDigit == droppedItemIDDigit || getClosest(droppedItem, "#subfolders-"+draggedItemIDDigit) != nil {
+				fmt.Println("can not move a folder before itself or one of its children")
+				return
+			}
+
+			pFldIDDigit := strings.Split(getClosest(droppedItem, "UL").(dom.HTMLElement).ID(), "-")[1]
+			if resp = sendRequest("/moveFolder/", []arg{{key: "sourceFolderId", val: draggedItemIDDigit}, {key: "destinationFolderId", val: pFldIDDigit}, {key: "beforeFolderId", val: droppedItemIDDigit}}); resp.StatusCode != http.StatusOK {
+				fmt.Println("dropFolder response code error")
+				return
+			}
+			defer resp.Body.Close()
+
+			draggedItemChildren.ParentNode().RemoveChild(draggedItemChildren)
+			draggedItem.ParentNode().RemoveChild(draggedItem)
+			// The parent folder is now manually ordered.
+			reloadFolder(pFldIDDigit)
+
+		} else if droppedItem.GetAttribute("data-query") != "" {
+
+			fmt.Println("smart folders are read-only")
+			return
+
+		} else if draggedItem != nil && strings.HasPrefix(draggedItemID, "folder") {
 
 			// Can not move a folder into itself.
 			if draggedItemIDDigit == droppedItemIDDigit {
@@ -723,7 +1654,7 @@ func dropFolder(e dom.Event) {
 
 		} else {
 
-			var dataBkm types.Bookmark
+			var dataBkm types.AddedBookmark
 			if resp = sendRequest("/addBookmark/", []arg{{key: "bookmarkUrl", val: u}, {key: "destinationFolderId", val: droppedItemIDDigit}}); resp.StatusCode != http.StatusOK {
 				fmt.Println("dropFolder response code error")
 				return
@@ -735,7 +1666,10 @@ func dropFolder(e dom.Event) {
 				return
 			}
 
-			newBkm := createBookmark(strconv.Itoa(dataBkm.Id), dataBkm.URL, dataBkm.URL, "", dataBkm.Starred, false)
+			newBkm := createBookmark(strconv.Itoa(dataBkm.Id), dataBkm.URL, dataBkm.URL, "", dataBkm.Starred, false, "", "", nil)
+			if len(dataBkm.Duplicates) > 0 {
+				displayDuplicateWarning(dataBkm.Duplicates)
+			}
 
 			droppedItemChildren.InsertBefore(newBkm, droppedItemChildren.FirstChild())
 			addClass(droppedItem, ClassItemFolderOpen)
@@ -745,6 +1679,187 @@ func dropFolder(e dom.Event) {
 
 }
 
+// dropBookmark moves the dragged bookmark before the bookmark it is dropped on.
+func dropBookmark(e dom.Event) {
+
+	e.PreventDefault()
+	draggedItemID := e.(*dom.DragEvent).Get("dataTransfer").Call("getData", "draggedItemID").String()
+
+	go func() {
+
+		var resp *http.Response
+
+		dropped := getClosest(e.Target(), "."+ClassItemBookmark)
+		if dropped == nil {
+			return
+		}
+		droppedItem := dropped.(dom.HTMLElement)
+		removeClass(droppedItem, ClassDropBefore)
+
+		draggedItem := d.GetElementByID(draggedItemID)
+		if draggedItem == nil || !strings.HasPrefix(draggedItemID, "bookmark-") || draggedItemID == droppedItem.ID() {
+			return
+		}
+		removeClass(draggedItem.(dom.HTMLElement), ClassDraggedItem)
+
+		// Only the bookmarks of the folders tree, not the search results.
+		pFld := getClosest(droppedItem, "UL")
+		if pFld == nil {
+			return
+		}
+		pFldIDDigit := strings.Split(pFld.(dom.HTMLElement).ID(), "-")[1]
+		draggedItemIDDigit := strings.Split(draggedItemID, "-")[1]
+		droppedItemIDDigit := strings.Split(droppedItem.ID(), "-")[1]
+
+		if resp = sendRequest("/moveBookmark/", []arg{{key: "bookmarkId", val: draggedItemIDDigit}, {key: "destinationFolderId", val: pFldIDDigit}, {key: "beforeBookmarkId", val: droppedItemIDDigit}}); resp.StatusCode != http.StatusOK {
+			fmt.Println("dropBookmark response code error")
+			return
+		}
+		defer resp.Body.Close()
+
+		draggedItem.ParentNode().RemoveChild(draggedItem)
+		// The folder is now manually ordered.
+		reloadFolder(pFldIDDigit)
+	}()
+
+}
+
+// reloadFolder reloads the content of the given opened folder.
+func reloadFolder(fldIDDigit string) {
+	undisplayChildrenFolders(fldIDDigit)
+	getChildrenItems(nil, fldIDDigit)
+}
+
+// sortFolder sets the next sort mode of the given folder element
+// and reloads the folder.
+func sortFolder(fld dom.HTMLElement) {
+
+	go func() {
+
+		var resp *http.Response
+
+		fldIDDigit := strings.Split(fld.ID(), "-")[1]
+		mode := folderSortModes[0]
+		for i, m := range folderSortModes {
+			if m == fld.GetAttribute("data-sort-mode") {
+				mode = folderSortModes[(i+1)%len(folderSortModes)]
+			}
+		}
+
+		if resp = sendRequest("/sortFolder/", []arg{{key: "folderId", val: fldIDDigit}, {key: "sortMode", val: mode}}); resp.StatusCode != http.StatusOK {
+			fmt.Println("sortFolder response code error")
+			return
+		}
+		defer resp.Body.Close()
+
+		fld.SetAttribute("data-sort-mode", mode)
+		fmt.Println("folder " + fldIDDigit + " sorted by " + mode)
+		reloadFolder(fldIDDigit)
+	}()
+
+}
+
+// keywordBookmark prompts for the keyword of the given bookmark link element and saves it.
+func keywordBookmark(el dom.HTMLElement) {
+	kw := js.Global.Call("prompt", "keyword, empty for none", el.GetAttribute("data-keyword"))
+	if kw == nil || kw == js.Undefined {
+		// Cancelled.
+		return
+	}
+
+	go func() {
+
+		var resp *http.Response
+
+		sl := strings.Split(el.ID(), "-")
+		bkmIDDigit := sl[len(sl)-1]
+		keyword := strings.ToLower(strings.TrimSpace(kw.String()))
+
+		if resp = sendRequest("/keywordBookmark/", []arg{{key: "bookmarkId", val: bkmIDDigit}, {key: "keyword", val: url.QueryEscape(keyword)}}); resp.StatusCode != http.StatusOK {
+			fmt.Println("keywordBookmark response code error")
+			if resp.StatusCode == http.StatusConflict {
+				js.Global.Call("alert", "the keyword "+keyword+" is already used")
+			}
+			return
+		}
+		defer resp.Body.Close()
+
+		el.SetAttribute("data-keyword", keyword)
+		fmt.Println("bookmark " + bkmIDDigit + " keyword " + keyword)
+	}()
+
+}
+
+// editDescription shows the description box of the given bookmark link element.
+func editDescription(elementID string) {
+	resetAll()
+
+	sl := strings.Split(elementID, "-")
+	bkmIDDigit := sl[len(sl)-1]
+	el := d.GetElementByID(elementID).(dom.HTMLElement)
+
+	el.ParentNode().InsertBefore(d.GetElementByID("description-input-box"), el.NextElementSibling())
+	showItem("description-input-box")
+	setItemValue("description-hidden-input-box-form", bkmIDDigit)
+	ta := d.GetElementByID("description-input-box-form").(*dom.HTMLTextAreaElement)
+	ta.Value = el.GetAttribute("data-description")
+	ta.Focus()
+}
+
+// describeBookmark saves the description of the description box.
+func describeBookmark(e dom.Event) {
+	e.PreventDefault()
+
+	go func() {
+		bkmIDDigit := d.GetElementByID("description-hidden-input-box-form").(*dom.HTMLInputElement).Value
+		description := d.GetElementByID("description-input-box-form").(*dom.HTMLTextAreaElement).Value
+
+		req := xhr.NewRequest("POST", "/describeBookmark/")
+		req.SetRequestHeader("Content-Type", "application/x-www-form-urlencoded")
+		form := url.Values{"bookmarkId": {bkmIDDigit}, "description": {description}}
+		if err := req.Send(form.Encode()); err != nil || req.Status != http.StatusOK {
+			fmt.Println("describeBookmark response code error")
+			return
+		}
+
+		d.GetElementByID("bookmark-link-"+bkmIDDigit).SetAttribute("data-description", description)
+		note := d.GetElementByID("bookmark-note-" + bkmIDDigit).(dom.HTMLElement)
+		if description == "" {
+			note.Style().SetProperty("display", "none", "")
+		} else {
+			note.Style().RemoveProperty("display")
+		}
+		// Removing the outdated rendered description.
+		if desc := d.GetElementByID("bookmark-description-" + bkmIDDigit); desc != nil {
+			desc.ParentNode().RemoveChild(desc)
+		}
+		hideDescriptionBox()
+	}()
+}
+
+// toggleDescription shows or hides the rendered description of the given bookmark.
+func toggleDescription(bkmIDDigit string) {
+	if desc := d.GetElementByID("bookmark-description-" + bkmIDDigit); desc != nil {
+		desc.ParentNode().RemoveChild(desc)
+		return
+	}
+
+	go func() {
+		req := xhr.NewRequest("GET", "/renderBookmarkDescription/?bookmarkId="+bkmIDDigit)
+		if err := req.Send(nil); err != nil || req.Status != http.StatusOK {
+			fmt.Println("renderBookmarkDescription response code error")
+			return
+		}
+
+		desc := d.CreateElement("div").(*dom.HTMLDivElement)
+		desc.SetID("bookmark-description-" + bkmIDDigit)
+		desc.SetClass(ClassBookmarkDescription)
+		// The HTML is sanitized by the server.
+		desc.SetInnerHTML(req.ResponseText)
+		d.GetElementByID("bookmark-" + bkmIDDigit).AppendChild(desc)
+	}()
+}
+
 func renameFolder(e dom.Event) {
 
 	e.PreventDefault()
@@ -817,8 +1932,16 @@ func getChildrenItems(e dom.Event, fldIDDigit string) {
 			return
 		}
 
+		// The last opened folder is the import destination,
+		// but for the read-only smart folders.
+		if fldIDDigit == "1" {
+			setImportFolder(fldIDDigit, "/")
+		} else if d.GetElementByID("folder-"+fldIDDigit).GetAttribute("data-query") == "" {
+			setImportFolder(fldIDDigit, d.GetElementByID("folder-"+fldIDDigit).(dom.HTMLElement).Title())
+		}
+
 		// Getting the folder subfolders.
-		if resp = sendRequest("/getChildrenFolders/", []arg{{key: "folderId", val: fldIDDigit}}); resp.StatusCode != http.StatusOK {
+		if resp = sendRequest("/getChildrenFolders/", append([]arg{{key: "folderId", val: fldIDDigit}}, sortArgs()...)); resp.StatusCode != http.StatusOK {
 			fmt.Println("getChildrenFolders response code error")
 			return
 		}
@@ -829,11 +1952,11 @@ func getChildrenItems(e dom.Event, fldIDDigit string) {
 			return
 		}
 		for _, fld := range dataFld {
-			displaySubfolder(fldIDDigit, strconv.Itoa(fld.Id), fld.Title, fld.NbChildrenFolders)
+			displaySubfolder(fldIDDigit, strconv.Itoa(fld.Id), fld.Title, fld.NbChildrenFolders, fld.SortMode, fld.Query)
 		}
 
 		// Getting the folder bookmarks.
-		if resp = sendRequest("/getFolderBookmarks/", []arg{{key: "folderId", val: fldIDDigit}}); resp.StatusCode != http.StatusOK {
+		if resp = sendRequest("/getFolderBookmarks/", append([]arg{{key: "folderId", val: fldIDDigit}}, sortArgs()...)); resp.StatusCode != http.StatusOK {
 			fmt.Println("getChildrenFolders response code error")
 			return
 		}
@@ -844,7 +1967,7 @@ func getChildrenItems(e dom.Event, fldIDDigit string) {
 			return
 		}
 		for _, bkm := range dataBkm {
-			displayBookmark(fldIDDigit, strconv.Itoa(bkm.Id), bkm.Title, bkm.URL, bkm.Favicon, bkm.Starred)
+			displayBookmark(fldIDDigit, strconv.Itoa(bkm.Id), bkm.Title, bkm.URL, bkm.Favicon, bkm.Starred, bkm.Description, bkm.Keyword, bkm.Tags)
 		}
 
 		// Changing the folder icon.
@@ -876,23 +1999,27 @@ func main() {
 	}
 
 	go func() {
+		dec := json.NewDecoder(c)
 		for {
-			buf := make([]byte, 1024)
-			n, err := c.Read(buf) // Blocks until a WebSocket frame is received
-			if err != nil {
-				fmt.Println(err)
-			}
-			//fmt.Println(string(buf[:n]))
-
-			var bkm types.Bookmark
-			if err := json.Unmarshal(buf[:n], &bkm); err != nil {
+			// Blocks until a WebSocket message is received.
+			var msg types.Message
+			if err := dec.Decode(&msg); err != nil {
 				fmt.Println(err)
 				return
 			}
-			newBkm := createBookmark(strconv.Itoa(bkm.Id), bkm.Title, bkm.URL, bkm.Favicon, false, false)
 
-			rootChildrens := d.GetElementByID("subfolders-1")
-			rootChildrens.InsertBefore(newBkm, rootChildrens.FirstChild())
+			switch msg.Type {
+			case types.MessageBookmark:
+				bkm := msg.Bookmark
+				newBkm := createBookmark(strconv.Itoa(bkm.Id), bkm.Title, bkm.URL, bkm.Favicon, false, false, bkm.Description, bkm.Keyword, bkm.Tags)
+
+				rootChildrens := d.GetElementByID("subfolders-1")
+				rootChildrens.InsertBefore(newBkm, rootChildrens.FirstChild())
+			case types.MessageImportJob:
+				displayImportJob(*msg.ImportJob)
+			case types.MessageBackup:
+				displayBackupStatus(*msg.Backup)
+			}
 		}
 	}()
 
@@ -907,6 +2034,7 @@ func main() {
 	// Add/Rename folder button listener.
 	d.GetElementByID("add-folder-button").AddEventListener("click", false, addFolder)
 	d.GetElementByID("rename-folder-button").AddEventListener("click", false, renameFolder)
+	d.GetElementByID("description-button").AddEventListener("click", false, describeBookmark)
 
 	// Bind enter key to add or rename a folder.
 	d.AddEventListener("keydown", false, func(e dom.Event) {
@@ -921,6 +2049,12 @@ func main() {
 	fld.AddEventListener("click", false, func(e dom.Event) {
 		getChildrenItems(e, "1")
 	})
+	fld.AddEventListener("mouseover", false, func(e dom.Event) {
+		mouseOverItem(e)
+	})
+	fld.AddEventListener("keydown", false, func(e dom.Event) {
+		keyDownItem(e)
+	})
 	fld.AddEventListener("dragover", false, func(e dom.Event) {
 		dragOverItem(e)
 	})
@@ -949,7 +2083,7 @@ func main() {
 	})
 	exportLink := d.GetElementByID("export-box").(*dom.HTMLDivElement)
 	exportLink.AddEventListener("click", false, func(e dom.Event) {
-		openInParent("/export/")
+		exportBookmarks()
 	})
 
 	// Import form listener.
@@ -958,6 +2092,26 @@ func main() {
 		importBookmarks(e)
 	})
 
+	// Sort order and stale bookmarks filter listeners.
+	d.GetElementByID("sort-order").AddEventListener("change", false, func(e dom.Event) {
+		refreshTree()
+	})
+	d.GetElementByID("stale-filter").AddEventListener("change", false, func(e dom.Event) {
+		staleBookmarks()
+	})
+	d.GetElementByID("duplicates-box").AddEventListener("click", false, func(e dom.Event) {
+		findDuplicates()
+	})
+	d.GetElementByID("reading-list-box").AddEventListener("click", false, func(e dom.Event) {
+		readingList()
+	})
+	d.GetElementByID("shares-box").AddEventListener("click", false, func(e dom.Event) {
+		displayShares()
+	})
+
+	// Backup failure alert.
+	go getBackupStatus()
+
 	// Search input listener.
 	searchInput := d.GetElementByID("search-form-input")
 	searchInput.AddEventListener("keyup", false, func(e dom.Event) {
@@ -970,6 +2124,11 @@ func main() {
 			changeTimer = 0
 		}, 400)
 	})
+	// Searching the search parameter, given by the /k/ resolver.
+	if q, err := url.ParseQuery(strings.TrimPrefix(js.Global.Get("location").Get("search").String(), "?")); err == nil && q.Get("search") != "" {
+		searchInput.(*dom.HTMLInputElement).Set("value", q.Get("search"))
+		searchBookmark()
+	}
 
 	// Enter and Esc key listeners
 	d.AddEventListener("keydown", false, func(e dom.Event) {
@@ -994,9 +2153,10 @@ func main() {
 		})
 	}
 	for _, e := range d.GetElementsByClassName("bookmark-starred-link") {
-		u := e.GetAttribute("Title")
+		idSplt := strings.Split(e.ID(), "-")
+		idDigit := idSplt[len(idSplt)-1]
 		e.AddEventListener("click", false, func(e dom.Event) {
-			openInParent(u)
+			openInParent(goURL(idDigit))
 		})
 	}
 
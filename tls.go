@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// selfSignedValidity is the validity of the generated self-signed certificates.
+const selfSignedValidity = 825 * 24 * time.Hour
+
+// certReloader serves the certificate of its files, reloaded on SIGHUP:
+// the new connections get the new certificate, the open ones are kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+}
+
+// newCertReloader returns a certReloader of the given files, loaded.
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the certificate files, the current certificate being kept on errors.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.cert = &cert
+	r.mutex.Unlock()
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// watch reloads the certificate on SIGHUP.
+func (r *certReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := r.reload(); err != nil {
+				log.WithFields(log.Fields{
+					"certFile": r.certFile,
+					"err":      err,
+				}).Error("certReloader:reload error, keeping the current certificate")
+				continue
+			}
+			log.WithFields(log.Fields{
+				"certFile": r.certFile,
+			}).Info("certReloader:certificate reloaded")
+		}
+	}()
+}
+
+// writePEM writes the given PEM block into the file path.
+func writePEM(path string, blockType string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: data}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// generateSelfSigned writes a self-signed certificate of the given host names
+// and IP addresses, and its key.
+func generateSelfSigned(certFile string, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"GoBkm"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err = writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	return writePEM(certFile, "CERTIFICATE", der, 0644)
+}
+
+// selfSignedHosts returns the host names and addresses of the self-signed certificate:
+// the base URL host, the listen address host, the machine name and the loopback addresses.
+func (sf *serverFlags) selfSignedHosts() []string {
+	var (
+		hosts []string
+		seen  = make(map[string]bool)
+	)
+	add := func(h string) {
+		if h != "" && h != "0.0.0.0" && h != "::" && !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	if u, err := url.Parse(*sf.baseURL); err == nil {
+		add(u.Hostname())
+	}
+	if h, _, err := net.SplitHostPort(*sf.listen); err == nil {
+		add(h)
+	}
+	if h, err := os.Hostname(); err == nil {
+		add(h)
+	}
+	add("localhost")
+	add("127.0.0.1")
+	add("::1")
+	return hosts
+}
+
+// tlsConfig returns the TLS configuration of the certificate files,
+// generating a self-signed certificate first if asked and missing,
+// the certificate being reloaded on SIGHUP.
+func (sf *serverFlags) tlsConfig() (*tls.Config, error) {
+	if *sf.tlsSelfSigned {
+		_, certErr := os.Stat(*sf.tlsCert)
+		_, keyErr := os.Stat(*sf.tlsKey)
+		if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+			hosts := sf.selfSignedHosts()
+			log.WithFields(log.Fields{
+				"certFile": *sf.tlsCert,
+				"hosts":    hosts,
+			}).Info("tlsConfig:generating a self-signed certificate")
+			if err := generateSelfSigned(*sf.tlsCert, *sf.tlsKey, hosts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	r, err := newCertReloader(*sf.tlsCert, *sf.tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	r.watch()
+	return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: r.getCertificate}, nil
+}
+
+// redirectHTTPS returns a handler redirecting the requests to the same URL
+// on HTTPS, served on the listen address httpsAddr.
+func redirectHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			// An IPv6 address without port.
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestServerFlags returns the server parameters of args.
+func newTestServerFlags(t *testing.T, args ...string) *serverFlags {
+	fs := flag.NewFlagSet("gobkm", flag.ContinueOnError)
+	sf := addServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+// leafCertificate returns the certificate served by r.
+func leafCertificate(t *testing.T, r *certReloader) *x509.Certificate {
+	cert, err := r.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestResolveTLS(t *testing.T) {
+	tests := []struct {
+		args      []string
+		baseURL   string
+		cert, key string
+		wantErr   bool
+	}{
+		{args: nil, baseURL: "http://localhost:8080"},
+		{args: []string{"-tlscert", "c.pem", "-tlskey", "k.pem", "-listen", "0.0.0.0:8443"}, baseURL: "https://localhost:8443", cert: "c.pem", key: "k.pem"},
+		{args: []string{"-tlsselfsigned", "-port", "8443"}, baseURL: "https://localhost:8443", cert: defaultTLSCert, key: defaultTLSKey},
+		{args: []string{"-tlsselfsigned", "-tlscert", "c.pem", "-baseurl", "https://bkm.example.com/"}, baseURL: "https://bkm.example.com", cert: "c.pem", key: defaultTLSKey},
+		{args: []string{"-tlscert", "c.pem"}, wantErr: true},
+		{args: []string{"-tlskey", "k.pem"}, wantErr: true},
+		{args: []string{"-httpredirect", ":80"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		sf := newTestServerFlags(t, tt.args...)
+		err := sf.resolve()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: resolve error = %v, want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (*sf.baseURL != tt.baseURL || *sf.tlsCert != tt.cert || *sf.tlsKey != tt.key) {
+			t.Errorf("%q: resolved %s %q %q, want %s %q %q", tt.args, *sf.baseURL, *sf.tlsCert, *sf.tlsKey, tt.baseURL, tt.cert, tt.key)
+		}
+	}
+}
+
+func TestSelfSignedHosts(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-listen", ":8443"}, []string{"localhost"}},
+		{[]string{"-listen", "0.0.0.0:8443"}, []string{"localhost"}},
+		{[]string{"-listen", "192.168.1.2:8443", "-baseurl", "https://bkm.example.com/gobkm"}, []string{"bkm.example.com", "192.168.1.2"}},
+		{[]string{"-listen", "[::1]:8443", "-baseurl", "https://127.0.0.1:8443"}, []string{"127.0.0.1", "::1"}},
+	}
+	for _, tt := range tests {
+		sf := newTestServerFlags(t, tt.args...)
+		if err = sf.resolve(); err != nil {
+			t.Fatal(err)
+		}
+		// The machine name and loopback addresses come last, once.
+		want := append(tt.want, hostname, "localhost", "127.0.0.1", "::1")
+		seen := make(map[string]bool)
+		var hosts []string
+		for _, h := range want {
+			if !seen[h] {
+				seen[h] = true
+				hosts = append(hosts, h)
+			}
+		}
+		if got := sf.selfSignedHosts(); fmt.Sprint(got) != fmt.Sprint(hosts) {
+			t.Errorf("%q: selfSignedHosts() = %v, want %v", tt.args, got, hosts)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobkm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "gobkm.crt"), filepath.Join(dir, "gobkm.key")
+
+	// Missing files without -tlsselfsigned.
+	sf := newTestServerFlags(t, "-tlscert", certFile, "-tlskey", keyFile, "-baseurl", "https://bkm.example.com")
+	if _, err = sf.tlsConfig(); err == nil {
+		t.Fatal("tlsConfig without the certificate files and no error")
+	}
+
+	// The self-signed certificate generated once.
+	sf = newTestServerFlags(t, "-tlsselfsigned", "-tlscert", certFile, "-tlskey", keyFile, "-baseurl", "https://bkm.example.com")
+	config, err := sf.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file %v %v", info, err)
+	}
+	cert, err := config.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "bkm.example.com" || leaf.VerifyHostname("bkm.example.com") != nil || leaf.VerifyHostname("127.0.0.1") != nil || leaf.VerifyHostname("::1") != nil {
+		t.Errorf("certificate %s of %v %v", leaf.Subject.CommonName, leaf.DNSNames, leaf.IPAddresses)
+	}
+	if _, err = sf.tlsConfig(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := leafCertificate(t, r); got.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Errorf("existing certificate regenerated")
+	}
+
+	// A broken certificate is not loaded, a new one is on SIGHUP.
+	if err = ioutil.WriteFile(certFile, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.reload(); err == nil {
+		t.Error("reload of a broken certificate without error")
+	}
+	if got := leafCertificate(t, r); got.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Errorf("broken certificate loaded")
+	}
+	if err = generateSelfSigned(certFile, keyFile, []string{"other.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	r.watch()
+	if err = syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; leafCertificate(t, r).Subject.CommonName != "other.example.com"; i++ {
+		if i == 100 {
+			t.Fatal("certificate not reloaded on SIGHUP")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		httpsAddr, host, target string
+		want                    string
+	}{
+		{":443", "bkm.example.com", "/", "https://bkm.example.com/"},
+		{":443", "bkm.example.com:80", "/gobkm/?folder=1&q=a+b", "https://bkm.example.com/gobkm/?folder=1&q=a+b"},
+		{"0.0.0.0:8443", "bkm.example.com:8080", "/a", "https://bkm.example.com:8443/a"},
+		{":443", "[::1]:80", "/a", "https://[::1]/a"},
+		{":8443", "[::1]", "/a", "https://[::1]:8443/a"},
+		{":8443", "127.0.0.1:80", "/a", "https://127.0.0.1:8443/a"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		redirectHTTPS(tt.httpsAddr).ServeHTTP(w, r)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.want {
+			t.Errorf("%s %s%s: %d %s, want %s", tt.httpsAddr, tt.host, tt.target, w.Code, w.Header().Get("Location"), tt.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package types
+
+import "time"
+
+// BackupStatus is the state of the scheduled backups.
+type BackupStatus struct {
+	Enabled     bool
+	LastAttempt time.Time // zero if no backup was attempted yet
+	LastSuccess time.Time // zero if no backup succeeded yet
+	// Error is the error of the last attempt, empty if it succeeded.
+	Error     string   `json:",omitempty"`
+	Snapshots []string // the kept snapshot files, newest first
+}
@@ -0,0 +1,26 @@
+package types
+
+// Batch operations.
+const (
+	BatchMove   = "move"
+	BatchDelete = "delete"
+	BatchStar   = "star"
+	BatchTag    = "tag"
+	BatchRename = "rename"
+)
+
+// BatchOperation is an operation of a batch, on a bookmark or a folder.
+// The operations of a batch are applied in a single transaction:
+// all of them or none.
+type BatchOperation struct {
+	Op         string // one of the Batch* constants
+	BookmarkId int    // the bookmark of the operation, or
+	FolderId   int    // the folder of the operation
+	// DestinationFolderId is the folder to move into, the root folder if 0.
+	// The moved items are put after the last item of the folder.
+	DestinationFolderId int
+	Starred             bool     // star: starring or unstarring the bookmark
+	Tags                []string // tag: the tags to add to the bookmark
+	Untags              []string // tag: the tags to remove from the bookmark
+	Title               string   // rename: the new title
+}
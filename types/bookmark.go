@@ -1,6 +1,9 @@
 package types
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Folder containing the bookmarks
 type Folder struct {
@@ -8,6 +11,17 @@ type Folder struct {
 	Title             string
 	Parent            *Folder
 	NbChildrenFolders int
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	// Position is the manual order within the parent folder.
+	Position float64
+	// SortMode is the order of the folder content,
+	// one of SortManual, SortTitle, SortCreated, SortVisits or SortFrecency, SortTitle if empty.
+	SortMode string
+	// Query makes a smart folder: its bookmarks are the bookmarks
+	// selected by these URL query parameters, such as search=kubernetes&starred=true.
+	// Smart folders have no subfolders and nothing can be moved into them.
+	Query string
 }
 
 // Bookmark
@@ -16,8 +30,41 @@ type Bookmark struct {
 	Title   string
 	URL     string
 	Favicon string // base64 encoded image
-	Starred bool
-	Folder  *Folder
+	// CanonicalURL is the normalized URL detecting the duplicates,
+	// maintained by the datastore.
+	CanonicalURL string
+	Starred      bool
+	Folder       *Folder
+	// Description is a free-form note in Markdown.
+	Description string
+	// Keyword is the optional shortcut opening the bookmark with /k/?q=keyword terms,
+	// the terms replacing the %s of the URL.
+	Keyword string
+	// Tags are lowercase labels without commas.
+	Tags []string
+	// Unread bookmarks are in the reading list until read or archived.
+	Unread   bool
+	Archived bool
+	// Maintained by the datastore.
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	LastVisitedAt time.Time // zero if never visited
+	VisitCount    int
+	// Position is the manual order within the folder.
+	Position float64
+}
+
+// AddedBookmark is a new bookmark with the bookmarks
+// already saved with the same canonical URL.
+type AddedBookmark struct {
+	Bookmark
+	Duplicates []*Bookmark
+}
+
+// Visit is the number of visits of a bookmark on a day.
+type Visit struct {
+	Day   time.Time
+	Count int
 }
 
 func (fd *Folder) String() string {
@@ -0,0 +1,40 @@
+package types
+
+// Import job status.
+const (
+	ImportJobRunning   = "running"
+	ImportJobDone      = "done"
+	ImportJobCancelled = "cancelled"
+	ImportJobFailed    = "failed"
+)
+
+// ImportReportEntry is a folder or bookmark processed by an import.
+type ImportReportEntry struct {
+	Type   string // folder or bookmark
+	Path   string // path of the parent folder
+	Title  string
+	URL    string `json:",omitempty"`
+	Reason string `json:",omitempty"` // why a malformed entry was rejected
+}
+
+// ImportReport lists what an import created, skipped or updated
+// and the malformed entries of the imported file.
+type ImportReport struct {
+	Mode      string
+	DryRun    bool
+	Created   []ImportReportEntry
+	Skipped   []ImportReportEntry
+	Updated   []ImportReportEntry
+	Malformed []ImportReportEntry
+}
+
+// ImportJob is the progress of a background import.
+type ImportJob struct {
+	Id        int
+	Status    string
+	Folders   int           // number of processed folders
+	Bookmarks int           // number of processed bookmarks
+	Malformed int           // number of malformed entries
+	Error     string        `json:",omitempty"`
+	Report    *ImportReport `json:",omitempty"` // set when the job is over
+}
@@ -0,0 +1,16 @@
+package types
+
+// Websocket message types.
+const (
+	MessageBookmark  = "bookmark"  // a bookmark has been added
+	MessageImportJob = "importJob" // an import job progressed
+	MessageBackup    = "backup"    // a scheduled backup was attempted
+)
+
+// Message is sent by the server to the client through the websocket.
+type Message struct {
+	Type      string
+	Bookmark  *Bookmark     `json:",omitempty"`
+	ImportJob *ImportJob    `json:",omitempty"`
+	Backup    *BackupStatus `json:",omitempty"`
+}
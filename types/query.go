@@ -0,0 +1,43 @@
+package types
+
+import "time"
+
+// Bookmarks sort orders, also the folders sort modes.
+const (
+	SortTitle    = "title"
+	SortCreated  = "created"
+	SortUpdated  = "updated"
+	SortVisited  = "visited"
+	SortVisits   = "visits"   // most visited
+	SortFrecency = "frecency" // visits weighted by their age
+	SortManual   = "manual"   // Position order
+)
+
+// BookmarkQuery selects and sorts bookmarks.
+// The zero values select all the bookmarks sorted by title.
+type BookmarkQuery struct {
+	FolderID int    // only the bookmarks of this folder if not 0
+	Under    int    // only the bookmarks of this folder and its subfolders if not 0
+	Search   string // title or description containing this string
+	Tag      string // only the bookmarks with this tag
+	Starred  bool   // only the starred bookmarks
+	Unread   bool   // only the unread bookmarks
+	Archived bool   // only the archived bookmarks
+
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedBefore time.Time
+	// NotVisitedSince also selects the bookmarks never visited.
+	NotVisitedSince time.Time
+
+	Sort  string // one of the Sort* constants, SortTitle by default
+	Desc  bool
+	Limit int // at most Limit bookmarks if not 0
+}
+
+// SortByFolder sorts the query by the given folder sort mode,
+// the most recent and most visited first.
+func (q *BookmarkQuery) SortByFolder(sortMode string) {
+	q.Sort = sortMode
+	q.Desc = sortMode == SortCreated || sortMode == SortVisits || sortMode == SortFrecency
+}
@@ -0,0 +1,12 @@
+package types
+
+import "time"
+
+// Share is a public read-only link to a folder and its subfolders.
+type Share struct {
+	// Token is the unguessable part of the share URL, /share/{token}.
+	Token     string
+	Folder    *Folder
+	CreatedAt time.Time
+	ExpiresAt time.Time // zero if the share never expires
+}
@@ -0,0 +1,19 @@
+package types
+
+// SyncFolder is a folder of a bookmarks tree synchronized with a browser,
+// such as the XBEL file of Floccus. The folders and bookmarks are identified
+// by their sync ids, stable across the synchronizations and chosen by the client
+// for the items it creates. The root folder has no sync id.
+type SyncFolder struct {
+	SyncId    int
+	Title     string
+	Folders   []*SyncFolder
+	Bookmarks []*SyncBookmark
+}
+
+// SyncBookmark is a bookmark of a synchronized bookmarks tree.
+type SyncBookmark struct {
+	SyncId int
+	Title  string
+	URL    string
+}